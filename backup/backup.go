@@ -0,0 +1,523 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package backup provides incremental backups of a LevelDB database.
+//
+// A backup repository is a directory that holds any number of backups. The
+// 'sorted table' files are immutable, so they are shared between backups:
+// a table is stored once per file number, size and checksum, and it is
+// removed once no backup references it. Other files, such as the manifest
+// and the journal, are private to a backup.
+//
+// Repository layout:
+//
+//	shared/NNNNNN_SIZE_CRC.ldb  shared tables
+//	private/ID/                 private files of a backup
+//	meta/ID                     backup metadata
+//	tmp/                        temporary files
+//
+// A backup exists once its metadata is written, partially created backups
+// are cleaned up when the repository is opened.
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/3JoB/goleveldb"
+	"github.com/3JoB/goleveldb/errors"
+	"github.com/3JoB/goleveldb/iterator"
+	"github.com/3JoB/goleveldb/opt"
+	"github.com/3JoB/goleveldb/storage"
+	"github.com/3JoB/goleveldb/table"
+)
+
+const (
+	sharedDir  = "shared"
+	privateDir = "private"
+	metaDir    = "meta"
+	tmpDir     = "tmp"
+)
+
+// Common errors.
+var (
+	ErrNotFound     = errors.New("leveldb/backup: backup not found")
+	ErrRestoreExist = errors.New("leveldb/backup: restore directory already exist")
+	ErrClosed       = errors.New("leveldb/backup: closed")
+)
+
+// ErrCorrupted is the error type that is returned when a file of a backup
+// is missing or corrupted.
+type ErrCorrupted struct {
+	ID     uint64
+	Fd     storage.FileDesc
+	Reason string
+}
+
+func (e *ErrCorrupted) Error() string {
+	return fmt.Sprintf("leveldb/backup: backup %d file %s corrupted: %s", e.ID, e.Fd, e.Reason)
+}
+
+// File describes a file of a backup.
+type File struct {
+	Fd       storage.FileDesc
+	Size     int64
+	Checksum uint32 // CRC-32 of the file content, using Castagnoli's polynomial.
+	Shared   bool   // Whether the file is shared between backups.
+}
+
+// Info describes a backup.
+type Info struct {
+	ID        uint64
+	Timestamp time.Time
+	Files     []File
+}
+
+// Size returns the total size of the backup files, including the shared
+// ones.
+func (i *Info) Size() (n int64) {
+	for _, f := range i.Files {
+		n += f.Size
+	}
+	return
+}
+
+// Engine manages backups of a backup repository.
+//
+// The Engine must be the only user of its repository directory. It is safe
+// for concurrent use.
+type Engine struct {
+	mu     sync.Mutex
+	dir    string
+	closed bool
+}
+
+// Open opens or creates a backup repository in the given directory. Files
+// of partially created or deleted backups are removed.
+func Open(dir string) (*Engine, error) {
+	for _, name := range []string{sharedDir, privateDir, metaDir, tmpDir} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			return nil, err
+		}
+	}
+	e := &Engine{dir: dir}
+	if err := e.gc(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *Engine) path(elem ...string) string {
+	return filepath.Join(append([]string{e.dir}, elem...)...)
+}
+
+func (e *Engine) filePath(id uint64, f File) string {
+	if f.Shared {
+		return e.path(sharedDir, sharedName(f))
+	}
+	return e.path(privateDir, strconv.FormatUint(id, 10), f.Fd.String())
+}
+
+// Returns name of the given shared file.
+func sharedName(f File) string {
+	return fmt.Sprintf("%06d_%d_%08x.ldb", f.Fd.Num, f.Size, f.Checksum)
+}
+
+// Returns ids of all backups in ascending order.
+func (e *Engine) ids() ([]uint64, error) {
+	entries, err := os.ReadDir(e.path(metaDir))
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, entry := range entries {
+		if id, err := strconv.ParseUint(entry.Name(), 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids, nil
+}
+
+func (e *Engine) info(id uint64) (*Info, error) {
+	f, err := os.Open(e.path(metaDir, strconv.FormatUint(id, 10)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer f.Close()
+	info, err := decodeInfo(f)
+	if err != nil {
+		return nil, err
+	}
+	info.ID = id
+	return info, nil
+}
+
+// CreateBackup creates a new backup of the given DB, while the DB remains
+// open for reads and writes. Tables that are already in the repository
+// aren't copied again.
+func (e *Engine) CreateBackup(db *leveldb.DB) (*Info, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return nil, ErrClosed
+	}
+
+	ids, err := e.ids()
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{ID: 1, Timestamp: time.Now()}
+	if len(ids) > 0 {
+		info.ID = ids[len(ids)-1] + 1
+	}
+	name := strconv.FormatUint(info.ID, 10)
+
+	// The checkpoint is created within the repository, so that its linked
+	// or copied files could be moved into the repository.
+	tmp := e.path(tmpDir, name)
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	if err := db.Checkpoint(tmp); err != nil {
+		return nil, err
+	}
+	stor, err := storage.OpenFile(tmp, true)
+	if err != nil {
+		return nil, err
+	}
+	fds, err := stor.List(storage.TypeAll)
+	if err == nil {
+		for _, fd := range fds {
+			f := File{Fd: fd, Shared: fd.Type == storage.TypeTable}
+			if f.Size, f.Checksum, err = checksumFile(stor, fd); err != nil {
+				break
+			}
+			info.Files = append(info.Files, f)
+		}
+	}
+	stor.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	private := e.path(privateDir, name)
+	if err := os.MkdirAll(private, 0755); err != nil {
+		return nil, err
+	}
+	for _, f := range info.Files {
+		dst := e.filePath(info.ID, f)
+		if f.Shared {
+			if fi, err := os.Stat(dst); err == nil && fi.Size() == f.Size {
+				// Already in the repository.
+				continue
+			}
+		}
+		if err := os.Rename(filepath.Join(tmp, f.Fd.String()), dst); err != nil {
+			return nil, err
+		}
+	}
+
+	// Write the metadata, the backup then exists.
+	if err := e.writeInfo(info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (e *Engine) writeInfo(info *Info) error {
+	name := strconv.FormatUint(info.ID, 10)
+	tmp := e.path(tmpDir, name+".meta")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := encodeInfo(f, info); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.path(metaDir, name))
+}
+
+// List returns all backups, ordered from the oldest.
+func (e *Engine) List() ([]*Info, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return nil, ErrClosed
+	}
+
+	ids, err := e.ids()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*Info, 0, len(ids))
+	for _, id := range ids {
+		info, err := e.info(id)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Verify verifies files of the given backup against their recorded size and
+// checksum. Tables are also read through table.Reader, verifying checksum
+// of each block. Returns *ErrCorrupted if a file is missing or corrupted.
+func (e *Engine) Verify(id uint64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrClosed
+	}
+
+	info, err := e.info(id)
+	if err != nil {
+		return err
+	}
+	for _, f := range info.Files {
+		if err := e.verifyFile(id, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore restores the given backup into the given directory, which must
+// not exist. The restored DB can then be opened using leveldb.OpenFile.
+func (e *Engine) Restore(id uint64, dir string) (err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrClosed
+	}
+
+	info, err := e.info(id)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return ErrRestoreExist
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	dst, err := storage.OpenFile(dir, false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		dst.Close()
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	var manifestFd storage.FileDesc
+	for _, f := range info.Files {
+		if err := e.restoreFile(id, f, dst); err != nil {
+			return err
+		}
+		if f.Fd.Type == storage.TypeManifest {
+			manifestFd = f.Fd
+		}
+	}
+	if manifestFd.Zero() {
+		return &ErrCorrupted{ID: id, Reason: "manifest missing"}
+	}
+	return dst.SetMeta(manifestFd)
+}
+
+func (e *Engine) restoreFile(id uint64, f File, dst storage.Storage) error {
+	r, err := os.Open(e.filePath(id, f))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &ErrCorrupted{ID: id, Fd: f.Fd, Reason: "file missing"}
+		}
+		return err
+	}
+	defer r.Close()
+	w, err := dst.Create(f.Fd)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	size, crc, err := copyChecksum(w, r)
+	if err != nil {
+		return err
+	}
+	if size != f.Size || crc != f.Checksum {
+		return &ErrCorrupted{ID: id, Fd: f.Fd, Reason: "checksum mismatch"}
+	}
+	return w.Sync()
+}
+
+// Delete deletes the given backup. Shared tables are removed once they are
+// no longer referenced by any backup.
+func (e *Engine) Delete(id uint64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrClosed
+	}
+
+	if err := e.delete(id); err != nil {
+		return err
+	}
+	return e.gc()
+}
+
+func (e *Engine) delete(id uint64) error {
+	name := strconv.FormatUint(id, 10)
+	if err := os.Remove(e.path(metaDir, name)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return os.RemoveAll(e.path(privateDir, name))
+}
+
+// Purge deletes old backups, keeping only the newest keep backups.
+func (e *Engine) Purge(keep int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrClosed
+	}
+
+	ids, err := e.ids()
+	if err != nil {
+		return err
+	}
+	for len(ids) > keep && len(ids) > 0 {
+		if err := e.delete(ids[0]); err != nil {
+			return err
+		}
+		ids = ids[1:]
+	}
+	return e.gc()
+}
+
+// Removes temporary files, private files of nonexistent backups and shared
+// files no longer referenced by any backup.
+func (e *Engine) gc() error {
+	ids, err := e.ids()
+	if err != nil {
+		return err
+	}
+	live := make(map[string]bool)
+	shared := make(map[string]bool)
+	for _, id := range ids {
+		info, err := e.info(id)
+		if err != nil {
+			return err
+		}
+		live[strconv.FormatUint(id, 10)] = true
+		for _, f := range info.Files {
+			if f.Shared {
+				shared[sharedName(f)] = true
+			}
+		}
+	}
+
+	for dir, keep := range map[string]map[string]bool{
+		tmpDir:     nil,
+		privateDir: live,
+		sharedDir:  shared,
+	} {
+		entries, err := os.ReadDir(e.path(dir))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if keep[entry.Name()] {
+				continue
+			}
+			if err := os.RemoveAll(e.path(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the Engine. Other methods return ErrClosed afterwards.
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	return nil
+}
+
+// Returns size and checksum of the given file.
+func checksumFile(stor storage.Storage, fd storage.FileDesc) (size int64, crc uint32, err error) {
+	r, err := stor.Open(fd)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	return copyChecksum(io.Discard, r)
+}
+
+func (e *Engine) verifyFile(id uint64, f File) error {
+	r, err := os.Open(e.filePath(id, f))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &ErrCorrupted{ID: id, Fd: f.Fd, Reason: "file missing"}
+		}
+		return err
+	}
+	defer r.Close()
+	size, crc, err := copyChecksum(io.Discard, r)
+	if err != nil {
+		return err
+	}
+	if size != f.Size || crc != f.Checksum {
+		return &ErrCorrupted{ID: id, Fd: f.Fd, Reason: "checksum mismatch"}
+	}
+	if f.Fd.Type != storage.TypeTable {
+		return nil
+	}
+
+	o := &opt.Options{Strict: opt.StrictAll}
+	tr, err := table.NewReader(r, size, f.Fd, nil, nil, o, nil, nil)
+	if err != nil {
+		return &ErrCorrupted{ID: id, Fd: f.Fd, Reason: err.Error()}
+	}
+	defer tr.Release()
+	ro := &opt.ReadOptions{Strict: opt.StrictAll}
+	for _, iter := range []iterator.Iterator{tr.NewIterator(nil, ro), tr.NewRangeDelIterator(ro)} {
+		for iter.Next() {
+		}
+		err := iter.Error()
+		iter.Release()
+		if err != nil {
+			return &ErrCorrupted{ID: id, Fd: f.Fd, Reason: err.Error()}
+		}
+	}
+	return nil
+}
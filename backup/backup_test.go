@@ -0,0 +1,250 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/3JoB/goleveldb"
+	"github.com/3JoB/goleveldb/storage"
+	"github.com/3JoB/goleveldb/util"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "goleveldb-backup-")
+	if err != nil {
+		t.Fatal("MkdirTemp: got error: ", err)
+	}
+	return dir
+}
+
+func checkDB(t *testing.T, dir string, want map[string]string) {
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+	got := make(map[string]string)
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		got[string(iter.Key())] = string(iter.Value())
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		t.Fatal("Iterator: got error: ", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("content mismatch, want=%v got=%v", want, got)
+	}
+}
+
+func sharedFiles(t *testing.T, e *Engine) int {
+	entries, err := os.ReadDir(e.path(sharedDir))
+	if err != nil {
+		t.Fatal("ReadDir: got error: ", err)
+	}
+	return len(entries)
+}
+
+func TestEngine(t *testing.T) {
+	temp := tempDir(t)
+	defer os.RemoveAll(temp)
+
+	db, err := leveldb.OpenFile(filepath.Join(temp, "db"), nil)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+	e, err := Open(filepath.Join(temp, "backup"))
+	if err != nil {
+		t.Fatal("Open: got error: ", err)
+	}
+	defer e.Close()
+
+	want := make(map[string]string)
+	put := func(prefix, value string) {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("%s%03d", prefix, i)
+			if err := db.Put([]byte(key), []byte(value), nil); err != nil {
+				t.Fatal("Put: got error: ", err)
+			}
+			want[key] = value
+		}
+	}
+	copyWant := func() map[string]string {
+		m := make(map[string]string)
+		for k, v := range want {
+			m[k] = v
+		}
+		return m
+	}
+
+	put("a", "v1")
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	put("b", "v1")
+	info1, err := e.CreateBackup(db)
+	if err != nil {
+		t.Fatal("CreateBackup: got error: ", err)
+	}
+	want1 := copyWant()
+	n1 := sharedFiles(t, e)
+
+	// The tables of the first backup are shared.
+	put("c", "v2")
+	if err := db.CompactRange(util.Range{Start: []byte("c"), Limit: []byte("d")}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	info2, err := e.CreateBackup(db)
+	if err != nil {
+		t.Fatal("CreateBackup: got error: ", err)
+	}
+	want2 := copyWant()
+	var n2 int
+	for _, f := range info2.Files {
+		if f.Shared {
+			n2++
+		}
+	}
+	if n := sharedFiles(t, e); n >= n1+n2 {
+		t.Errorf("shared tables aren't deduplicated: got %d files, backups have %d and %d", n, n1, n2)
+	}
+
+	infos, err := e.List()
+	if err != nil {
+		t.Fatal("List: got error: ", err)
+	}
+	if len(infos) != 2 || infos[0].ID != info1.ID || infos[1].ID != info2.ID {
+		t.Fatalf("List: got %v", infos)
+	}
+	if infos[1].Size() != info2.Size() || len(infos[1].Files) != len(info2.Files) || !infos[1].Timestamp.Equal(info2.Timestamp.Round(time.Duration(0))) {
+		t.Errorf("List: got %+v, want %+v", infos[1], info2)
+	}
+	for _, info := range infos {
+		if err := e.Verify(info.ID); err != nil {
+			t.Errorf("Verify(%d): got error: %v", info.ID, err)
+		}
+	}
+
+	dir1 := filepath.Join(temp, "restore1")
+	if err := e.Restore(info1.ID, dir1); err != nil {
+		t.Fatal("Restore: got error: ", err)
+	}
+	if err := e.Restore(info1.ID, dir1); err != ErrRestoreExist {
+		t.Errorf("Restore: expect ErrRestoreExist, got %v", err)
+	}
+	checkDB(t, dir1, want1)
+
+	// Purge the first backup; its tables still used by the second backup
+	// are kept.
+	if err := e.Purge(1); err != nil {
+		t.Fatal("Purge: got error: ", err)
+	}
+	if err := e.Verify(info1.ID); err != ErrNotFound {
+		t.Errorf("Verify: expect ErrNotFound, got %v", err)
+	}
+	if n := sharedFiles(t, e); n != n2 {
+		t.Errorf("Purge: got %d shared files, want %d", n, n2)
+	}
+	dir2 := filepath.Join(temp, "restore2")
+	if err := e.Restore(info2.ID, dir2); err != nil {
+		t.Fatal("Restore: got error: ", err)
+	}
+	checkDB(t, dir2, want2)
+
+	// Reopen the repository.
+	e.Close()
+	if _, err := e.List(); err != ErrClosed {
+		t.Errorf("List: expect ErrClosed, got %v", err)
+	}
+	if e, err = Open(filepath.Join(temp, "backup")); err != nil {
+		t.Fatal("Open: got error: ", err)
+	}
+	if err := e.Delete(info2.ID); err != nil {
+		t.Fatal("Delete: got error: ", err)
+	}
+	if n := sharedFiles(t, e); n != 0 {
+		t.Errorf("Delete: got %d shared files, want 0", n)
+	}
+}
+
+func TestEngine_Verify(t *testing.T) {
+	temp := tempDir(t)
+	defer os.RemoveAll(temp)
+
+	db, err := leveldb.OpenFile(filepath.Join(temp, "db"), nil)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+	e, err := Open(filepath.Join(temp, "backup"))
+	if err != nil {
+		t.Fatal("Open: got error: ", err)
+	}
+	defer e.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("k%03d", i)), bytes.Repeat([]byte("v"), 100), nil); err != nil {
+			t.Fatal("Put: got error: ", err)
+		}
+	}
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	info, err := e.CreateBackup(db)
+	if err != nil {
+		t.Fatal("CreateBackup: got error: ", err)
+	}
+
+	var table File
+	for _, f := range info.Files {
+		if f.Fd.Type == storage.TypeTable {
+			table = f
+		}
+	}
+	if !table.Shared {
+		t.Fatal("no shared table in backup")
+	}
+
+	// Corrupt the table. The repository files are hard-linked to the DB
+	// tables, so a copy is modified.
+	path := e.filePath(info.ID, table)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal("ReadFile: got error: ", err)
+	}
+	data[10] ^= 0xff
+	if err := os.Remove(path); err != nil {
+		t.Fatal("Remove: got error: ", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal("WriteFile: got error: ", err)
+	}
+	err = e.Verify(info.ID)
+	if cerr, ok := err.(*ErrCorrupted); !ok || cerr.Fd != table.Fd {
+		t.Errorf("Verify: expect ErrCorrupted of %s, got %v", table.Fd, err)
+	}
+	if err := e.Restore(info.ID, filepath.Join(temp, "restore")); err == nil {
+		t.Error("Restore: expect error")
+	}
+	if _, err := os.Stat(filepath.Join(temp, "restore")); !os.IsNotExist(err) {
+		t.Errorf("Restore: directory is not removed, got %v", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal("Remove: got error: ", err)
+	}
+	if err, ok := e.Verify(info.ID).(*ErrCorrupted); !ok || err.Reason != "file missing" {
+		t.Errorf("Verify: expect missing file, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package backup
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/3JoB/goleveldb/storage"
+)
+
+/*
+Backup metadata is a text file, the first line is the format version
+followed by the backup timestamp and a line per file:
+
+	leveldb-backup 1
+	timestamp UNIX-NANO
+	file TYPE NUM SIZE CRC SHARED
+	...
+
+The TYPE is the storage.FileType, the CRC is hexadecimal and the SHARED is
+either 0 or 1.
+*/
+
+const infoVersion = 1
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func encodeInfo(w io.Writer, info *Info) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "leveldb-backup %d\n", infoVersion)
+	fmt.Fprintf(bw, "timestamp %d\n", info.Timestamp.UnixNano())
+	for _, f := range info.Files {
+		var shared int
+		if f.Shared {
+			shared = 1
+		}
+		fmt.Fprintf(bw, "file %d %d %d %08x %d\n", f.Fd.Type, f.Fd.Num, f.Size, f.Checksum, shared)
+	}
+	return bw.Flush()
+}
+
+func decodeInfo(r io.Reader) (*Info, error) {
+	info := &Info{}
+	scanner := bufio.NewScanner(r)
+	for i := 0; scanner.Scan(); i++ {
+		line := scanner.Text()
+		switch {
+		case i == 0:
+			var version int
+			if _, err := fmt.Sscanf(line, "leveldb-backup %d", &version); err != nil || version != infoVersion {
+				return nil, fmt.Errorf("leveldb/backup: invalid metadata header %q", line)
+			}
+		case i == 1:
+			var ns int64
+			if _, err := fmt.Sscanf(line, "timestamp %d", &ns); err != nil {
+				return nil, fmt.Errorf("leveldb/backup: invalid metadata timestamp %q", line)
+			}
+			info.Timestamp = time.Unix(0, ns)
+		default:
+			var (
+				f      File
+				shared int
+			)
+			if _, err := fmt.Sscanf(line, "file %d %d %d %x %d", &f.Fd.Type, &f.Fd.Num, &f.Size, &f.Checksum, &shared); err != nil || !storage.FileDescOk(f.Fd) {
+				return nil, fmt.Errorf("leveldb/backup: invalid metadata file %q", line)
+			}
+			f.Shared = shared != 0
+			info.Files = append(info.Files, f)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Copies src into dst, returns size and checksum of the copied content.
+func copyChecksum(dst io.Writer, src io.Reader) (size int64, crc uint32, err error) {
+	h := crc32.New(crcTable)
+	size, err = io.Copy(io.MultiWriter(dst, h), src)
+	return size, h.Sum32(), err
+}
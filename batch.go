@@ -10,6 +10,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 
 	"github.com/3JoB/goleveldb/errors"
 	"github.com/3JoB/goleveldb/memdb"
@@ -33,6 +34,23 @@ func newErrBatchCorrupted(reason string) error {
 const (
 	batchHeaderLen = 8 + 4
 	batchGrowLimit = 3000
+
+	// batchFlagColumnFamily is or'ed into record type of a column family
+	// record, which is followed by the column family id.
+	batchFlagColumnFamily = 0x80
+
+	// batchFlagMarker is set in record type of a two-phase commit marker,
+	// which is followed by the transaction name. Markers aren't indexed.
+	batchFlagMarker = 0x40
+)
+
+// batchMarker is the record type of a two-phase commit marker.
+type batchMarker byte
+
+const (
+	batchMarkerPrepare  = batchMarker(batchFlagMarker | 1)
+	batchMarkerCommit   = batchMarker(batchFlagMarker | 2)
+	batchMarkerRollback = batchMarker(batchFlagMarker | 3)
 )
 
 // BatchReplay wraps basic batch operations.
@@ -41,7 +59,30 @@ type BatchReplay interface {
 	Delete(key []byte)
 }
 
+// ColumnFamilyBatchReplay wraps column family batch operations. Records
+// written using PutCF or DeleteCF are only replayed if the BatchReplay
+// also implements ColumnFamilyBatchReplay, otherwise they are skipped.
+type ColumnFamilyBatchReplay interface {
+	PutCF(cf uint32, key, value []byte)
+	DeleteCF(cf uint32, key []byte)
+}
+
+// MergeBatchReplay wraps merge batch operation. Records written using Merge
+// are only replayed if the BatchReplay also implements MergeBatchReplay,
+// otherwise they are skipped.
+type MergeBatchReplay interface {
+	Merge(key, operand []byte)
+}
+
+// RangeDeleteBatchReplay wraps range deletion batch operation. Records
+// written using DeleteRange are only replayed if the BatchReplay also
+// implements RangeDeleteBatchReplay, otherwise they are skipped.
+type RangeDeleteBatchReplay interface {
+	DeleteRange(start, limit []byte)
+}
+
 type batchIndex struct {
+	cf                 uint32
 	keyType            keyType
 	keyPos, keyLen     int
 	valuePos, valueLen int
@@ -71,6 +112,14 @@ type Batch struct {
 	//
 	// batchGrowLimit is used as the default threshold if it's not configured.
 	growLimit int
+
+	// savePoints is the stack of savepoints, most recent last.
+	savePoints []batchSavePoint
+}
+
+// batchSavePoint records the batch length at the time a savepoint is set.
+type batchSavePoint struct {
+	dataLen, indexLen, internalLen int
 }
 
 func (b *Batch) grow(n int) {
@@ -90,22 +139,31 @@ func (b *Batch) grow(n int) {
 	}
 }
 
-func (b *Batch) appendRec(kt keyType, key, value []byte) {
+func (b *Batch) appendRec(cf uint32, kt keyType, key, value []byte) {
 	n := 1 + binary.MaxVarintLen32 + len(key)
-	if kt == keyTypeVal {
+	if cf != 0 {
+		n += binary.MaxVarintLen32
+	}
+	if kt.hasValue() {
 		n += binary.MaxVarintLen32 + len(value)
 	}
 	b.grow(n)
-	index := batchIndex{keyType: kt}
+	index := batchIndex{cf: cf, keyType: kt}
 	o := len(b.data)
 	data := b.data[:o+n]
-	data[o] = byte(kt)
-	o++
+	if cf != 0 {
+		data[o] = byte(kt) | batchFlagColumnFamily
+		o++
+		o += binary.PutUvarint(data[o:], uint64(cf))
+	} else {
+		data[o] = byte(kt)
+		o++
+	}
 	o += binary.PutUvarint(data[o:], uint64(len(key)))
 	index.keyPos = o
 	index.keyLen = len(key)
 	o += copy(data[o:], key)
-	if kt == keyTypeVal {
+	if kt.hasValue() {
 		o += binary.PutUvarint(data[o:], uint64(len(value)))
 		index.valuePos = o
 		index.valueLen = len(value)
@@ -116,18 +174,66 @@ func (b *Batch) appendRec(kt keyType, key, value []byte) {
 	b.internalLen += index.keyLen + index.valueLen + 8
 }
 
+// Appends a two-phase commit marker; it isn't indexed, so it is only seen by
+// the journal.
+func (b *Batch) appendMarker(m batchMarker, name string) {
+	n := 1 + binary.MaxVarintLen32 + len(name)
+	b.grow(n)
+	o := len(b.data)
+	data := b.data[:o+n]
+	data[o] = byte(m)
+	o++
+	o += binary.PutUvarint(data[o:], uint64(len(name)))
+	o += copy(data[o:], name)
+	b.data = data[:o]
+}
+
 // Put appends 'put operation' of the given key/value pair to the batch.
 // It is safe to modify the contents of the argument after Put returns but not
 // before.
 func (b *Batch) Put(key, value []byte) {
-	b.appendRec(keyTypeVal, key, value)
+	b.appendRec(0, keyTypeVal, key, value)
 }
 
 // Delete appends 'delete operation' of the given key to the batch.
 // It is safe to modify the contents of the argument after Delete returns but
 // not before.
 func (b *Batch) Delete(key []byte) {
-	b.appendRec(keyTypeDel, key, nil)
+	b.appendRec(0, keyTypeDel, key, nil)
+}
+
+// DeleteRange appends 'range delete operation' of the given key range to the
+// batch. The range covers keys from start (inclusive) to limit (exclusive);
+// it is ignored when written if start is not less than limit.
+// It is safe to modify the contents of the argument after DeleteRange returns
+// but not before.
+func (b *Batch) DeleteRange(start, limit []byte) {
+	b.appendRec(0, keyTypeRangeDel, start, limit)
+}
+
+// Merge appends 'merge operation' of the given key/operand pair to the batch.
+// The operand is combined with the existing value of the key using the merge
+// operator, see opt.Options.MergeOperator.
+// It is safe to modify the contents of the argument after Merge returns but
+// not before.
+func (b *Batch) Merge(key, operand []byte) {
+	b.appendRec(0, keyTypeMerge, key, operand)
+}
+
+// PutCF appends 'put operation' of the given key/value pair on the given
+// column family to the batch.
+// It is safe to modify the contents of the argument after PutCF returns but
+// not before.
+func (b *Batch) PutCF(cf *ColumnFamily, key, value []byte) {
+	b.appendRec(cf.ID(), keyTypeVal, key, value)
+}
+
+// DeleteCF appends 'delete operation' of the given key on the given column
+// family to the batch.
+// It is safe to modify the contents of the argument after DeleteCF returns
+// but not before.
+func (b *Batch) DeleteCF(cf *ColumnFamily, key []byte) {
+	b.appendRec(cf.ID(), keyTypeDel, key, nil)
 }
 
 // Dump dumps batch contents. The returned slice can be loaded into the
@@ -148,17 +254,77 @@ func (b *Batch) Load(data []byte) error {
 
 // Replay replays batch contents.
 func (b *Batch) Replay(r BatchReplay) error {
+	rcf, _ := r.(ColumnFamilyBatchReplay)
+	rrd, _ := r.(RangeDeleteBatchReplay)
+	rmg, _ := r.(MergeBatchReplay)
 	for _, index := range b.index {
+		if index.cf != 0 {
+			if rcf != nil {
+				switch index.keyType {
+				case keyTypeVal:
+					rcf.PutCF(index.cf, index.k(b.data), index.v(b.data))
+				case keyTypeDel:
+					rcf.DeleteCF(index.cf, index.k(b.data))
+				}
+			}
+			continue
+		}
 		switch index.keyType {
 		case keyTypeVal:
 			r.Put(index.k(b.data), index.v(b.data))
 		case keyTypeDel:
 			r.Delete(index.k(b.data))
+		case keyTypeRangeDel:
+			if rrd != nil {
+				rrd.DeleteRange(index.k(b.data), index.v(b.data))
+			}
+		case keyTypeMerge:
+			if rmg != nil {
+				rmg.Merge(index.k(b.data), index.v(b.data))
+			}
 		}
 	}
 	return nil
 }
 
+// SetSavePoint records the current state of the batch, so that records
+// appended afterward can be undone with RollbackToSavePoint. Savepoints can
+// be nested.
+func (b *Batch) SetSavePoint() {
+	b.savePoints = append(b.savePoints, batchSavePoint{
+		dataLen:     len(b.data),
+		indexLen:    len(b.index),
+		internalLen: b.internalLen,
+	})
+}
+
+// RollbackToSavePoint removes the records appended since the most recent
+// savepoint, and removes that savepoint. It returns ErrNoSavePoint if there
+// is no savepoint.
+func (b *Batch) RollbackToSavePoint() error {
+	n := len(b.savePoints)
+	if n == 0 {
+		return ErrNoSavePoint
+	}
+	sp := b.savePoints[n-1]
+	b.savePoints = b.savePoints[:n-1]
+	b.data = b.data[:sp.dataLen]
+	b.index = b.index[:sp.indexLen]
+	b.internalLen = sp.internalLen
+	return nil
+}
+
+// PopSavePoint removes the most recent savepoint without rolling back the
+// batch. It returns ErrNoSavePoint if there is no savepoint.
+func (b *Batch) PopSavePoint() error {
+	n := len(b.savePoints)
+	if n == 0 {
+		return ErrNoSavePoint
+	}
+	b.savePoints = b.savePoints[:n-1]
+	return nil
+}
+
 // Len returns number of records in the batch.
 func (b *Batch) Len() int {
 	return len(b.index)
@@ -169,6 +335,37 @@ func (b *Batch) Reset() {
 	b.data = b.data[:0]
 	b.index = b.index[:0]
 	b.internalLen = 0
+	b.savePoints = b.savePoints[:0]
+}
+
+// hasColumnFamily returns whether the batch contains column family records.
+func (b *Batch) hasColumnFamily() bool {
+	for _, index := range b.index {
+		if index.cf != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRangeDel returns whether the batch contains range deletion records.
+func (b *Batch) hasRangeDel() bool {
+	for _, index := range b.index {
+		if index.keyType == keyTypeRangeDel {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMerge returns whether the batch contains merge records.
+func (b *Batch) hasMerge() bool {
+	for _, index := range b.index {
+		if index.keyType == keyTypeMerge {
+			return true
+		}
+	}
+	return false
 }
 
 func (b *Batch) replayInternal(fn func(i int, kt keyType, k, v []byte) error) error {
@@ -203,6 +400,7 @@ func (b *Batch) decode(data []byte, expectedLen int) error {
 	b.data = data
 	b.index = b.index[:0]
 	b.internalLen = 0
+	b.savePoints = b.savePoints[:0]
 	err := decodeBatch(data, func(i int, index batchIndex) error {
 		b.index = append(b.index, index)
 		b.internalLen += index.keyLen + index.valueLen + 8
@@ -217,11 +415,27 @@ func (b *Batch) decode(data []byte, expectedLen int) error {
 	return nil
 }
 
-func (b *Batch) putMem(seq uint64, mdb *memdb.DB) error {
+// familyMemFunc returns memdb of the given column family for a record with
+// the given sequence number. It returns nil if the record should be skipped.
+type familyMemFunc func(cf uint32, seq uint64) *memdb.DB
+
+func (b *Batch) putMem(icmp *iComparer, seq uint64, mdb, rdel *memdb.DB, fmdb familyMemFunc) error {
 	var ik []byte
 	for i, index := range b.index {
+		m := mdb
+		if index.cf != 0 {
+			if m = fmdb(index.cf, seq+uint64(i)); m == nil {
+				continue
+			}
+		} else if index.keyType == keyTypeRangeDel {
+			// Empty or inverted ranges delete nothing.
+			if icmp.uCompare(index.k(b.data), index.v(b.data)) >= 0 {
+				continue
+			}
+			m = rdel
+		}
 		ik = makeInternalKey(ik, index.k(b.data), seq+uint64(i), index.keyType)
-		if err := mdb.Put(ik, index.v(b.data)); err != nil {
+		if err := m.Put(ik, index.v(b.data)); err != nil {
 			return err
 		}
 	}
@@ -276,13 +490,37 @@ func MakeBatchWithConfig(config *BatchConfig) *Batch {
 func decodeBatch(data []byte, fn func(i int, index batchIndex) error) error {
 	var index batchIndex
 	for i, o := 0, 0; o < len(data); i++ {
+		// Two-phase commit marker.
+		if data[o]&batchFlagMarker != 0 {
+			_, _, n, err := decodeBatchMarker(data[o:])
+			if err != nil {
+				return err
+			}
+			o += n
+			i--
+			continue
+		}
+
 		// Key type.
-		index.keyType = keyType(data[o])
-		if index.keyType > keyTypeVal {
+		index.keyType = keyType(data[o] &^ batchFlagColumnFamily)
+		if index.keyType > keyTypeMax {
 			return newErrBatchCorrupted(fmt.Sprintf("bad record: invalid type %#x", uint(index.keyType)))
 		}
+		hasCF := data[o]&batchFlagColumnFamily != 0
 		o++
 
+		// Column family.
+		if hasCF {
+			x, n := binary.Uvarint(data[o:])
+			o += n
+			if n <= 0 || x == 0 || x > math.MaxUint32 || index.keyType == keyTypeRangeDel || index.keyType == keyTypeMerge {
+				return newErrBatchCorrupted("bad record: invalid column family")
+			}
+			index.cf = uint32(x)
+		} else {
+			index.cf = 0
+		}
+
 		// Key.
 		x, n := binary.Uvarint(data[o:])
 		o += n
@@ -294,7 +532,7 @@ func decodeBatch(data []byte, fn func(i int, index batchIndex) error) error {
 		o += index.keyLen
 
 		// Value.
-		if index.keyType == keyTypeVal {
+		if index.keyType.hasValue() {
 			x, n = binary.Uvarint(data[o:])
 			o += n
 			if n <= 0 || o+int(x) > len(data) {
@@ -315,7 +553,26 @@ func decodeBatch(data []byte, fn func(i int, index batchIndex) error) error {
 	return nil
 }
 
-func decodeBatchToMem(data []byte, expectSeq uint64, mdb *memdb.DB) (seq uint64, batchLen int, err error) {
+// Decodes the two-phase commit marker at the beginning of the given batch
+// data, if any. It returns the number of bytes read.
+func decodeBatchMarker(data []byte) (m batchMarker, name []byte, n int, err error) {
+	if len(data) == 0 || data[0]&batchFlagMarker == 0 {
+		return 0, nil, 0, nil
+	}
+	m = batchMarker(data[0])
+	if m < batchMarkerPrepare || m > batchMarkerRollback {
+		return 0, nil, 0, newErrBatchCorrupted(fmt.Sprintf("bad record: invalid marker %#x", uint(m)))
+	}
+	x, vn := binary.Uvarint(data[1:])
+	n = 1 + vn
+	if vn <= 0 || n+int(x) > len(data) {
+		return 0, nil, 0, newErrBatchCorrupted("bad record: invalid marker name length")
+	}
+	name = data[n : n+int(x)]
+	return m, name, n + int(x), nil
+}
+
+func decodeBatchToMem(icmp *iComparer, data []byte, expectSeq uint64, mdb, rdel *memdb.DB, fmdb familyMemFunc) (seq uint64, batchLen int, err error) {
 	seq, batchLen, err = decodeBatchHeader(data)
 	if err != nil {
 		return 0, 0, err
@@ -330,11 +587,22 @@ func decodeBatchToMem(data []byte, expectSeq uint64, mdb *memdb.DB) (seq uint64,
 		if i >= batchLen {
 			return newErrBatchCorrupted("invalid records length")
 		}
+		decodedLen++
+		m := mdb
+		if index.cf != 0 {
+			if m = fmdb(index.cf, seq+uint64(i)); m == nil {
+				return nil
+			}
+		} else if index.keyType == keyTypeRangeDel {
+			if icmp.uCompare(index.k(data), index.v(data)) >= 0 {
+				return nil
+			}
+			m = rdel
+		}
 		ik = makeInternalKey(ik, index.k(data), seq+uint64(i), index.keyType)
-		if err := mdb.Put(ik, index.v(data)); err != nil {
+		if err := m.Put(ik, index.v(data)); err != nil {
 			return err
 		}
-		decodedLen++
 		return nil
 	})
 	if err == nil && decodedLen != batchLen {
@@ -176,3 +176,47 @@ func benchmarkBatchWrite(b *testing.B, config *BatchConfig) {
 	}
 	b.ReportAllocs()
 }
+
+func TestBatch_SavePoint(t *testing.T) {
+	batch := new(Batch)
+	if err := batch.RollbackToSavePoint(); err != ErrNoSavePoint {
+		t.Errorf("RollbackToSavePoint: expect ErrNoSavePoint, got %v", err)
+	}
+	batch.Put([]byte("a"), []byte("1"))
+	batch.SetSavePoint()
+	batch.Put([]byte("b"), []byte("2"))
+	batch.SetSavePoint()
+	batch.Delete([]byte("c"))
+	batch.SetSavePoint()
+	if err := batch.PopSavePoint(); err != nil {
+		t.Fatal("PopSavePoint: got error: ", err)
+	}
+	if err := batch.RollbackToSavePoint(); err != nil {
+		t.Fatal("RollbackToSavePoint: got error: ", err)
+	}
+	if n := batch.Len(); n != 2 {
+		t.Errorf("Len: got %d, want 2", n)
+	}
+	batch.Put([]byte("d"), []byte("4"))
+	if err := batch.RollbackToSavePoint(); err != nil {
+		t.Fatal("RollbackToSavePoint: got error: ", err)
+	}
+	if err := batch.RollbackToSavePoint(); err != ErrNoSavePoint {
+		t.Errorf("RollbackToSavePoint: expect ErrNoSavePoint, got %v", err)
+	}
+
+	want := new(Batch)
+	want.Put([]byte("a"), []byte("1"))
+	if !bytes.Equal(batch.Dump(), want.Dump()) {
+		t.Errorf("Dump: got %q, want %q", batch.Dump(), want.Dump())
+	}
+	if batch.internalLen != want.internalLen {
+		t.Errorf("internalLen: got %d, want %d", batch.internalLen, want.internalLen)
+	}
+
+	batch.SetSavePoint()
+	batch.Reset()
+	if err := batch.PopSavePoint(); err != ErrNoSavePoint {
+		t.Errorf("PopSavePoint: expect ErrNoSavePoint, got %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"os"
+	"sort"
+
+	"github.com/3JoB/goleveldb/journal"
+	"github.com/3JoB/goleveldb/storage"
+)
+
+// checkpointEntry is an unflushed memdb entry written into the journal of
+// a checkpoint.
+type checkpointEntry struct {
+	cf    uint32
+	seq   uint64
+	kt    keyType
+	key   []byte
+	value []byte
+}
+
+// checkpoint holds the state of the DB captured by a checkpoint.
+type checkpoint struct {
+	manifestFd storage.FileDesc
+	journalFd  storage.FileDesc
+	versions   []*version
+}
+
+func (cp *checkpoint) release() {
+	for _, v := range cp.versions {
+		v.release()
+	}
+	cp.versions = nil
+}
+
+// Appends entries of the given memdb with sequence number greater than
+// minSeq.
+func appendCheckpointEntries(entries []checkpointEntry, mdb *memDB, rdel bool, minSeq uint64) []checkpointEntry {
+	if mdb == nil {
+		return entries
+	}
+	m := mdb.DB
+	if rdel {
+		if m = mdb.rdel; m == nil {
+			return entries
+		}
+	}
+	iter := m.NewIterator(nil)
+	defer iter.Release()
+	for iter.Next() {
+		ukey, seq, kt, kerr := parseInternalKey(iter.Key())
+		if kerr != nil || seq <= minSeq {
+			continue
+		}
+		entries = append(entries, checkpointEntry{cf: mdb.cf, seq: seq, kt: kt, key: ukey, value: iter.Value()})
+	}
+	return entries
+}
+
+// Writes the prepare records of unresolved transactions and the unflushed
+// memdb entries into the journal of the checkpoint; need write lock and
+// compaction commit lock.
+func (db *DB) writeCheckpointJournal(dst storage.Storage, fd storage.FileDesc) error {
+	var entries []checkpointEntry
+	em, fm := db.getMems()
+	defer em.decref()
+	if fm != nil {
+		defer fm.decref()
+	}
+	// Entries of the default column family up to the last flushed sequence
+	// number are already in the tables.
+	for _, mdb := range []*memDB{fm, em} {
+		entries = appendCheckpointEntries(entries, mdb, false, db.s.stSeqNum)
+		entries = appendCheckpointEntries(entries, mdb, true, db.s.stSeqNum)
+	}
+	db.cfMu.RLock()
+	for _, cf := range db.cfs {
+		if cf.s.cfDropped {
+			continue
+		}
+		cem, cfm := cf.getMems()
+		for _, mdb := range []*memDB{cfm, cem} {
+			entries = appendCheckpointEntries(entries, mdb, false, cf.s.stSeqNum)
+			if mdb != nil {
+				defer mdb.decref()
+			}
+		}
+	}
+	db.cfMu.RUnlock()
+	// Sequence number of the batches must be increasing.
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+
+	writer, err := dst.Create(fd)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+	jw := journal.NewWriter(writer)
+
+	// Prepared transactions are only held by the journal.
+	db.preparedMu.Lock()
+	for _, record := range db.prepared {
+		w, err := jw.Next()
+		if err == nil {
+			_, err = w.Write(record)
+		}
+		if err != nil {
+			db.preparedMu.Unlock()
+			return err
+		}
+	}
+	db.preparedMu.Unlock()
+
+	// Entries of consecutive sequence numbers are written as a batch.
+	var (
+		batch    = new(Batch)
+		batchSeq uint64
+	)
+	flush := func() error {
+		if batch.Len() == 0 {
+			return nil
+		}
+		w, err := jw.Next()
+		if err != nil {
+			return err
+		}
+		if err := writeBatchesWithHeader(w, []*Batch{batch}, batchSeq); err != nil {
+			return err
+		}
+		batch.Reset()
+		return nil
+	}
+	for _, e := range entries {
+		if batch.Len() > 0 && e.seq != batchSeq+uint64(batch.Len()) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if batch.Len() == 0 {
+			batchSeq = e.seq
+		}
+		batch.appendRec(e.cf, e.kt, e.key, e.value)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := jw.Close(); err != nil {
+		return err
+	}
+	return writer.Sync()
+}
+
+// Captures the current state of the DB, and writes the manifest and the
+// journal of the checkpoint into dst.
+func (db *DB) captureCheckpoint(dst storage.Storage) (cp *checkpoint, err error) {
+	// Hold write lock, so that the memdbs won't be written or rotated.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return nil, err
+	case <-db.closeC:
+		return nil, ErrClosed
+	}
+	defer func() { <-db.writeLockC }()
+
+	// Hold compaction commit lock, so that the manifest won't be written.
+	db.compCommitLk.Lock()
+	defer db.compCommitLk.Unlock()
+
+	cp = &checkpoint{
+		manifestFd: storage.FileDesc{Type: storage.TypeManifest, Num: db.s.allocFileNum()},
+		journalFd:  storage.FileDesc{Type: storage.TypeJournal, Num: db.s.allocFileNum()},
+	}
+	defer func() {
+		if err != nil {
+			cp.release()
+		}
+	}()
+
+	if err := db.writeCheckpointJournal(dst, cp.journalFd); err != nil {
+		return nil, err
+	}
+
+	writer, err := dst.Create(cp.manifestFd)
+	if err != nil {
+		return nil, err
+	}
+	defer writer.Close()
+	jw := journal.NewWriter(writer)
+
+	v := db.s.version()
+	cp.versions = append(cp.versions, v)
+	rec := &sessionRecord{}
+	rec.setJournalNum(cp.journalFd.Num)
+	db.s.fillRecord(rec, true)
+	v.fillRecord(rec)
+	w, err := jw.Next()
+	if err != nil {
+		return nil, err
+	}
+	if err := rec.encode(w); err != nil {
+		return nil, err
+	}
+	for _, fs := range db.s.liveFamilies() {
+		cp.versions = append(cp.versions, fs.version())
+		if err := fs.writeSnapshot(jw); err != nil {
+			return nil, err
+		}
+	}
+	if err := jw.Flush(); err != nil {
+		return nil, err
+	}
+	if err := writer.Sync(); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// Checkpoint creates a consistent copy of the DB in the given directory,
+// while the DB remains open for reads and writes. The directory must not
+// exist, and it can then be opened using OpenFile.
+//
+// The tables are hard-linked into the directory when the DB storage supports
+// it (see storage.Linker), otherwise they are copied. Unflushed writes are
+// written into a fresh journal. File removals are deferred until the
+// checkpoint is done.
+func (db *DB) Checkpoint(dir string) (err error) {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return ErrCheckpointExist
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	dst, err := storage.OpenFile(dir, false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		dst.Close()
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	// Live tables mustn't be removed until they are linked or copied.
+	unfreeze := db.s.stor.freezeRemove()
+	defer unfreeze()
+
+	cp, err := db.captureCheckpoint(dst)
+	if err != nil {
+		return err
+	}
+	defer cp.release()
+
+	linker, _ := db.s.stor.Storage.(storage.Linker)
+	for _, v := range cp.versions {
+		for _, tables := range v.levels {
+			for _, t := range tables {
+				if linker != nil && linker.Link(t.fd, dir) == nil {
+					continue
+				}
+				if err := v.s.tops.copyTo(t, dst); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := dst.SetMeta(cp.manifestFd); err != nil {
+		return err
+	}
+
+	db.logf("db@checkpoint done D·%s M·%d J·%d", dir, cp.manifestFd.Num, cp.journalFd.Num)
+	return nil
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/3JoB/goleveldb/storage"
+	"github.com/3JoB/goleveldb/util"
+)
+
+func checkpointDir(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "goleveldb-checkpoint-")
+	if err != nil {
+		t.Fatal("MkdirTemp: got error: ", err)
+	}
+	return dir
+}
+
+// Opens the checkpoint in the given directory and checks its content.
+func checkCheckpoint(t *testing.T, dir string, want map[string]string, cfWant map[string]string) {
+	db, err := OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+
+	got := make(map[string]string)
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		got[string(iter.Key())] = string(iter.Value())
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		t.Fatal("Iterator: got error: ", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("checkpoint content mismatch, want=%v got=%v", want, got)
+	}
+
+	if cfWant != nil {
+		cf, err := db.ColumnFamily("cf")
+		if err != nil {
+			t.Fatal("ColumnFamily: got error: ", err)
+		}
+		for k, v := range cfWant {
+			if got, err := cf.Get([]byte(k), nil); err != nil || string(got) != v {
+				t.Errorf("ColumnFamily.Get(%q): got (%q, %v), want %q", k, got, err, v)
+			}
+		}
+	}
+}
+
+func TestDB_Checkpoint(t *testing.T) {
+	temp := checkpointDir(t)
+	defer os.RemoveAll(temp)
+
+	db, err := OpenFile(filepath.Join(temp, "db"), nil)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+	cf, err := db.CreateColumnFamily("cf", nil)
+	if err != nil {
+		t.Fatal("CreateColumnFamily: got error: ", err)
+	}
+
+	want := make(map[string]string)
+	put := func(key, value string) {
+		if err := db.Put([]byte(key), []byte(value), nil); err != nil {
+			t.Fatal("Put: got error: ", err)
+		}
+		want[key] = value
+	}
+	for i := 0; i < 100; i++ {
+		put(fmt.Sprintf("k%03d", i), "v1")
+	}
+	if err := cf.Put([]byte("a"), []byte("cf1"), nil); err != nil {
+		t.Fatal("ColumnFamily.Put: got error: ", err)
+	}
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	// These are only in the journal.
+	for i := 0; i < 100; i += 2 {
+		put(fmt.Sprintf("k%03d", i), "v2")
+	}
+	if err := cf.Put([]byte("b"), []byte("cf2"), nil); err != nil {
+		t.Fatal("ColumnFamily.Put: got error: ", err)
+	}
+
+	dir := filepath.Join(temp, "checkpoint")
+	if err := db.Checkpoint(dir); err != nil {
+		t.Fatal("Checkpoint: got error: ", err)
+	}
+	if err := db.Checkpoint(dir); err != ErrCheckpointExist {
+		t.Errorf("Checkpoint: expect ErrCheckpointExist, got %v", err)
+	}
+
+	// Writes after the checkpoint aren't visible in the checkpoint, and
+	// table removal doesn't affect the linked tables.
+	if err := db.Put([]byte("k000"), []byte("v3"), nil); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	checkCheckpoint(t, dir, want, map[string]string{"a": "cf1", "b": "cf2"})
+
+	// The checkpoint of the checkpoint.
+	cdb, err := OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	dir2 := filepath.Join(temp, "checkpoint2")
+	if err := cdb.Checkpoint(dir2); err != nil {
+		t.Fatal("Checkpoint: got error: ", err)
+	}
+	cdb.Close()
+	checkCheckpoint(t, dir2, want, nil)
+}
+
+func TestDB_Checkpoint_CopyTables(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	temp := checkpointDir(t)
+	defer os.RemoveAll(temp)
+
+	want := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("k%d", i)
+		h.put(key, "v1")
+		want[key] = "v1"
+		if i%3 == 0 {
+			h.compactMem()
+		}
+	}
+	h.delete("k0")
+	delete(want, "k0")
+	h.deleteRange("k8", "k9")
+	delete(want, "k8")
+
+	dir := filepath.Join(temp, "checkpoint")
+	if err := h.db.Checkpoint(dir); err != nil {
+		t.Fatal("Checkpoint: got error: ", err)
+	}
+	h.put("k1", "v2")
+	h.compactRange("", "")
+	checkCheckpoint(t, dir, want, nil)
+}
+
+func TestDB_Checkpoint_PreparedTransaction(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	temp := checkpointDir(t)
+	defer os.RemoveAll(temp)
+
+	h.put("a", "v1")
+	tr := h.openPessimisticTransaction(nil)
+	tr.Put([]byte("b"), []byte("tr1"))
+	if err := tr.Prepare("tr1"); err != nil {
+		t.Fatal("Prepare: got error: ", err)
+	}
+
+	dir := filepath.Join(temp, "checkpoint")
+	if err := h.db.Checkpoint(dir); err != nil {
+		t.Fatal("Checkpoint: got error: ", err)
+	}
+	if err := tr.Rollback(); err != nil {
+		t.Fatal("Rollback: got error: ", err)
+	}
+
+	// The transaction is still prepared in the checkpoint.
+	db, err := OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+	trs := db.PreparedTransactions()
+	if len(trs) != 1 || trs[0].Name() != "tr1" {
+		t.Fatalf("PreparedTransactions: got %d transactions, want tr1", len(trs))
+	}
+	if err := trs[0].Commit(nil); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	for k, want := range map[string]string{"a": "v1", "b": "tr1"} {
+		if v, err := db.Get([]byte(k), nil); err != nil || string(v) != want {
+			t.Errorf("Get(%q): got (%q, %v), want %q", k, v, err, want)
+		}
+	}
+}
+
+func TestIStorage_FreezeRemove(t *testing.T) {
+	stor := newIStorage(storage.NewMemStorage())
+	defer stor.Close()
+	fd := storage.FileDesc{Type: storage.TypeTable, Num: 1}
+	w, err := stor.Create(fd)
+	if err != nil {
+		t.Fatal("Create: got error: ", err)
+	}
+	w.Close()
+
+	unfreeze1 := stor.freezeRemove()
+	unfreeze2 := stor.freezeRemove()
+	if err := stor.Remove(fd); err != nil {
+		t.Fatal("Remove: got error: ", err)
+	}
+	unfreeze1()
+	unfreeze1()
+	if !stor.removeFrozen() {
+		t.Error("removal is not frozen")
+	}
+	if fds, _ := stor.List(storage.TypeTable); len(fds) != 1 {
+		t.Errorf("file removed while frozen: %v", fds)
+	}
+	unfreeze2()
+	if fds, _ := stor.List(storage.TypeTable); len(fds) != 0 {
+		t.Errorf("file not removed after unfreeze: %v", fds)
+	}
+}
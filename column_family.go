@@ -0,0 +1,731 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/3JoB/goleveldb/iterator"
+	"github.com/3JoB/goleveldb/memdb"
+	"github.com/3JoB/goleveldb/opt"
+	"github.com/3JoB/goleveldb/util"
+)
+
+var (
+	errInvalidColumnFamilyName = errors.New("leveldb: invalid column family name")
+)
+
+// ColumnFamily is a handle of a column family. A column family is a
+// separate key space within the DB, with its own comparer, filter,
+// compression and compaction options and its own set of 'sorted tables'.
+// All column families share the journal and sequence numbers with the DB,
+// so a Batch written across column families is applied atomically, and
+// snapshots cover every column family.
+//
+// The DB itself acts as the default column family. Options of existing
+// column families must be given using Options.ColumnFamilies when the DB
+// is opened.
+//
+// ColumnFamily is safe for concurrent use. The handle is valid until the
+// column family is dropped or the DB is closed.
+type ColumnFamily struct {
+	db *DB
+	s  *session
+
+	// Guarded by db.memMu.
+	mem, frozenMem *memDB
+
+	dropped   uint32
+	compStats cStats
+}
+
+// ID returns id of the column family.
+func (cf *ColumnFamily) ID() uint32 {
+	return cf.s.cfID
+}
+
+// Name returns name of the column family.
+func (cf *ColumnFamily) Name() string {
+	return cf.s.cfName
+}
+
+func (cf *ColumnFamily) String() string {
+	return fmt.Sprintf("leveldb.ColumnFamily{%d:%s}", cf.s.cfID, cf.s.cfName)
+}
+
+// Check read ok status.
+func (cf *ColumnFamily) ok() error {
+	if err := cf.db.ok(); err != nil {
+		return err
+	}
+	if atomic.LoadUint32(&cf.dropped) != 0 {
+		return ErrColumnFamilyDropped
+	}
+	return nil
+}
+
+// Get all memdbs of the column family.
+func (cf *ColumnFamily) getMems() (e, f *memDB) {
+	cf.db.memMu.RLock()
+	defer cf.db.memMu.RUnlock()
+	if cf.mem != nil {
+		cf.mem.incref()
+	}
+	if cf.frozenMem != nil {
+		cf.frozenMem.incref()
+	}
+	return cf.mem, cf.frozenMem
+}
+
+// Get effective memdb of the column family.
+func (cf *ColumnFamily) getEffectiveMem() *memDB {
+	cf.db.memMu.RLock()
+	defer cf.db.memMu.RUnlock()
+	if cf.mem != nil {
+		cf.mem.incref()
+	}
+	return cf.mem
+}
+
+func (cf *ColumnFamily) get(key []byte, seq uint64, ro *opt.ReadOptions) (value []byte, err error) {
+	ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
+
+	mo := cf.s.o.GetMergeOperator()
+	var ops mergeOperands
+
+	em, fm := cf.getMems()
+	for _, m := range [...]*memDB{em, fm} {
+		if m == nil {
+			continue
+		}
+		defer m.decref()
+
+		if ok, mv, me := memGet(m.DB, ikey, cf.s.icmp, 0, &ops); ok {
+			return ops.resolve(mo, key, append([]byte(nil), mv...), me)
+		}
+	}
+
+	v := cf.s.version()
+	value, cSched, err := v.get(nil, ikey, ro, false, 0, &ops)
+	v.release()
+	if cSched {
+		// Trigger table compaction.
+		cf.db.compTrigger(cf.db.tcompCmdC)
+	}
+	return ops.resolve(mo, key, value, err)
+}
+
+func (cf *ColumnFamily) has(key []byte, seq uint64, ro *opt.ReadOptions) (ret bool, err error) {
+	ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
+
+	em, fm := cf.getMems()
+	for _, m := range [...]*memDB{em, fm} {
+		if m == nil {
+			continue
+		}
+		defer m.decref()
+
+		if ok, _, me := memGet(m.DB, ikey, cf.s.icmp, 0, nil); ok {
+			return me == nil, nilIfNotFound(me)
+		}
+	}
+
+	v := cf.s.version()
+	_, cSched, err := v.get(nil, ikey, ro, true, 0, nil)
+	v.release()
+	if cSched {
+		// Trigger table compaction.
+		cf.db.compTrigger(cf.db.tcompCmdC)
+	}
+	if err == nil {
+		ret = true
+	} else if err == ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+func (cf *ColumnFamily) newRawIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	strict := opt.GetStrict(cf.s.o.Options, ro, opt.StrictReader)
+	em, fm := cf.getMems()
+	if em == nil {
+		if fm != nil {
+			fm.decref()
+		}
+		return iterator.NewEmptyIterator(ErrColumnFamilyDropped)
+	}
+	v := cf.s.version()
+
+	tableIts := v.getIterators(slice, ro)
+	its := make([]iterator.Iterator, 0, len(tableIts)+2)
+
+	emi := em.NewIterator(slice)
+	emi.SetReleaser(&memdbReleaser{m: em})
+	its = append(its, emi)
+	if fm != nil {
+		fmi := fm.NewIterator(slice)
+		fmi.SetReleaser(&memdbReleaser{m: fm})
+		its = append(its, fmi)
+	}
+	its = append(its, tableIts...)
+	mi := iterator.NewMergedIterator(its, cf.s.icmp, strict)
+	mi.SetReleaser(&versionReleaser{v: v})
+	return mi
+}
+
+func (cf *ColumnFamily) newIterator(seq uint64, slice *util.Range, ro *opt.ReadOptions) *dbIter {
+	rawIter := cf.newRawIterator(makeInternalRange(slice), ro)
+	return cf.db.newDBIter(cf.s, rawIter, seq, ro)
+}
+
+// Get gets the value for the given key from the column family. It returns
+// ErrNotFound if the column family does not contains the key.
+//
+// The returned slice is its own copy, it is safe to modify the contents
+// of the returned slice.
+// It is safe to modify the contents of the argument after Get returns.
+func (cf *ColumnFamily) Get(key []byte, ro *opt.ReadOptions) (value []byte, err error) {
+	err = cf.ok()
+	if err != nil {
+		return
+	}
+
+	se := cf.db.acquireSnapshot()
+	defer cf.db.releaseSnapshot(se)
+	return cf.get(key, se.seq, ro)
+}
+
+// Has returns true if the column family does contains the given key.
+//
+// It is safe to modify the contents of the argument after Has returns.
+func (cf *ColumnFamily) Has(key []byte, ro *opt.ReadOptions) (ret bool, err error) {
+	err = cf.ok()
+	if err != nil {
+		return
+	}
+
+	se := cf.db.acquireSnapshot()
+	defer cf.db.releaseSnapshot(se)
+	return cf.has(key, se.seq, ro)
+}
+
+// NewIterator returns an iterator for the latest snapshot of the column
+// family. See DB.NewIterator for the iterator semantics.
+//
+// The iterator must be released after use, by calling Release method.
+func (cf *ColumnFamily) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	if err := cf.ok(); err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+
+	se := cf.db.acquireSnapshot()
+	defer cf.db.releaseSnapshot(se)
+	return cf.newIterator(se.seq, slice, ro)
+}
+
+// Put sets the value for the given key in the column family. Write merge
+// also applies for Put, see DB.Write.
+//
+// It is safe to modify the contents of the arguments after Put returns but
+// not before.
+func (cf *ColumnFamily) Put(key, value []byte, wo *opt.WriteOptions) error {
+	if err := cf.ok(); err != nil {
+		return err
+	}
+	return cf.db.putRec(cf.ID(), keyTypeVal, key, value, wo)
+}
+
+// Delete deletes the value for the given key in the column family. Write
+// merge also applies for Delete, see DB.Write.
+//
+// It is safe to modify the contents of the arguments after Delete returns
+// but not before.
+func (cf *ColumnFamily) Delete(key []byte, wo *opt.WriteOptions) error {
+	if err := cf.ok(); err != nil {
+		return err
+	}
+	return cf.db.putRec(cf.ID(), keyTypeDel, key, nil, wo)
+}
+
+// CompactRange compacts the column family for the given key range. See
+// DB.CompactRange.
+func (cf *ColumnFamily) CompactRange(r util.Range) error {
+	if err := cf.ok(); err != nil {
+		return err
+	}
+	db := cf.db
+
+	// Lock writer.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+
+	// Check for overlaps in memdb.
+	mdb := cf.getEffectiveMem()
+	if mdb == nil {
+		<-db.writeLockC
+		return ErrColumnFamilyDropped
+	}
+	defer mdb.decref()
+	if isMemOverlaps(cf.s.icmp, mdb.DB, r.Start, r.Limit) {
+		// Memdb compaction.
+		if _, err := db.rotateMem(0, false); err != nil {
+			<-db.writeLockC
+			return err
+		}
+		<-db.writeLockC
+		if err := db.compTriggerWait(db.mcompCmdC); err != nil {
+			return err
+		}
+	} else {
+		<-db.writeLockC
+	}
+
+	// Table compaction.
+	return db.compTriggerRange(db.tcompCmdC, cf.s, -1, r.Start, r.Limit)
+}
+
+// SizeOf calculates approximate sizes of the given key ranges of the
+// column family. See DB.SizeOf.
+func (cf *ColumnFamily) SizeOf(ranges []util.Range) (Sizes, error) {
+	if err := cf.ok(); err != nil {
+		return nil, err
+	}
+
+	v := cf.s.version()
+	defer v.release()
+	return v.sizeOf(ranges)
+}
+
+// GetProperty returns value of the given property name of the column
+// family.
+//
+// Property names:
+//
+//	leveldb.num-files-at-level{n}
+//		Returns the number of files at level 'n'.
+//	leveldb.stats
+//		Returns statistics of the column family.
+//	leveldb.sstables
+//		Returns sstables list for each level.
+//	leveldb.cachedblock
+//		Returns size of cached block, of the whole DB.
+//	leveldb.openedtables
+//		Returns number of opened tables, of the whole DB.
+func (cf *ColumnFamily) GetProperty(name string) (value string, err error) {
+	err = cf.ok()
+	if err != nil {
+		return
+	}
+
+	const prefix = "leveldb."
+	if !strings.HasPrefix(name, prefix) {
+		return "", ErrNotFound
+	}
+	p := name[len(prefix):]
+
+	v := cf.s.version()
+	defer v.release()
+
+	switch {
+	case strings.HasPrefix(p, numFilesPrefix):
+		value, err = numFilesProperty(v, p)
+	case p == "stats":
+		value = statsProperty(v, &cf.compStats)
+	case p == "sstables":
+		value = sstablesProperty(v)
+	case p == "cachedblock":
+		if cf.s.tops.blockCache != nil {
+			value = fmt.Sprintf("%d", cf.s.tops.blockCache.Size())
+		} else {
+			value = "<nil>"
+		}
+	case p == "openedtables":
+		value = fmt.Sprintf("%d", cf.s.tops.fileCache.Size())
+	default:
+		err = ErrNotFound
+	}
+	return
+}
+
+// Returns column family with the given id, or nil if no such column family.
+func (db *DB) family(id uint32) *ColumnFamily {
+	db.cfMu.RLock()
+	defer db.cfMu.RUnlock()
+	return db.cfs[id]
+}
+
+// Returns all column families sorted by id.
+func (db *DB) families() []*ColumnFamily {
+	db.cfMu.RLock()
+	defer db.cfMu.RUnlock()
+	cfs := make([]*ColumnFamily, 0, len(db.cfs))
+	for _, cf := range db.cfs {
+		cfs = append(cfs, cf)
+	}
+	sort.Slice(cfs, func(i, j int) bool {
+		return cfs[i].s.cfID < cfs[j].s.cfID
+	})
+	return cfs
+}
+
+// Returns the DB session followed by sessions of all column families.
+func (db *DB) sessions() []*session {
+	cfs := db.families()
+	ss := make([]*session, 0, len(cfs)+1)
+	ss = append(ss, db.s)
+	for _, cf := range cfs {
+		ss = append(ss, cf.s)
+	}
+	return ss
+}
+
+// Returns compaction stats of the given session.
+func (db *DB) compStatsOf(s *session) *cStats {
+	if s.parent == nil {
+		return &db.compStats
+	}
+	if cf := db.family(s.cfID); cf != nil {
+		return &cf.compStats
+	}
+	// The column family was dropped.
+	return &cStats{}
+}
+
+// Returns effective memdb of the given column family; need write lock held.
+func (db *DB) familyMem(id uint32) *memdb.DB {
+	db.cfMu.RLock()
+	defer db.cfMu.RUnlock()
+	cf := db.cfs[id]
+	if cf == nil {
+		return nil
+	}
+	db.memMu.RLock()
+	defer db.memMu.RUnlock()
+	if cf.mem == nil {
+		return nil
+	}
+	return cf.mem.DB
+}
+
+// Returns whether any of non-empty column family memdb doesn't have n bytes
+// of free space.
+func (db *DB) familyMemFull(n int) bool {
+	db.cfMu.RLock()
+	defer db.cfMu.RUnlock()
+	if len(db.cfs) == 0 {
+		return false
+	}
+	db.memMu.RLock()
+	defer db.memMu.RUnlock()
+	for _, cf := range db.cfs {
+		if cf.mem != nil && cf.mem.Len() > 0 && cf.mem.Free() < n {
+			return true
+		}
+	}
+	return false
+}
+
+// Get frozen memdbs of the column families.
+func (db *DB) getFamilyFrozenMems() (cfs []*ColumnFamily, mdbs []*memDB) {
+	db.cfMu.RLock()
+	defer db.cfMu.RUnlock()
+	db.memMu.RLock()
+	defer db.memMu.RUnlock()
+	for _, cf := range db.cfs {
+		if cf.frozenMem != nil {
+			cf.frozenMem.incref()
+			cfs = append(cfs, cf)
+			mdbs = append(mdbs, cf.frozenMem)
+		}
+	}
+	return
+}
+
+// Returns the largest level-0 tables count of the DB and its column families.
+// Sessions using FIFO compaction are skipped since their tables stay in
+// level-0.
+func (db *DB) maxL0Len() (n int) {
+	for _, s := range db.sessions() {
+		if s.o.GetCompactionStyle() == opt.FIFOCompaction {
+			continue
+		}
+		if x := s.tLen(0); x > n {
+			n = x
+		}
+	}
+	return
+}
+
+// Checks that column families referenced by the batch exist.
+func (db *DB) checkFamilies(b *Batch) error {
+	db.cfMu.RLock()
+	defer db.cfMu.RUnlock()
+	for _, index := range b.index {
+		if index.cf != 0 && db.cfs[index.cf] == nil {
+			return ErrColumnFamilyNotFound
+		}
+	}
+	return nil
+}
+
+// Creates new memdb for the given column family session.
+func (db *DB) newFamilyMem(fs *session) *memDB {
+	return &memDB{
+		db: db,
+		DB: memdb.New(fs.icmp, fs.o.GetWriteBuffer()),
+		cf: fs.cfID,
+	}
+}
+
+// familyReplay holds column family memdbs during journal recovery.
+type familyReplay struct {
+	s    *session
+	mdbs map[uint32]*memdb.DB
+}
+
+func newFamilyReplay(s *session) *familyReplay {
+	return &familyReplay{s: s, mdbs: make(map[uint32]*memdb.DB)}
+}
+
+// Returns memdb of the given column family; records that are already
+// flushed into the column family tables are skipped.
+func (r *familyReplay) mem(id uint32, seq uint64) *memdb.DB {
+	fs := r.s.families[id]
+	if fs == nil || seq <= fs.stSeqNum {
+		return nil
+	}
+	mdb := r.mdbs[id]
+	if mdb == nil {
+		mdb = memdb.New(fs.icmp, fs.o.GetWriteBuffer())
+		r.mdbs[id] = mdb
+	}
+	return mdb
+}
+
+// Flush column family memdbs into tables and commit them. Unless all is
+// true, only memdbs larger than their write buffer are flushed.
+func (r *familyReplay) flush(seq uint64, all bool) error {
+	for id, mdb := range r.mdbs {
+		fs := r.s.families[id]
+		if mdb.Len() == 0 || (!all && mdb.Size() < fs.o.GetWriteBuffer()) {
+			continue
+		}
+		rec := &sessionRecord{}
+		if _, err := fs.flushMemdb(rec, mdb, nil, 0); err != nil {
+			return err
+		}
+		rec.setSeqNum(seq)
+		if err := fs.commit(rec, false); err != nil {
+			return err
+		}
+		mdb.Reset()
+	}
+	return nil
+}
+
+// ColumnFamily returns the column family with the given name. It returns
+// ErrColumnFamilyNotFound if no such column family exists.
+func (db *DB) ColumnFamily(name string) (*ColumnFamily, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+	for _, cf := range db.families() {
+		if cf.s.cfName == name {
+			return cf, nil
+		}
+	}
+	return nil, ErrColumnFamilyNotFound
+}
+
+// ColumnFamilies returns all column families of the DB, excluding the
+// default column family, sorted by id.
+func (db *DB) ColumnFamilies() []*ColumnFamily {
+	if err := db.ok(); err != nil {
+		return nil
+	}
+	return db.families()
+}
+
+// CreateColumnFamily creates a new column family with the given name and
+// options. It returns ErrColumnFamilyExist if a column family with the same
+// name already exists.
+//
+// Only 'sorted table', comparer, filter, compaction and write buffer options
+// are applicable to a column family. The options must be given again using
+// Options.ColumnFamilies when the DB is reopened.
+func (db *DB) CreateColumnFamily(name string, o *opt.Options) (*ColumnFamily, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errInvalidColumnFamilyName
+	}
+
+	// Lock writer.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return nil, err
+	case <-db.closeC:
+		return nil, ErrClosed
+	}
+	defer func() { <-db.writeLockC }()
+
+	if _, err := db.ColumnFamily(name); err == nil {
+		return nil, ErrColumnFamilyExist
+	}
+
+	db.compCommitLk.Lock()
+	defer db.compCommitLk.Unlock()
+
+	id := db.s.stMaxColumnFamily + 1
+	fs := db.s.newFamily(id, name, o)
+	rec := &sessionRecord{}
+	fs.fillRecord(rec, true)
+	rec.setSeqNum(db.seq)
+	if err := fs.commit(rec, false); err != nil {
+		fs.close()
+		return nil, err
+	}
+	db.s.families[id] = fs
+	db.s.stMaxColumnFamily = id
+
+	cf := &ColumnFamily{db: db, s: fs}
+	mem := db.newFamilyMem(fs)
+	mem.incref()
+	db.memMu.Lock()
+	cf.mem = mem
+	db.memMu.Unlock()
+	db.cfMu.Lock()
+	db.cfs[id] = cf
+	db.cfMu.Unlock()
+
+	db.logf("db@column-family created %q C·%d", name, id)
+	return cf, nil
+}
+
+// DropColumnFamily drops the given column family and removes all of its
+// data. The handle should not be used after DropColumnFamily returns.
+func (db *DB) DropColumnFamily(cf *ColumnFamily) error {
+	if err := cf.ok(); err != nil {
+		return err
+	}
+
+	// Lock writer.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+	defer func() { <-db.writeLockC }()
+
+	db.compCommitLk.Lock()
+	defer db.compCommitLk.Unlock()
+
+	if atomic.LoadUint32(&cf.dropped) != 0 {
+		return ErrColumnFamilyDropped
+	}
+
+	rec := &sessionRecord{}
+	v := cf.s.version()
+	for level, tables := range v.levels {
+		for _, t := range tables {
+			rec.delTable(level, t.fd.Num)
+		}
+	}
+	v.release()
+	rec.dropColumnFamily()
+	if err := cf.s.commit(rec, false); err != nil {
+		return err
+	}
+	cf.s.cfDropped = true
+	atomic.StoreUint32(&cf.dropped, 1)
+
+	db.cfMu.Lock()
+	delete(db.cfs, cf.s.cfID)
+	db.cfMu.Unlock()
+
+	db.memMu.Lock()
+	for _, m := range [...]*memDB{cf.mem, cf.frozenMem} {
+		if m != nil {
+			m.decref()
+		}
+	}
+	cf.mem = nil
+	cf.frozenMem = nil
+	db.memMu.Unlock()
+
+	db.logf("db@column-family dropped %q C·%d", cf.s.cfName, cf.s.cfID)
+	return nil
+}
+
+// GetCF gets the value for the given key from the given column family of
+// the snapshot. It returns ErrNotFound if the column family does not
+// contains the key.
+//
+// The caller should not modify the contents of the returned slice, but
+// it is safe to modify the contents of the argument after GetCF returns.
+func (snap *Snapshot) GetCF(cf *ColumnFamily, key []byte, ro *opt.ReadOptions) (value []byte, err error) {
+	snap.mu.RLock()
+	defer snap.mu.RUnlock()
+	if snap.released {
+		err = ErrSnapshotReleased
+		return
+	}
+	err = cf.ok()
+	if err != nil {
+		return
+	}
+	return cf.get(key, snap.elem.seq, ro)
+}
+
+// HasCF returns true if the given column family of the snapshot does
+// contains the given key.
+//
+// It is safe to modify the contents of the argument after HasCF returns.
+func (snap *Snapshot) HasCF(cf *ColumnFamily, key []byte, ro *opt.ReadOptions) (ret bool, err error) {
+	snap.mu.RLock()
+	defer snap.mu.RUnlock()
+	if snap.released {
+		err = ErrSnapshotReleased
+		return
+	}
+	err = cf.ok()
+	if err != nil {
+		return
+	}
+	return cf.has(key, snap.elem.seq, ro)
+}
+
+// NewIteratorCF returns an iterator for the given column family of the
+// snapshot. See Snapshot.NewIterator for the iterator semantics.
+//
+// The iterator must be released after use, by calling Release method.
+func (snap *Snapshot) NewIteratorCF(cf *ColumnFamily, slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	snap.mu.Lock()
+	defer snap.mu.Unlock()
+	if snap.released {
+		return iterator.NewEmptyIterator(ErrSnapshotReleased)
+	}
+	if err := cf.ok(); err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	// Since iterator already hold version ref, it doesn't need to
+	// hold snapshot ref.
+	return cf.newIterator(snap.elem.seq, slice, ro)
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/3JoB/goleveldb/comparer"
+	"github.com/3JoB/goleveldb/opt"
+	"github.com/3JoB/goleveldb/util"
+)
+
+func (h *dbHarness) createCF(name string, o *opt.Options) *ColumnFamily {
+	cf, err := h.db.CreateColumnFamily(name, o)
+	if err != nil {
+		h.t.Fatal("CreateColumnFamily: got error: ", err)
+	}
+	return cf
+}
+
+func (h *dbHarness) getCF(name string) *ColumnFamily {
+	cf, err := h.db.ColumnFamily(name)
+	if err != nil {
+		h.t.Fatal("ColumnFamily: got error: ", err)
+	}
+	return cf
+}
+
+func (h *dbHarness) putCF(cf *ColumnFamily, key, value string) {
+	if err := cf.Put([]byte(key), []byte(value), h.wo); err != nil {
+		h.t.Error("Put: got error: ", err)
+	}
+}
+
+func (h *dbHarness) cfKeyVal(cf *ColumnFamily) string {
+	iter := cf.NewIterator(nil, h.ro)
+	defer iter.Release()
+	var s string
+	for iter.Next() {
+		s += fmt.Sprintf("(%s,%s)", iter.Key(), iter.Value())
+	}
+	if err := iter.Error(); err != nil {
+		h.t.Error("Iterator: got error: ", err)
+	}
+	return s
+}
+
+func TestDB_ColumnFamily_Basic(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	cf := h.createCF("cf1", nil)
+	if cf.ID() == 0 || cf.Name() != "cf1" {
+		t.Fatalf("invalid column family: %v", cf)
+	}
+	if _, err := h.db.CreateColumnFamily("cf1", nil); err != ErrColumnFamilyExist {
+		t.Fatalf("CreateColumnFamily: expect ErrColumnFamilyExist, got %v", err)
+	}
+	if _, err := h.db.ColumnFamily("cf2"); err != ErrColumnFamilyNotFound {
+		t.Fatalf("ColumnFamily: expect ErrColumnFamilyNotFound, got %v", err)
+	}
+
+	h.put("foo", "v1")
+	h.putCF(cf, "foo", "v2")
+	h.putCF(cf, "bar", "v3")
+	h.getVal("foo", "v1")
+	h.get("bar", false)
+	h.getValr(cf, "foo", "v2")
+	h.getValr(cf, "bar", "v3")
+
+	if ok, err := cf.Has([]byte("bar"), h.ro); err != nil || !ok {
+		t.Errorf("Has: got (%v, %v)", ok, err)
+	}
+	if err := cf.Delete([]byte("bar"), h.wo); err != nil {
+		t.Fatal("Delete: got error: ", err)
+	}
+	h.getr(cf, "bar", false)
+
+	if got := h.cfKeyVal(cf); got != "(foo,v2)" {
+		t.Errorf("iterator: got %s", got)
+	}
+	h.getKeyVal("(foo->v1)")
+
+	if v, err := h.db.GetProperty("leveldb.columnfamilies"); err != nil || v != fmt.Sprintf("%d:cf1\n", cf.ID()) {
+		t.Errorf("GetProperty: got (%q, %v)", v, err)
+	}
+}
+
+func TestDB_ColumnFamily_Batch(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	cf1 := h.createCF("cf1", nil)
+	cf2 := h.createCF("cf2", nil)
+
+	b := new(Batch)
+	b.Put([]byte("k"), []byte("v0"))
+	b.PutCF(cf1, []byte("k"), []byte("v1"))
+	b.PutCF(cf2, []byte("k"), []byte("v2"))
+	b.DeleteCF(cf2, []byte("x"))
+	h.write(b)
+
+	snap := h.getSnapshot()
+	defer snap.Release()
+
+	b.Reset()
+	b.PutCF(cf1, []byte("k"), []byte("v3"))
+	h.write(b)
+
+	h.getVal("k", "v0")
+	h.getValr(cf1, "k", "v3")
+	h.getValr(cf2, "k", "v2")
+
+	if v, err := snap.GetCF(cf1, []byte("k"), h.ro); err != nil || string(v) != "v1" {
+		t.Errorf("snapshot GetCF: got (%q, %v)", v, err)
+	}
+	iter := snap.NewIteratorCF(cf1, nil, h.ro)
+	if !iter.First() || string(iter.Value()) != "v1" || iter.Next() {
+		t.Error("snapshot NewIteratorCF: invalid iteration")
+	}
+	iter.Release()
+
+	// Records of a column family are not visible to the batch replay.
+	var n int
+	b.Reset()
+	b.Put([]byte("a"), []byte("1"))
+	b.PutCF(cf1, []byte("b"), []byte("2"))
+	b.Replay(funcReplay(func() { n++ }))
+	if n != 1 {
+		t.Errorf("Replay: expect 1 record, got %d", n)
+	}
+}
+
+type funcReplay func()
+
+func (f funcReplay) Put(key, value []byte) { f() }
+func (f funcReplay) Delete(key []byte)     { f() }
+
+func TestDB_ColumnFamily_Reopen(t *testing.T) {
+	o := &opt.Options{
+		DisableLargeBatchTransaction: true,
+		ColumnFamilies: map[string]*opt.Options{
+			"rev": {Comparer: reverseComparer{}},
+		},
+	}
+	h := newDbHarnessWopt(t, o)
+	defer h.close()
+
+	rev := h.createCF("rev", o.ColumnFamilies["rev"])
+	plain := h.createCF("plain", nil)
+	for _, k := range []string{"a", "b", "c"} {
+		h.putCF(rev, k, "r"+k)
+		h.putCF(plain, k, "p"+k)
+	}
+	h.put("a", "d")
+
+	// Recover from journal.
+	h.reopenDB()
+	rev, plain = h.getCF("rev"), h.getCF("plain")
+	if got := h.cfKeyVal(rev); got != "(c,rc)(b,rb)(a,ra)" {
+		t.Errorf("rev: got %s", got)
+	}
+	if got := h.cfKeyVal(plain); got != "(a,pa)(b,pb)(c,pc)" {
+		t.Errorf("plain: got %s", got)
+	}
+
+	// Recover from tables.
+	h.compactMem()
+	if n := rev.s.tLen(0); n != 1 {
+		t.Errorf("rev: expect 1 level-0 table, got %d", n)
+	}
+	h.putCF(plain, "d", "pd")
+	h.reopenDB()
+	rev, plain = h.getCF("rev"), h.getCF("plain")
+	h.getValr(rev, "b", "rb")
+	h.getValr(plain, "d", "pd")
+	h.getVal("a", "d")
+
+	if err := plain.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	if v, err := plain.GetProperty("leveldb.num-files-at-level0"); err != nil || v != "0" {
+		t.Errorf("GetProperty: got (%q, %v)", v, err)
+	}
+	if got := h.cfKeyVal(plain); got != "(a,pa)(b,pb)(c,pc)(d,pd)" {
+		t.Errorf("plain: got %s", got)
+	}
+
+	// Comparer mismatch.
+	h.closeDB()
+	families := h.o.ColumnFamilies
+	h.o.ColumnFamilies = nil
+	h.openAssert(false)
+	h.o.ColumnFamilies = families
+	h.openDB()
+}
+
+func TestDB_ColumnFamily_Drop(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	cf := h.createCF("cf1", nil)
+	h.putCF(cf, "foo", "v1")
+	h.put("foo", "v0")
+	h.compactMem()
+	h.putCF(cf, "bar", "v2")
+
+	if err := h.db.DropColumnFamily(cf); err != nil {
+		t.Fatal("DropColumnFamily: got error: ", err)
+	}
+	if _, err := cf.Get([]byte("foo"), h.ro); err != ErrColumnFamilyDropped {
+		t.Errorf("Get: expect ErrColumnFamilyDropped, got %v", err)
+	}
+	b := new(Batch)
+	b.PutCF(cf, []byte("k"), []byte("v"))
+	if err := h.db.Write(b, h.wo); err != ErrColumnFamilyNotFound {
+		t.Errorf("Write: expect ErrColumnFamilyNotFound, got %v", err)
+	}
+
+	h.reopenDB()
+	if len(h.db.ColumnFamilies()) != 0 {
+		t.Error("dropped column family is still exist")
+	}
+	h.getVal("foo", "v0")
+
+	cf = h.createCF("cf1", nil)
+	h.getr(cf, "foo", false)
+	h.getr(cf, "bar", false)
+	h.reopenDB()
+	h.getr(h.getCF("cf1"), "foo", false)
+}
+
+type reverseComparer struct{}
+
+func (reverseComparer) Compare(a, b []byte) int {
+	return comparer.DefaultComparer.Compare(b, a)
+}
+
+func (reverseComparer) Name() string {
+	return "leveldb.ReverseBytewiseComparator"
+}
+
+func (reverseComparer) Separator(dst, a, b []byte) []byte { return nil }
+
+func (reverseComparer) Successor(dst, b []byte) []byte { return nil }
+
+func TestDB_ColumnFamily_Options(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		NoSync: true,
+		Strict: opt.DefaultStrict | opt.StrictManifest,
+	})
+	defer h.close()
+
+	cf := h.createCF("cf1", &opt.Options{BlockSize: 1024})
+	h.putCF(cf, "a", "v1")
+	if err := cf.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+
+	// Options applicable to a column family are its own, the rest are
+	// inherited, and the caches are shared.
+	o := cf.s.o
+	if o.GetBlockSize() != 1024 || !o.GetNoSync() || !o.GetStrict(opt.StrictManifest) {
+		t.Errorf("got BlockSize %d, NoSync %v, Strict %v", o.GetBlockSize(), o.GetNoSync(), o.Strict)
+	}
+	if cf.s.tops.fileCache != h.db.s.tops.fileCache || cf.s.tops.blockCache != h.db.s.tops.blockCache {
+		t.Error("column family doesn't share the caches of the DB")
+	}
+	// The compacted table is opened through the cache of the DB on read.
+	if v, err := cf.Get([]byte("a"), h.ro); err != nil || string(v) != "v1" {
+		t.Fatalf("Get: got (%q, %v)", v, err)
+	}
+	if n := h.db.s.tops.fileCache.Size(); n == 0 {
+		t.Error("column family tables aren't in the file cache of the DB")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/3JoB/goleveldb/opt"
+)
+
+// prefixFilter removes keys prefixed with "exp-", and upper-cases values of
+// keys prefixed with "up-".
+type prefixFilter struct {
+	mu     sync.Mutex
+	levels map[int]bool
+}
+
+func (f *prefixFilter) Filter(level int, key, value []byte) (opt.CompactionDecision, []byte) {
+	f.mu.Lock()
+	f.levels[level] = true
+	f.mu.Unlock()
+	switch {
+	case bytes.HasPrefix(key, []byte("exp-")):
+		return opt.CompactionRemove, nil
+	case bytes.HasPrefix(key, []byte("up-")):
+		return opt.CompactionChangeValue, bytes.ToUpper(value)
+	}
+	return opt.CompactionKeep, nil
+}
+
+func TestDB_CompactionFilter(t *testing.T) {
+	f := &prefixFilter{levels: make(map[int]bool)}
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		CompactionFilter:             f,
+	})
+	defer h.close()
+
+	h.put("exp-a", "v1")
+	h.put("keep", "v1")
+	h.put("up-a", "v1")
+	h.compactMem()
+	h.compactRange("", "")
+	// Filtered also if there are older entries in deeper level.
+	h.put("exp-b", "v1")
+	h.compactMem()
+	h.compactRange("", "")
+	h.put("exp-b", "v2")
+	h.compactMem()
+
+	// Memdb flush isn't filtered.
+	h.getKeyVal("(exp-b->v2)(keep->v1)(up-a->V1)")
+	h.compactRange("", "")
+	h.getKeyVal("(keep->v1)(up-a->V1)")
+	if !f.levels[0] {
+		t.Errorf("filter isn't called for level 0, got %v", f.levels)
+	}
+}
+
+func TestDB_CompactionFilter_Snapshot(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		CompactionFilter:             &prefixFilter{levels: make(map[int]bool)},
+	})
+	defer h.close()
+
+	h.put("exp-a", "v1")
+	h.put("up-a", "v1")
+	snap := h.getSnapshot()
+	h.put("exp-b", "v1")
+	h.put("up-a", "v2")
+	h.compactMem()
+	h.compactRange("", "")
+
+	// Entries visible to the snapshot aren't filtered.
+	h.getValr(snap, "exp-a", "v1")
+	h.getValr(snap, "up-a", "v1")
+	h.getKeyVal("(exp-a->v1)(up-a->V2)")
+
+	// Filtered once released, when compacted again.
+	snap.Release()
+	h.put("a", "v1")
+	h.put("z", "v1")
+	h.compactMem()
+	h.compactRange("", "")
+	h.getKeyVal("(a->v1)(up-a->V2)(z->v1)")
+}
@@ -65,3 +65,9 @@ func (icmp *iComparer) Successor(dst, b []byte) []byte {
 	}
 	return nil
 }
+
+// UserKey returns the user key of the internal key, so that the data block
+// hash index of tables maps user keys.
+func (icmp *iComparer) UserKey(key []byte) []byte {
+	return internalKey(key).ukey()
+}
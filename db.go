@@ -46,10 +46,17 @@ type DB struct {
 	level0Comp    uint32 // The cumulative number of level0 compaction
 	nonLevel0Comp uint32 // The cumulative number of non-level0 compaction
 	seekComp      uint32 // The cumulative number of seek compaction
+	universalComp uint32 // The cumulative number of universal compaction
+	fifoComp      uint32 // The cumulative number of FIFO compaction
 
 	// Session.
 	s *session
 
+	// Column families.
+	cfMu sync.RWMutex
+
+	cfs map[uint32]*ColumnFamily
+
 	// MemDB.
 	memMu sync.RWMutex
 
@@ -61,6 +68,11 @@ type DB struct {
 	frozenJournalFd storage.FileDesc
 	frozenSeq       uint64
 
+	// Obsolete journals retained for GetUpdatesSince.
+	retainMu sync.Mutex
+
+	retainedJournals []retainedJournal
+
 	// Snapshot.
 	snapsMu sync.Mutex
 
@@ -77,6 +89,17 @@ type DB struct {
 	writeDelayN  int
 	tr           *Transaction
 
+	// Pessimistic transaction.
+	keyLocks *keyLockManager
+
+	// TTL mode clock.
+	ttlNow func() time.Time
+
+	// Two-phase commit.
+	preparedMu   sync.Mutex
+	prepared     map[string][]byte // journal records of unresolved prepares
+	recoveredTxs map[string]*PessimisticTransaction
+
 	// Compaction.
 	compCommitLk sync.Mutex
 
@@ -105,6 +128,8 @@ func openDB(s *session) (*DB, error) {
 		s: s,
 		// Initial sequence
 		seq: s.stSeqNum,
+		// Column families
+		cfs: make(map[uint32]*ColumnFamily),
 		// MemDB
 		memPool: make(chan *memdb.DB, 1),
 		// Snapshot
@@ -115,6 +140,13 @@ func openDB(s *session) (*DB, error) {
 		writeMergedC: make(chan bool),
 		writeLockC:   make(chan struct{}, 1),
 		writeAckC:    make(chan error),
+		// Pessimistic transaction
+		keyLocks: newKeyLockManager(),
+		// TTL
+		ttlNow: time.Now,
+		// Two-phase commit
+		prepared:     make(map[string][]byte),
+		recoveredTxs: make(map[string]*PessimisticTransaction),
 		// Compaction
 		tcompCmdC:   make(chan cCmd),
 		tcompPauseC: make(chan chan<- struct{}),
@@ -125,6 +157,9 @@ func openDB(s *session) (*DB, error) {
 		// Close
 		closeC: make(chan struct{}),
 	}
+	for _, fs := range s.liveFamilies() {
+		db.cfs[fs.cfID] = &ColumnFamily{db: db, s: fs}
+	}
 
 	// Read-only mode.
 	readOnly := s.o.GetReadOnly()
@@ -149,6 +184,13 @@ func openDB(s *session) (*DB, error) {
 			}
 			return nil, err
 		}
+
+		// Recover prepared transactions.
+		if err := db.recoverPrepared(); err != nil {
+			db.journal.Close()
+			db.journalWriter.Close()
+			return nil, err
+		}
 	}
 
 	// Doesn't need to be included in the wait group.
@@ -530,6 +572,8 @@ func (db *DB) recoverJournal() error {
 
 			jr       *journal.Reader
 			mdb      = memdb.New(db.s.icmp, writeBuffer)
+			rdel     = memdb.New(db.s.icmp, 0)
+			freplay  = newFamilyReplay(db.s)
 			buf      = &util.Buffer{}
 			batchSeq uint64
 			batchLen int
@@ -553,8 +597,12 @@ func (db *DB) recoverJournal() error {
 
 			// Flush memdb and remove obsolete journal file.
 			if !ofd.Zero() {
-				if mdb.Len() > 0 {
-					if _, err := db.s.flushMemdb(rec, mdb, 0); err != nil {
+				if err := freplay.flush(db.seq, true); err != nil {
+					fr.Close()
+					return err
+				}
+				if mdb.Len() > 0 || rdel.Len() > 0 {
+					if _, err := db.s.flushMemdb(rec, mdb, rdel, 0); err != nil {
 						fr.Close()
 						return err
 					}
@@ -568,7 +616,7 @@ func (db *DB) recoverJournal() error {
 				}
 				rec.resetAddedTables()
 
-				if err := db.s.stor.Remove(ofd); err != nil {
+				if err := db.removeJournal(ofd); err != nil {
 					fr.Close()
 					return err
 				}
@@ -577,6 +625,7 @@ func (db *DB) recoverJournal() error {
 
 			// Replay journal to memdb.
 			mdb.Reset()
+			rdel.Reset()
 			for {
 				r, err := jr.Next()
 				if err != nil {
@@ -598,7 +647,10 @@ func (db *DB) recoverJournal() error {
 					fr.Close()
 					return errors.SetFd(err, fd)
 				}
-				batchSeq, batchLen, err = decodeBatchToMem(buf.Bytes(), db.seq, mdb)
+				if db.replayMarker(buf.Bytes()) {
+					continue
+				}
+				batchSeq, batchLen, err = decodeBatchToMem(db.s.icmp, buf.Bytes(), db.seq, mdb, rdel, freplay.mem)
 				if err != nil {
 					if !strict && errors.IsCorrupted(err) {
 						db.s.logf("journal error: %v (skipped)", err)
@@ -615,12 +667,17 @@ func (db *DB) recoverJournal() error {
 
 				// Flush it if large enough.
 				if mdb.Size() >= writeBuffer {
-					if _, err := db.s.flushMemdb(rec, mdb, 0); err != nil {
+					if _, err := db.s.flushMemdb(rec, mdb, rdel, 0); err != nil {
 						fr.Close()
 						return err
 					}
 
 					mdb.Reset()
+					rdel.Reset()
+				}
+				if err := freplay.flush(db.seq, false); err != nil {
+					fr.Close()
+					return err
 				}
 			}
 
@@ -629,8 +686,11 @@ func (db *DB) recoverJournal() error {
 		}
 
 		// Flush the last memdb.
-		if mdb.Len() > 0 {
-			if _, err := db.s.flushMemdb(rec, mdb, 0); err != nil {
+		if err := freplay.flush(db.seq, true); err != nil {
+			return err
+		}
+		if mdb.Len() > 0 || rdel.Len() > 0 {
+			if _, err := db.s.flushMemdb(rec, mdb, rdel, 0); err != nil {
 				return err
 			}
 		}
@@ -655,7 +715,7 @@ func (db *DB) recoverJournal() error {
 
 	// Remove the last obsolete journal file.
 	if !ofd.Zero() {
-		if err := db.s.stor.Remove(ofd); err != nil {
+		if err := db.removeJournal(ofd); err != nil {
 			return err
 		}
 	}
@@ -685,7 +745,9 @@ func (db *DB) recoverJournalRO() error {
 		checksum    = db.s.o.GetStrict(opt.StrictJournalChecksum)
 		writeBuffer = db.s.o.GetWriteBuffer()
 
-		mdb = memdb.New(db.s.icmp, writeBuffer)
+		mdb     = memdb.New(db.s.icmp, writeBuffer)
+		rdel    = memdb.New(db.s.icmp, 0)
+		freplay = newFamilyReplay(db.s)
 	)
 
 	// Recover journals.
@@ -738,7 +800,10 @@ func (db *DB) recoverJournalRO() error {
 					fr.Close()
 					return errors.SetFd(err, fd)
 				}
-				batchSeq, batchLen, err = decodeBatchToMem(buf.Bytes(), db.seq, mdb)
+				if db.replayMarker(buf.Bytes()) {
+					continue
+				}
+				batchSeq, batchLen, err = decodeBatchToMem(db.s.icmp, buf.Bytes(), db.seq, mdb, rdel, freplay.mem)
 				if err != nil {
 					if !strict && errors.IsCorrupted(err) {
 						db.s.logf("journal error: %v (skipped)", err)
@@ -759,23 +824,40 @@ func (db *DB) recoverJournalRO() error {
 	}
 
 	// Set memDB.
-	db.mem = &memDB{db: db, DB: mdb, ref: 1}
+	db.mem = &memDB{db: db, DB: mdb, ref: 1, rdel: rdel}
+	for id, cf := range db.cfs {
+		if fmdb := freplay.mdbs[id]; fmdb != nil {
+			cf.mem = &memDB{db: db, DB: fmdb, ref: 1, cf: id}
+		} else {
+			cf.mem = db.newFamilyMem(cf.s)
+			cf.mem.incref()
+		}
+	}
 
 	return nil
 }
 
-func memGet(mdb *memdb.DB, ikey internalKey, icmp *iComparer) (ok bool, mv []byte, err error) {
+// memGet finds the value of the given key within the given memdb. Merge
+// operands found are appended to ops, in which case it returns false if the
+// operand chain continues beyond the memdb. If ops is nil, a merge operand
+// is reported as an existing value.
+func memGet(mdb *memdb.DB, ikey internalKey, icmp *iComparer, rdSeq uint64, ops *mergeOperands) (ok bool, mv []byte, err error) {
 	mk, mv, err := mdb.Find(ikey)
 	if err == nil {
-		ukey, _, kt, kerr := parseInternalKey(mk)
+		ukey, seq, kt, kerr := parseInternalKey(mk)
 		if kerr != nil {
 			// Shouldn't have had happen.
 			panic(kerr)
 		}
 		if icmp.uCompare(ukey, ikey.ukey()) == 0 {
-			if kt == keyTypeDel {
+			if kt == keyTypeDel || seq < rdSeq {
 				return true, nil, ErrNotFound
 			}
+			if kt == keyTypeMerge && ops != nil {
+				iter := mdb.NewIterator(nil)
+				defer iter.Release()
+				return ops.collect(iter, icmp, ikey, rdSeq)
+			}
 			return true, mv, nil
 		}
 	} else if err != ErrNotFound {
@@ -784,35 +866,107 @@ func memGet(mdb *memdb.DB, ikey internalKey, icmp *iComparer) (ok bool, mv []byt
 	return
 }
 
+// rangeDelSeq returns the highest sequence number, not greater than seq, of
+// range tombstones covering the given key.
+func (db *DB) rangeDelSeq(em, fm *memDB, v *version, key []byte, seq uint64) (uint64, error) {
+	rdels, err := v.rangeDels()
+	if err != nil {
+		return 0, err
+	}
+	rdSeq := rdels.coverSeq(db.s.icmp, key, seq)
+	for _, m := range [...]*memDB{em, fm} {
+		if m == nil {
+			continue
+		}
+		mrdels, err := m.rangeDels(db.s.icmp)
+		if err != nil {
+			return 0, err
+		}
+		if mseq := mrdels.coverSeq(db.s.icmp, key, seq); mseq > rdSeq {
+			rdSeq = mseq
+		}
+	}
+	return rdSeq, nil
+}
+
+// rangeDels returns range tombstones of the memdbs and the tables, each
+// fragmented separately.
+func (db *DB) rangeDels() (rdels []rangeFragments, err error) {
+	em, fm := db.getMems()
+	v := db.s.version()
+	defer v.release()
+	for _, m := range [...]*memDB{em, fm} {
+		if m == nil {
+			continue
+		}
+		defer m.decref()
+
+		mrdels, err := m.rangeDels(db.s.icmp)
+		if err != nil {
+			return nil, err
+		}
+		if len(mrdels) > 0 {
+			rdels = append(rdels, mrdels)
+		}
+	}
+	vrdels, err := v.rangeDels()
+	if err != nil {
+		return nil, err
+	}
+	if len(vrdels) > 0 {
+		rdels = append(rdels, vrdels)
+	}
+	return rdels, nil
+}
+
 func (db *DB) get(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) (value []byte, err error) {
+	if db.ttlEnabled() {
+		defer func() {
+			if err == nil {
+				value, err = db.ttlValue(value)
+			}
+		}()
+	}
 	ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
 
+	em, fm := db.getMems()
+	for _, m := range [...]*memDB{em, fm} {
+		if m != nil {
+			defer m.decref()
+		}
+	}
+	v := db.s.version()
+	defer v.release()
+
+	rdSeq, err := db.rangeDelSeq(em, fm, v, key, seq)
+	if err != nil {
+		return nil, err
+	}
+
+	mo := db.s.o.GetMergeOperator()
+	var ops mergeOperands
 	if auxm != nil {
-		if ok, mv, me := memGet(auxm, ikey, db.s.icmp); ok {
-			return append([]byte(nil), mv...), me
+		if ok, mv, me := memGet(auxm, ikey, db.s.icmp, rdSeq, &ops); ok {
+			return ops.resolve(mo, key, append([]byte(nil), mv...), me)
 		}
 	}
 
-	em, fm := db.getMems()
 	for _, m := range [...]*memDB{em, fm} {
 		if m == nil {
 			continue
 		}
-		defer m.decref()
 
-		if ok, mv, me := memGet(m.DB, ikey, db.s.icmp); ok {
-			return append([]byte(nil), mv...), me
+		if ok, mv, me := memGet(m.DB, ikey, db.s.icmp, rdSeq, &ops); ok {
+			return ops.resolve(mo, key, append([]byte(nil), mv...), me)
 		}
 	}
 
-	v := db.s.version()
-	value, cSched, err := v.get(auxt, ikey, ro, false)
-	v.release()
+	value, cSched, err := v.get(auxt, ikey, ro, false, rdSeq, &ops)
 	if cSched {
 		// Trigger table compaction.
 		db.compTrigger(db.tcompCmdC)
 	}
-	return
+	return ops.resolve(mo, key, value, err)
 }
 
 func nilIfNotFound(err error) error {
@@ -823,29 +977,44 @@ func nilIfNotFound(err error) error {
 }
 
 func (db *DB) has(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) (ret bool, err error) {
+	if db.ttlEnabled() {
+		// The value is needed to know whether it is expired.
+		_, err = db.get(auxm, auxt, key, seq, ro)
+		return err == nil, nilIfNotFound(err)
+	}
 	ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
 
+	em, fm := db.getMems()
+	for _, m := range [...]*memDB{em, fm} {
+		if m != nil {
+			defer m.decref()
+		}
+	}
+	v := db.s.version()
+	defer v.release()
+
+	rdSeq, err := db.rangeDelSeq(em, fm, v, key, seq)
+	if err != nil {
+		return false, err
+	}
+
 	if auxm != nil {
-		if ok, _, me := memGet(auxm, ikey, db.s.icmp); ok {
+		if ok, _, me := memGet(auxm, ikey, db.s.icmp, rdSeq, nil); ok {
 			return me == nil, nilIfNotFound(me)
 		}
 	}
 
-	em, fm := db.getMems()
 	for _, m := range [...]*memDB{em, fm} {
 		if m == nil {
 			continue
 		}
-		defer m.decref()
 
-		if ok, _, me := memGet(m.DB, ikey, db.s.icmp); ok {
+		if ok, _, me := memGet(m.DB, ikey, db.s.icmp, rdSeq, nil); ok {
 			return me == nil, nilIfNotFound(me)
 		}
 	}
 
-	v := db.s.version()
-	_, cSched, err := v.get(auxt, ikey, ro, true)
-	v.release()
+	_, cSched, err := v.get(auxt, ikey, ro, true, rdSeq, nil)
 	if cSched {
 		// Trigger table compaction.
 		db.compTrigger(db.tcompCmdC)
@@ -948,6 +1117,8 @@ func (db *DB) GetSnapshot() (*Snapshot, error) {
 //		Returns cumulative write delay caused by compaction.
 //	leveldb.sstables
 //		Returns sstables list for each level.
+//	leveldb.columnfamilies
+//		Returns id and name of each column family.
 //	leveldb.blockpool
 //		Returns block pool stats.
 //	leveldb.cachedblock
@@ -973,44 +1144,13 @@ func (db *DB) GetProperty(name string) (value string, err error) {
 	v := db.s.version()
 	defer v.release()
 
-	numFilesPrefix := "num-files-at-level"
 	switch {
 	case strings.HasPrefix(p, numFilesPrefix):
-		var level uint
-		var rest string
-		n, _ := fmt.Sscanf(p[len(numFilesPrefix):], "%d%s", &level, &rest)
-		if n != 1 {
-			err = ErrNotFound
-		} else {
-			value = fmt.Sprint(v.tLen(int(level)))
-		}
+		value, err = numFilesProperty(v, p)
 	case p == "stats":
-		value = "Compactions\n" +
-			" Level |   Tables   |    Size(MB)   |    Time(sec)  |    Read(MB)   |   Write(MB)\n" +
-			"-------+------------+---------------+---------------+---------------+---------------\n"
-		var totalTables int
-		var totalSize, totalRead, totalWrite int64
-		var totalDuration time.Duration
-		for level, tables := range v.levels {
-			duration, read, write := db.compStats.getStat(level)
-			if len(tables) == 0 && duration == 0 {
-				continue
-			}
-			totalTables += len(tables)
-			totalSize += tables.size()
-			totalRead += read
-			totalWrite += write
-			totalDuration += duration
-			value += fmt.Sprintf(" %3d   | %10d | %13.5f | %13.5f | %13.5f | %13.5f\n",
-				level, len(tables), float64(tables.size())/1048576.0, duration.Seconds(),
-				float64(read)/1048576.0, float64(write)/1048576.0)
-		}
-		value += "-------+------------+---------------+---------------+---------------+---------------\n"
-		value += fmt.Sprintf(" Total | %10d | %13.5f | %13.5f | %13.5f | %13.5f\n",
-			totalTables, float64(totalSize)/1048576.0, totalDuration.Seconds(),
-			float64(totalRead)/1048576.0, float64(totalWrite)/1048576.0)
+		value = statsProperty(v, &db.compStats)
 	case p == "compcount":
-		value = fmt.Sprintf("MemComp:%d Level0Comp:%d NonLevel0Comp:%d SeekComp:%d", atomic.LoadUint32(&db.memComp), atomic.LoadUint32(&db.level0Comp), atomic.LoadUint32(&db.nonLevel0Comp), atomic.LoadUint32(&db.seekComp))
+		value = fmt.Sprintf("MemComp:%d Level0Comp:%d NonLevel0Comp:%d SeekComp:%d UniversalComp:%d FIFOComp:%d", atomic.LoadUint32(&db.memComp), atomic.LoadUint32(&db.level0Comp), atomic.LoadUint32(&db.nonLevel0Comp), atomic.LoadUint32(&db.seekComp), atomic.LoadUint32(&db.universalComp), atomic.LoadUint32(&db.fifoComp))
 	case p == "iostats":
 		value = fmt.Sprintf("Read(MB):%.5f Write(MB):%.5f",
 			float64(db.s.stor.reads())/1048576.0,
@@ -1020,11 +1160,10 @@ func (db *DB) GetProperty(name string) (value string, err error) {
 		paused := atomic.LoadInt32(&db.inWritePaused) == 1
 		value = fmt.Sprintf("DelayN:%d Delay:%s Paused:%t", writeDelayN, writeDelay, paused)
 	case p == "sstables":
-		for level, tables := range v.levels {
-			value += fmt.Sprintf("--- level %d ---\n", level)
-			for _, t := range tables {
-				value += fmt.Sprintf("%d:%d[%q .. %q]\n", t.fd.Num, t.size, t.imin, t.imax)
-			}
+		value = sstablesProperty(v)
+	case p == "columnfamilies":
+		for _, cf := range db.families() {
+			value += fmt.Sprintf("%d:%s\n", cf.s.cfID, cf.s.cfName)
 		}
 	case p == "blockpool":
 		value = fmt.Sprintf("%v", db.s.tops.blockBuffer)
@@ -1050,6 +1189,65 @@ func (db *DB) GetProperty(name string) (value string, err error) {
 	return
 }
 
+const numFilesPrefix = "num-files-at-level"
+
+func numFilesProperty(v *version, p string) (string, error) {
+	var level uint
+	var rest string
+	n, _ := fmt.Sscanf(p[len(numFilesPrefix):], "%d%s", &level, &rest)
+	if n != 1 {
+		return "", ErrNotFound
+	}
+	return fmt.Sprint(v.tLen(int(level))), nil
+}
+
+func statsProperty(v *version, stats *cStats) string {
+	value := "Compactions\n" +
+		" Level |   Tables   |    Size(MB)   |    Time(sec)  |    Read(MB)   |   Write(MB)\n" +
+		"-------+------------+---------------+---------------+---------------+---------------\n"
+	var totalTables int
+	var totalSize, totalRead, totalWrite int64
+	var totalDuration time.Duration
+	for level, tables := range v.levels {
+		duration, read, write := stats.getStat(level)
+		if len(tables) == 0 && duration == 0 {
+			continue
+		}
+		totalTables += len(tables)
+		totalSize += tables.size()
+		totalRead += read
+		totalWrite += write
+		totalDuration += duration
+		value += fmt.Sprintf(" %3d   | %10d | %13.5f | %13.5f | %13.5f | %13.5f\n",
+			level, len(tables), float64(tables.size())/1048576.0, duration.Seconds(),
+			float64(read)/1048576.0, float64(write)/1048576.0)
+	}
+	value += "-------+------------+---------------+---------------+---------------+---------------\n"
+	value += fmt.Sprintf(" Total | %10d | %13.5f | %13.5f | %13.5f | %13.5f\n",
+		totalTables, float64(totalSize)/1048576.0, totalDuration.Seconds(),
+		float64(totalRead)/1048576.0, float64(totalWrite)/1048576.0)
+	if v.s.o.GetCompactionStyle() == opt.UniversalCompaction {
+		value += "Sorted Runs\n" +
+			"  Run  | Level |   Tables   |    Size(MB)\n" +
+			"-------+-------+------------+---------------\n"
+		for i, r := range v.sortedRuns() {
+			value += fmt.Sprintf(" %4d  |  %3d  | %10d | %13.5f\n", i, r.level, len(r.tables), float64(r.size)/1048576.0)
+		}
+	}
+	return value
+}
+
+func sstablesProperty(v *version) string {
+	var value string
+	for level, tables := range v.levels {
+		value += fmt.Sprintf("--- level %d ---\n", level)
+		for _, t := range tables {
+			value += fmt.Sprintf("%d:%d[%q .. %q]\n", t.fd.Num, t.size, t.imin, t.imax)
+		}
+	}
+	return value
+}
+
 // DBStats is database statistics.
 type DBStats struct {
 	WriteDelayCount    int32
@@ -1078,6 +1276,8 @@ type DBStats struct {
 	Level0Comp    uint32
 	NonLevel0Comp uint32
 	SeekComp      uint32
+	UniversalComp uint32
+	FIFOComp      uint32
 
 	FileCacheTotal uint64
 	FileCacheMiss  uint64
@@ -1141,6 +1341,8 @@ func (db *DB) Stats(s *DBStats) error {
 	s.Level0Comp = atomic.LoadUint32(&db.level0Comp)
 	s.NonLevel0Comp = atomic.LoadUint32(&db.nonLevel0Comp)
 	s.SeekComp = atomic.LoadUint32(&db.seekComp)
+	s.UniversalComp = atomic.LoadUint32(&db.universalComp)
+	s.FIFOComp = atomic.LoadUint32(&db.fifoComp)
 
 	s.FileCacheTotal = atomic.LoadUint64(&db.s.tops.cacheTotal)
 	s.FileCacheMiss = atomic.LoadUint64(&db.s.tops.cacheMiss)
@@ -1162,27 +1364,7 @@ func (db *DB) SizeOf(ranges []util.Range) (Sizes, error) {
 
 	v := db.s.version()
 	defer v.release()
-
-	sizes := make(Sizes, 0, len(ranges))
-	for _, r := range ranges {
-		imin := makeInternalKey(nil, r.Start, keyMaxSeq, keyTypeSeek)
-		imax := makeInternalKey(nil, r.Limit, keyMaxSeq, keyTypeSeek)
-		start, err := v.offsetOf(imin)
-		if err != nil {
-			return nil, err
-		}
-		limit, err := v.offsetOf(imax)
-		if err != nil {
-			return nil, err
-		}
-		var size int64
-		if limit >= start {
-			size = limit - start
-		}
-		sizes = append(sizes, size)
-	}
-
-	return sizes, nil
+	return v.sizeOf(ranges)
 }
 
 // Close closes the DB. This will also releases any outstanding snapshot,
@@ -8,6 +8,7 @@ package leveldb
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,6 +16,7 @@ import (
 	"github.com/3JoB/goleveldb/errors"
 	"github.com/3JoB/goleveldb/opt"
 	"github.com/3JoB/goleveldb/storage"
+	"github.com/3JoB/goleveldb/table"
 )
 
 var (
@@ -258,14 +260,57 @@ func (db *DB) compactionExitTransact() {
 	panic(errCompactionTransactExiting)
 }
 
-func (db *DB) compactionCommit(name string, rec *sessionRecord) {
+func (db *DB) compactionCommit(name string, s *session, rec *sessionRecord) {
 	db.compCommitLk.Lock()
 	defer db.compCommitLk.Unlock() // Defer is necessary.
 	db.compactionTransactFunc(name+"@commit", func(cnt *compactionTransactCounter) error {
-		return db.s.commit(rec, true)
+		return s.commit(rec, true)
 	}, nil)
 }
 
+func (db *DB) familyMemCompaction(cf *ColumnFamily, mdb *memDB) {
+	db.logf("memdb@flush C·%d N·%d S·%s", cf.s.cfID, mdb.Len(), shortenb(int64(mdb.Size())))
+
+	var (
+		rec        = &sessionRecord{}
+		stats      = &cStatStaging{}
+		flushLevel int
+	)
+
+	// Generate tables.
+	db.compactionTransactFunc("memdb@flush", func(cnt *compactionTransactCounter) (err error) {
+		stats.startTimer()
+		flushLevel, err = cf.s.flushMemdb(rec, mdb.DB, nil, db.memdbMaxLevel)
+		stats.stopTimer()
+		return
+	}, func() error {
+		for _, r := range rec.addedTables {
+			db.logf("memdb@flush revert @%d", r.num)
+			if err := db.s.stor.Remove(storage.FileDesc{Type: storage.TypeTable, Num: r.num}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	// Journal records of the column family up to this seq no longer need
+	// to be replayed.
+	rec.setSeqNum(db.frozenSeq)
+
+	// Commit.
+	stats.startTimer()
+	db.compactionCommit("memdb", cf.s, rec)
+	stats.stopTimer()
+
+	db.logf("memdb@flush committed C·%d F·%d T·%v", cf.s.cfID, len(rec.addedTables), stats.duration)
+
+	// Save compaction stats
+	for _, r := range rec.addedTables {
+		stats.write += r.size
+	}
+	cf.compStats.addStat(flushLevel, stats)
+}
+
 func (db *DB) memCompaction() {
 	mdb := db.getFrozenMem()
 	if mdb == nil {
@@ -273,10 +318,17 @@ func (db *DB) memCompaction() {
 	}
 	defer mdb.decref()
 
+	cfs, fmdbs := db.getFamilyFrozenMems()
+	defer func() {
+		for _, m := range fmdbs {
+			m.decref()
+		}
+	}()
+
 	db.logf("memdb@flush N·%d S·%s", mdb.Len(), shortenb(int64(mdb.Size())))
 
 	// Don't compact empty memdb.
-	if mdb.Len() == 0 {
+	if mdb.Len() == 0 && mdb.rdel.Len() == 0 && len(fmdbs) == 0 {
 		db.logf("memdb@flush skipping")
 		// drop frozen memdb
 		db.dropFrozenMem()
@@ -294,6 +346,12 @@ func (db *DB) memCompaction() {
 		db.compactionExitTransact()
 	}
 
+	// Flush column families first, the frozen journal is shared and
+	// could only be dropped after all of them are flushed.
+	for i, cf := range cfs {
+		db.familyMemCompaction(cf, fmdbs[i])
+	}
+
 	var (
 		rec        = &sessionRecord{}
 		stats      = &cStatStaging{}
@@ -301,27 +359,29 @@ func (db *DB) memCompaction() {
 	)
 
 	// Generate tables.
-	db.compactionTransactFunc("memdb@flush", func(cnt *compactionTransactCounter) (err error) {
-		stats.startTimer()
-		flushLevel, err = db.s.flushMemdb(rec, mdb.DB, db.memdbMaxLevel)
-		stats.stopTimer()
-		return
-	}, func() error {
-		for _, r := range rec.addedTables {
-			db.logf("memdb@flush revert @%d", r.num)
-			if err := db.s.stor.Remove(storage.FileDesc{Type: storage.TypeTable, Num: r.num}); err != nil {
-				return err
+	if mdb.Len() > 0 || mdb.rdel.Len() > 0 {
+		db.compactionTransactFunc("memdb@flush", func(cnt *compactionTransactCounter) (err error) {
+			stats.startTimer()
+			flushLevel, err = db.s.flushMemdb(rec, mdb.DB, mdb.rdel, db.memdbMaxLevel)
+			stats.stopTimer()
+			return
+		}, func() error {
+			for _, r := range rec.addedTables {
+				db.logf("memdb@flush revert @%d", r.num)
+				if err := db.s.stor.Remove(storage.FileDesc{Type: storage.TypeTable, Num: r.num}); err != nil {
+					return err
+				}
 			}
-		}
-		return nil
-	})
+			return nil
+		})
+	}
 
 	rec.setJournalNum(db.journalFd.Num)
 	rec.setSeqNum(db.frozenSeq)
 
 	// Commit.
 	stats.startTimer()
-	db.compactionCommit("memdb", rec)
+	db.compactionCommit("memdb", db.s, rec)
 	stats.stopTimer()
 
 	db.logf("memdb@flush committed F·%d T·%v", len(rec.addedTables), stats.duration)
@@ -363,6 +423,7 @@ type tableCompactionBuilder struct {
 	snapIter        int
 	snapKerrCnt     int
 	snapDropCnt     int
+	snapRdLower     []byte
 
 	kerrCnt int
 	dropCnt int
@@ -371,11 +432,180 @@ type tableCompactionBuilder struct {
 	strict    bool
 	tableSize int
 
+	// Range tombstones of the compacted tables, fragmented; rdKeep holds
+	// those that should be written to the output tables. The tombstones
+	// written to the current table are clipped to start from rdLower.
+	rdFrags rangeFragments
+	rdKeep  rangeTombstones
+	rdLower []byte
+
+	// Merge operands of the current user key that are visible to all
+	// snapshots, from the newest, along with their sequence numbers.
+	mo        opt.MergeOperator
+	mergeUkey []byte
+	mergeOps  [][]byte
+	mergeSeqs []uint64
+
+	// Compaction filter; entries newer than filterSeq aren't visible to any
+	// snapshot.
+	filter    opt.CompactionFilter
+	filterSeq uint64
+
+	// ttl is true if values carry their expiry time, see Options.TTL.
+	ttl bool
+
+	// Compression dictionary of the output tables, trained once from
+	// samples of the compacted entries.
+	dictTrained bool
+	dict        []byte
+
 	tw *tWriter
 }
 
-func (b *tableCompactionBuilder) appendKV(key, value []byte) error {
-	// Create new table if not already.
+func (b *tableCompactionBuilder) loadRangeDels() error {
+	ro := &opt.ReadOptions{
+		DontFillCache: true,
+		Strict:        opt.StrictOverride,
+	}
+	var rdels rangeTombstones
+	b.rdFrags, b.rdKeep = nil, nil
+	for _, tables := range b.c.levels {
+		for _, t := range tables {
+			if !t.rangeDel {
+				continue
+			}
+			iter := b.s.tops.newRangeDelIterator(t, ro)
+			var err error
+			rdels, err = appendRangeTombstones(rdels, iter, keyMaxSeq)
+			iter.Release()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	rdels.sort(b.s.icmp)
+	b.rdFrags = rdels.fragment(b.s.icmp)
+	for _, t := range rdels {
+		// The tombstone is obsolete if no snapshot could see entries it
+		// covers and there is no data in higher levels within its range.
+		if t.seq <= b.minSeq && b.c.baseLevelForRange(t.start, t.limit) {
+			continue
+		}
+		b.rdKeep = append(b.rdKeep, t)
+	}
+	return nil
+}
+
+// Appends kept range tombstones, clipped to [rdLower, limit), into the
+// current table. A nil limit is treated as a key after all keys.
+func (b *tableCompactionBuilder) appendRangeDels(limit []byte) error {
+	var clipped rangeTombstones
+	for _, t := range b.rdKeep {
+		start, tlimit := t.start, t.limit
+		if limit != nil && b.s.icmp.uCompare(start, limit) >= 0 {
+			break
+		}
+		if b.rdLower != nil && b.s.icmp.uCompare(start, b.rdLower) < 0 {
+			start = b.rdLower
+		}
+		if limit != nil && b.s.icmp.uCompare(tlimit, limit) > 0 {
+			tlimit = limit
+		}
+		if b.s.icmp.uCompare(start, tlimit) < 0 {
+			clipped = append(clipped, rangeTombstone{start: start, limit: tlimit, seq: t.seq})
+		}
+	}
+	if len(clipped) > 0 {
+		clipped.sort(b.s.icmp)
+		if err := b.openTable(); err != nil {
+			return err
+		}
+		var ikey []byte
+		for _, t := range clipped {
+			ikey = makeInternalKey(ikey, t.start, t.seq, keyTypeRangeDel)
+			if err := b.tw.appendRangeDel(ikey, t.limit); err != nil {
+				return err
+			}
+		}
+	}
+	b.rdLower = append(b.rdLower[:0], limit...)
+	return nil
+}
+
+// The compression dictionary is trained from samples of up to this many
+// times the dictionary size.
+const compressionDictSampleFactor = 100
+
+// Trains the compression dictionary of the output tables from samples of the
+// compacted entries, if enabled. Training failure isn't fatal, the tables are
+// then built without dictionary.
+func (b *tableCompactionBuilder) trainDict() {
+	if b.dictTrained {
+		return
+	}
+	b.dictTrained = true
+	size := b.s.o.GetCompressionDictSize()
+	if size <= 0 {
+		return
+	}
+
+	samples, err := b.dictSamples(size * compressionDictSampleFactor)
+	if err != nil {
+		b.s.logf("table@build compression dictionary sampling failed: %v", err)
+		return
+	}
+	dict, err := table.TrainCompressionDict(b.s.o.GetCompressionPerLevel(b.c.outLevel), samples, size)
+	if err != nil {
+		b.s.logf("table@build compression dictionary training failed: %v", err)
+		return
+	}
+	b.dict = dict
+}
+
+// Returns samples of up to budget bytes of the compacted entries. Each input
+// table is sampled from its start, for a share of the budget proportional to
+// its size, so that the samples are spread along the whole input.
+func (b *tableCompactionBuilder) dictSamples(budget int) (samples [][]byte, err error) {
+	var total int64
+	for _, tables := range b.c.levels {
+		total += tables.size()
+	}
+	if total == 0 {
+		return nil, nil
+	}
+
+	ro := &opt.ReadOptions{
+		DontFillCache: true,
+		Strict:        opt.StrictOverride,
+	}
+	slice := makeInternalRange(b.c.slice)
+	for _, tables := range b.c.levels {
+		for _, t := range tables {
+			quota := int(int64(budget) * t.size / total)
+			iter := b.s.tops.newIterator(t, slice, ro)
+			for n := 0; n < quota && iter.Next(); {
+				ukey, _, kt, kerr := parseInternalKey(iter.Key())
+				if kerr != nil {
+					continue
+				}
+				samples = append(samples, append([]byte(nil), ukey...))
+				n += len(ukey)
+				if kt.hasValue() {
+					samples = append(samples, append([]byte(nil), iter.Value()...))
+					n += len(iter.Value())
+				}
+			}
+			err = iter.Error()
+			iter.Release()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return
+}
+
+func (b *tableCompactionBuilder) openTable() error {
 	if b.tw == nil {
 		// Check for pause event.
 		if b.db != nil {
@@ -390,16 +620,108 @@ func (b *tableCompactionBuilder) appendKV(key, value []byte) error {
 
 		// Create new table.
 		var err error
-		b.tw, err = b.s.tops.create()
+		b.tw, err = b.s.tops.create(b.c.outLevel)
 		if err != nil {
 			return err
 		}
+		if b.dict != nil {
+			if err := b.tw.tw.SetCompressionDict(b.dict); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *tableCompactionBuilder) appendKV(key, value []byte) error {
+	// Create new table if not already.
+	if err := b.openTable(); err != nil {
+		return err
 	}
 
 	// Write key/value into table.
 	return b.tw.append(key, value)
 }
 
+// Appends the pending merge operands into the current table. If base is
+// true the operands are merged into the given value, where a nil value
+// means the key doesn't exist; merged reports whether it succeed, in which
+// case the base entry is obsolete. Otherwise the operands are combined
+// using partial merge when possible.
+func (b *tableCompactionBuilder) flushMerge(base bool, value []byte) (merged bool, err error) {
+	if len(b.mergeOps) == 0 {
+		return false, nil
+	}
+	defer func() {
+		b.mergeOps = b.mergeOps[:0]
+		b.mergeSeqs = b.mergeSeqs[:0]
+	}()
+
+	ukey := b.mergeUkey
+	operands := make([][]byte, len(b.mergeOps))
+	for i, op := range b.mergeOps {
+		operands[len(b.mergeOps)-1-i] = op
+	}
+	if !base && b.c.baseLevelForKey(ukey) {
+		// There is no data in lower levels.
+		base, value = true, nil
+	}
+	if base {
+		if v, ok := b.mo.FullMerge(ukey, value, operands); ok {
+			return true, b.appendKV(makeInternalKey(nil, ukey, b.mergeSeqs[0], keyTypeVal), v)
+		}
+	} else if len(operands) > 1 {
+		op, ok := operands[0], true
+		for _, right := range operands[1:] {
+			if op, ok = b.mo.PartialMerge(ukey, op, right); !ok {
+				break
+			}
+		}
+		if ok {
+			return false, b.appendKV(makeInternalKey(nil, ukey, b.mergeSeqs[0], keyTypeMerge), op)
+		}
+	}
+
+	// Keep the operands as is.
+	for i, op := range b.mergeOps {
+		if err := b.appendKV(makeInternalKey(nil, ukey, b.mergeSeqs[i], keyTypeMerge), op); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Applies the compaction filter to the given entry, the latest of its key.
+// It returns true if the entry has been removed or replaced.
+func (b *tableCompactionBuilder) filterKV(ukey []byte, seq uint64, value []byte) (bool, error) {
+	var expiry uint64
+	if b.ttl {
+		value, expiry = splitTTL(value)
+	}
+	decision, newValue := b.filter.Filter(b.c.sourceLevel, ukey, value)
+	switch decision {
+	case opt.CompactionRemove:
+		return true, b.removeKV(ukey, seq)
+	case opt.CompactionChangeValue:
+		if b.ttl {
+			newValue = appendTTL(nil, newValue, expiry)
+		}
+		return true, b.appendKV(makeInternalKey(nil, ukey, seq, keyTypeVal), newValue)
+	}
+	return false, nil
+}
+
+// Removes the given entry, the value of its key.
+func (b *tableCompactionBuilder) removeKV(ukey []byte, seq uint64) error {
+	b.dropCnt++
+	// Older entries of the key are dropped as well if visible to all
+	// snapshots, otherwise they must be shadowed.
+	if seq <= b.minSeq && b.c.baseLevelForKey(ukey) {
+		return nil
+	}
+	return b.appendKV(makeInternalKey(nil, ukey, seq, keyTypeDel), nil)
+}
+
 func (b *tableCompactionBuilder) needFlush() bool {
 	return b.tw.tw.BytesLen() >= b.tableSize
 }
@@ -409,9 +731,13 @@ func (b *tableCompactionBuilder) flush() error {
 	if err != nil {
 		return err
 	}
-	b.rec.addTableFile(b.c.sourceLevel+1, t)
+	if ctime := b.c.ctime(); ctime != 0 {
+		// Keep the age of the compacted data.
+		t.ctime = ctime
+	}
+	b.rec.addTableFile(b.c.outLevel, t)
 	b.stat1.write += t.size
-	b.s.logf("table@build created L%d@%d N·%d S·%s %q:%q", b.c.sourceLevel+1, t.fd.Num, b.tw.tw.EntriesLen(), shortenb(t.size), t.imin, t.imax)
+	b.s.logf("table@build created L%d@%d N·%d S·%s %q:%q", b.c.outLevel, t.fd.Num, b.tw.tw.EntriesLen(), shortenb(t.size), t.imin, t.imax)
 	b.tw = nil
 	return nil
 }
@@ -433,6 +759,9 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) (err error)
 	lastSeq := b.snapLastSeq
 	b.kerrCnt = b.snapKerrCnt
 	b.dropCnt = b.snapDropCnt
+	b.rdLower = append([]byte(nil), b.snapRdLower...)
+	b.mergeOps = b.mergeOps[:0]
+	b.mergeSeqs = b.mergeSeqs[:0]
 	// Restore compaction state.
 	b.c.restore()
 
@@ -449,6 +778,11 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) (err error)
 	b.stat1.startTimer()
 	defer b.stat1.stopTimer()
 
+	if err := b.loadRangeDels(); err != nil {
+		return err
+	}
+	b.trainDict()
+
 	iter := b.c.newIterator()
 	defer iter.Release()
 	for i := 0; iter.Next(); i++ {
@@ -472,11 +806,20 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) (err error)
 		if kerr == nil {
 			shouldStop := !resumed && b.c.shouldStopBefore(ikey)
 
+			newest := false
 			if !hasLastUkey || b.s.icmp.uCompare(lastUkey, ukey) != 0 {
+				newest = true
+
 				// First occurrence of this user key.
+				if _, err := b.flushMerge(false, nil); err != nil {
+					return err
+				}
 
 				// Only rotate tables if ukey doesn't hop across.
 				if b.tw != nil && (shouldStop || b.needFlush()) {
+					if err := b.appendRangeDels(ukey); err != nil {
+						return err
+					}
 					if err := b.flush(); err != nil {
 						return err
 					}
@@ -489,6 +832,7 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) (err error)
 					b.snapIter = i
 					b.snapKerrCnt = b.kerrCnt
 					b.snapDropCnt = b.dropCnt
+					b.snapRdLower = append(b.snapRdLower[:0], b.rdLower...)
 				}
 
 				hasLastUkey = true
@@ -496,6 +840,32 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) (err error)
 				lastSeq = keyMaxSeq
 			}
 
+			if len(b.mergeOps) > 0 {
+				// Underneath merge operands visible to all snapshots.
+				covered := kt != keyTypeDel && b.rdFrags.coverSeq(b.s.icmp, ukey, b.minSeq) > seq
+				if kt == keyTypeMerge && !covered {
+					b.mergeOps = append(b.mergeOps, append([]byte(nil), iter.Value()...))
+					b.mergeSeqs = append(b.mergeSeqs, seq)
+					lastSeq = seq
+					continue
+				}
+				var value []byte
+				if kt == keyTypeVal && !covered {
+					value = append([]byte{}, iter.Value()...)
+				}
+				merged, err := b.flushMerge(value != nil, value)
+				if err != nil {
+					return err
+				}
+				if merged {
+					lastSeq = seq
+					b.dropCnt++
+					continue
+				}
+				// The operands are kept, so is the entry underneath.
+				lastSeq = keyMaxSeq
+			}
+
 			switch {
 			case lastSeq <= b.minSeq:
 				// Dropped because newer entry for same user key exist
@@ -511,13 +881,46 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) (err error)
 				lastSeq = seq
 				b.dropCnt++
 				continue
+			case kt != keyTypeDel && b.rdFrags.coverSeq(b.s.icmp, ukey, b.minSeq) > seq:
+				// Deleted by a range tombstone that is visible to all
+				// snapshots.
+				lastSeq = seq
+				b.dropCnt++
+				continue
+			case kt == keyTypeMerge && seq <= b.minSeq && b.mo != nil:
+				// Merge operands visible to all snapshots are collected
+				// until the entry underneath them is known.
+				lastSeq = seq
+				b.mergeUkey = append(b.mergeUkey[:0], ukey...)
+				b.mergeOps = append(b.mergeOps, append([]byte(nil), iter.Value()...))
+				b.mergeSeqs = append(b.mergeSeqs, seq)
+				continue
 			default:
 				lastSeq = seq
+				if kt == keyTypeVal && b.ttl && b.db.ttlValueExpired(iter.Value()) {
+					// Expired values aren't visible to any snapshot.
+					if err := b.removeKV(ukey, seq); err != nil {
+						return err
+					}
+					continue
+				}
+				if newest && kt == keyTypeVal && seq > b.filterSeq && b.filter != nil {
+					filtered, err := b.filterKV(ukey, seq, iter.Value())
+					if err != nil {
+						return err
+					}
+					if filtered {
+						continue
+					}
+				}
 			}
 		} else {
 			if b.strict {
 				return kerr
 			}
+			if _, err := b.flushMerge(false, nil); err != nil {
+				return err
+			}
 
 			// Don't drop corrupted keys.
 			hasLastUkey = false
@@ -536,6 +939,16 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) (err error)
 	}
 
 	// Finish last table.
+	if _, err := b.flushMerge(false, nil); err != nil {
+		return err
+	}
+	var limit []byte
+	if b.c.slice != nil {
+		limit = b.c.slice.Limit
+	}
+	if err := b.appendRangeDels(limit); err != nil {
+		return err
+	}
 	if b.tw != nil && !b.tw.empty() {
 		return b.flush()
 	}
@@ -552,56 +965,206 @@ func (b *tableCompactionBuilder) revert() error {
 	return nil
 }
 
+// subcompactionBuilder builds the output tables of a compaction split into
+// disjoint key ranges, each one built concurrently by its own
+// tableCompactionBuilder. The output tables are added to the record of the
+// whole compaction once all ranges are built.
+type subcompactionBuilder struct {
+	b    *tableCompactionBuilder
+	subs []*tableCompactionBuilder
+	done []bool
+}
+
+func newSubcompactionBuilder(b *tableCompactionBuilder, bounds [][]byte) *subcompactionBuilder {
+	sb := &subcompactionBuilder{
+		b:    b,
+		subs: make([]*tableCompactionBuilder, len(bounds)+1),
+		done: make([]bool, len(bounds)+1),
+	}
+	for i := range sb.subs {
+		var umin, umax []byte
+		if i > 0 {
+			umin = bounds[i-1]
+		}
+		if i < len(bounds) {
+			umax = bounds[i]
+		}
+		sub := *b
+		sub.c = b.c.sub(umin, umax)
+		sub.rec = &sessionRecord{}
+		sub.stat1 = &cStatStaging{}
+		sub.snapRdLower = append([]byte(nil), umin...)
+		sb.subs[i] = &sub
+	}
+	return sb
+}
+
+func (sb *subcompactionBuilder) run(cnt *compactionTransactCounter) error {
+	sb.b.stat1.startTimer()
+	defer sb.b.stat1.stopTimer()
+
+	// Train the compression dictionary once for all ranges.
+	sb.b.trainDict()
+
+	var (
+		wg     sync.WaitGroup
+		cnts   = make([]compactionTransactCounter, len(sb.subs))
+		errs   = make([]error, len(sb.subs))
+		panics = make([]interface{}, len(sb.subs))
+	)
+	for i, sub := range sb.subs {
+		if sb.done[i] {
+			continue
+		}
+		sub.dictTrained, sub.dict = true, sb.b.dict
+		wg.Add(1)
+		go func(i int, sub *tableCompactionBuilder) {
+			defer wg.Done()
+			defer func() {
+				x := recover()
+				if msg, ok := x.(string); ok && strings.HasPrefix(msg, "leveldb: internal key ") {
+					// Seeking into a table holding a corrupted key, which
+					// is reported as corruption of the compaction input.
+					errs[i] = errors.NewErrCorrupted(storage.FileDesc{}, errors.New(msg))
+				} else {
+					// Exiting the transaction is handled by the caller.
+					panics[i] = x
+				}
+			}()
+			errs[i] = sub.run(&cnts[i])
+		}(i, sub)
+	}
+	wg.Wait()
+
+	for i := range sb.subs {
+		*cnt += cnts[i]
+		if !sb.done[i] && errs[i] == nil && panics[i] == nil {
+			sb.done[i] = true
+		}
+	}
+	for _, x := range panics {
+		if x != nil {
+			panic(x)
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	// All ranges are built, collect their output.
+	for _, sub := range sb.subs {
+		for _, r := range sub.rec.addedTables {
+			sb.b.rec.addTableFile(r.level, tableFileFromRecord(r))
+		}
+		sb.b.stat1.write += sub.stat1.write
+		sb.b.kerrCnt += sub.kerrCnt
+		sb.b.dropCnt += sub.dropCnt
+	}
+	return nil
+}
+
+func (sb *subcompactionBuilder) revert() error {
+	for _, sub := range sb.subs {
+		if err := sub.revert(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 	defer c.release()
 
 	rec := &sessionRecord{}
+	if c.typ == fifoCompaction {
+		// Tables are dropped as a whole, nothing is written.
+		var (
+			nTables int
+			size    int64
+		)
+		for i, tables := range c.levels {
+			for _, t := range tables {
+				rec.delTable(c.sourceLevel+i, t.fd.Num)
+				nTables++
+				size += t.size
+			}
+		}
+		db.logf("table@compaction fifo dropping F·%d S·%s", nTables, shortenb(size))
+		db.compactionCommit("table-fifo", c.s, rec)
+		atomic.AddUint32(&db.fifoComp, 1)
+		return
+	}
 	rec.addCompPtr(c.sourceLevel, c.imax)
 
 	if !noTrivial && c.trivial() {
 		t := c.levels[0][0]
-		db.logf("table@move L%d@%d -> L%d", c.sourceLevel, t.fd.Num, c.sourceLevel+1)
+		db.logf("table@move L%d@%d -> L%d", c.sourceLevel, t.fd.Num, c.outLevel)
 		rec.delTable(c.sourceLevel, t.fd.Num)
-		rec.addTableFile(c.sourceLevel+1, t)
-		db.compactionCommit("table-move", rec)
+		rec.addTableFile(c.outLevel, t)
+		db.compactionCommit("table-move", c.s, rec)
 		return
 	}
 
 	var stats [2]cStatStaging
 	for i, tables := range c.levels {
 		for _, t := range tables {
-			stats[i].read += t.size
+			stats[0].read += t.size
 			// Insert deleted tables into record
 			rec.delTable(c.sourceLevel+i, t.fd.Num)
 		}
 	}
-	sourceSize := stats[0].read + stats[1].read
+	sourceSize := stats[0].read
 	minSeq := db.minSeq()
-	db.logf("table@compaction L%d·%d -> L%d·%d S·%s Q·%d", c.sourceLevel, len(c.levels[0]), c.sourceLevel+1, len(c.levels[1]), shortenb(sourceSize), minSeq)
+	switch c.typ {
+	case universalCompaction:
+		var nTables int
+		for _, tables := range c.levels {
+			nTables += len(tables)
+		}
+		db.logf("table@compaction universal L%d..L%d·%d -> L%d S·%s Q·%d", c.sourceLevel, c.sourceLevel+len(c.levels)-1, nTables, c.outLevel, shortenb(sourceSize), minSeq)
+	case fifoMergeCompaction:
+		db.logf("table@compaction fifo merging L0·%d -> L0 S·%s Q·%d", len(c.levels[0]), shortenb(sourceSize), minSeq)
+	default:
+		db.logf("table@compaction L%d·%d -> L%d·%d S·%s Q·%d", c.sourceLevel, len(c.levels[0]), c.outLevel, len(c.levels[1]), shortenb(sourceSize), minSeq)
+	}
 
 	b := &tableCompactionBuilder{
 		db:        db,
-		s:         db.s,
+		s:         c.s,
 		c:         c,
 		rec:       rec,
 		stat1:     &stats[1],
 		minSeq:    minSeq,
-		strict:    db.s.o.GetStrict(opt.StrictCompaction),
-		tableSize: db.s.o.GetCompactionTableSize(c.sourceLevel + 1),
+		strict:    c.s.o.GetStrict(opt.StrictCompaction),
+		mo:        c.s.o.GetMergeOperator(),
+		filter:    c.s.o.GetCompactionFilter(),
+		filterSeq: db.maxSnapSeq(),
+		ttl:       c.s == db.s && db.ttlEnabled(),
+		tableSize: c.s.o.GetCompactionTableSize(c.outLevel),
+	}
+	// Subcompactions seek into the compacted tables, hence can't skip over
+	// corrupted keys. Merging FIFO tables is meant to reduce their number.
+	if bounds := c.subBounds(c.s.o.GetMaxSubcompactions()); len(bounds) > 0 && b.strict && c.typ != fifoMergeCompaction {
+		db.logf("table@compaction splitting into %d subcompactions", len(bounds)+1)
+		db.compactionTransact("table@build", newSubcompactionBuilder(b, bounds))
+	} else {
+		db.compactionTransact("table@build", b)
 	}
-	db.compactionTransact("table@build", b)
 
 	// Commit.
 	stats[1].startTimer()
-	db.compactionCommit("table", rec)
+	db.compactionCommit("table", c.s, rec)
 	stats[1].stopTimer()
 
 	resultSize := stats[1].write
 	db.logf("table@compaction committed F%s S%s Ke·%d D·%d T·%v", sint(len(rec.addedTables)-len(rec.deletedTables)), sshortenb(resultSize-sourceSize), b.kerrCnt, b.dropCnt, stats[1].duration)
 
 	// Save compaction stats
+	compStats := db.compStatsOf(c.s)
 	for i := range stats {
-		db.compStats.addStat(c.sourceLevel+1, &stats[i])
+		compStats.addStat(c.outLevel, &stats[i])
 	}
 	switch c.typ {
 	case level0Compaction:
@@ -610,33 +1173,42 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 		atomic.AddUint32(&db.nonLevel0Comp, 1)
 	case seekCompaction:
 		atomic.AddUint32(&db.seekComp, 1)
+	case universalCompaction:
+		atomic.AddUint32(&db.universalComp, 1)
+	case fifoMergeCompaction:
+		atomic.AddUint32(&db.fifoComp, 1)
 	}
 }
 
-func (db *DB) tableRangeCompaction(level int, umin, umax []byte) error {
+func (db *DB) tableRangeCompaction(s *session, level int, umin, umax []byte) error {
 	db.logf("table@compaction range L%d %q:%q", level, umin, umax)
-	if level >= 0 {
-		if c := db.s.getCompactionRange(level, umin, umax, true); c != nil {
+	if s.o.GetCompactionStyle() == opt.FIFOCompaction {
+		if c := s.getFIFOCompactionRange(umin, umax); c != nil {
+			db.tableCompaction(c, true)
+		}
+	} else if level >= 0 {
+		if c := s.getCompactionRange(level, umin, umax, true); c != nil {
 			db.tableCompaction(c, true)
 		}
 	} else {
 		// Retry until nothing to compact.
+		var m int
 		for {
 			compacted := false
 
 			// Scan for maximum level with overlapped tables.
-			v := db.s.version()
-			m := 1
+			v := s.version()
+			m = 1
 			for i := m; i < len(v.levels); i++ {
 				tables := v.levels[i]
-				if tables.overlaps(db.s.icmp, umin, umax, false) {
+				if tables.overlaps(s.icmp, umin, umax, false) {
 					m = i
 				}
 			}
 			v.release()
 
 			for level := 0; level < m; level++ {
-				if c := db.s.getCompactionRange(level, umin, umax, false); c != nil {
+				if c := s.getCompactionRange(level, umin, umax, false); c != nil {
 					db.tableCompaction(c, true)
 					compacted = true
 				}
@@ -646,28 +1218,87 @@ func (db *DB) tableRangeCompaction(level int, umin, umax []byte) error {
 				break
 			}
 		}
+
+		// Expired entries of the deepest level are only purged by
+		// compacting it, in place.
+		if s == db.s && db.ttlEnabled() {
+			if c := s.getCompactionInPlace(m, umin, umax); c != nil {
+				db.tableCompaction(c, true)
+			}
+		}
 	}
 
 	return nil
 }
 
 func (db *DB) tableAutoCompaction() {
-	if c := db.s.pickCompaction(); c != nil {
+	// Tables of FIFO compaction older than the age limit are dropped first,
+	// regardless of the compaction scores.
+	for _, s := range db.sessions() {
+		if !s.fifoAging() {
+			continue
+		}
+		if c := s.pickFIFOExpiry(db.ttlNow()); c != nil {
+			db.tableCompaction(c, false)
+			return
+		}
+	}
+
+	// Pick the session with highest compaction score, the DB session and
+	// column family sessions are compacted independently.
+	var (
+		cs    *session
+		score float64
+	)
+	for _, s := range db.sessions() {
+		v := s.version()
+		if v.needCompaction() && (cs == nil || v.cScore > score) {
+			cs, score = s, v.cScore
+		}
+		v.release()
+	}
+	if cs == nil {
+		return
+	}
+	if c := cs.pickCompaction(); c != nil {
 		db.tableCompaction(c, false)
 	}
 }
 
 func (db *DB) tableNeedCompaction() bool {
-	v := db.s.version()
-	defer v.release()
-	return v.needCompaction()
+	for _, s := range db.sessions() {
+		v := s.version()
+		need := v.needCompaction() || (s.fifoAging() && len(v.fifoExpired(db.ttlNow())) > 0)
+		v.release()
+		if need {
+			return true
+		}
+	}
+	return false
+}
+
+// tableExpiryC returns a channel receiving once the next table of FIFO
+// compaction gets older than the age limit, along with the function that
+// stops its timer. The channel is nil if no table expires.
+func (db *DB) tableExpiryC() (<-chan time.Time, func() bool) {
+	var next time.Time
+	for _, s := range db.sessions() {
+		v := s.version()
+		if t, ok := v.fifoNextExpiry(); ok && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+		v.release()
+	}
+	if next.IsZero() {
+		return nil, func() bool { return false }
+	}
+	t := time.NewTimer(next.Sub(db.ttlNow()))
+	return t.C, t.Stop
 }
 
 // resumeWrite returns an indicator whether we should resume write operation if enough level0 files are compacted.
 func (db *DB) resumeWrite() bool {
-	v := db.s.version()
-	defer v.release()
-	return v.tLen(0) < db.s.o.GetWriteL0PauseTrigger()
+	return db.maxL0Len() < db.s.o.GetWriteL0PauseTrigger()
 }
 
 func (db *DB) pauseCompaction(ch chan<- struct{}) {
@@ -697,6 +1328,7 @@ func (r cAuto) ack(err error) {
 }
 
 type cRange struct {
+	s        *session
 	level    int
 	min, max []byte
 	ackC     chan<- error
@@ -742,12 +1374,12 @@ func (db *DB) compTriggerWait(compC chan<- cCmd) (err error) {
 }
 
 // Send range compaction request.
-func (db *DB) compTriggerRange(compC chan<- cCmd, level int, min, max []byte) (err error) {
+func (db *DB) compTriggerRange(compC chan<- cCmd, s *session, level int, min, max []byte) (err error) {
 	ch := make(chan error)
 	defer close(ch)
 	// Send cmd.
 	select {
-	case compC <- cRange{level: level, min: min, max: max, ackC: ch}:
+	case compC <- cRange{s: s, level: level, min: min, max: max, ackC: ch}:
 	case err := <-db.compErrC:
 		return err
 	case <-db.closeC:
@@ -842,14 +1474,19 @@ func (db *DB) tCompaction() {
 				waitQ[i] = nil
 			}
 			waitQ = waitQ[:0]
+			expiryC, stopExpiry := db.tableExpiryC()
 			select {
 			case x = <-db.tcompCmdC:
 			case ch := <-db.tcompPauseC:
+				stopExpiry()
 				db.pauseCompaction(ch)
 				continue
+			case <-expiryC:
 			case <-db.closeC:
+				stopExpiry()
 				return
 			}
+			stopExpiry()
 		}
 		if x != nil {
 			switch cmd := x.(type) {
@@ -863,7 +1500,7 @@ func (db *DB) tCompaction() {
 					}
 				}
 			case cRange:
-				x.ack(db.tableRangeCompaction(cmd.level, cmd.min, cmd.max))
+				x.ack(db.tableRangeCompaction(cmd.s, cmd.level, cmd.min, cmd.max))
 			default:
 				panic("leveldb: unknown command")
 			}
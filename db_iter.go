@@ -60,24 +60,41 @@ func (db *DB) newRawIterator(auxm *memDB, auxt tFiles, slice *util.Range, ro *op
 	return mi
 }
 
+// makeInternalRange converts user key range into internal key range.
+func makeInternalRange(slice *util.Range) *util.Range {
+	if slice == nil {
+		return nil
+	}
+	islice := &util.Range{}
+	if slice.Start != nil {
+		islice.Start = makeInternalKey(nil, slice.Start, keyMaxSeq, keyTypeSeek)
+	}
+	if slice.Limit != nil {
+		islice.Limit = makeInternalKey(nil, slice.Limit, keyMaxSeq, keyTypeSeek)
+	}
+	return islice
+}
+
 func (db *DB) newIterator(auxm *memDB, auxt tFiles, seq uint64, slice *util.Range, ro *opt.ReadOptions) *dbIter {
-	var islice *util.Range
-	if slice != nil {
-		islice = &util.Range{}
-		if slice.Start != nil {
-			islice.Start = makeInternalKey(nil, slice.Start, keyMaxSeq, keyTypeSeek)
-		}
-		if slice.Limit != nil {
-			islice.Limit = makeInternalKey(nil, slice.Limit, keyMaxSeq, keyTypeSeek)
-		}
+	rawIter := db.newRawIterator(auxm, auxt, makeInternalRange(slice), ro)
+	iter := db.newDBIter(db.s, rawIter, seq, ro)
+	if rdels, err := db.rangeDels(); err != nil {
+		iter.setErr(err)
+	} else {
+		iter.rdels = rdels
 	}
-	rawIter := db.newRawIterator(auxm, auxt, islice, ro)
+	return iter
+}
+
+func (db *DB) newDBIter(s *session, rawIter iterator.Iterator, seq uint64, ro *opt.ReadOptions) *dbIter {
 	iter := &dbIter{
 		db:              db,
-		icmp:            db.s.icmp,
+		s:               s,
+		icmp:            s.icmp,
 		iter:            rawIter,
 		seq:             seq,
-		strict:          opt.GetStrict(db.s.o.Options, ro, opt.StrictReader),
+		strict:          opt.GetStrict(s.o.Options, ro, opt.StrictReader),
+		ttl:             s == db.s && db.ttlEnabled(),
 		disableSampling: db.s.o.GetDisableSeeksCompaction() || db.s.o.GetIteratorSamplingRate() <= 0,
 		key:             make([]byte, 0),
 		value:           make([]byte, 0),
@@ -107,12 +124,19 @@ const (
 // dbIter represent an interator states over a database session.
 type dbIter struct {
 	db              *DB
+	s               *session
 	icmp            *iComparer
 	iter            iterator.Iterator
 	seq             uint64
 	strict          bool
 	disableSampling bool
 
+	// rdels holds range tombstones of the iterated memdbs and tables.
+	rdels []rangeFragments
+
+	// ttl is true if values carry their expiry time, see Options.TTL.
+	ttl bool
+
 	samplingGap int
 	dir         dir
 	key         []byte
@@ -130,10 +154,34 @@ func (i *dbIter) sampleSeek() {
 	i.samplingGap -= len(ikey) + len(i.iter.Value())
 	for i.samplingGap < 0 {
 		i.samplingGap += i.db.iterSamplingRate()
-		i.db.sampleSeek(ikey)
+		i.db.sampleSeek(i.s, ikey)
 	}
 }
 
+// Returns true if the given entry is deleted by a range tombstone.
+func (i *dbIter) rangeDeleted(ukey []byte, seq uint64) bool {
+	for _, rdels := range i.rdels {
+		if rdels.coverSeq(i.icmp, ukey, i.seq) > seq {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the current entry, a value, is expired.
+func (i *dbIter) expired() bool {
+	return i.ttl && i.db.ttlValueExpired(i.iter.Value())
+}
+
+// Sets the current value from the current entry.
+func (i *dbIter) setValue() {
+	value := i.iter.Value()
+	if i.ttl {
+		value, _ = splitTTL(value)
+	}
+	i.value = append(i.value[:0], value...)
+}
+
 func (i *dbIter) setErr(err error) {
 	i.err = err
 	i.key = nil
@@ -206,6 +254,11 @@ func (i *dbIter) next() bool {
 		if ukey, seq, kt, kerr := parseInternalKey(i.iter.Key()); kerr == nil {
 			i.sampleSeek()
 			if seq <= i.seq {
+				if kt != keyTypeDel && i.rangeDeleted(ukey, seq) {
+					kt = keyTypeDel
+				} else if kt == keyTypeVal && i.expired() {
+					kt = keyTypeDel
+				}
 				switch kt {
 				case keyTypeDel:
 					// Skip deleted key.
@@ -214,10 +267,16 @@ func (i *dbIter) next() bool {
 				case keyTypeVal:
 					if i.dir == dirSOI || i.icmp.uCompare(ukey, i.key) > 0 {
 						i.key = append(i.key[:0], ukey...)
-						i.value = append(i.value[:0], i.iter.Value()...)
+						i.setValue()
 						i.dir = dirForward
 						return true
 					}
+				case keyTypeMerge:
+					if i.dir == dirSOI || i.icmp.uCompare(ukey, i.key) > 0 {
+						i.key = append(i.key[:0], ukey...)
+						i.dir = dirForward
+						return i.mergeForward()
+					}
 				}
 			}
 		} else if i.strict {
@@ -233,6 +292,73 @@ func (i *dbIter) next() bool {
 	return false
 }
 
+// Collects merge operands of the current key, starting from the current
+// entry, and merges them into the value underneath. The underlying iterator
+// is left at the last entry consumed of the current key.
+func (i *dbIter) mergeForward() bool {
+	ops := [][]byte{append([]byte(nil), i.iter.Value()...)}
+	exist := false
+	for i.iter.Next() {
+		ukey, seq, kt, kerr := parseInternalKey(i.iter.Key())
+		if kerr != nil {
+			if i.strict {
+				i.setErr(kerr)
+				return false
+			}
+			continue
+		}
+		i.sampleSeek()
+		if i.icmp.uCompare(ukey, i.key) != 0 {
+			// Step back, so the next key won't be skipped.
+			i.iter.Prev()
+			break
+		}
+		if kt != keyTypeDel && i.rangeDeleted(ukey, seq) {
+			kt = keyTypeDel
+		}
+		if kt == keyTypeMerge {
+			ops = append(ops, append([]byte(nil), i.iter.Value()...))
+			continue
+		}
+		if kt == keyTypeVal {
+			i.value = append(i.value[:0], i.iter.Value()...)
+			exist = true
+		}
+		break
+	}
+	if err := i.iter.Error(); err != nil {
+		i.setErr(err)
+		return false
+	}
+	for l, r := 0, len(ops)-1; l < r; l, r = l+1, r-1 {
+		ops[l], ops[r] = ops[r], ops[l]
+	}
+	return i.merge(ops, exist)
+}
+
+// Merges the given operands of the current key, ordered from the oldest to
+// the newest, into the current value. The current value is ignored unless
+// exist is true.
+func (i *dbIter) merge(ops [][]byte, exist bool) bool {
+	if len(ops) == 0 {
+		return true
+	}
+	var existing []byte
+	if exist {
+		existing = i.value
+		if existing == nil {
+			existing = []byte{}
+		}
+	}
+	value, err := fullMerge(i.s.o.GetMergeOperator(), i.key, existing, ops)
+	if err != nil {
+		i.setErr(err)
+		return false
+	}
+	i.value = append(i.value[:0], value...)
+	return true
+}
+
 func (i *dbIter) Next() bool {
 	if i.dir == dirEOI || i.err != nil {
 		return false
@@ -252,18 +378,39 @@ func (i *dbIter) Next() bool {
 func (i *dbIter) prev() bool {
 	i.dir = dirBackward
 	del := true
+	var (
+		// Merge operands of the current key, from the oldest.
+		ops   [][]byte
+		exist bool
+	)
 	if i.iter.Valid() {
 		for {
 			if ukey, seq, kt, kerr := parseInternalKey(i.iter.Key()); kerr == nil {
 				i.sampleSeek()
 				if seq <= i.seq {
 					if !del && i.icmp.uCompare(ukey, i.key) < 0 {
-						return true
+						return i.merge(ops, exist)
+					}
+					if kt != keyTypeDel && i.rangeDeleted(ukey, seq) {
+						kt = keyTypeDel
+					} else if kt == keyTypeVal && i.expired() {
+						kt = keyTypeDel
 					}
-					del = (kt == keyTypeDel)
-					if !del {
+					switch kt {
+					case keyTypeDel:
+						del = true
+						ops = ops[:0]
+					case keyTypeVal:
+						del, exist = false, true
+						ops = ops[:0]
 						i.key = append(i.key[:0], ukey...)
-						i.value = append(i.value[:0], i.iter.Value()...)
+						i.setValue()
+					case keyTypeMerge:
+						if del {
+							del, exist = false, false
+							i.key = append(i.key[:0], ukey...)
+						}
+						ops = append(ops, append([]byte(nil), i.iter.Value()...))
 					}
 				}
 			} else if i.strict {
@@ -280,7 +427,7 @@ func (i *dbIter) prev() bool {
 		i.iterErr()
 		return false
 	}
-	return true
+	return i.merge(ops, exist)
 }
 
 func (i *dbIter) Prev() bool {
@@ -342,6 +489,7 @@ func (i *dbIter) Release() {
 		i.dir = dirReleased
 		i.key = nil
 		i.value = nil
+		i.rdels = nil
 		i.iter.Release()
 		i.iter = nil
 		atomic.AddInt32(&i.db.aliveIters, -1)
@@ -0,0 +1,306 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+
+	"github.com/3JoB/goleveldb/errors"
+	"github.com/3JoB/goleveldb/journal"
+	"github.com/3JoB/goleveldb/opt"
+	"github.com/3JoB/goleveldb/storage"
+)
+
+type retainedJournal struct {
+	fd   storage.FileDesc
+	size int64
+	t    time.Time
+}
+
+// Returns the size of the given journal file, or zero if the size is unknown.
+func (db *DB) journalSize(fd storage.FileDesc) int64 {
+	r, err := db.s.stor.Open(fd)
+	if err != nil {
+		db.logf("journal@retain size @%d %q", fd.Num, err)
+		return 0
+	}
+	defer r.Close()
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		db.logf("journal@retain size @%d %q", fd.Num, err)
+		return 0
+	}
+	return size
+}
+
+// Removes the given obsolete journal file, or retains it for GetUpdatesSince
+// if journal retention is enabled.
+func (db *DB) removeJournal(fd storage.FileDesc) error {
+	if db.s.o.GetJournalRetentionSize() == 0 && db.s.o.GetJournalRetentionTime() == 0 {
+		return db.s.stor.Remove(fd)
+	}
+
+	db.retainMu.Lock()
+	defer db.retainMu.Unlock()
+	for _, rj := range db.retainedJournals {
+		if rj.fd == fd {
+			return nil
+		}
+	}
+	rj := retainedJournal{fd: fd, size: db.journalSize(fd), t: time.Now()}
+	i := len(db.retainedJournals)
+	db.retainedJournals = append(db.retainedJournals, rj)
+	for ; i > 0 && db.retainedJournals[i-1].fd.Num > fd.Num; i-- {
+		db.retainedJournals[i] = db.retainedJournals[i-1]
+	}
+	db.retainedJournals[i] = rj
+	db.logf("journal@retain retained @%d S·%s", fd.Num, shortenb(rj.size))
+	return db.purgeJournalsLocked()
+}
+
+// Removes retained journals exceeding the retention limits; need retainMu.
+func (db *DB) purgeJournalsLocked() error {
+	var (
+		maxSize = int64(db.s.o.GetJournalRetentionSize())
+		maxTime = db.s.o.GetJournalRetentionTime()
+		size    int64
+	)
+	for _, rj := range db.retainedJournals {
+		size += rj.size
+	}
+	for len(db.retainedJournals) > 0 {
+		rj := db.retainedJournals[0]
+		if (maxSize == 0 || size <= maxSize) && (maxTime == 0 || time.Since(rj.t) <= maxTime) {
+			break
+		}
+		if err := db.s.stor.Remove(rj.fd); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		db.logf("journal@retain removed @%d", rj.fd.Num)
+		size -= rj.size
+		db.retainedJournals = db.retainedJournals[1:]
+	}
+	return nil
+}
+
+// Removes retained journals exceeding the retention time.
+func (db *DB) purgeJournals() error {
+	db.retainMu.Lock()
+	defer db.retainMu.Unlock()
+	return db.purgeJournalsLocked()
+}
+
+// UpdateIterator iterates over the batches committed to the DB, in
+// sequence number order. It is created by DB.GetUpdatesSince.
+type UpdateIterator struct {
+	db      *DB
+	fds     []storage.FileDesc
+	seq     uint64
+	lastSeq uint64
+	strict  bool
+
+	reader   storage.Reader
+	jr       *journal.Reader
+	buf      bytes.Buffer
+	started  bool
+	batchSeq uint64
+	batch    *Batch
+	err      error
+	released bool
+}
+
+func (it *UpdateIterator) closeReader() {
+	if it.reader != nil {
+		it.reader.Close()
+		it.reader = nil
+	}
+}
+
+// Returns true if the journal being read is the last one, which might still
+// be written.
+func (it *UpdateIterator) readingLast() bool {
+	return len(it.fds) == 1
+}
+
+func (it *UpdateIterator) done() bool {
+	it.closeReader()
+	it.fds = nil
+	it.batch = nil
+	if !it.started && it.seq <= it.lastSeq {
+		// None of the requested batches found.
+		it.err = ErrJournalNotRetained
+	}
+	return false
+}
+
+// Next moves the iterator to the next batch. It returns false if the
+// iterator is exhausted or an error occurred.
+func (it *UpdateIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.released {
+		it.err = ErrIterReleased
+		return false
+	}
+	for {
+		if it.jr == nil || it.reader == nil {
+			if len(it.fds) == 0 {
+				return it.done()
+			}
+			fd := it.fds[0]
+			r, err := it.db.s.stor.Open(fd)
+			if err != nil {
+				if os.IsNotExist(err) {
+					err = ErrJournalNotRetained
+				}
+				it.err = err
+				return false
+			}
+			it.reader = r
+			if it.jr == nil {
+				it.jr = journal.NewReader(r, dropper{s: it.db.s, fd: fd}, it.strict, true)
+			} else {
+				// Reset returns the error of the previous journal.
+				_ = it.jr.Reset(r, dropper{s: it.db.s, fd: fd}, it.strict, true)
+			}
+		}
+
+		r, err := it.jr.Next()
+		if err == nil {
+			it.buf.Reset()
+			_, err = it.buf.ReadFrom(r)
+			if err == io.ErrUnexpectedEOF {
+				// Corrupted, with strict == false.
+				continue
+			}
+		}
+		if err != nil {
+			if err == io.EOF || (it.readingLast() && errors.IsCorrupted(err)) {
+				// The tail of the journal being written might be
+				// incomplete.
+				it.closeReader()
+				it.fds = it.fds[1:]
+				continue
+			}
+			it.err = errors.SetFd(err, it.fds[0])
+			return false
+		}
+
+		batchSeq, batchLen, err := decodeBatchHeader(it.buf.Bytes())
+		if err != nil {
+			it.err = errors.SetFd(err, it.fds[0])
+			return false
+		}
+		if batchSeq == 0 {
+			// Two-phase commit marker, without any write.
+			continue
+		}
+		if batchSeq > it.lastSeq {
+			return it.done()
+		}
+		if !it.started {
+			// Data is missing if the oldest retained batch is after the
+			// requested one.
+			if batchSeq > it.seq && batchSeq > 1 {
+				it.err = ErrJournalNotRetained
+				return false
+			}
+			it.started = true
+		}
+		if batchSeq+uint64(batchLen) <= it.seq {
+			continue
+		}
+		data := append([]byte(nil), it.buf.Bytes()[batchHeaderLen:]...)
+		batch := new(Batch)
+		if err := batch.decode(data, batchLen); err != nil {
+			it.err = errors.SetFd(err, it.fds[0])
+			return false
+		}
+		if it.db.ttlEnabled() {
+			batch = ttlStripBatch(batch)
+		}
+		it.batchSeq = batchSeq
+		it.batch = batch
+		return true
+	}
+}
+
+// Seq returns the sequence number of the first record of the current batch.
+func (it *UpdateIterator) Seq() uint64 {
+	return it.batchSeq
+}
+
+// Batch returns the current batch. The batch is owned by the caller, it
+// is not modified by subsequent calls to Next.
+func (it *UpdateIterator) Batch() *Batch {
+	return it.batch
+}
+
+// Error returns any accumulated error.
+func (it *UpdateIterator) Error() error {
+	return it.err
+}
+
+// Release releases the iterator. It is ok to call Release multiple times.
+func (it *UpdateIterator) Release() {
+	if !it.released {
+		it.released = true
+		it.closeReader()
+		it.fds = nil
+		it.jr = nil
+		it.batch = nil
+	}
+}
+
+// GetUpdatesSince returns an iterator of the batches committed to the DB,
+// starting from the batch containing the record with the given sequence
+// number. The iterator reads the batches from the journal files, and stops
+// at the last batch committed when GetUpdatesSince was called.
+//
+// Obsolete journal files are only retained if JournalRetentionSize or
+// JournalRetentionTime is set. The iterator reports ErrJournalNotRetained
+// if the requested batches are no longer retained.
+//
+// Batches committed using a transaction, including large batches written by
+// Write unless DisableLargeBatchTransaction is set, aren't written to the
+// journal, and so won't be returned by the iterator. Reading the journal
+// being written requires the storage to allow opening a file being written,
+// as the file-system backed storage does.
+//
+// In TTL mode, the expiry times of the values are stripped from the
+// returned batches.
+//
+// The iterator must be released after use, by calling Release method.
+func (db *DB) GetUpdatesSince(seq uint64) (*UpdateIterator, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+	if err := db.purgeJournals(); err != nil {
+		return nil, err
+	}
+
+	// Any batch up to the last sequence number is within the listed
+	// journals, as journals are only rotated after the sequence number is
+	// updated.
+	lastSeq := db.getSeq()
+	fds, err := db.s.stor.List(storage.TypeJournal)
+	if err != nil {
+		return nil, err
+	}
+	sortFds(fds)
+	return &UpdateIterator{
+		db:      db,
+		fds:     fds,
+		seq:     seq,
+		lastSeq: lastSeq,
+		strict:  db.s.o.GetStrict(opt.StrictJournal),
+	}, nil
+}
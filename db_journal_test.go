@@ -0,0 +1,136 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/3JoB/goleveldb/opt"
+	"github.com/3JoB/goleveldb/util"
+)
+
+type stringReplay struct {
+	strings.Builder
+}
+
+func (r *stringReplay) Put(key, value []byte) { fmt.Fprintf(r, "(%s,%s)", key, value) }
+func (r *stringReplay) Delete(key []byte)     { fmt.Fprintf(r, "(%s)", key) }
+func (r *stringReplay) PutCF(cf uint32, key, value []byte) {
+	fmt.Fprintf(r, "(%d:%s,%s)", cf, key, value)
+}
+func (r *stringReplay) DeleteCF(cf uint32, key []byte) { fmt.Fprintf(r, "(%d:%s)", cf, key) }
+
+// Returns the batches read by GetUpdatesSince, one per line.
+func getUpdatesSince(t *testing.T, db *DB, seq uint64) (string, error) {
+	it, err := db.GetUpdatesSince(seq)
+	if err != nil {
+		t.Fatal("GetUpdatesSince: got error: ", err)
+	}
+	defer it.Release()
+	var s []string
+	for it.Next() {
+		r := new(stringReplay)
+		it.Batch().Replay(r)
+		s = append(s, fmt.Sprintf("%d:%s", it.Seq(), r))
+	}
+	return strings.Join(s, "\n"), it.Error()
+}
+
+func TestDB_GetUpdatesSince(t *testing.T) {
+	dir := checkpointDir(t)
+	defer os.RemoveAll(dir)
+
+	o := &opt.Options{JournalRetentionSize: 64 * opt.MiB}
+	db, err := OpenFile(dir, o)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer func() { db.Close() }()
+	cf, err := db.CreateColumnFamily("cf", nil)
+	if err != nil {
+		t.Fatal("CreateColumnFamily: got error: ", err)
+	}
+
+	var want []string
+	write := func(i int) {
+		b := new(Batch)
+		b.Put([]byte(fmt.Sprintf("k%d", i)), []byte("v"))
+		b.Delete([]byte(fmt.Sprintf("d%d", i)))
+		b.PutCF(cf, []byte("c"), []byte(fmt.Sprint(i)))
+		seq := db.getSeq() + 1
+		if err := db.Write(b, nil); err != nil {
+			t.Fatal("Write: got error: ", err)
+		}
+		want = append(want, fmt.Sprintf("%d:(k%d,v)(d%d)(%d:c,%d)", seq, i, i, cf.ID(), i))
+	}
+	for i := 0; i < 5; i++ {
+		write(i)
+		// Obsolete journals are retained.
+		if err := db.CompactRange(util.Range{}); err != nil {
+			t.Fatal("CompactRange: got error: ", err)
+		}
+	}
+	write(5)
+
+	if got, err := getUpdatesSince(t, db, 0); err != nil || got != strings.Join(want, "\n") {
+		t.Errorf("GetUpdatesSince(0): got (%s, %v), want %s", got, err, strings.Join(want, "\n"))
+	}
+	// Starts from the batch containing the sequence number.
+	if got, err := getUpdatesSince(t, db, 8); err != nil || got != strings.Join(want[2:], "\n") {
+		t.Errorf("GetUpdatesSince(8): got (%s, %v), want %s", got, err, strings.Join(want[2:], "\n"))
+	}
+	if got, err := getUpdatesSince(t, db, db.getSeq()+1); err != nil || got != "" {
+		t.Errorf("GetUpdatesSince(last+1): got (%s, %v)", got, err)
+	}
+
+	// Batches written after GetUpdatesSince are not visible.
+	it, err := db.GetUpdatesSince(1)
+	if err != nil {
+		t.Fatal("GetUpdatesSince: got error: ", err)
+	}
+	write(6)
+	var n int
+	for it.Next() {
+		n++
+	}
+	it.Release()
+	if n != 6 || it.Error() != nil {
+		t.Errorf("GetUpdatesSince: got %d batches (%v), want 6", n, it.Error())
+	}
+	if it.Next() || it.Error() != ErrIterReleased {
+		t.Errorf("Next: expect ErrIterReleased, got %v", it.Error())
+	}
+
+	// Journals of a previous session are retained.
+	db.Close()
+	if db, err = OpenFile(dir, o); err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	if got, err := getUpdatesSince(t, db, 1); err != nil || got != strings.Join(want, "\n") {
+		t.Errorf("GetUpdatesSince(1) after reopen: got (%s, %v), want %s", got, err, strings.Join(want, "\n"))
+	}
+
+	// Obsolete journals are removed once the retention limit is exceeded.
+	db.Close()
+	o.JournalRetentionSize = 1
+	if db, err = OpenFile(dir, o); err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	if _, err := getUpdatesSince(t, db, 1); err != ErrJournalNotRetained {
+		t.Errorf("GetUpdatesSince: expect ErrJournalNotRetained, got %v", err)
+	}
+	seq := db.getSeq() + 1
+	if err := db.Put([]byte("a"), []byte("b"), nil); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	if got, err := getUpdatesSince(t, db, seq); err != nil || got != fmt.Sprintf("%d:(a,b)", seq) {
+		t.Errorf("GetUpdatesSince(%d): got (%s, %v)", seq, got, err)
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/3JoB/goleveldb/opt"
+)
+
+// OptimisticTransaction is the optimistic transaction handle.
+//
+// Unlike Transaction, an optimistic transaction doesn't block other writes.
+// Writes are buffered until commit, and the keys read or written by the
+// transaction are recorded along with the sequence number observed. Commit
+// fails with ErrConflict if any of those keys has been written since, in
+// which case the transaction can be retried.
+type OptimisticTransaction struct {
+	db   *DB
+	lk   sync.RWMutex
+	snap *snapshotElement
+	buf  txBuffer
+
+	// Sequence number observed by the recorded keys.
+	keys map[string]uint64
+
+	closed bool
+}
+
+// Records the given key; need tr.lk. It returns the sequence number the key
+// was first observed at.
+func (tr *OptimisticTransaction) track(key []byte) uint64 {
+	if seq, ok := tr.keys[string(key)]; ok {
+		return seq
+	}
+	seq := tr.db.getSeq()
+	tr.keys[string(key)] = seq
+	return seq
+}
+
+// Get gets the value for the given key. It returns ErrNotFound if the
+// DB does not contains the key. Writes of the transaction are visible, and
+// the key is recorded for conflict detection.
+//
+// The returned slice is its own copy, it is safe to modify the contents
+// of the returned slice.
+// It is safe to modify the contents of the argument after Get returns.
+func (tr *OptimisticTransaction) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	tr.lk.Lock()
+	defer tr.lk.Unlock()
+	if tr.closed {
+		return nil, errTransactionDone
+	}
+	seq := tr.track(key)
+	if ok, kt, value := tr.buf.get(key); ok {
+		if kt == keyTypeDel {
+			return nil, ErrNotFound
+		}
+		return append([]byte(nil), value...), nil
+	}
+	return tr.db.get(nil, nil, key, seq, ro)
+}
+
+// Has returns true if the DB does contains the given key. Writes of the
+// transaction are visible, and the key is recorded for conflict detection.
+//
+// It is safe to modify the contents of the argument after Has returns.
+func (tr *OptimisticTransaction) Has(key []byte, ro *opt.ReadOptions) (bool, error) {
+	tr.lk.Lock()
+	defer tr.lk.Unlock()
+	if tr.closed {
+		return false, errTransactionDone
+	}
+	seq := tr.track(key)
+	if ok, kt, _ := tr.buf.get(key); ok {
+		return kt != keyTypeDel, nil
+	}
+	return tr.db.has(nil, nil, key, seq, ro)
+}
+
+func (tr *OptimisticTransaction) put(kt keyType, key, value []byte) error {
+	tr.lk.Lock()
+	defer tr.lk.Unlock()
+	if tr.closed {
+		return errTransactionDone
+	}
+	tr.track(key)
+	return tr.buf.put(kt, key, value)
+}
+
+// Put sets the value for the given key. The write is buffered until commit,
+// and the key is recorded for conflict detection.
+//
+// It is safe to modify the contents of the arguments after Put returns.
+func (tr *OptimisticTransaction) Put(key, value []byte) error {
+	return tr.put(keyTypeVal, key, value)
+}
+
+// Delete deletes the value for the given key. The write is buffered until
+// commit, and the key is recorded for conflict detection.
+//
+// It is safe to modify the contents of the arguments after Delete returns.
+func (tr *OptimisticTransaction) Delete(key []byte) error {
+	return tr.put(keyTypeDel, key, nil)
+}
+
+func (tr *OptimisticTransaction) setDone() {
+	tr.closed = true
+	tr.db.releaseSnapshot(tr.snap)
+	tr.buf = txBuffer{}
+	tr.keys = nil
+}
+
+// Commit applies the writes of the transaction atomically, as a single
+// batch. It returns ErrConflict if any of the keys read or written by the
+// transaction has been written since it was observed; the transaction is
+// then discarded.
+//
+// Other methods should not be called after transaction has been committed.
+func (tr *OptimisticTransaction) Commit(wo *opt.WriteOptions) error {
+	tr.lk.Lock()
+	defer tr.lk.Unlock()
+	if tr.closed {
+		return errTransactionDone
+	}
+	db := tr.db
+	if err := db.ok(); err != nil {
+		return err
+	}
+
+	batch := tr.buf.batch()
+
+	// Hold write lock, so that no write happens between the validation and
+	// the write of the batch.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+	if err := db.checkConflict(tr.keys); err != nil {
+		<-db.writeLockC
+		if err == ErrConflict {
+			tr.setDone()
+		}
+		return err
+	}
+	if batch.Len() == 0 {
+		<-db.writeLockC
+		tr.setDone()
+		return nil
+	}
+	batch = db.ttlBatch(batch, wo)
+	// writeLocked releases the write lock.
+	if err := db.writeLocked(batch, nil, false, wo.GetSync() && !db.s.o.GetNoSync()); err != nil {
+		return err
+	}
+	tr.setDone()
+	return nil
+}
+
+// Discard discards the transaction.
+// This method is noop if transaction is already closed (either committed or
+// discarded)
+//
+// Other methods should not be called after transaction has been discarded.
+func (tr *OptimisticTransaction) Discard() {
+	tr.lk.Lock()
+	if !tr.closed {
+		tr.setDone()
+	}
+	tr.lk.Unlock()
+}
+
+// Returns ErrConflict if any of the given keys has been written after the
+// sequence number it was observed at; need write lock.
+func (db *DB) checkConflict(keys map[string]uint64) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	ukeys := make([]string, 0, len(keys))
+	for key := range keys {
+		ukeys = append(ukeys, key)
+	}
+	sort.Slice(ukeys, func(i, j int) bool {
+		return db.s.icmp.uCompare([]byte(ukeys[i]), []byte(ukeys[j])) < 0
+	})
+
+	em, fm := db.getMems()
+	for _, m := range [...]*memDB{em, fm} {
+		if m != nil {
+			defer m.decref()
+		}
+	}
+	v := db.s.version()
+	defer v.release()
+
+	// The newest record of a key is the first one, across the memdbs and
+	// the tables. Records newer than the transaction snapshot are never
+	// dropped by compaction.
+	iter := db.newRawIterator(nil, nil, nil, nil)
+	defer iter.Release()
+	var ikey internalKey
+	for _, key := range ukeys {
+		seq := keys[key]
+		ikey = makeInternalKey(ikey, []byte(key), keyMaxSeq, keyTypeSeek)
+		if iter.Seek(ikey) {
+			ukey, kseq, _, kerr := parseInternalKey(iter.Key())
+			if kerr != nil {
+				return kerr
+			}
+			if kseq > seq && db.s.icmp.uCompare(ukey, []byte(key)) == 0 {
+				return ErrConflict
+			}
+		}
+		if err := iter.Error(); err != nil {
+			return err
+		}
+		rdSeq, err := db.rangeDelSeq(em, fm, v, []byte(key), keyMaxSeq)
+		if err != nil {
+			return err
+		}
+		if rdSeq > seq {
+			return ErrConflict
+		}
+	}
+	return nil
+}
+
+// OpenOptimisticTransaction opens an optimistic transaction. Unlike
+// OpenTransaction, any number of optimistic transactions can be opened
+// concurrently, and writes to the DB aren't blocked.
+//
+// Reads of the transaction see the writes of the transaction. Writes of the
+// transaction are applied as a single batch when committed, provided no key
+// read or written by the transaction has been written since.
+//
+// The transaction must be closed once done, either by committing or
+// discarding the transaction.
+func (db *DB) OpenOptimisticTransaction() (*OptimisticTransaction, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+	return &OptimisticTransaction{
+		db:   db,
+		snap: db.acquireSnapshot(),
+		buf:  newTxBuffer(db.s.icmp.ucmp),
+		keys: make(map[string]uint64),
+	}, nil
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func (h *dbHarness) openOptimisticTransaction() *OptimisticTransaction {
+	tr, err := h.db.OpenOptimisticTransaction()
+	if err != nil {
+		h.t.Fatal("OpenOptimisticTransaction: got error: ", err)
+	}
+	return tr
+}
+
+func TestDB_OptimisticTransaction(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "v1")
+	tr := h.openOptimisticTransaction()
+	if v, err := tr.Get([]byte("a"), nil); err != nil || string(v) != "v1" {
+		t.Errorf("Get: got (%q, %v)", v, err)
+	}
+	tr.Put([]byte("a"), []byte("v2"))
+	tr.Put([]byte("b"), []byte("v2"))
+	tr.Delete([]byte("b"))
+	tr.Put([]byte("c"), []byte("v2"))
+
+	// Writes are only visible to the transaction.
+	if v, err := tr.Get([]byte("a"), nil); err != nil || string(v) != "v2" {
+		t.Errorf("Get: got (%q, %v)", v, err)
+	}
+	if ok, err := tr.Has([]byte("b"), nil); err != nil || ok {
+		t.Errorf("Has: got (%v, %v)", ok, err)
+	}
+	h.getVal("a", "v1")
+	h.get("c", false)
+
+	// Unrelated writes don't conflict.
+	h.put("d", "v1")
+	if err := tr.Commit(nil); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	h.getKeyVal("(a->v2)(c->v2)(d->v1)")
+
+	if _, err := tr.Get([]byte("a"), nil); err != errTransactionDone {
+		t.Errorf("Get: expect errTransactionDone, got %v", err)
+	}
+	if err := tr.Commit(nil); err != errTransactionDone {
+		t.Errorf("Commit: expect errTransactionDone, got %v", err)
+	}
+}
+
+func TestDB_OptimisticTransaction_Conflict(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "v1")
+	h.put("k", "v1")
+
+	tests := []struct {
+		name  string
+		write func()
+	}{
+		{"memdb", func() { h.put("k", "v2") }},
+		{"table", func() { h.put("k", "v3"); h.compactMem() }},
+		{"deletion", func() { h.delete("k") }},
+		{"range deletion", func() { h.deleteRange("j", "l") }},
+	}
+	for _, test := range tests {
+		// Read conflict.
+		tr := h.openOptimisticTransaction()
+		tr.Get([]byte("k"), nil)
+		tr.Put([]byte("a"), []byte(test.name))
+		test.write()
+		if err := tr.Commit(nil); err != ErrConflict {
+			t.Errorf("%s: Commit: expect ErrConflict, got %v", test.name, err)
+		}
+		h.getVal("a", "v1")
+		if err := tr.Commit(nil); err != errTransactionDone {
+			t.Errorf("%s: Commit: expect errTransactionDone, got %v", test.name, err)
+		}
+	}
+
+	// Write conflict.
+	tr1 := h.openOptimisticTransaction()
+	tr2 := h.openOptimisticTransaction()
+	tr1.Put([]byte("k"), []byte("tr1"))
+	tr2.Put([]byte("k"), []byte("tr2"))
+	if err := tr2.Commit(nil); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	if err := tr1.Commit(nil); err != ErrConflict {
+		t.Errorf("Commit: expect ErrConflict, got %v", err)
+	}
+	h.getVal("k", "tr2")
+}
+
+func TestDB_OptimisticTransaction_Concurrent(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	const (
+		n = 8
+		m = 50
+	)
+	h.put("counter", "0")
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < m; j++ {
+				for {
+					tr, err := h.db.OpenOptimisticTransaction()
+					if err != nil {
+						t.Error("OpenOptimisticTransaction: got error: ", err)
+						return
+					}
+					v, err := tr.Get([]byte("counter"), nil)
+					if err != nil {
+						t.Error("Get: got error: ", err)
+						tr.Discard()
+						return
+					}
+					c, _ := strconv.Atoi(string(v))
+					tr.Put([]byte("counter"), []byte(strconv.Itoa(c+1)))
+					if err := tr.Commit(nil); err == nil {
+						break
+					} else if err != ErrConflict {
+						t.Error("Commit: got error: ", err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	h.getVal("counter", strconv.Itoa(n*m))
+}
@@ -0,0 +1,299 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/3JoB/goleveldb/iterator"
+	"github.com/3JoB/goleveldb/opt"
+	"github.com/3JoB/goleveldb/util"
+)
+
+// PessimisticTransaction is the pessimistic transaction handle.
+//
+// A pessimistic transaction locks every key it writes, or reads with
+// GetForUpdate, until it is committed or discarded. Other pessimistic
+// transactions wanting the same key wait for the lock to be released, up to
+// the lock timeout. Waiting for a lock that would never be released, because
+// its owner transitively waits for the transaction, fails with ErrDeadlock.
+//
+// Writes are buffered until commit. Key locks are only honoured by
+// pessimistic transactions; plain writes to the DB don't acquire them.
+//
+// A transaction can be prepared for two-phase commit, see Prepare.
+type PessimisticTransaction struct {
+	db      *DB
+	id      uint64
+	timeout time.Duration
+	lk      sync.RWMutex
+	buf     txBuffer
+	locked  map[string]struct{}
+	name    string // set once prepared
+	closed  bool
+}
+
+// Acquires the lock of the given key; need tr.lk.
+func (tr *PessimisticTransaction) lock(key []byte) error {
+	if _, ok := tr.locked[string(key)]; ok {
+		return nil
+	}
+	if err := tr.db.keyLocks.lock(tr.id, key, tr.timeout, tr.db.closeC); err != nil {
+		return err
+	}
+	tr.locked[string(key)] = struct{}{}
+	return nil
+}
+
+// Get gets the value for the given key. It returns ErrNotFound if the
+// DB does not contains the key. Writes of the transaction are visible.
+// The key isn't locked; use GetForUpdate to lock it.
+//
+// The returned slice is its own copy, it is safe to modify the contents
+// of the returned slice.
+// It is safe to modify the contents of the argument after Get returns.
+func (tr *PessimisticTransaction) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	tr.lk.RLock()
+	defer tr.lk.RUnlock()
+	if tr.closed {
+		return nil, errTransactionDone
+	}
+	return tr.get(key, ro)
+}
+
+func (tr *PessimisticTransaction) get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	if ok, kt, value := tr.buf.get(key); ok {
+		if kt == keyTypeDel {
+			return nil, ErrNotFound
+		}
+		return append([]byte(nil), value...), nil
+	}
+	return tr.db.Get(key, ro)
+}
+
+// GetForUpdate locks the given key and gets its value. It returns
+// ErrNotFound if the DB does not contains the key. The key stays locked
+// until the transaction is committed or discarded, so the value can't be
+// changed by other pessimistic transactions meanwhile.
+//
+// It returns ErrLockTimeout if the lock can't be acquired in time, and
+// ErrDeadlock if waiting for the lock would deadlock.
+//
+// The returned slice is its own copy, it is safe to modify the contents
+// of the returned slice.
+// It is safe to modify the contents of the argument after GetForUpdate
+// returns.
+func (tr *PessimisticTransaction) GetForUpdate(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	tr.lk.Lock()
+	defer tr.lk.Unlock()
+	if tr.closed {
+		return nil, errTransactionDone
+	} else if tr.name != "" {
+		return nil, errTransactionPrepared
+	}
+	if err := tr.lock(key); err != nil {
+		return nil, err
+	}
+	return tr.get(key, ro)
+}
+
+func (tr *PessimisticTransaction) put(kt keyType, key, value []byte) error {
+	tr.lk.Lock()
+	defer tr.lk.Unlock()
+	if tr.closed {
+		return errTransactionDone
+	} else if tr.name != "" {
+		return errTransactionPrepared
+	}
+	if err := tr.lock(key); err != nil {
+		return err
+	}
+	return tr.buf.put(kt, key, value)
+}
+
+// Put locks the given key and sets its value. The write is buffered until
+// commit.
+//
+// It returns ErrLockTimeout if the lock can't be acquired in time, and
+// ErrDeadlock if waiting for the lock would deadlock.
+//
+// It is safe to modify the contents of the arguments after Put returns.
+func (tr *PessimisticTransaction) Put(key, value []byte) error {
+	return tr.put(keyTypeVal, key, value)
+}
+
+// Delete locks the given key and deletes its value. The write is buffered
+// until commit.
+//
+// It returns ErrLockTimeout if the lock can't be acquired in time, and
+// ErrDeadlock if waiting for the lock would deadlock.
+//
+// It is safe to modify the contents of the arguments after Delete returns.
+func (tr *PessimisticTransaction) Delete(key []byte) error {
+	return tr.put(keyTypeDel, key, nil)
+}
+
+// NewIterator returns an iterator for the latest DB state, overlaid with the
+// writes of the transaction. Keys aren't locked.
+// The returned iterator is not safe for concurrent use, and the writes of
+// the transaction made after the iterator is created may or may not be
+// visible.
+//
+// Slice allows slicing the iterator to only contains keys in the given
+// range. A nil Range.Start is treated as a key before all keys in the
+// DB. And a nil Range.Limit is treated as a key after all keys in
+// the DB.
+//
+// The iterator must be released after use, by calling Release method.
+//
+// Also read Iterator documentation of the leveldb/iterator package.
+func (tr *PessimisticTransaction) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	tr.lk.RLock()
+	defer tr.lk.RUnlock()
+	if tr.closed {
+		return iterator.NewEmptyIterator(errTransactionDone)
+	}
+	return newTxIter(tr.db.s.icmp.ucmp, tr.db.NewIterator(slice, ro), tr.buf, slice)
+}
+
+func (tr *PessimisticTransaction) setDone() {
+	tr.closed = true
+	for key := range tr.locked {
+		tr.db.keyLocks.unlock(tr.id, key)
+	}
+	tr.buf = txBuffer{}
+	tr.locked = nil
+	if tr.name != "" {
+		tr.db.preparedMu.Lock()
+		if tr.db.recoveredTxs[tr.name] == tr {
+			delete(tr.db.recoveredTxs, tr.name)
+		}
+		tr.db.preparedMu.Unlock()
+	}
+}
+
+// Name returns the name the transaction was prepared with, or an empty
+// string if it isn't prepared.
+func (tr *PessimisticTransaction) Name() string {
+	tr.lk.RLock()
+	defer tr.lk.RUnlock()
+	return tr.name
+}
+
+// Prepare persists the writes of the transaction in the journal under the
+// given name, as the first phase of a two-phase commit. A prepared
+// transaction can't be written anymore, and keeps its key locks until
+// resolved by either Commit or Rollback. If the DB is reopened before that,
+// the transaction is recovered, see DB.PreparedTransactions.
+//
+// The name must be unique among the prepared transactions, otherwise
+// ErrPreparedExist is returned.
+func (tr *PessimisticTransaction) Prepare(name string) error {
+	tr.lk.Lock()
+	defer tr.lk.Unlock()
+	if tr.closed {
+		return errTransactionDone
+	} else if tr.name != "" {
+		return errTransactionPrepared
+	} else if name == "" {
+		return errTransactionNoName
+	}
+	if err := tr.db.prepare(name, tr.buf.batch()); err != nil {
+		return err
+	}
+	tr.name = name
+	return nil
+}
+
+// Commit applies the writes of the transaction atomically, through DB.Write,
+// and releases the key locks. If the transaction is prepared, the commit is
+// also recorded in the journal along with the writes. If the write fails the
+// transaction stays open, and can be committed again or discarded.
+//
+// Other methods should not be called after transaction has been committed.
+func (tr *PessimisticTransaction) Commit(wo *opt.WriteOptions) error {
+	tr.lk.Lock()
+	defer tr.lk.Unlock()
+	if tr.closed {
+		return errTransactionDone
+	}
+	batch := tr.buf.batch()
+	if tr.name != "" {
+		if err := tr.db.commitPrepared(tr.name, tr.db.ttlBatch(batch, wo), wo.GetSync() && !tr.db.s.o.GetNoSync()); err != nil {
+			return err
+		}
+	} else if batch.Len() > 0 {
+		if err := tr.db.Write(batch, wo); err != nil {
+			return err
+		}
+	}
+	tr.setDone()
+	return nil
+}
+
+// Rollback discards the transaction and releases the key locks. If the
+// transaction is prepared, the rollback is recorded in the journal; if that
+// fails the transaction stays open.
+//
+// Other methods should not be called after transaction has been rolled back.
+func (tr *PessimisticTransaction) Rollback() error {
+	tr.lk.Lock()
+	defer tr.lk.Unlock()
+	if tr.closed {
+		return errTransactionDone
+	}
+	if tr.name != "" {
+		if err := tr.db.rollbackPrepared(tr.name); err != nil {
+			return err
+		}
+	}
+	tr.setDone()
+	return nil
+}
+
+// Discard discards the transaction and releases the key locks. A prepared
+// transaction is rolled back, see Rollback; if that fails the transaction
+// stays prepared, keeps its key locks, and can be resolved again or is
+// recovered when the DB is reopened.
+// This method is noop if transaction is already closed (either committed or
+// discarded)
+//
+// Other methods should not be called after transaction has been discarded.
+func (tr *PessimisticTransaction) Discard() {
+	tr.lk.Lock()
+	if !tr.closed {
+		// If the rollback fails the transaction is kept prepared, along
+		// with its key locks, as its prepare record is left in the journal.
+		if tr.name == "" || tr.db.rollbackPrepared(tr.name) == nil {
+			tr.setDone()
+		}
+	}
+	tr.lk.Unlock()
+}
+
+// OpenPessimisticTransaction opens a pessimistic transaction. Any number of
+// pessimistic transactions can be opened concurrently; they are serialized
+// by the key locks.
+//
+// Reads of the transaction see the writes of the transaction. Writes of the
+// transaction are applied as a single batch when committed.
+//
+// The transaction must be closed once done, either by committing or
+// discarding the transaction, otherwise its key locks are never released.
+func (db *DB) OpenPessimisticTransaction(o *opt.TransactionOptions) (*PessimisticTransaction, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+	return &PessimisticTransaction{
+		db:      db,
+		id:      db.keyLocks.newID(),
+		timeout: o.GetLockTimeout(),
+		buf:     newTxBuffer(db.s.icmp.ucmp),
+		locked:  make(map[string]struct{}),
+	}, nil
+}
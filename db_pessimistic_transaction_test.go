@@ -0,0 +1,220 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/3JoB/goleveldb/opt"
+	"github.com/3JoB/goleveldb/util"
+)
+
+func (h *dbHarness) openPessimisticTransaction(o *opt.TransactionOptions) *PessimisticTransaction {
+	tr, err := h.db.OpenPessimisticTransaction(o)
+	if err != nil {
+		h.t.Fatal("OpenPessimisticTransaction: got error: ", err)
+	}
+	return tr
+}
+
+func TestDB_PessimisticTransaction(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "v1")
+	h.put("b", "v1")
+	h.put("d", "v1")
+	tr := h.openPessimisticTransaction(nil)
+	if v, err := tr.GetForUpdate([]byte("a"), nil); err != nil || string(v) != "v1" {
+		t.Errorf("GetForUpdate: got (%q, %v)", v, err)
+	}
+	tr.Put([]byte("a"), []byte("v2"))
+	tr.Delete([]byte("b"))
+	tr.Put([]byte("c"), []byte("v2"))
+	tr.Put([]byte("e"), []byte("v2"))
+	tr.Delete([]byte("f"))
+
+	// Writes are only visible to the transaction.
+	if v, err := tr.Get([]byte("a"), nil); err != nil || string(v) != "v2" {
+		t.Errorf("Get: got (%q, %v)", v, err)
+	}
+	if _, err := tr.Get([]byte("b"), nil); err != ErrNotFound {
+		t.Errorf("Get: expect ErrNotFound, got %v", err)
+	}
+	h.getVal("a", "v1")
+	h.get("c", false)
+
+	iter := tr.NewIterator(nil, nil)
+	var got string
+	for iter.Next() {
+		got += "(" + string(iter.Key()) + "->" + string(iter.Value()) + ")"
+	}
+	if want := "(a->v2)(c->v2)(d->v1)(e->v2)"; got != want {
+		t.Errorf("iterator forward: got %s, want %s", got, want)
+	}
+	got = ""
+	for iter.Prev() {
+		got += "(" + string(iter.Key()) + "->" + string(iter.Value()) + ")"
+	}
+	if want := "(e->v2)(d->v1)(c->v2)(a->v2)"; got != want {
+		t.Errorf("iterator backward: got %s, want %s", got, want)
+	}
+	// Direction changes.
+	if !iter.Seek([]byte("b")) || string(iter.Key()) != "c" {
+		t.Errorf("Seek: got %q", iter.Key())
+	}
+	if !iter.Prev() || string(iter.Key()) != "a" {
+		t.Errorf("Prev: got %q", iter.Key())
+	}
+	if !iter.Next() || string(iter.Key()) != "c" {
+		t.Errorf("Next: got %q", iter.Key())
+	}
+	if !iter.Next() || string(iter.Key()) != "d" {
+		t.Errorf("Next: got %q", iter.Key())
+	}
+	iter.Release()
+
+	iter = tr.NewIterator(&util.Range{Start: []byte("b"), Limit: []byte("e")}, nil)
+	got = ""
+	for iter.Next() {
+		got += "(" + string(iter.Key()) + "->" + string(iter.Value()) + ")"
+	}
+	if want := "(c->v2)(d->v1)"; got != want {
+		t.Errorf("iterator slice: got %s, want %s", got, want)
+	}
+	iter.Release()
+
+	if err := tr.Commit(nil); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	h.getKeyVal("(a->v2)(c->v2)(d->v1)(e->v2)")
+
+	if _, err := tr.Get([]byte("a"), nil); err != errTransactionDone {
+		t.Errorf("Get: expect errTransactionDone, got %v", err)
+	}
+	if err := tr.Commit(nil); err != errTransactionDone {
+		t.Errorf("Commit: expect errTransactionDone, got %v", err)
+	}
+}
+
+func TestDB_PessimisticTransaction_LockTimeout(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	o := &opt.TransactionOptions{LockTimeout: 10 * time.Millisecond}
+	tr1 := h.openPessimisticTransaction(o)
+	tr2 := h.openPessimisticTransaction(o)
+	if err := tr1.Put([]byte("k"), []byte("tr1")); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	if err := tr2.Put([]byte("k"), []byte("tr2")); err != ErrLockTimeout {
+		t.Errorf("Put: expect ErrLockTimeout, got %v", err)
+	}
+	if _, err := tr2.GetForUpdate([]byte("k"), nil); err != ErrLockTimeout {
+		t.Errorf("GetForUpdate: expect ErrLockTimeout, got %v", err)
+	}
+	// Plain reads don't lock.
+	if _, err := tr2.Get([]byte("k"), nil); err != ErrNotFound {
+		t.Errorf("Get: expect ErrNotFound, got %v", err)
+	}
+
+	// The lock is released on commit.
+	if err := tr1.Commit(nil); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	if v, err := tr2.GetForUpdate([]byte("k"), nil); err != nil || string(v) != "tr1" {
+		t.Errorf("GetForUpdate: got (%q, %v)", v, err)
+	}
+
+	// And on discard.
+	tr3 := h.openPessimisticTransaction(o)
+	tr2.Discard()
+	if err := tr3.Put([]byte("k"), []byte("tr3")); err != nil {
+		t.Errorf("Put: got error: %v", err)
+	}
+	tr3.Discard()
+	h.getVal("k", "tr1")
+}
+
+func TestDB_PessimisticTransaction_Deadlock(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	o := &opt.TransactionOptions{LockTimeout: -1}
+	tr1 := h.openPessimisticTransaction(o)
+	tr2 := h.openPessimisticTransaction(o)
+	tr1.Put([]byte("a"), []byte("tr1"))
+	tr2.Put([]byte("b"), []byte("tr2"))
+
+	errC := make(chan error)
+	go func() {
+		errC <- tr1.Put([]byte("b"), []byte("tr1"))
+	}()
+	// Wait for tr1 to be waiting for tr2.
+	for {
+		h.db.keyLocks.waitMu.Lock()
+		_, waiting := h.db.keyLocks.waitFor[tr1.id]
+		h.db.keyLocks.waitMu.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := tr2.Put([]byte("a"), []byte("tr2")); err != ErrDeadlock {
+		t.Errorf("Put: expect ErrDeadlock, got %v", err)
+	}
+	tr2.Discard()
+	if err := <-errC; err != nil {
+		t.Errorf("Put: got error: %v", err)
+	}
+	if err := tr1.Commit(nil); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	h.getKeyVal("(a->tr1)(b->tr1)")
+}
+
+func TestDB_PessimisticTransaction_Concurrent(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	const (
+		n = 8
+		m = 50
+	)
+	h.put("counter", "0")
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < m; j++ {
+				tr, err := h.db.OpenPessimisticTransaction(&opt.TransactionOptions{LockTimeout: -1})
+				if err != nil {
+					t.Error("OpenPessimisticTransaction: got error: ", err)
+					return
+				}
+				v, err := tr.GetForUpdate([]byte("counter"), nil)
+				if err != nil {
+					t.Error("GetForUpdate: got error: ", err)
+					tr.Discard()
+					return
+				}
+				c, _ := strconv.Atoi(string(v))
+				tr.Put([]byte("counter"), []byte(strconv.Itoa(c+1)))
+				if err := tr.Commit(nil); err != nil {
+					t.Error("Commit: got error: ", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	h.getVal("counter", strconv.Itoa(n*m))
+}
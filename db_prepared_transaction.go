@@ -0,0 +1,227 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"sort"
+)
+
+// Two-phase commit is recorded in the journal using marker records:
+//
+//   - A prepare record holds the prepare marker followed by the writes of the
+//     transaction, with zero sequence number; the writes aren't applied.
+//   - A commit record is a regular batch starting with the commit marker.
+//   - A rollback record holds the rollback marker only, with zero sequence
+//     number.
+//
+// Prepare records of unresolved transactions are re-logged to every new
+// journal, so they survive removal of the older journals.
+
+// Handles the two-phase commit marker of the given journal record, if any.
+// It returns true if the record holds no write to replay.
+func (db *DB) replayMarker(record []byte) bool {
+	seq, _, err := decodeBatchHeader(record)
+	if err != nil {
+		return false
+	}
+	m, name, _, err := decodeBatchMarker(record[batchHeaderLen:])
+	if err != nil || m == 0 {
+		return false
+	}
+	if m == batchMarkerPrepare {
+		db.prepared[string(name)] = append([]byte(nil), record...)
+	} else {
+		delete(db.prepared, string(name))
+	}
+	// Records without writes to replay have zero sequence number.
+	return seq == 0
+}
+
+// Writes the prepare records to the journal; need write lock.
+func (db *DB) relogPrepared() error {
+	db.preparedMu.Lock()
+	defer db.preparedMu.Unlock()
+	if len(db.prepared) == 0 {
+		return nil
+	}
+	for _, record := range db.prepared {
+		wr, err := db.journal.Next()
+		if err != nil {
+			return err
+		}
+		if _, err := wr.Write(record); err != nil {
+			return err
+		}
+	}
+	if err := db.journal.Flush(); err != nil {
+		return err
+	}
+	if db.s.o.GetNoSync() {
+		return nil
+	}
+	return db.journalWriter.Sync()
+}
+
+func (db *DB) lockWrite() error {
+	select {
+	case db.writeLockC <- struct{}{}:
+		return nil
+	case err := <-db.compPerErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+}
+
+// Writes a marker record, without any write to apply, to the journal; need
+// write lock. It returns the written record.
+func (db *DB) writeMarker(m batchMarker, name string, b *Batch, sync bool) ([]byte, error) {
+	mb := new(Batch)
+	mb.appendMarker(m, name)
+	if b != nil {
+		mb.append(b)
+	}
+	record := append(encodeBatchHeader(nil, 0, mb.Len()), mb.data...)
+	wr, err := db.journal.Next()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := wr.Write(record); err != nil {
+		return nil, err
+	}
+	if err := db.journal.Flush(); err != nil {
+		return nil, err
+	}
+	if sync {
+		if err := db.journalWriter.Sync(); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+func (db *DB) prepare(name string, b *Batch) error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	if err := db.lockWrite(); err != nil {
+		return err
+	}
+	defer func() { <-db.writeLockC }()
+
+	db.preparedMu.Lock()
+	_, exist := db.prepared[name]
+	db.preparedMu.Unlock()
+	if exist {
+		return ErrPreparedExist
+	}
+	record, err := db.writeMarker(batchMarkerPrepare, name, b, !db.s.o.GetNoSync())
+	if err != nil {
+		return err
+	}
+	db.preparedMu.Lock()
+	db.prepared[name] = record
+	db.preparedMu.Unlock()
+	return nil
+}
+
+func (db *DB) commitPrepared(name string, b *Batch, sync bool) error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	if err := db.lockWrite(); err != nil {
+		return err
+	}
+
+	// The prepare record must not be re-logged after the commit record, in
+	// case the journal is rotated by the write.
+	db.preparedMu.Lock()
+	record := db.prepared[name]
+	delete(db.prepared, name)
+	db.preparedMu.Unlock()
+
+	var err error
+	if b.Len() == 0 {
+		_, err = db.writeMarker(batchMarkerCommit, name, nil, sync)
+		<-db.writeLockC
+	} else {
+		mb := new(Batch)
+		mb.appendMarker(batchMarkerCommit, name)
+		mb.append(b)
+		// writeLocked releases the write lock.
+		err = db.writeLocked(mb, nil, false, sync)
+	}
+	if err != nil && record != nil {
+		db.preparedMu.Lock()
+		db.prepared[name] = record
+		db.preparedMu.Unlock()
+	}
+	return err
+}
+
+func (db *DB) rollbackPrepared(name string) error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	if err := db.lockWrite(); err != nil {
+		return err
+	}
+	defer func() { <-db.writeLockC }()
+
+	if _, err := db.writeMarker(batchMarkerRollback, name, nil, !db.s.o.GetNoSync()); err != nil {
+		return err
+	}
+	db.preparedMu.Lock()
+	delete(db.prepared, name)
+	db.preparedMu.Unlock()
+	return nil
+}
+
+// Recreates the transactions prepared but not yet resolved.
+func (db *DB) recoverPrepared() error {
+	for name, record := range db.prepared {
+		b := new(Batch)
+		if err := b.decode(append([]byte(nil), record[batchHeaderLen:]...), -1); err != nil {
+			return err
+		}
+		tr, err := db.OpenPessimisticTransaction(nil)
+		if err != nil {
+			return err
+		}
+		err = b.replayInternal(func(i int, kt keyType, k, v []byte) error {
+			if err := tr.lock(k); err != nil {
+				return err
+			}
+			return tr.buf.put(kt, k, v)
+		})
+		if err != nil {
+			tr.setDone()
+			return err
+		}
+		tr.name = name
+		db.recoveredTxs[name] = tr
+		db.logf("transaction@recovery prepared %q N·%d", name, b.Len())
+	}
+	return nil
+}
+
+// PreparedTransactions returns the transactions recovered on open that were
+// prepared but neither committed nor rolled back, sorted by name. The
+// transactions hold the locks of their keys, and should be resolved by
+// calling either Commit or Rollback.
+func (db *DB) PreparedTransactions() []*PessimisticTransaction {
+	db.preparedMu.Lock()
+	defer db.preparedMu.Unlock()
+	trs := make([]*PessimisticTransaction, 0, len(db.recoveredTxs))
+	for _, tr := range db.recoveredTxs {
+		trs = append(trs, tr)
+	}
+	sort.Slice(trs, func(i, j int) bool {
+		return trs[i].name < trs[j].name
+	})
+	return trs
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/3JoB/goleveldb/opt"
+	"github.com/3JoB/goleveldb/storage"
+	"github.com/3JoB/goleveldb/testutil"
+)
+
+func (h *dbHarness) preparedTransactions(names ...string) []*PessimisticTransaction {
+	h.t.Helper()
+	trs := h.db.PreparedTransactions()
+	if len(trs) != len(names) {
+		h.t.Fatalf("PreparedTransactions: got %d transactions, want %d", len(trs), len(names))
+	}
+	for i, tr := range trs {
+		if tr.Name() != names[i] {
+			h.t.Errorf("PreparedTransactions: got %q, want %q", tr.Name(), names[i])
+		}
+	}
+	return trs
+}
+
+func TestDB_PreparedTransaction(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "v1")
+	tr1 := h.openPessimisticTransaction(nil)
+	tr1.Put([]byte("a"), []byte("tr1"))
+	tr1.Delete([]byte("b"))
+	if err := tr1.Prepare(""); err != errTransactionNoName {
+		t.Errorf("Prepare: expect errTransactionNoName, got %v", err)
+	}
+	if err := tr1.Prepare("tr1"); err != nil {
+		t.Fatal("Prepare: got error: ", err)
+	}
+	if err := tr1.Put([]byte("c"), []byte("tr1")); err != errTransactionPrepared {
+		t.Errorf("Put: expect errTransactionPrepared, got %v", err)
+	}
+	tr2 := h.openPessimisticTransaction(nil)
+	if err := tr2.Prepare("tr1"); err != ErrPreparedExist {
+		t.Errorf("Prepare: expect ErrPreparedExist, got %v", err)
+	}
+	tr2.Put([]byte("c"), []byte("tr2"))
+	if err := tr2.Prepare("tr2"); err != nil {
+		t.Fatal("Prepare: got error: ", err)
+	}
+	tr3 := h.openPessimisticTransaction(nil)
+	tr3.Put([]byte("d"), []byte("tr3"))
+	if err := tr3.Prepare("tr3"); err != nil {
+		t.Fatal("Prepare: got error: ", err)
+	}
+
+	// Prepared writes aren't applied.
+	h.getKeyVal("(a->v1)")
+	if err := tr1.Commit(nil); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	if err := tr2.Rollback(); err != nil {
+		t.Fatal("Rollback: got error: ", err)
+	}
+	h.getKeyVal("(a->tr1)")
+
+	// The unresolved transaction is recovered, also across journal
+	// rotation.
+	h.compactMem()
+	h.put("e", "v1")
+	h.reopenDB()
+	h.compactMem()
+	h.reopenDB()
+	h.getKeyVal("(a->tr1)(e->v1)")
+	trs := h.preparedTransactions("tr3")
+	if v, err := trs[0].Get([]byte("d"), nil); err != nil || string(v) != "tr3" {
+		t.Errorf("Get: got (%q, %v)", v, err)
+	}
+
+	// The recovered transaction holds the locks of its keys.
+	tr4 := h.openPessimisticTransaction(&opt.TransactionOptions{LockTimeout: time.Millisecond})
+	if _, err := tr4.GetForUpdate([]byte("d"), nil); err != ErrLockTimeout {
+		t.Errorf("GetForUpdate: expect ErrLockTimeout, got %v", err)
+	}
+	tr4.Discard()
+
+	if err := trs[0].Commit(nil); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	h.preparedTransactions()
+	h.getKeyVal("(a->tr1)(d->tr3)(e->v1)")
+	h.reopenDB()
+	h.preparedTransactions()
+	h.getKeyVal("(a->tr1)(d->tr3)(e->v1)")
+}
+
+func TestDB_PreparedTransaction_Rollback(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	for _, name := range []string{"a", "b", "c"} {
+		tr := h.openPessimisticTransaction(nil)
+		tr.Put([]byte(name), []byte(name))
+		if err := tr.Prepare(name); err != nil {
+			t.Fatal("Prepare: got error: ", err)
+		}
+	}
+	// An empty transaction can be prepared as well.
+	tr := h.openPessimisticTransaction(nil)
+	if err := tr.Prepare("d"); err != nil {
+		t.Fatal("Prepare: got error: ", err)
+	}
+	h.reopenDB()
+
+	trs := h.preparedTransactions("a", "b", "c", "d")
+	if err := trs[0].Rollback(); err != nil {
+		t.Fatal("Rollback: got error: ", err)
+	}
+	trs[1].Discard()
+	if err := trs[3].Commit(nil); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	seq := h.db.getSeq()
+	h.reopenDB()
+	if got := h.db.getSeq(); got != seq {
+		t.Errorf("seq: got %d, want %d", got, seq)
+	}
+	trs = h.preparedTransactions("c")
+	if err := trs[0].Commit(nil); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	h.reopenDB()
+	h.preparedTransactions()
+	h.getKeyVal("(c->c)")
+}
+
+func TestDB_PreparedTransaction_DiscardError(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	tr := h.openPessimisticTransaction(nil)
+	tr.Put([]byte("a"), []byte("tr"))
+	if err := tr.Prepare("tr"); err != nil {
+		t.Fatal("Prepare: got error: ", err)
+	}
+
+	// A failed rollback keeps the transaction prepared, with its key locks.
+	h.stor.EmulateError(testutil.ModeSync, storage.TypeJournal, errors.New("journal sync error"))
+	tr.Discard()
+	h.stor.EmulateError(testutil.ModeSync, storage.TypeJournal, nil)
+	if tr.Name() != "tr" {
+		t.Errorf("Name: got %q, want %q", tr.Name(), "tr")
+	}
+	h.db.preparedMu.Lock()
+	_, prepared := h.db.prepared["tr"]
+	h.db.preparedMu.Unlock()
+	if !prepared {
+		t.Error("prepare record of the transaction is dropped")
+	}
+	tr2 := h.openPessimisticTransaction(&opt.TransactionOptions{LockTimeout: time.Millisecond})
+	if err := tr2.Put([]byte("a"), []byte("tr2")); err != ErrLockTimeout {
+		t.Errorf("Put: expect ErrLockTimeout, got %v", err)
+	}
+
+	tr.Discard()
+	if err := tr2.Put([]byte("a"), []byte("tr2")); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	if err := tr2.Commit(nil); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	h.reopenDB()
+	h.preparedTransactions()
+	h.getKeyVal("(a->tr2)")
+}
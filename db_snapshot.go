@@ -71,6 +71,18 @@ func (db *DB) minSeq() uint64 {
 	return db.getSeq()
 }
 
+// Gets the sequence of the latest snapshot, or zero if there is none.
+func (db *DB) maxSnapSeq() uint64 {
+	db.snapsMu.Lock()
+	defer db.snapsMu.Unlock()
+
+	if e := db.snapsList.Back(); e != nil {
+		return e.Value.(*snapshotElement).seq
+	}
+
+	return 0
+}
+
 // Snapshot is a DB snapshot.
 type Snapshot struct {
 	db       *DB
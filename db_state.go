@@ -8,6 +8,7 @@ package leveldb
 
 import (
 	"errors"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -24,6 +25,16 @@ type memDB struct {
 	db *DB
 	*memdb.DB
 	ref int32
+	cf  uint32 // column family id; zero for the default column family
+
+	// rdel holds range tombstones of the default column family.
+	rdel *memdb.DB
+
+	// rdFrags caches the fragmented range tombstones of rdel, built from
+	// rdFragsLen tombstones.
+	rdMu       sync.Mutex
+	rdFrags    rangeFragments
+	rdFragsLen int
 }
 
 func (m *memDB) getref() int32 {
@@ -36,13 +47,14 @@ func (m *memDB) incref() {
 
 func (m *memDB) decref() {
 	if ref := atomic.AddInt32(&m.ref, -1); ref == 0 {
-		// Only put back memdb with std capacity.
-		if m.Capacity() == m.db.s.o.GetWriteBuffer() {
+		// Only put back memdb of the default column family with std capacity.
+		if m.cf == 0 && m.Capacity() == m.db.s.o.GetWriteBuffer() {
 			m.Reset()
 			m.db.mpoolPut(m.DB)
 		}
 		m.db = nil
 		m.DB = nil
+		m.rdel = nil
 	} else if ref < 0 {
 		panic("negative memdb ref")
 	}
@@ -62,8 +74,8 @@ func (db *DB) setSeq(seq uint64) {
 	atomic.StoreUint64(&db.seq, seq)
 }
 
-func (db *DB) sampleSeek(ikey internalKey) {
-	v := db.s.version()
+func (db *DB) sampleSeek(s *session, ikey internalKey) {
+	v := s.version()
 	if v.sampleSeek(ikey) {
 		// Trigger table compaction.
 		db.compTrigger(db.tcompCmdC)
@@ -90,8 +102,9 @@ func (db *DB) mpoolGet(n int) *memDB {
 		mdb = memdb.New(db.s.icmp, maxInt(db.s.o.GetWriteBuffer(), n))
 	}
 	return &memDB{
-		db: db,
-		DB: mdb,
+		db:   db,
+		DB:   mdb,
+		rdel: memdb.New(db.s.icmp, 0),
 	}
 }
 
@@ -127,6 +140,8 @@ func (db *DB) newMem(n int) (mem *memDB, err error) {
 		return
 	}
 
+	db.cfMu.RLock()
+	defer db.cfMu.RUnlock()
 	db.memMu.Lock()
 	defer db.memMu.Unlock()
 
@@ -147,11 +162,26 @@ func (db *DB) newMem(n int) (mem *memDB, err error) {
 	}
 	db.journalWriter = w
 	db.journalFd = fd
+	// Prepared transactions are re-logged, as the older journals are
+	// removed once their memdb is flushed.
+	if err := db.relogPrepared(); err != nil {
+		return nil, err
+	}
 	db.frozenMem = db.mem
 	mem = db.mpoolGet(n)
 	mem.incref() // for self
 	mem.incref() // for caller
 	db.mem = mem
+	// Column families share the journal, so freeze their non-empty memdbs
+	// as well.
+	for _, cf := range db.cfs {
+		if cf.mem != nil && cf.mem.Len() == 0 {
+			continue
+		}
+		cf.frozenMem = cf.mem
+		cf.mem = db.newFamilyMem(cf.s)
+		cf.mem.incref()
+	}
 	// The seq only incremented by the writer. And whoever called newMem
 	// should hold write lock, so no need additional synchronization here.
 	db.frozenSeq = db.seq
@@ -197,8 +227,10 @@ func (db *DB) getFrozenMem() *memDB {
 
 // Drop frozen memdb; assume that frozen memdb isn't nil.
 func (db *DB) dropFrozenMem() {
+	db.cfMu.RLock()
+	defer db.cfMu.RUnlock()
 	db.memMu.Lock()
-	if err := db.s.stor.Remove(db.frozenJournalFd); err != nil {
+	if err := db.removeJournal(db.frozenJournalFd); err != nil {
 		db.logf("journal@remove removing @%d %q", db.frozenJournalFd.Num, err)
 	} else {
 		db.logf("journal@remove removed @%d", db.frozenJournalFd.Num)
@@ -206,14 +238,26 @@ func (db *DB) dropFrozenMem() {
 	db.frozenJournalFd = storage.FileDesc{}
 	db.frozenMem.decref()
 	db.frozenMem = nil
+	for _, cf := range db.cfs {
+		if cf.frozenMem != nil {
+			cf.frozenMem.decref()
+			cf.frozenMem = nil
+		}
+	}
 	db.memMu.Unlock()
 }
 
 // Clear mems ptr; used by DB.Close().
 func (db *DB) clearMems() {
+	db.cfMu.RLock()
+	defer db.cfMu.RUnlock()
 	db.memMu.Lock()
 	db.mem = nil
 	db.frozenMem = nil
+	for _, cf := range db.cfs {
+		cf.mem = nil
+		cf.frozenMem = nil
+	}
 	db.memMu.Unlock()
 }
 
@@ -299,6 +299,8 @@ func (h *dbHarness) allEntriesFor(key, want string) {
 				res += string(iter.Value())
 			case keyTypeDel:
 				res += "DEL"
+			case keyTypeMerge:
+				res += "+" + string(iter.Value())
 			}
 		} else {
 			if !first {
@@ -394,7 +396,7 @@ func (h *dbHarness) compactRangeAtErr(level int, min, max string, wanterr bool)
 
 	t.Logf("starting table range compaction: level=%d, min=%q, max=%q", level, min, max)
 
-	if err := db.compTriggerRange(db.tcompCmdC, level, _min, _max); err != nil {
+	if err := db.compTriggerRange(db.tcompCmdC, db.s, level, _min, _max); err != nil {
 		if wanterr {
 			t.Log("CompactRangeAt: got error (expected): ", err)
 		} else {
@@ -1745,6 +1747,388 @@ func TestDB_BloomFilter(t *testing.T) {
 	h.stor.Release(testutil.ModeSync, storage.TypeTable)
 }
 
+func TestDB_FullFilter(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		DisableBlockCache:            true,
+		Filter:                       filter.NewBloomFilter(10),
+	})
+	defer h.close()
+
+	key := func(i int) string {
+		return fmt.Sprintf("key%06d", i)
+	}
+
+	const n = 1000
+
+	// Tables with per-block filters stay usable along with tables with full
+	// filters.
+	for i := 0; i < n; i += 2 {
+		h.put(key(i), key(i))
+	}
+	h.compactMem()
+	h.o.FullFilter = true
+	h.reopenDB()
+	for i := 1; i < n; i += 2 {
+		h.put(key(i), key(i))
+	}
+	h.compactMem()
+
+	// Prevent auto compactions triggered by seeks
+	h.stor.Stall(testutil.ModeSync, storage.TypeTable)
+
+	for i := 0; i < n; i++ {
+		h.getVal(key(i), key(i))
+	}
+
+	// Lookup missing keys. Should rarely read from either sstable.
+	h.stor.ResetCounter(testutil.ModeRead, storage.TypeTable)
+	for i := 0; i < n; i++ {
+		h.get(key(i)+".missing", false)
+	}
+	cnt, _ := h.stor.Counter(testutil.ModeRead, storage.TypeTable)
+	t.Logf("lookup of %d missing keys yield %d sstable I/O reads", n, cnt)
+	if max := 3 * n / 100; cnt > max {
+		t.Errorf("num of sstable I/O reads of missing keys was more than %d, got %d", max, cnt)
+	}
+
+	h.stor.Release(testutil.ModeSync, storage.TypeTable)
+}
+
+func TestDB_RangeFilter(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		DisableBlockCache:            true,
+		RangeFilter:                  filter.NewTrieRangeFilter(),
+	})
+	defer h.close()
+
+	key := func(i int) string {
+		return fmt.Sprintf("key%06d", i)
+	}
+
+	const n = 1000
+
+	// Populate multiple layers, keys are multiple of 10.
+	for i := 0; i < n; i += 10 {
+		h.put(key(i), key(i))
+	}
+	h.compactMem()
+	h.compactRange("", "")
+	for i := 0; i < n; i += 100 {
+		h.put(key(i), key(i))
+	}
+	h.compactMem()
+
+	// Prevent auto compactions triggered by seeks
+	h.stor.Stall(testutil.ModeSync, storage.TypeTable)
+
+	count := func(start, limit int) (n int) {
+		iter := h.db.NewIterator(&util.Range{Start: []byte(key(start)), Limit: []byte(key(limit))}, nil)
+		for iter.Next() {
+			n++
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal("Iterator: got error: ", err)
+		}
+		iter.Release()
+		return
+	}
+
+	if got := count(0, n); got != n/10 {
+		t.Errorf("got %d keys, want %d", got, n/10)
+	}
+
+	// Iterate empty ranges. Should rarely read from either sstable.
+	h.stor.ResetCounter(testutil.ModeRead, storage.TypeTable)
+	for i := 0; i < n; i += 10 {
+		if got := count(i+1, i+9); got != 0 {
+			t.Errorf("range [%q, %q): got %d keys, want none", key(i+1), key(i+9), got)
+		}
+	}
+	cnt, _ := h.stor.Counter(testutil.ModeRead, storage.TypeTable)
+	t.Logf("iteration of %d empty ranges yield %d sstable I/O reads", n/10, cnt)
+	if max := n / 10 / 5; cnt > max {
+		t.Errorf("num of sstable I/O reads of empty ranges was more than %d, got %d", max, cnt)
+	}
+
+	h.stor.Release(testutil.ModeSync, storage.TypeTable)
+}
+
+func TestDB_DataBlockHashIndex(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		DataBlockHashIndex:           true,
+	})
+	defer h.close()
+
+	key := func(i int) string {
+		return fmt.Sprintf("key%06d", i)
+	}
+
+	const n = 1000
+
+	// Keep older versions along with newer ones within the same tables.
+	for i := 0; i < n; i++ {
+		h.put(key(i), "v1"+key(i))
+	}
+	h.compactMem()
+	snap := h.getSnapshot()
+	for i := 0; i < n; i++ {
+		switch {
+		case i%5 == 0:
+			h.delete(key(i))
+		case i%3 == 0:
+			h.put(key(i), "v2"+key(i))
+		}
+	}
+	h.compactMem()
+	h.compactRange("", "")
+
+	check := func() {
+		for i := 0; i < n; i++ {
+			switch {
+			case i%5 == 0:
+				h.get(key(i), false)
+			case i%3 == 0:
+				h.getVal(key(i), "v2"+key(i))
+			default:
+				h.getVal(key(i), "v1"+key(i))
+			}
+			h.get(key(i)+".missing", false)
+		}
+	}
+	check()
+	for i := 0; i < n; i++ {
+		h.getValr(snap, key(i), "v1"+key(i))
+	}
+	snap.Release()
+
+	// Tables stay readable regardless of the option.
+	h.o.DataBlockHashIndex = false
+	h.reopenDB()
+	check()
+}
+
+func TestDB_UniversalCompaction(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		CompactionStyle:              opt.UniversalCompaction,
+	})
+	defer h.close()
+
+	key := func(i int) string {
+		return fmt.Sprintf("key%06d", i)
+	}
+
+	const n = 1000
+
+	// Auto compaction isn't waited for, poll the sorted runs instead.
+	sortedRuns := func() []sortedRun {
+		for i := 0; ; i++ {
+			v := h.db.s.version()
+			runs, need := v.sortedRuns(), v.needCompaction()
+			v.release()
+			if !need || i == 500 {
+				return runs
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Overwrite the same keys over many sorted runs.
+	for round := 0; round < 10; round++ {
+		for i := round % 3; i < n; i += 3 {
+			h.put(key(i), fmt.Sprintf("v%d%s", round, key(i)))
+		}
+		h.compactMem()
+
+		if runs := sortedRuns(); len(runs) >= h.o.GetCompactionL0Trigger() {
+			t.Errorf("round %d: got %d sorted runs, want less than %d", round, len(runs), h.o.GetCompactionL0Trigger())
+		}
+	}
+
+	// The oldest sorted run is at the last level.
+	if runs := sortedRuns(); runs[len(runs)-1].level != universalNumLevel-1 {
+		t.Errorf("got oldest sorted run at level %d, want %d", runs[len(runs)-1].level, universalNumLevel-1)
+	}
+
+	check := func() {
+		for i := 0; i < n; i++ {
+			round := 9 - (9-i%3)%3
+			h.getVal(key(i), fmt.Sprintf("v%d%s", round, key(i)))
+		}
+	}
+	check()
+
+	// Sorted runs of new keys are merged by size ratio, above the oldest
+	// sorted run.
+	var above bool
+	for round := 0; round < 10; round++ {
+		for i := 0; i < n/3; i++ {
+			h.put(key(n*(round+1)+i), key(i))
+		}
+		h.compactMem()
+
+		runs := sortedRuns()
+		for i, r := range runs[1:] {
+			if r.level <= runs[i].level && r.level > 0 {
+				t.Errorf("round %d: got sorted run at level %d after level %d", round, r.level, runs[i].level)
+			}
+		}
+		if len(runs) > 1 && runs[len(runs)-2].level > 0 {
+			above = true
+		}
+	}
+	if !above {
+		t.Error("got no sorted run merged above the oldest one")
+	}
+	check()
+	for round := 0; round < 10; round++ {
+		for i := 0; i < n/3; i++ {
+			h.getVal(key(n*(round+1)+i), key(i))
+		}
+	}
+
+	var stats DBStats
+	if err := h.db.Stats(&stats); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if stats.UniversalComp == 0 || stats.Level0Comp != 0 || stats.NonLevel0Comp != 0 {
+		t.Errorf("got compactions Universal:%d Level0:%d NonLevel0:%d, want universal only", stats.UniversalComp, stats.Level0Comp, stats.NonLevel0Comp)
+	}
+	if value, err := h.db.GetProperty("leveldb.stats"); err != nil {
+		t.Fatal("GetProperty: got error: ", err)
+	} else if !strings.Contains(value, "Sorted Runs") {
+		t.Errorf("GetProperty: missing sorted runs stats: %s", value)
+	}
+
+	// The compaction style may change between sessions.
+	h.o.CompactionStyle = opt.LeveledCompaction
+	h.reopenDB()
+	check()
+}
+
+func TestDB_PartitionedIndex(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		BlockSize:                    256,
+		Filter:                       filter.NewBloomFilter(10),
+		IndexPartitionSize:           256,
+	})
+	defer h.close()
+
+	const n = 1000
+	key := func(i int) string {
+		return fmt.Sprintf("key%06d", i)
+	}
+	for i := 0; i < n; i += 2 {
+		h.put(key(i), key(i))
+	}
+	h.compactMem()
+	h.compactRange("", "")
+
+	check := func() {
+		for i := 0; i < n; i++ {
+			if i%2 == 0 {
+				h.getVal(key(i), key(i))
+			} else {
+				h.get(key(i), false)
+			}
+		}
+		iter := h.db.NewIterator(&util.Range{Start: []byte(key(101)), Limit: []byte(key(901))}, nil)
+		i := 102
+		for ; iter.Next(); i += 2 {
+			if got := string(iter.Key()); got != key(i) {
+				t.Fatalf("Iterator: got key %q, want %q", got, key(i))
+			}
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal("Iterator: got error: ", err)
+		}
+		iter.Release()
+		if i != 902 {
+			t.Errorf("Iterator: stopped before %q, want %q", key(i), key(902))
+		}
+	}
+	check()
+
+	// Both layouts are readable regardless of the option.
+	h.o.IndexPartitionSize = 0
+	h.reopenDB()
+	check()
+	for i := 1; i < n; i += 4 {
+		h.put(key(i), key(i))
+	}
+	h.compactMem()
+	h.get(key(1), true)
+	h.o.IndexPartitionSize = 256
+	h.reopenDB()
+	h.get(key(1), true)
+	h.get(key(3), false)
+}
+
+func TestDB_PrefixFilter(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		DisableBlockCache:            true,
+		Filter:                       filter.NewBloomFilter(10),
+		PrefixExtractor:              filter.NewFixedPrefix(5),
+	})
+	defer h.close()
+
+	prefix := func(i int) string {
+		return fmt.Sprintf("p%03d-", i)
+	}
+
+	const n, m = 200, 50
+
+	// Only even prefixes exist.
+	for i := 0; i < n; i += 2 {
+		for j := 0; j < m; j++ {
+			h.put(prefix(i)+fmt.Sprintf("%03d", j), "v")
+		}
+	}
+	h.compactMem()
+	h.compactRange("", "")
+
+	// Prevent auto compactions triggered by seeks
+	h.stor.Stall(testutil.ModeSync, storage.TypeTable)
+
+	count := func(i int) (n int) {
+		iter := h.db.NewIterator(util.BytesPrefix([]byte(prefix(i))), nil)
+		for iter.Next() {
+			n++
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal("Iterator: got error: ", err)
+		}
+		iter.Release()
+		return
+	}
+
+	for i := 0; i < n; i += 2 {
+		if got := count(i); got != m {
+			t.Errorf("prefix %q: got %d keys, want %d", prefix(i), got, m)
+		}
+	}
+
+	// Iterate missing prefixes. Should rarely read from sstable.
+	h.stor.ResetCounter(testutil.ModeRead, storage.TypeTable)
+	for i := 1; i < n; i += 2 {
+		if got := count(i); got != 0 {
+			t.Errorf("prefix %q: got %d keys, want none", prefix(i), got)
+		}
+	}
+	cnt, _ := h.stor.Counter(testutil.ModeRead, storage.TypeTable)
+	t.Logf("iteration of %d missing prefixes yield %d sstable I/O reads", n/2, cnt)
+	if max := n / 2 / 10; cnt > max {
+		t.Errorf("num of sstable I/O reads of missing prefixes was more than %d, got %d", max, cnt)
+	}
+
+	h.stor.Release(testutil.ModeSync, storage.TypeTable)
+}
+
 func TestDB_Concurrent(t *testing.T) {
 	const n, secs, maxkey = 4, 6, 1000
 	h := newDbHarness(t)
@@ -2611,7 +2995,7 @@ func TestDB_TableCompactionBuilder(t *testing.T) {
 		value      = bytes.Repeat([]byte{'0'}, 100)
 	)
 	for i := 0; i < 2; i++ {
-		tw, err := s.tops.create()
+		tw, err := s.tops.create(0)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -3001,3 +3385,447 @@ func TestDB_GracefulClose(t *testing.T) {
 	iter.Release()
 	closeWait.Wait()
 }
+
+func TestDB_CompressionPerLevel(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Compression:                  opt.LZ4Compression,
+		CompressionPerLevel:          []opt.Compression{opt.SnappyCompression, opt.DefaultCompression, opt.ZstdCompression},
+		CompressionLevel:             3,
+	})
+	defer h.close()
+
+	put := func(prefix string) {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("%s%03d", prefix, i)
+			h.put(key, strings.Repeat(key, 10))
+		}
+	}
+	check := func(prefixes ...string) {
+		for _, prefix := range prefixes {
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("%s%03d", prefix, i)
+				h.getVal(key, strings.Repeat(key, 10))
+			}
+		}
+	}
+
+	put("a")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.compactRangeAt(1, "", "")
+	put("b")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	put("c")
+	h.compactMem()
+	h.tablesPerLevel("1,1,1")
+	check("a", "b", "c")
+
+	// Tables are decoded by their block type, regardless of the options.
+	h.o.Compression = opt.NoCompression
+	h.o.CompressionPerLevel = nil
+	h.reopenDB()
+	check("a", "b", "c")
+}
+
+func TestDB_CompressionPerLevel_MemdbFlush(t *testing.T) {
+	build := func(perLevel []opt.Compression) (size int64) {
+		h := newDbHarnessWopt(t, &opt.Options{
+			DisableLargeBatchTransaction: true,
+			Compression:                  opt.SnappyCompression,
+			CompressionPerLevel:          perLevel,
+		})
+		defer h.close()
+		h.db.memdbMaxLevel = 2
+
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("k%03d", i)
+			h.put(key, strings.Repeat(key, 100))
+		}
+		h.compactMem()
+		h.tablesPerLevel("0,0,1")
+		v := h.db.s.version()
+		size = v.levels[2][0].size
+		v.release()
+
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("k%03d", i)
+			h.getVal(key, strings.Repeat(key, 100))
+		}
+		return
+	}
+
+	// The flushed table lands on level-2, so it must be written with the
+	// level-2 codec rather than the level-0 one.
+	compressed := build(nil)
+	raw := build([]opt.Compression{opt.SnappyCompression, opt.SnappyCompression, opt.NoCompression})
+	if raw <= compressed {
+		t.Errorf("table flushed to level-2 is compressed: %d <= %d", raw, compressed)
+	}
+}
+
+func TestDB_CompressionDict(t *testing.T) {
+	value := func(i int) string {
+		return fmt.Sprintf(`{"id":%d,"name":"user-%d","email":"user-%d@example.com","active":true}`, i, i, i)
+	}
+	build := func(dictSize int) (size int64) {
+		h := newDbHarnessWopt(t, &opt.Options{
+			DisableLargeBatchTransaction: true,
+			BlockSize:                    1024,
+			Compression:                  opt.ZstdCompression,
+			CompressionDictSize:          dictSize,
+		})
+		defer h.close()
+
+		for i := 0; i < 2000; i++ {
+			h.put(fmt.Sprintf("k%05d", i), value(i))
+		}
+		h.compactMem()
+		h.compactRangeAt(0, "", "")
+		h.tablesPerLevel("0,1")
+		v := h.db.s.version()
+		size = v.levels[1][0].size
+		v.release()
+
+		check := func() {
+			for i := 0; i < 2000; i++ {
+				h.getVal(fmt.Sprintf("k%05d", i), value(i))
+			}
+		}
+		check()
+		h.reopenDB()
+		check()
+		return
+	}
+
+	size0, size1 := build(0), build(2048)
+	if size1 >= size0 {
+		t.Errorf("table with compression dictionary is not smaller: %d >= %d", size1, size0)
+	}
+}
+
+func TestDB_CompressionDict_Samples(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Compression:                  opt.NoCompression,
+	})
+	defer h.close()
+
+	for _, prefix := range []string{"a", "m", "z"} {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("%s%03d", prefix, i)
+			h.put(key, strings.Repeat(key, 10))
+		}
+		h.compactMem()
+		h.compactRangeAt(0, "", "")
+	}
+	h.tablesPerLevel("0,3")
+
+	// The samples are spread along the whole input, not only its head.
+	c := h.db.s.getCompactionRange(1, nil, nil, true)
+	defer c.release()
+	b := &tableCompactionBuilder{s: h.db.s, c: c}
+	samples, err := b.dictSamples(3000)
+	if err != nil {
+		t.Fatal("dictSamples: got error: ", err)
+	}
+	seen := make(map[byte]bool)
+	var n int
+	for i := 0; i < len(samples); i += 2 {
+		seen[samples[i][0]] = true
+		n += len(samples[i]) + len(samples[i+1])
+	}
+	// Each table share may be exceeded by one entry.
+	if !seen['a'] || !seen['m'] || !seen['z'] || n > 3000+3*44 {
+		t.Errorf("got samples of %d bytes from %v", n, seen)
+	}
+}
+
+func TestDB_FIFOCompaction(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Compression:                  opt.NoCompression,
+		CompactionStyle:              opt.FIFOCompaction,
+	})
+	defer h.close()
+
+	const n = 200
+
+	key := func(round, i int) string {
+		return fmt.Sprintf("key%03d%06d", round, i)
+	}
+	value := func(round, i int) string {
+		return key(round, i) + strings.Repeat("x", 100)
+	}
+	write := func(round int) {
+		for i := 0; i < n; i++ {
+			h.put(key(round, i), value(round, i))
+		}
+		h.compactMem()
+	}
+
+	// Auto compaction isn't waited for, poll the tables instead.
+	tables := func() (num int, size int64) {
+		for i := 0; ; i++ {
+			v := h.db.s.version()
+			need := v.needCompaction() || len(v.fifoExpired(h.db.ttlNow())) > 0
+			num, size = 0, 0
+			var deep int
+			for level, tt := range v.levels {
+				if level > 0 {
+					deep += len(tt)
+				}
+				num += len(tt)
+				size += tt.size()
+			}
+			v.release()
+			if !need || i == 500 {
+				if deep > 0 {
+					t.Errorf("got %d tables beyond level-0, want none", deep)
+				}
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Writes aren't paused by the number of level-0 tables.
+	const rounds = 15
+	for round := 0; round < rounds; round++ {
+		write(round)
+	}
+	num, size := tables()
+	if num != rounds {
+		t.Errorf("got %d tables, want %d", num, rounds)
+	}
+	// Creation times are tracked even without an age limit, as they order
+	// the tables.
+	v := h.db.s.version()
+	for _, tt := range v.levels {
+		for _, tf := range tt {
+			if tf.ctime == 0 {
+				t.Errorf("table @%d: got no creation time", tf.fd.Num)
+			}
+		}
+	}
+	v.release()
+
+	// The oldest tables are dropped once too large in total.
+	h.o.CompactionFIFOMaxSize = size * 2 / 5
+	h.reopenDB()
+	if num, size = tables(); size > h.o.CompactionFIFOMaxSize || num == 0 || num >= rounds {
+		t.Errorf("got %d tables of %d bytes, want within %d bytes", num, size, h.o.CompactionFIFOMaxSize)
+	}
+	first := rounds - num
+	for round := 0; round < rounds; round++ {
+		if round < first {
+			h.get(key(round, 0), false)
+		} else {
+			h.getVal(key(round, 0), value(round, 0))
+			h.getVal(key(round, n-1), value(round, n-1))
+		}
+	}
+
+	// The newest small tables are merged together.
+	h.o.CompactionFIFOMaxSize = 0
+	h.o.CompactionFIFOMerge = true
+	h.reopenDB()
+	if num, _ = tables(); num != 1 {
+		t.Errorf("got %d tables, want merged into one", num)
+	}
+	h.delete(key(first, 0))
+	for round := rounds; round < rounds+3; round++ {
+		write(round)
+	}
+	if num, _ = tables(); num != 1 {
+		t.Errorf("got %d tables, want merged into one", num)
+	}
+	h.get(key(first, 0), false)
+	for round := first; round < rounds+3; round++ {
+		h.getVal(key(round, n-1), value(round, n-1))
+	}
+
+	var stats DBStats
+	if err := h.db.Stats(&stats); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if stats.FIFOComp == 0 || stats.Level0Comp != 0 || stats.NonLevel0Comp != 0 {
+		t.Errorf("got compactions FIFO:%d Level0:%d NonLevel0:%d, want FIFO only", stats.FIFOComp, stats.Level0Comp, stats.NonLevel0Comp)
+	}
+
+	// Tables are dropped once older than the age limit, the ages survive
+	// reopening.
+	h.o.CompactionFIFOMaxAge = time.Nanosecond
+	h.reopenDB()
+	if num, _ = tables(); num != 0 {
+		t.Errorf("got %d tables, want all expired", num)
+	}
+	h.get(key(rounds, 0), false)
+
+	h.o.CompactionFIFOMaxAge = 200 * time.Millisecond
+	h.reopenDB()
+	write(rounds)
+	for i := 0; ; i++ {
+		v := h.db.s.version()
+		num := len(v.fifoTables())
+		v.release()
+		if num == 0 {
+			break
+		}
+		if i == 500 {
+			t.Fatalf("got %d tables, want dropped once expired", num)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.get(key(rounds, 0), false)
+}
+
+func TestDB_FIFOCompaction_CompactRange(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		CompactionStyle:              opt.FIFOCompaction,
+	})
+	defer h.close()
+
+	h.put("a1", "v1")
+	h.put("a2", "v1")
+	h.compactMem()
+	h.put("b1", "v1")
+	h.compactMem()
+	h.put("a1", "v2")
+	h.put("c1", "v1")
+	h.compactMem()
+	h.tablesPerLevel("3")
+
+	// The tables between the overlapped ones are merged as well.
+	h.compactRange("a1", "a1")
+	h.tablesPerLevel("1")
+	h.getKeyVal("(a1->v2)(a2->v1)(b1->v1)(c1->v1)")
+
+	h.put("d1", "v1")
+	h.compactMem()
+	h.compactRange("", "")
+	h.tablesPerLevel("1")
+	h.getKeyVal("(a1->v2)(a2->v1)(b1->v1)(c1->v1)(d1->v1)")
+
+	// The merged table keeps the age of its data, so it is still the first
+	// dropped once too large in total, rather than the newer table.
+	h.put("e1", "v1")
+	h.compactMem()
+	h.compactRange("a1", "a1")
+	h.tablesPerLevel("2")
+	v := h.db.s.version()
+	h.o.CompactionFIFOMaxSize = v.levels[0].size() - 1
+	v.release()
+	h.reopenDB()
+	for i := 0; i < 500; i++ {
+		v := h.db.s.version()
+		need := v.needCompaction()
+		v.release()
+		if !need {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.tablesPerLevel("1")
+	h.getKeyVal("(e1->v1)")
+}
+
+func TestDB_Subcompactions(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Compression:                  opt.NoCompression,
+		CompactionTableSize:          16 * opt.KiB,
+		MaxSubcompactions:            4,
+	})
+	defer h.close()
+
+	const n = 4000
+
+	key := func(i int) string {
+		return fmt.Sprintf("key%06d", i)
+	}
+
+	// Level-1 tables, then overlapping level-0 tables spanning the whole
+	// key range.
+	want := make(map[string]string)
+	for i := 0; i < n; i++ {
+		h.put(key(i), key(i))
+		want[key(i)] = key(i)
+	}
+	h.compactRange("", "")
+	for round := 0; round < 3; round++ {
+		for i := round; i < n; i += 2 {
+			value := fmt.Sprintf("v%d%s", round, key(i))
+			h.put(key(i), value)
+			want[key(i)] = value
+		}
+		for i := round; i < n; i += 7 {
+			h.delete(key(i))
+			delete(want, key(i))
+		}
+		h.compactMem()
+	}
+	if err := h.db.DeleteRange([]byte(key(n/2-100)), []byte(key(n/2+100)), h.wo); err != nil {
+		t.Fatal("DeleteRange: got error: ", err)
+	}
+	for i := n/2 - 100; i < n/2+100; i++ {
+		delete(want, key(i))
+	}
+	h.put(key(n), "last")
+	want[key(n)] = "last"
+	h.compactMem()
+
+	c := h.db.s.getCompactionRange(0, nil, nil, true)
+	if c == nil {
+		t.Fatal("got no level-0 compaction")
+	}
+	bounds := c.subBounds(h.o.GetMaxSubcompactions())
+	c.release()
+	if len(bounds) != h.o.GetMaxSubcompactions()-1 {
+		t.Fatalf("got %d subcompaction bounds, want %d", len(bounds), h.o.GetMaxSubcompactions()-1)
+	}
+
+	h.compactRange("", "")
+
+	check := func() {
+		v := h.db.s.version()
+		defer v.release()
+		for level, tables := range v.levels {
+			if level == 0 {
+				if len(tables) > 0 {
+					t.Errorf("got %d level-0 tables, want none", len(tables))
+				}
+				continue
+			}
+			for i := 1; i < len(tables); i++ {
+				if h.db.s.icmp.uCompare(tables[i-1].imax.ukey(), tables[i].imin.ukey()) >= 0 {
+					t.Errorf("level-%d tables @%d and @%d overlap", level, tables[i-1].fd.Num, tables[i].fd.Num)
+				}
+			}
+		}
+
+		iter := h.db.NewIterator(nil, h.ro)
+		defer iter.Release()
+		var got int
+		for iter.Next() {
+			got++
+			if value, ok := want[string(iter.Key())]; !ok {
+				t.Errorf("got unexpected key %q", iter.Key())
+			} else if value != string(iter.Value()) {
+				t.Errorf("key %q: got value %q, want %q", iter.Key(), iter.Value(), value)
+			}
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal("Iterator: got error: ", err)
+		}
+		if got != len(want) {
+			t.Errorf("got %d keys, want %d", got, len(want))
+		}
+	}
+	check()
+
+	h.reopenDB()
+	check()
+}
@@ -16,7 +16,13 @@ import (
 	"github.com/3JoB/goleveldb/util"
 )
 
-var errTransactionDone = errors.New("leveldb: transaction already closed")
+var (
+	errTransactionDone         = errors.New("leveldb: transaction already closed")
+	errTransactionColumnFamily = errors.New("leveldb: transaction does not support column families")
+	errTransactionRangeDel     = errors.New("leveldb: transaction does not support range deletion")
+	errTransactionPrepared     = errors.New("leveldb: transaction already prepared")
+	errTransactionNoName       = errors.New("leveldb: transaction name is empty")
+)
 
 // Transaction is the transaction handle.
 type Transaction struct {
@@ -29,6 +35,15 @@ type Transaction struct {
 	rec       sessionRecord
 	stats     cStatStaging
 	closed    bool
+
+	// savePoints is the stack of savepoints, most recent last.
+	savePoints []txSavePoint
+}
+
+// txSavePoint records the transaction state at the time a savepoint is set.
+type txSavePoint struct {
+	seq    uint64
+	tables int
 }
 
 // Get gets the value for the given key. It returns ErrNotFound if the
@@ -94,7 +109,7 @@ func (tr *Transaction) flush() error {
 	if tr.mem.Len() != 0 {
 		tr.stats.startTimer()
 		iter := tr.mem.NewIterator(nil)
-		t, n, err := tr.db.s.tops.createFrom(iter)
+		t, n, err := tr.db.s.tops.createFrom(iter, nil, 0)
 		iter.Release()
 		tr.stats.stopTimer()
 		if err != nil {
@@ -115,14 +130,18 @@ func (tr *Transaction) flush() error {
 	return nil
 }
 
-func (tr *Transaction) put(kt keyType, key, value []byte) error {
-	tr.ikScratch = makeInternalKey(tr.ikScratch, key, tr.seq+1, kt)
-	if tr.mem.Free() < len(tr.ikScratch)+len(value) {
+func (tr *Transaction) putInternal(ikey, value []byte) error {
+	if tr.mem.Free() < len(ikey)+len(value) {
 		if err := tr.flush(); err != nil {
 			return err
 		}
 	}
-	if err := tr.mem.Put(tr.ikScratch, value); err != nil {
+	return tr.mem.Put(ikey, value)
+}
+
+func (tr *Transaction) put(kt keyType, key, value []byte) error {
+	tr.ikScratch = makeInternalKey(tr.ikScratch, key, tr.seq+1, kt)
+	if err := tr.putInternal(tr.ikScratch, value); err != nil {
 		return err
 	}
 	tr.seq++
@@ -141,6 +160,9 @@ func (tr *Transaction) Put(key, value []byte, wo *opt.WriteOptions) error {
 	if tr.closed {
 		return errTransactionDone
 	}
+	if tr.db.ttlEnabled() {
+		value = appendTTL(nil, value, tr.db.ttlExpiry(wo))
+	}
 	return tr.put(keyTypeVal, key, value)
 }
 
@@ -174,11 +196,158 @@ func (tr *Transaction) Write(b *Batch, wo *opt.WriteOptions) error {
 	if tr.closed {
 		return errTransactionDone
 	}
+	if b.hasColumnFamily() {
+		return errTransactionColumnFamily
+	}
+	if b.hasRangeDel() {
+		return errTransactionRangeDel
+	}
+	if tr.db.s.o.GetMergeOperator() == nil && b.hasMerge() {
+		return ErrNoMergeOperator
+	}
+	if !tr.db.ttlEnabled() {
+		return b.replayInternal(func(i int, kt keyType, k, v []byte) error {
+			return tr.put(kt, k, v)
+		})
+	}
+	if b.hasMerge() {
+		return ErrMergeTTL
+	}
+	expiry := tr.db.ttlExpiry(wo)
+	var buf []byte
 	return b.replayInternal(func(i int, kt keyType, k, v []byte) error {
+		if kt == keyTypeVal {
+			buf = appendTTL(buf[:0], v, expiry)
+			v = buf
+		}
 		return tr.put(kt, k, v)
 	})
 }
 
+// SetSavePoint records the current state of the transaction, so that writes
+// made afterward can be undone with RollbackToSavePoint. Savepoints can be
+// nested.
+func (tr *Transaction) SetSavePoint() error {
+	tr.lk.Lock()
+	defer tr.lk.Unlock()
+	if tr.closed {
+		return errTransactionDone
+	}
+	tr.savePoints = append(tr.savePoints, txSavePoint{seq: tr.seq, tables: len(tr.tables)})
+	return nil
+}
+
+// RollbackToSavePoint undoes the writes made since the most recent
+// savepoint, and removes that savepoint. It returns ErrNoSavePoint if there
+// is no savepoint. If any other error is returned, the transaction should be
+// discarded.
+func (tr *Transaction) RollbackToSavePoint() error {
+	tr.lk.Lock()
+	defer tr.lk.Unlock()
+	if tr.closed {
+		return errTransactionDone
+	}
+	n := len(tr.savePoints)
+	if n == 0 {
+		return ErrNoSavePoint
+	}
+	sp := tr.savePoints[n-1]
+	tr.savePoints = tr.savePoints[:n-1]
+	return tr.rollback(sp)
+}
+
+// PopSavePoint removes the most recent savepoint without undoing any write.
+// It returns ErrNoSavePoint if there is no savepoint.
+func (tr *Transaction) PopSavePoint() error {
+	tr.lk.Lock()
+	defer tr.lk.Unlock()
+	if tr.closed {
+		return errTransactionDone
+	}
+	n := len(tr.savePoints)
+	if n == 0 {
+		return ErrNoSavePoint
+	}
+	tr.savePoints = tr.savePoints[:n-1]
+	return nil
+}
+
+func (tr *Transaction) rollback(sp txSavePoint) error {
+	if tr.seq == sp.seq {
+		return nil
+	}
+
+	// Writes since the savepoint are only in the memdb, which isn't shared
+	// with any iterator; simply delete them.
+	if len(tr.tables) == sp.tables && tr.mem.getref() == 1 {
+		var ikeys [][]byte
+		iter := tr.mem.NewIterator(nil)
+		for iter.Next() {
+			if _, seq, _, err := parseInternalKey(iter.Key()); err == nil && seq > sp.seq {
+				ikeys = append(ikeys, append([]byte(nil), iter.Key()...))
+			}
+		}
+		iter.Release()
+		for _, ikey := range ikeys {
+			tr.mem.Delete(ikey)
+		}
+		tr.seq = sp.seq
+		return nil
+	}
+
+	// Otherwise rebuild the memdb and the tables flushed since the
+	// savepoint, keeping the writes made before the savepoint.
+	tables := append(tFiles(nil), tr.tables[sp.tables:]...)
+	tr.tables = tr.tables[:sp.tables]
+	tr.rec.addedTables = tr.rec.addedTables[:sp.tables]
+	mem := tr.mem
+	tr.mem = tr.db.mpoolGet(0)
+	tr.mem.incref()
+	defer func() {
+		mem.decref()
+		// File nums aren't reused, as blocks of the removed tables may
+		// still be cached.
+		for _, t := range tables {
+			tr.db.logf("transaction@rollback @%d", t.fd.Num)
+			tr.db.s.tops.removeFile(t.fd, false)
+		}
+	}()
+	tr.seq = sp.seq
+
+	for i := 0; i <= len(tables); i++ {
+		var iter iterator.Iterator
+		if i < len(tables) {
+			iter = tr.db.s.tops.newIterator(tables[i], nil, nil)
+		} else {
+			iter = mem.NewIterator(nil)
+		}
+		err := tr.refill(iter, sp.seq)
+		iter.Release()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Puts the records of the given iterator written before the given sequence
+// number.
+func (tr *Transaction) refill(iter iterator.Iterator, seq uint64) error {
+	for iter.Next() {
+		_, kseq, _, err := parseInternalKey(iter.Key())
+		if err != nil {
+			return err
+		}
+		if kseq > seq {
+			continue
+		}
+		if err := tr.putInternal(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
 func (tr *Transaction) setDone() {
 	tr.closed = true
 	tr.db.tr = nil
@@ -275,7 +444,7 @@ func (tr *Transaction) Discard() {
 }
 
 func (db *DB) waitCompaction() error {
-	if db.s.tLen(0) >= db.s.o.GetWriteL0PauseTrigger() {
+	if db.maxL0Len() >= db.s.o.GetWriteL0PauseTrigger() {
 		return db.compTriggerWait(db.tcompCmdC)
 	}
 	return nil
@@ -313,7 +482,7 @@ func (db *DB) OpenTransaction() (*Transaction, error) {
 	}
 
 	// Flush current memdb.
-	if db.mem != nil && db.mem.Len() != 0 {
+	if db.mem != nil && (db.mem.Len() != 0 || db.mem.rdel.Len() != 0) {
 		if _, err := db.rotateMem(0, true); err != nil {
 			return nil, err
 		}
@@ -0,0 +1,296 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"github.com/3JoB/goleveldb/comparer"
+	"github.com/3JoB/goleveldb/iterator"
+	"github.com/3JoB/goleveldb/memdb"
+	"github.com/3JoB/goleveldb/util"
+)
+
+// txBuffer buffers the writes of a transaction until commit. It is keyed by
+// user key, and the value is prefixed with the key type.
+type txBuffer struct {
+	*memdb.DB
+}
+
+func newTxBuffer(cmp comparer.BasicComparer) txBuffer {
+	return txBuffer{DB: memdb.New(cmp, 0)}
+}
+
+// Returns the buffered write of the given key, if any.
+func (b txBuffer) get(key []byte) (ok bool, kt keyType, value []byte) {
+	v, err := b.Get(key)
+	if err != nil {
+		return false, 0, nil
+	}
+	return true, keyType(v[0]), v[1:]
+}
+
+func (b txBuffer) put(kt keyType, key, value []byte) error {
+	v := make([]byte, 1+len(value))
+	v[0] = byte(kt)
+	copy(v[1:], value)
+	return b.Put(key, v)
+}
+
+// Returns the buffered writes as a batch.
+func (b txBuffer) batch() *Batch {
+	batch := new(Batch)
+	iter := b.NewIterator(nil)
+	defer iter.Release()
+	for iter.Next() {
+		batch.appendRec(0, keyType(iter.Value()[0]), iter.Key(), iter.Value()[1:])
+	}
+	return batch
+}
+
+// txIter iterates over the buffered writes of a transaction on top of a
+// DB iterator. Buffered writes shadow the DB records of the same key.
+type txIter struct {
+	cmp      comparer.BasicComparer
+	base     iterator.Iterator
+	delta    iterator.Iterator
+	cur      iterator.Iterator
+	dir      dir
+	err      error
+	releaser util.Releaser
+}
+
+func newTxIter(cmp comparer.BasicComparer, base iterator.Iterator, b txBuffer, slice *util.Range) *txIter {
+	return &txIter{
+		cmp:   cmp,
+		base:  base,
+		delta: b.NewIterator(slice),
+	}
+}
+
+func (i *txIter) deltaDeleted() bool {
+	return keyType(i.delta.Value()[0]) == keyTypeDel
+}
+
+func (i *txIter) iterErr() {
+	for _, iter := range [...]iterator.Iterator{i.base, i.delta} {
+		if err := iter.Error(); err != nil {
+			i.err = err
+			return
+		}
+	}
+}
+
+// Moves to the smallest key not shadowed by a buffered deletion.
+func (i *txIter) findForward() bool {
+	for {
+		bv, dv := i.base.Valid(), i.delta.Valid()
+		if dv {
+			c := 1
+			if bv {
+				c = i.cmp.Compare(i.base.Key(), i.delta.Key())
+			}
+			if c >= 0 {
+				if i.deltaDeleted() {
+					if c == 0 {
+						i.base.Next()
+					}
+					i.delta.Next()
+					continue
+				}
+				i.cur = i.delta
+				i.dir = dirForward
+				return true
+			}
+		}
+		if bv {
+			i.cur = i.base
+			i.dir = dirForward
+			return true
+		}
+		i.cur = nil
+		i.dir = dirEOI
+		i.iterErr()
+		return false
+	}
+}
+
+// Moves to the largest key not shadowed by a buffered deletion.
+func (i *txIter) findBackward() bool {
+	for {
+		bv, dv := i.base.Valid(), i.delta.Valid()
+		if dv {
+			c := -1
+			if bv {
+				c = i.cmp.Compare(i.base.Key(), i.delta.Key())
+			}
+			if c <= 0 {
+				if i.deltaDeleted() {
+					if c == 0 {
+						i.base.Prev()
+					}
+					i.delta.Prev()
+					continue
+				}
+				i.cur = i.delta
+				i.dir = dirBackward
+				return true
+			}
+		}
+		if bv {
+			i.cur = i.base
+			i.dir = dirBackward
+			return true
+		}
+		i.cur = nil
+		i.dir = dirSOI
+		i.iterErr()
+		return false
+	}
+}
+
+func (i *txIter) Valid() bool {
+	return i.err == nil && i.dir > dirEOI
+}
+
+func (i *txIter) First() bool {
+	if i.err != nil {
+		return false
+	} else if i.dir == dirReleased {
+		i.err = ErrIterReleased
+		return false
+	}
+	i.base.First()
+	i.delta.First()
+	return i.findForward()
+}
+
+func (i *txIter) Last() bool {
+	if i.err != nil {
+		return false
+	} else if i.dir == dirReleased {
+		i.err = ErrIterReleased
+		return false
+	}
+	i.base.Last()
+	i.delta.Last()
+	return i.findBackward()
+}
+
+func (i *txIter) Seek(key []byte) bool {
+	if i.err != nil {
+		return false
+	} else if i.dir == dirReleased {
+		i.err = ErrIterReleased
+		return false
+	}
+	i.base.Seek(key)
+	i.delta.Seek(key)
+	return i.findForward()
+}
+
+func (i *txIter) Next() bool {
+	if i.err != nil {
+		return false
+	}
+	switch i.dir {
+	case dirReleased:
+		i.err = ErrIterReleased
+		return false
+	case dirSOI:
+		return i.First()
+	case dirEOI:
+		return false
+	case dirBackward:
+		// Position both iterators after the current key.
+		key := append([]byte(nil), i.cur.Key()...)
+		for _, iter := range [...]iterator.Iterator{i.base, i.delta} {
+			if iter.Seek(key) && i.cmp.Compare(iter.Key(), key) == 0 {
+				iter.Next()
+			}
+		}
+	default:
+		if i.cur == i.delta && i.base.Valid() && i.cmp.Compare(i.base.Key(), i.delta.Key()) == 0 {
+			i.base.Next()
+		}
+		i.cur.Next()
+	}
+	return i.findForward()
+}
+
+func (i *txIter) Prev() bool {
+	if i.err != nil {
+		return false
+	}
+	switch i.dir {
+	case dirReleased:
+		i.err = ErrIterReleased
+		return false
+	case dirSOI:
+		return false
+	case dirEOI:
+		return i.Last()
+	case dirForward:
+		// Position both iterators before the current key.
+		key := append([]byte(nil), i.cur.Key()...)
+		for _, iter := range [...]iterator.Iterator{i.base, i.delta} {
+			if iter.Seek(key) {
+				iter.Prev()
+			} else {
+				iter.Last()
+			}
+		}
+	default:
+		if i.cur == i.delta && i.base.Valid() && i.cmp.Compare(i.base.Key(), i.delta.Key()) == 0 {
+			i.base.Prev()
+		}
+		i.cur.Prev()
+	}
+	return i.findBackward()
+}
+
+func (i *txIter) Key() []byte {
+	if i.err != nil || i.dir <= dirEOI {
+		return nil
+	}
+	return i.cur.Key()
+}
+
+func (i *txIter) Value() []byte {
+	if i.err != nil || i.dir <= dirEOI {
+		return nil
+	}
+	if i.cur == i.delta {
+		return i.delta.Value()[1:]
+	}
+	return i.base.Value()
+}
+
+func (i *txIter) Release() {
+	if i.dir != dirReleased {
+		if i.releaser != nil {
+			i.releaser.Release()
+			i.releaser = nil
+		}
+		i.dir = dirReleased
+		i.cur = nil
+		i.base.Release()
+		i.delta.Release()
+	}
+}
+
+func (i *txIter) SetReleaser(releaser util.Releaser) {
+	if i.dir == dirReleased {
+		panic(util.ErrReleased)
+	}
+	if i.releaser != nil && releaser != nil {
+		panic(util.ErrHasReleaser)
+	}
+	i.releaser = releaser
+}
+
+func (i *txIter) Error() error {
+	return i.err
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2016, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/3JoB/goleveldb/opt"
+)
+
+func TestDB_TransactionSavePoint(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		WriteBuffer:                  2 * opt.KiB,
+	})
+	defer h.close()
+
+	h.put("a", "v1")
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		t.Fatal("OpenTransaction: got error: ", err)
+	}
+	if err := tr.RollbackToSavePoint(); err != ErrNoSavePoint {
+		t.Errorf("RollbackToSavePoint: expect ErrNoSavePoint, got %v", err)
+	}
+
+	// Rollback within the memdb.
+	tr.Put([]byte("b"), []byte("v1"), nil)
+	tr.SetSavePoint()
+	tr.Put([]byte("b"), []byte("v2"), nil)
+	tr.Delete([]byte("a"), nil)
+	tr.Put([]byte("c"), []byte("v2"), nil)
+	if err := tr.RollbackToSavePoint(); err != nil {
+		t.Fatal("RollbackToSavePoint: got error: ", err)
+	}
+	if v, err := tr.Get([]byte("b"), nil); err != nil || string(v) != "v1" {
+		t.Errorf("Get: got (%q, %v)", v, err)
+	}
+	if v, err := tr.Get([]byte("a"), nil); err != nil || string(v) != "v1" {
+		t.Errorf("Get: got (%q, %v)", v, err)
+	}
+
+	// Rollback of flushed writes.
+	value := strings.Repeat("x", 100)
+	tr.SetSavePoint()
+	tr.Put([]byte("c"), []byte("v3"), nil)
+	tr.SetSavePoint()
+	for i := 0; i < 100; i++ {
+		tr.Put([]byte(fmt.Sprintf("k%03d", i)), []byte(value), nil)
+	}
+	if len(tr.tables) == 0 {
+		t.Fatal("expect transaction tables")
+	}
+	if err := tr.RollbackToSavePoint(); err != nil {
+		t.Fatal("RollbackToSavePoint: got error: ", err)
+	}
+	if _, err := tr.Get([]byte("k000"), nil); err != ErrNotFound {
+		t.Errorf("Get: expect ErrNotFound, got %v", err)
+	}
+	if err := tr.PopSavePoint(); err != nil {
+		t.Fatal("PopSavePoint: got error: ", err)
+	}
+	if err := tr.PopSavePoint(); err != ErrNoSavePoint {
+		t.Errorf("PopSavePoint: expect ErrNoSavePoint, got %v", err)
+	}
+	tr.Put([]byte("d"), []byte("v4"), nil)
+
+	if err := tr.Commit(); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	h.getKeyVal("(a->v1)(b->v1)(c->v3)(d->v4)")
+	h.reopenDB()
+	h.getKeyVal("(a->v1)(b->v1)(c->v3)(d->v4)")
+}
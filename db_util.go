@@ -49,6 +49,15 @@ func (db *DB) checkAndCleanFiles() error {
 			tmap[t.fd.Num] = false
 		}
 	}
+	for _, fs := range db.s.liveFamilies() {
+		fv := fs.version()
+		for _, tables := range fv.levels {
+			for _, t := range tables {
+				tmap[t.fd.Num] = false
+			}
+		}
+		fv.release()
+	}
 
 	fds, err := db.s.stor.List(storage.TypeAll)
 	if err != nil {
@@ -95,7 +104,12 @@ func (db *DB) checkAndCleanFiles() error {
 	db.logf("db@janitor F·%d G·%d", len(fds), len(rem))
 	for _, fd := range rem {
 		db.logf("db@janitor removing %s-%d", fd.Type, fd.Num)
-		if err := db.s.stor.Remove(fd); err != nil {
+		if fd.Type == storage.TypeJournal {
+			// Obsolete journals might be retained.
+			if err := db.removeJournal(fd); err != nil {
+				return err
+			}
+		} else if err := db.s.stor.Remove(fd); err != nil {
 			return err
 		}
 	}
@@ -79,13 +79,14 @@ func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) {
 				mdb = nil
 			}
 		}()
-		tLen := db.s.tLen(0)
+		tLen := db.maxL0Len()
 		mdbFree = mdb.Free()
+		familyFull := db.familyMemFull(n)
 		switch {
 		case tLen >= slowdownTrigger && !delayed:
 			delayed = true
 			time.Sleep(time.Millisecond)
-		case mdbFree >= n:
+		case mdbFree >= n && !familyFull:
 			return false
 		case tLen >= pauseTrigger:
 			delayed = true
@@ -99,7 +100,7 @@ func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) {
 			}
 		default:
 			// Allow memdb to grow if it has no entry.
-			if mdb.Len() == 0 {
+			if mdb.Len() == 0 && !familyFull {
 				mdbFree = n
 			} else {
 				mdb.decref()
@@ -134,6 +135,7 @@ func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) {
 type writeMerge struct {
 	sync       bool
 	batch      *Batch
+	cf         uint32
 	keyType    keyType
 	key, value []byte
 }
@@ -207,7 +209,7 @@ func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
 					}
 					// We can use same batch since concurrent write doesn't
 					// guarantee write order.
-					ourBatch.appendRec(incoming.keyType, incoming.key, incoming.value)
+					ourBatch.appendRec(incoming.cf, incoming.keyType, incoming.key, incoming.value)
 					mergeLimit -= internalLen
 				}
 				sync = sync || incoming.sync
@@ -235,8 +237,9 @@ func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
 	}
 
 	// Put batches.
+	fmdb := func(cf uint32, _ uint64) *memdb.DB { return db.familyMem(cf) }
 	for _, batch := range batches {
-		if err := batch.putMem(seq, mdb.DB); err != nil {
+		if err := batch.putMem(db.s.icmp, seq, mdb.DB, mdb.rdel, fmdb); err != nil {
 			panic(err)
 		}
 		seq += uint64(batch.Len())
@@ -268,11 +271,20 @@ func (db *DB) Write(batch *Batch, wo *opt.WriteOptions) error {
 	if err := db.ok(); err != nil || batch == nil || batch.Len() == 0 {
 		return err
 	}
+	if err := db.checkFamilies(batch); err != nil {
+		return err
+	}
+	if db.s.o.GetMergeOperator() == nil && batch.hasMerge() {
+		return ErrNoMergeOperator
+	}
+	if db.ttlEnabled() && batch.hasMerge() {
+		return ErrMergeTTL
+	}
 
 	// If the batch size is larger than write buffer, it may justified to write
 	// using transaction instead. Using transaction the batch will be written
 	// into tables directly, skipping the journaling.
-	if batch.internalLen > db.s.o.GetWriteBuffer() && !db.s.o.GetDisableLargeBatchTransaction() {
+	if batch.internalLen > db.s.o.GetWriteBuffer() && !db.s.o.GetDisableLargeBatchTransaction() && !batch.hasColumnFamily() && !batch.hasRangeDel() {
 		tr, err := db.OpenTransaction()
 		if err != nil {
 			return err
@@ -283,6 +295,7 @@ func (db *DB) Write(batch *Batch, wo *opt.WriteOptions) error {
 		}
 		return tr.Commit()
 	}
+	batch = db.ttlBatch(batch, wo)
 
 	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge()
 	sync := wo.GetSync() && !db.s.o.GetNoSync()
@@ -321,10 +334,13 @@ func (db *DB) Write(batch *Batch, wo *opt.WriteOptions) error {
 	return db.writeLocked(batch, nil, merge, sync)
 }
 
-func (db *DB) putRec(kt keyType, key, value []byte, wo *opt.WriteOptions) error {
+func (db *DB) putRec(cf uint32, kt keyType, key, value []byte, wo *opt.WriteOptions) error {
 	if err := db.ok(); err != nil {
 		return err
 	}
+	if cf == 0 && kt == keyTypeVal && db.ttlEnabled() {
+		value = appendTTL(nil, value, db.ttlExpiry(wo))
+	}
 
 	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge()
 	sync := wo.GetSync() && !db.s.o.GetNoSync()
@@ -332,7 +348,7 @@ func (db *DB) putRec(kt keyType, key, value []byte, wo *opt.WriteOptions) error
 	// Acquire write lock.
 	if merge {
 		select {
-		case db.writeMergeC <- writeMerge{sync: sync, keyType: kt, key: key, value: value}:
+		case db.writeMergeC <- writeMerge{sync: sync, cf: cf, keyType: kt, key: key, value: value}:
 			if <-db.writeMergedC {
 				// Write is merged.
 				return <-db.writeAckC
@@ -362,7 +378,7 @@ func (db *DB) putRec(kt keyType, key, value []byte, wo *opt.WriteOptions) error
 
 	batch := db.batchPool.Get().(*Batch)
 	batch.Reset()
-	batch.appendRec(kt, key, value)
+	batch.appendRec(cf, kt, key, value)
 	return db.writeLocked(batch, batch, merge, sync)
 }
 
@@ -373,7 +389,7 @@ func (db *DB) putRec(kt keyType, key, value []byte, wo *opt.WriteOptions) error
 // It is safe to modify the contents of the arguments after Put returns but not
 // before.
 func (db *DB) Put(key, value []byte, wo *opt.WriteOptions) error {
-	return db.putRec(keyTypeVal, key, value, wo)
+	return db.putRec(0, keyTypeVal, key, value, wo)
 }
 
 // Delete deletes the value for the given key. Delete will not returns error if
@@ -382,7 +398,38 @@ func (db *DB) Put(key, value []byte, wo *opt.WriteOptions) error {
 // It is safe to modify the contents of the arguments after Delete returns but
 // not before.
 func (db *DB) Delete(key []byte, wo *opt.WriteOptions) error {
-	return db.putRec(keyTypeDel, key, nil, wo)
+	return db.putRec(0, keyTypeDel, key, nil, wo)
+}
+
+// Merge merges the given operand into the value of the given key, using the
+// merge operator specified by opt.Options.MergeOperator. The operands are
+// resolved when the key is read, so Merge doesn't need to read the existing
+// value. Write merge also applies for Merge, see Write.
+//
+// It is safe to modify the contents of the arguments after Merge returns but
+// not before.
+func (db *DB) Merge(key, operand []byte, wo *opt.WriteOptions) error {
+	if db.s.o.GetMergeOperator() == nil {
+		return ErrNoMergeOperator
+	}
+	if db.ttlEnabled() {
+		return ErrMergeTTL
+	}
+	return db.putRec(0, keyTypeMerge, key, operand, wo)
+}
+
+// DeleteRange deletes all keys in the given key range, from start
+// (inclusive) to limit (exclusive), by writing a single range tombstone.
+// DeleteRange is a no-op if start is not less than limit. Write merge also
+// applies for DeleteRange, see Write.
+//
+// It is safe to modify the contents of the arguments after DeleteRange
+// returns but not before.
+func (db *DB) DeleteRange(start, limit []byte, wo *opt.WriteOptions) error {
+	if db.s.icmp.uCompare(start, limit) >= 0 {
+		return db.ok()
+	}
+	return db.putRec(0, keyTypeRangeDel, start, limit, wo)
 }
 
 func isMemOverlaps(icmp *iComparer, mem *memdb.DB, min, max []byte) bool {
@@ -401,6 +448,13 @@ func isMemOverlaps(icmp *iComparer, mem *memdb.DB, min, max []byte) bool {
 // A nil Range.Start is treated as a key before all keys in the DB.
 // And a nil Range.Limit is treated as a key after all keys in the DB.
 // Therefore if both is nil then it will compact entire DB.
+//
+// In TTL mode, expired entries within the range are purged as well, see
+// opt.Options.TTL.
+//
+// With FIFO compaction, the level-0 tables overlapping the range are merged
+// together, along with the ones between them in age; tables stay in
+// level-0.
 func (db *DB) CompactRange(r util.Range) error {
 	if err := db.ok(); err != nil {
 		return err
@@ -421,7 +475,7 @@ func (db *DB) CompactRange(r util.Range) error {
 		return ErrClosed
 	}
 	defer mdb.decref()
-	if isMemOverlaps(db.s.icmp, mdb.DB, r.Start, r.Limit) {
+	if isMemOverlaps(db.s.icmp, mdb.DB, r.Start, r.Limit) || mdb.rdel.Len() > 0 {
 		// Memdb compaction.
 		if _, err := db.rotateMem(0, false); err != nil {
 			<-db.writeLockC
@@ -436,7 +490,7 @@ func (db *DB) CompactRange(r util.Range) error {
 	}
 
 	// Table compaction.
-	return db.compTriggerRange(db.tcompCmdC, -1, r.Start, r.Limit)
+	return db.compTriggerRange(db.tcompCmdC, db.s, -1, r.Start, r.Limit)
 }
 
 // SetReadOnly makes DB read-only. It will stay read-only until reopened.
@@ -17,4 +17,28 @@ var (
 	ErrSnapshotReleased = errors.New("leveldb: snapshot released")
 	ErrIterReleased     = errors.New("leveldb: iterator released")
 	ErrClosed           = errors.New("leveldb: closed")
+
+	ErrColumnFamilyExist    = errors.New("leveldb: column family already exist")
+	ErrColumnFamilyNotFound = errors.New("leveldb: column family not found")
+	ErrColumnFamilyDropped  = errors.New("leveldb: column family dropped")
+
+	ErrNoMergeOperator = errors.New("leveldb: merge operator not specified")
+	ErrMergeFailed     = errors.New("leveldb: merge operator failed")
+	ErrMergeTTL        = errors.New("leveldb: merge not supported in TTL mode")
+
+	ErrCheckpointExist = errors.New("leveldb: checkpoint directory already exist")
+
+	ErrJournalNotRetained = errors.New("leveldb: journal not retained")
+
+	ErrSSTKeyOrder   = errors.New("leveldb: keys must be added in increasing order")
+	ErrSSTClosed     = errors.New("leveldb: sst writer closed")
+	ErrIngestOverlap = errors.New("leveldb: ingested files overlap")
+	ErrIngestTTL     = errors.New("leveldb: ingest not supported in TTL mode")
+
+	ErrNoSavePoint = errors.New("leveldb: no savepoint")
+	ErrConflict    = errors.New("leveldb: transaction conflict")
+	ErrLockTimeout = errors.New("leveldb: transaction lock timeout")
+	ErrDeadlock    = errors.New("leveldb: transaction deadlock")
+
+	ErrPreparedExist = errors.New("leveldb: prepared transaction already exist")
 )
@@ -7,7 +7,10 @@
 package leveldb
 
 import (
+	"bytes"
+
 	"github.com/3JoB/goleveldb/filter"
+	"github.com/3JoB/goleveldb/util"
 )
 
 type iFilter struct {
@@ -29,3 +32,51 @@ type iFilterGenerator struct {
 func (g iFilterGenerator) Add(key []byte) {
 	g.FilterGenerator.Add(internalKey(key).ukey())
 }
+
+type iRangeFilter struct {
+	filter.RangeFilter
+}
+
+func (f iRangeFilter) MayContainRange(filter, start, limit []byte) bool {
+	if start != nil {
+		start = internalKey(start).ukey()
+	}
+	if limit != nil {
+		limit = internalKey(limit).ukey()
+	}
+	return f.RangeFilter.MayContainRange(filter, start, limit)
+}
+
+func (f iRangeFilter) NewGenerator() filter.FilterGenerator {
+	return iFilterGenerator{FilterGenerator: f.RangeFilter.NewGenerator()}
+}
+
+type iPrefixExtractor struct {
+	filter.PrefixExtractor
+}
+
+func (e iPrefixExtractor) InDomain(key []byte) bool {
+	return e.PrefixExtractor.InDomain(internalKey(key).ukey())
+}
+
+// Prefix returns the prefix as an internal key, as the filter takes.
+func (e iPrefixExtractor) Prefix(key []byte) []byte {
+	return makeInternalKey(nil, e.PrefixExtractor.Prefix(internalKey(key).ukey()), keyMaxSeq, keyTypeSeek)
+}
+
+// Returns the prefix shared by all keys within the given internal key range,
+// as an internal key, or nil if there is none.
+func (e iPrefixExtractor) rangePrefix(slice *util.Range) []byte {
+	if slice == nil || slice.Start == nil || slice.Limit == nil {
+		return nil
+	}
+	start, limit := internalKey(slice.Start).ukey(), internalKey(slice.Limit).ukey()
+	if !e.PrefixExtractor.InDomain(start) {
+		return nil
+	}
+	prefix := e.PrefixExtractor.Prefix(start)
+	if !bytes.HasPrefix(limit, prefix) && !bytes.Equal(limit, util.BytesPrefix(prefix).Limit) {
+		return nil
+	}
+	return makeInternalKey(nil, prefix, keyMaxSeq, keyTypeSeek)
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"github.com/3JoB/goleveldb/util"
+)
+
+const (
+	// Size of the cache line of the blocked bloom filter.
+	cacheLineBytes = 64
+	cacheLineBits  = cacheLineBytes * 8
+)
+
+// Returns the 64-bit hash of the key, made of two 32-bit hashes.
+func hash64(key []byte) uint64 {
+	return uint64(bloomHash(key))<<32 | uint64(util.Hash(key, 0x9e3779b9))
+}
+
+type blockedBloomFilter int
+
+// Name: The blocked bloom filter serializes its parameters and is backward
+// compatible with respect to them. Therefor, its parameters are not added to
+// its name.
+func (blockedBloomFilter) Name() string {
+	return "leveldb.BlockedBloomFilter"
+}
+
+func (f blockedBloomFilter) Contains(filter, key []byte) bool {
+	nBytes := len(filter) - 1
+	if nBytes < cacheLineBytes {
+		return false
+	}
+	nLines := uint32(nBytes / cacheLineBytes)
+
+	// Use the encoded k so that we can read filters generated by
+	// blocked bloom filters created using different parameters.
+	k := filter[nBytes]
+	if k > 30 {
+		// Reserved for potentially new encodings.
+		// Consider it a match.
+		return true
+	}
+
+	h := hash64(key)
+	line := filter[blockedBloomLine(uint32(h>>32), nLines)*cacheLineBytes:]
+	kh := uint32(h)
+	for j := uint8(0); j < k; j++ {
+		// The top 9 bits address a bit within the cache line.
+		bitpos := kh >> (32 - 9)
+		if (uint32(line[bitpos/8]) & (1 << (bitpos % 8))) == 0 {
+			return false
+		}
+		kh *= 0x9e3779b9
+	}
+	return true
+}
+
+// Maps the hash to a cache line, without modulo.
+func blockedBloomLine(h, nLines uint32) uint32 {
+	return uint32((uint64(h) * uint64(nLines)) >> 32)
+}
+
+func (f blockedBloomFilter) NewGenerator() FilterGenerator {
+	// Round down to reduce probing cost a little bit.
+	k := uint8(f * 69 / 100) // 0.69 =~ ln(2)
+	if k < 1 {
+		k = 1
+	} else if k > 30 {
+		k = 30
+	}
+	return &blockedBloomFilterGenerator{
+		n: int(f),
+		k: k,
+	}
+}
+
+type blockedBloomFilterGenerator struct {
+	n int
+	k uint8
+
+	keyHashes []uint64
+}
+
+func (g *blockedBloomFilterGenerator) Add(key []byte) {
+	g.keyHashes = append(g.keyHashes, hash64(key))
+}
+
+func (g *blockedBloomFilterGenerator) Generate(b Buffer) {
+	// Compute the number of cache lines, there is at least one.
+	nBits := len(g.keyHashes) * g.n
+	nLines := uint32((nBits + cacheLineBits - 1) / cacheLineBits)
+	if nLines < 1 {
+		nLines = 1
+	}
+	nBytes := int(nLines) * cacheLineBytes
+
+	dest := b.Alloc(nBytes + 1)
+	// Alloc doesn't clear reused memory.
+	for i := range dest[:nBytes] {
+		dest[i] = 0
+	}
+	dest[nBytes] = g.k
+	for _, h := range g.keyHashes {
+		line := dest[blockedBloomLine(uint32(h>>32), nLines)*cacheLineBytes:]
+		kh := uint32(h)
+		for j := uint8(0); j < g.k; j++ {
+			bitpos := kh >> (32 - 9)
+			line[bitpos/8] |= (1 << (bitpos % 8))
+			kh *= 0x9e3779b9
+		}
+	}
+
+	g.keyHashes = g.keyHashes[:0]
+}
+
+// NewBlockedBloomFilter creates a new initialized cache-local blocked bloom
+// filter for given bitsPerKey.
+//
+// Unlike the bloom filter created by NewBloomFilter, all probes for a key
+// fall within a single 64-bytes cache line, which makes probing cheaper at
+// the cost of a slightly higher false positive rate for the same
+// bitsPerKey. Like the bloom filter, bitsPerKey is persisted for each
+// serialization, so changing it is backward compatible.
+func NewBlockedBloomFilter(bitsPerKey int) Filter {
+	return blockedBloomFilter(bitsPerKey)
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"testing"
+)
+
+func TestBlockedBloomFilter_Small(t *testing.T) {
+	h := newFilterHarness(t, NewBlockedBloomFilter(10))
+	h.build()
+	h.assert([]byte("hello"), false, false)
+	h.add([]byte("hello"))
+	h.add([]byte("world"))
+	h.build()
+	h.assert([]byte("hello"), true, false)
+	h.assert([]byte("world"), true, false)
+	h.assert([]byte("x"), false, false)
+	h.assert([]byte("foo"), false, false)
+}
+
+func TestBlockedBloomFilter_VaryingLengths(t *testing.T) {
+	h := newFilterHarness(t, NewBlockedBloomFilter(10))
+	for n := 1; n < 10000; n = nextN(n) {
+		h.reset()
+		for i := 0; i < n; i++ {
+			h.addNum(uint32(i))
+		}
+		h.build()
+
+		got := h.filterLen()
+		want := (n*10/8+63)/64*64 + 64 + 1
+		if got > want {
+			t.Errorf("filter len test failed, '%d' > '%d'", got, want)
+		}
+
+		for i := 0; i < n; i++ {
+			h.assertNum(uint32(i), true, false)
+		}
+
+		var rate float32
+		for i := 0; i < 10000; i++ {
+			if h.assertNum(uint32(i+1000000000), true, true) {
+				rate++
+			}
+		}
+		rate /= 10000
+		if rate > 0.03 {
+			t.Errorf("false positive rate is more than 3%%, got %v, at len %d", rate, n)
+		}
+	}
+}
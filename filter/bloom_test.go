@@ -22,7 +22,10 @@ type harness struct {
 }
 
 func newHarness(t *testing.T) *harness {
-	bloom := NewBloomFilter(10)
+	return newFilterHarness(t, NewBloomFilter(10))
+}
+
+func newFilterHarness(t *testing.T, bloom Filter) *harness {
 	return &harness{
 		t:         t,
 		bloom:     bloom,
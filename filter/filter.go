@@ -58,3 +58,42 @@ type FilterGenerator interface {
 	// to Generate the filter generator maybe resetted, depends on implementation.
 	Generate(b Buffer)
 }
+
+// PrefixExtractor extracts prefixes of keys, so that filters can also tell
+// whether keys with a given prefix may exist.
+type PrefixExtractor interface {
+	// Name returns the name of this extractor.
+	//
+	// Note that if the extracted prefixes change, the name returned by this
+	// method must be changed. Otherwise, filters holding old prefixes may
+	// be used to exclude keys.
+	Name() string
+
+	// InDomain returns true if the given key has a prefix.
+	InDomain(key []byte) bool
+
+	// Prefix returns the prefix of the given key, which must be in domain.
+	//
+	// The prefix must be a prefix of the key, and every key starting with
+	// the prefix must be in domain and have the same prefix.
+	Prefix(key []byte) []byte
+}
+
+// RangeFilter is the range filter, which tells whether any key may exist
+// within a range of keys.
+type RangeFilter interface {
+	// Name returns the name of this range filter.
+	//
+	// Note that if the filter encoding changes in an incompatible way,
+	// the name returned by this method must be changed.
+	Name() string
+
+	// NewGenerator creates a new filter generator. The keys must be added
+	// in increasing order, repeated keys are ignored.
+	NewGenerator() FilterGenerator
+
+	// MayContainRange returns false if the filter contains no key within
+	// the given range. A nil start is treated as a key before all keys, and
+	// a nil limit as a key after all keys.
+	MayContainRange(filter, start, limit []byte) bool
+}
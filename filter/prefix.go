@@ -0,0 +1,31 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"strconv"
+)
+
+type fixedPrefix int
+
+func (p fixedPrefix) Name() string {
+	return "leveldb.FixedPrefix." + strconv.Itoa(int(p))
+}
+
+func (p fixedPrefix) InDomain(key []byte) bool {
+	return len(key) >= int(p)
+}
+
+func (p fixedPrefix) Prefix(key []byte) []byte {
+	return key[:p]
+}
+
+// NewFixedPrefix creates a new prefix extractor which extracts the first n
+// bytes of keys. Keys shorter than n bytes have no prefix.
+func NewFixedPrefix(n int) PrefixExtractor {
+	return fixedPrefix(n)
+}
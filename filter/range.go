@@ -0,0 +1,223 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	// Number of entries between restart points of the trie range filter.
+	trieRestartInterval = 16
+
+	// Number of bytes of the keys kept past their minimal distinguishing
+	// prefixes, as SuRF-Real does, which lowers false positives.
+	trieSuffixLen = 1
+)
+
+// The trie range filter stores, as SuRF, the trie of the keys truncated to
+// their minimal distinguishing prefixes, that is the shortest prefixes
+// telling each key apart from its neighbours, plus a few more bytes. The
+// truncated keys are sorted and prefix-free, except for complete keys which
+// may be prefixes of the following ones.
+//
+// The trie is encoded as the front-coded sorted truncated keys:
+//
+//	+---------+---------+-----+---------+------------+-----+--------------------+
+//	| entry 1 | entry 2 | ... | entry n | restart 1  | ... | restarts (4-bytes) |
+//	+---------+---------+-----+---------+------------+-----+--------------------+
+//
+// Each entry is the shared prefix length, followed by the unshared length
+// shifted left by one with the lowest bit set for a complete key, followed
+// by the unshared bytes. The shared prefix length is zero at restart points.
+type trieRangeFilter struct{}
+
+func (trieRangeFilter) Name() string {
+	return "leveldb.TrieRangeFilter"
+}
+
+// Decodes the entry at the given offset, appending its key to prev which
+// must be the previous key.
+func trieEntry(filter []byte, offset int, prev []byte) (key []byte, complete bool, n int) {
+	shared, n0 := binary.Uvarint(filter[offset:])
+	unshared, n1 := binary.Uvarint(filter[offset+n0:])
+	if n0 <= 0 || n1 <= 0 {
+		return nil, false, 0
+	}
+	complete = unshared&1 != 0
+	unshared >>= 1
+	m := offset + n0 + n1
+	if shared > uint64(len(prev)) || uint64(m)+unshared > uint64(len(filter)) {
+		return nil, false, 0
+	}
+	key = append(prev[:shared], filter[m:m+int(unshared)]...)
+	return key, complete, n0 + n1 + int(unshared)
+}
+
+func (trieRangeFilter) MayContainRange(filter, start, limit []byte) bool {
+	if len(filter) < 4 {
+		return false
+	}
+	nRestarts := int(binary.LittleEndian.Uint32(filter[len(filter)-4:]))
+	restartsOffset := len(filter) - 4 - nRestarts*4
+	if nRestarts == 0 || restartsOffset < 0 {
+		// Empty, or corrupted hence consider it a match.
+		return nRestarts != 0
+	}
+	restart := func(i int) int {
+		return int(binary.LittleEndian.Uint32(filter[restartsOffset+i*4:]))
+	}
+
+	// Scan from the last restart point whose key is less than start.
+	var corrupted bool
+	r := sort.Search(nRestarts, func(i int) bool {
+		key, _, n := trieEntry(filter, restart(i), nil)
+		if n == 0 {
+			corrupted = true
+		}
+		return bytes.Compare(key, start) >= 0
+	}) - 1
+	if corrupted {
+		return true
+	}
+	offset := 0
+	if r > 0 {
+		offset = restart(r)
+	}
+	var (
+		prev, key         []byte
+		prevComplete      bool
+		complete, matched bool
+	)
+	for offset < restartsOffset {
+		var n int
+		key, complete, n = trieEntry(filter, offset, key)
+		if n == 0 {
+			return true
+		}
+		if bytes.Compare(key, start) >= 0 {
+			matched = true
+			break
+		}
+		prev, prevComplete = append(prev[:0], key...), complete
+		offset += n
+	}
+
+	// A key truncated to a prefix of start may be greater than start. Only
+	// the previous truncated key may be such a prefix, since the truncated
+	// keys other than the complete ones are prefix-free.
+	if prev != nil && !prevComplete && bytes.HasPrefix(start, prev) {
+		return true
+	}
+	// The first truncated key not less than start is a prefix of a key not
+	// less than start.
+	return matched && (limit == nil || bytes.Compare(key, limit) < 0)
+}
+
+func (trieRangeFilter) NewGenerator() FilterGenerator {
+	return &trieRangeFilterGenerator{}
+}
+
+type trieRangeFilterGenerator struct {
+	buf      []byte
+	restarts []uint32
+	nEntries int
+
+	// The pending key, and the length of its common prefix with the
+	// previous key.
+	key     []byte
+	hasKey  bool
+	prevLCP int
+
+	// The previous truncated key.
+	prev []byte
+}
+
+func sharedLen(a, b []byte) int {
+	i, n := 0, len(a)
+	if n > len(b) {
+		n = len(b)
+	}
+	for i < n && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// Appends the pending key truncated to its minimal distinguishing prefix,
+// given the length of its common prefix with the next key.
+func (g *trieRangeFilterGenerator) flush(nextLCP int) {
+	n := g.prevLCP
+	if nextLCP > n {
+		n = nextLCP
+	}
+	n += 1 + trieSuffixLen
+	complete := n >= len(g.key)
+	if complete {
+		n = len(g.key)
+	}
+	truncated := g.key[:n]
+
+	shared := 0
+	if g.nEntries%trieRestartInterval == 0 {
+		g.restarts = append(g.restarts, uint32(len(g.buf)))
+	} else {
+		shared = sharedLen(g.prev, truncated)
+	}
+	unshared := uint64(len(truncated)-shared) << 1
+	if complete {
+		unshared |= 1
+	}
+	g.buf = binary.AppendUvarint(g.buf, uint64(shared))
+	g.buf = binary.AppendUvarint(g.buf, unshared)
+	g.buf = append(g.buf, truncated[shared:]...)
+	g.prev = append(g.prev[:0], truncated...)
+	g.nEntries++
+}
+
+func (g *trieRangeFilterGenerator) Add(key []byte) {
+	if g.hasKey {
+		if bytes.Equal(key, g.key) {
+			return
+		}
+		lcp := sharedLen(g.key, key)
+		g.flush(lcp)
+		g.prevLCP = lcp
+	}
+	g.key = append(g.key[:0], key...)
+	g.hasKey = true
+}
+
+func (g *trieRangeFilterGenerator) Generate(b Buffer) {
+	if g.hasKey {
+		g.flush(0)
+	}
+	b.Write(g.buf)
+	for _, x := range g.restarts {
+		binary.LittleEndian.PutUint32(b.Alloc(4), x)
+	}
+	binary.LittleEndian.PutUint32(b.Alloc(4), uint32(len(g.restarts)))
+
+	g.buf = g.buf[:0]
+	g.restarts = g.restarts[:0]
+	g.nEntries = 0
+	g.hasKey = false
+	g.prevLCP = 0
+}
+
+// NewTrieRangeFilter creates a new initialized range filter, which stores
+// the trie of the keys truncated to their minimal distinguishing prefixes
+// plus one byte, as SuRF does.
+//
+// The filter has no false negative, and its false positives are ranges
+// falling between a truncated key and the key itself. It relies on the
+// keys being ordered bytewise.
+func NewTrieRangeFilter() RangeFilter {
+	return trieRangeFilter{}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/3JoB/goleveldb/util"
+)
+
+func buildRangeFilter(keys []string) []byte {
+	g := NewTrieRangeFilter().NewGenerator()
+	for _, key := range keys {
+		g.Add([]byte(key))
+	}
+	b := &util.Buffer{}
+	g.Generate(b)
+	return b.Bytes()
+}
+
+func TestTrieRangeFilter_Small(t *testing.T) {
+	f := NewTrieRangeFilter()
+	keys := []string{"", "ab", "abc", "abc", "abd", "b", "bcdef", "c"}
+	filter := buildRangeFilter(keys)
+	for _, x := range []struct {
+		start, limit string
+		want         bool
+	}{
+		{"", "a", true},
+		{"a", "ab", false},
+		{"ab", "ab\x00", true},
+		{"ab\x00", "abc", false},
+		{"abc", "abc\x00", true},
+		{"abe", "b", false},
+		{"b\x00", "bb", false},
+		{"bcz", "c", false},
+		{"c\x00", "z", false},
+		{"c", "", true},
+		{"d", "", false},
+	} {
+		var limit []byte
+		if x.limit != "" {
+			limit = []byte(x.limit)
+		}
+		if got := f.MayContainRange(filter, []byte(x.start), limit); got != x.want {
+			t.Errorf("MayContainRange(%q, %q): got %v, want %v", x.start, x.limit, got, x.want)
+		}
+	}
+	if f.MayContainRange(buildRangeFilter(nil), nil, nil) {
+		t.Error("MayContainRange: empty filter contains keys")
+	}
+	if !f.MayContainRange(filter, nil, nil) {
+		t.Error("MayContainRange: unbounded range contains no key")
+	}
+}
+
+func TestTrieRangeFilter_Random(t *testing.T) {
+	f := NewTrieRangeFilter()
+	rnd := rand.New(rand.NewSource(0))
+	randKey := func() string {
+		b := make([]byte, 1+rnd.Intn(8))
+		for i := range b {
+			b[i] = "abcd"[rnd.Intn(4)]
+		}
+		return string(b)
+	}
+	for n := 1; n < 1000; n = nextN(n) {
+		keys := make([]string, n)
+		for i := range keys {
+			keys[i] = randKey()
+		}
+		sort.Strings(keys)
+		filter := buildRangeFilter(keys)
+
+		var empty, falsePositive int
+		for i := 0; i < 1000; i++ {
+			start, limit := randKey(), randKey()
+			if start > limit {
+				start, limit = limit, start
+			}
+			j := sort.SearchStrings(keys, start)
+			want := j < len(keys) && keys[j] < limit
+			got := f.MayContainRange(filter, []byte(start), []byte(limit))
+			if want && !got {
+				t.Fatalf("n=%d: MayContainRange(%q, %q): got false negative", n, start, limit)
+			}
+			if !want {
+				empty++
+				if got {
+					falsePositive++
+				}
+			}
+		}
+		if empty > 100 && falsePositive > empty/2 {
+			t.Errorf("n=%d: false positive rate is more than 50%%, got %d/%d", n, falsePositive, empty)
+		}
+	}
+}
+
+func TestTrieRangeFilter_Size(t *testing.T) {
+	var keys []string
+	var keysLen int
+	for i := 0; i < 10000; i++ {
+		keys = append(keys, fmt.Sprintf("user%08d-some-long-suffix", i*7))
+		keysLen += len(keys[i])
+	}
+	filter := buildRangeFilter(keys)
+	if n := len(filter); n > keysLen/4 {
+		t.Errorf("filter len test failed, '%d' > '%d'", n, keysLen/4)
+	}
+	if !bytes.Equal(buildRangeFilter(keys), filter) {
+		t.Error("filter isn't deterministic")
+	}
+}
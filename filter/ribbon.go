@@ -0,0 +1,243 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+const (
+	// Width in bits of the coefficient rows of the ribbon filter.
+	ribbonWidth = 64
+
+	// Length of the ribbon filter trailer, which is the number of starts
+	// (4-bytes), the number of result bits (1-byte) and the seed (1-byte).
+	ribbonTrailerLen = 6
+
+	// Maximum number of seeds tried before giving up on building the
+	// filter.
+	ribbonMaxSeeds = 32
+)
+
+// Mixes the bits of x, see splitmix64.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Returns the start, coefficient row and result of the key hash under the
+// given seed. The coefficient row always has its lowest bit set.
+func ribbonEquation(h uint64, seed uint8, nStarts uint32) (start uint32, coeff uint64, result uint32) {
+	x := mix64(h ^ uint64(seed)*0x9e3779b97f4a7c15)
+	start = uint32((uint64(uint32(x>>32)) * uint64(nStarts)) >> 32)
+	result = uint32(x)
+	coeff = mix64(x) | 1
+	return
+}
+
+type ribbonFilter uint8
+
+// Name: The ribbon filter serializes its parameters and is backward
+// compatible with respect to them. Therefor, its parameters are not added to
+// its name.
+func (ribbonFilter) Name() string {
+	return "leveldb.RibbonFilter"
+}
+
+func (f ribbonFilter) Contains(filter, key []byte) bool {
+	n := len(filter) - ribbonTrailerLen
+	if n < 0 {
+		return false
+	}
+	trailer := filter[n:]
+	nStarts := binary.LittleEndian.Uint32(trailer)
+	r := int(trailer[4])
+	if r == 0 || r > 32 {
+		// Zero if the filter couldn't be built, the rest are reserved for
+		// potentially new encodings. Consider it a match.
+		return true
+	}
+	if nStarts == 0 {
+		return false
+	}
+	nWords := ribbonWords(nStarts)
+	if n != r*nWords*8 {
+		return true
+	}
+
+	start, coeff, result := ribbonEquation(hash64(key), trailer[5], nStarts)
+	for j := 0; j < r; j++ {
+		column := filter[j*nWords*8:]
+		w, off := int(start/64), start%64
+		x := binary.LittleEndian.Uint64(column[w*8:]) >> off
+		if off > 0 {
+			x |= binary.LittleEndian.Uint64(column[(w+1)*8:]) << (64 - off)
+		}
+		if uint32(bits.OnesCount64(x&coeff)&1) != (result>>j)&1 {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the number of 64-bit words of each solution column.
+func ribbonWords(nStarts uint32) int {
+	// The slots span the starts plus the coefficient width, with one more
+	// word so that any start can be read as a whole word.
+	return int((nStarts+ribbonWidth-1+63)/64) + 1
+}
+
+func (f ribbonFilter) NewGenerator() FilterGenerator {
+	return &ribbonFilterGenerator{r: uint8(f)}
+}
+
+type ribbonFilterGenerator struct {
+	r uint8
+
+	keyHashes []uint64
+
+	// Banding storage, reused across filters.
+	coeffs  []uint64
+	results []uint32
+}
+
+func (g *ribbonFilterGenerator) Add(key []byte) {
+	g.keyHashes = append(g.keyHashes, hash64(key))
+}
+
+// Solves the system of the key equations, returns false if the equations
+// are inconsistent.
+func (g *ribbonFilterGenerator) band(seed uint8, nStarts uint32) bool {
+	nSlots := int(nStarts) + ribbonWidth - 1
+	if cap(g.coeffs) < nSlots {
+		g.coeffs = make([]uint64, nSlots)
+		g.results = make([]uint32, nSlots)
+	}
+	g.coeffs, g.results = g.coeffs[:nSlots], g.results[:nSlots]
+	for i := range g.coeffs {
+		g.coeffs[i] = 0
+		g.results[i] = 0
+	}
+	for _, h := range g.keyHashes {
+		start, coeff, result := ribbonEquation(h, seed, nStarts)
+		i := int(start)
+		for {
+			if g.coeffs[i] == 0 {
+				g.coeffs[i] = coeff
+				g.results[i] = result
+				break
+			}
+			coeff ^= g.coeffs[i]
+			result ^= g.results[i]
+			if coeff == 0 {
+				if result != 0 {
+					return false
+				}
+				// Duplicate or redundant equation.
+				break
+			}
+			tz := bits.TrailingZeros64(coeff)
+			coeff >>= uint(tz)
+			i += tz
+		}
+	}
+	return true
+}
+
+func (g *ribbonFilterGenerator) Generate(b Buffer) {
+	defer func() {
+		g.keyHashes = g.keyHashes[:0]
+	}()
+
+	n := len(g.keyHashes)
+	if n == 0 {
+		trailer := b.Alloc(ribbonTrailerLen)
+		binary.LittleEndian.PutUint32(trailer, 0)
+		trailer[4], trailer[5] = g.r, 0
+		return
+	}
+
+	// The equations are likely solvable given enough more slots than keys,
+	// which takes relatively more slots for more keys. Grow by ~1% per
+	// failed seed.
+	eps := 0.02*math.Log10(float64(n)) - 0.01
+	if eps < 0.03 {
+		eps = 0.03
+	}
+	nStarts := uint32(float64(n)*(1+eps)) + 1
+	for seed := uint8(0); seed < ribbonMaxSeeds; seed++ {
+		if seed > 0 {
+			nStarts += nStarts/100 + 1
+		}
+		if g.band(seed, nStarts) {
+			g.solve(b, seed, nStarts)
+			return
+		}
+	}
+
+	// Give up, the filter matches everything.
+	trailer := b.Alloc(ribbonTrailerLen)
+	binary.LittleEndian.PutUint32(trailer, 0)
+	trailer[4], trailer[5] = 0, 0
+}
+
+// Back-substitutes the banded equations, and writes the solution as one
+// bit column per result bit.
+func (g *ribbonFilterGenerator) solve(b Buffer, seed uint8, nStarts uint32) {
+	r := int(g.r)
+	nWords := ribbonWords(nStarts)
+	dest := b.Alloc(r*nWords*8 + ribbonTrailerLen)
+	// Alloc doesn't clear reused memory.
+	for i := range dest {
+		dest[i] = 0
+	}
+	var state [32]uint64
+	for i := len(g.coeffs) - 1; i >= 0; i-- {
+		coeff := g.coeffs[i]
+		for j := 0; j < r; j++ {
+			// The state holds the solution of the following slots.
+			var bit uint64
+			if coeff != 0 {
+				bit = uint64(bits.OnesCount64((coeff>>1)&state[j])&1) ^ uint64((g.results[i]>>j)&1)
+			}
+			state[j] = state[j]<<1 | bit
+			if bit != 0 {
+				dest[j*nWords*8+i/8] |= 1 << (i % 8)
+			}
+		}
+	}
+	trailer := dest[r*nWords*8:]
+	binary.LittleEndian.PutUint32(trailer, nStarts)
+	trailer[4], trailer[5] = g.r, seed
+}
+
+// NewRibbonFilter creates a new initialized ribbon filter for the given
+// false positive rate, which is rounded down to a power of two.
+//
+// The ribbon filter solves a linear system over the key hashes, and takes
+// about 1.1*log2(1/fpRate) bits per key, which is 20-30% less than a bloom
+// filter of the same false positive rate, at the cost of more CPU to
+// generate. The false positive rate is persisted for each serialization, so
+// changing it is backward compatible.
+func NewRibbonFilter(fpRate float64) Filter {
+	r := 1
+	if fpRate > 0 && fpRate < 1 {
+		r = int(math.Ceil(-math.Log2(fpRate)))
+	} else if fpRate <= 0 {
+		r = 32
+	}
+	if r > 32 {
+		r = 32
+	}
+	return ribbonFilter(r)
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"testing"
+)
+
+func TestRibbonFilter_Small(t *testing.T) {
+	h := newFilterHarness(t, NewRibbonFilter(0.01))
+	h.build()
+	h.assert([]byte("hello"), false, false)
+	h.add([]byte("hello"))
+	h.add([]byte("world"))
+	h.add([]byte("hello"))
+	h.build()
+	h.assert([]byte("hello"), true, false)
+	h.assert([]byte("world"), true, false)
+	h.assert([]byte("x"), false, false)
+	h.assert([]byte("foo"), false, false)
+}
+
+func TestRibbonFilter_VaryingLengths(t *testing.T) {
+	h := newFilterHarness(t, NewRibbonFilter(0.01))
+	for n := 1; n < 100000; n = nextN(n) {
+		h.reset()
+		for i := 0; i < n; i++ {
+			h.addNum(uint32(i))
+		}
+		h.build()
+
+		// Less than 8 bits per key, against 10 bits per key of the bloom
+		// filter of about the same false positive rate.
+		got := h.filterLen()
+		want := n*7*112/100/8 + 7*3*8 + ribbonTrailerLen
+		if got > want {
+			t.Errorf("filter len test failed, '%d' > '%d'", got, want)
+		}
+
+		for i := 0; i < n; i++ {
+			h.assertNum(uint32(i), true, false)
+		}
+
+		var rate float32
+		for i := 0; i < 10000; i++ {
+			if h.assertNum(uint32(i+1000000000), true, true) {
+				rate++
+			}
+		}
+		rate /= 10000
+		if rate > 0.02 {
+			t.Errorf("false positive rate is more than 2%%, got %v, at len %d", rate, n)
+		}
+	}
+}
+
+func TestRibbonFilter_Params(t *testing.T) {
+	for _, x := range []struct {
+		fpRate float64
+		r      int
+	}{{0.5, 1}, {0.01, 7}, {1.0 / 1024, 10}, {0.001, 10}, {0, 32}, {2, 1}} {
+		if got := int(NewRibbonFilter(x.fpRate).(ribbonFilter)); got != x.r {
+			t.Errorf("NewRibbonFilter(%v): got %d result bits, want %d", x.fpRate, got, x.r)
+		}
+	}
+}
@@ -5,8 +5,10 @@ go 1.20
 require (
 	github.com/goccy/go-reflect v1.2.0
 	github.com/golang/snappy v0.0.4
+	github.com/klauspost/compress v1.17.9
 	github.com/onsi/ginkgo/v2 v2.9.2
 	github.com/onsi/gomega v1.27.6
+	github.com/pierrec/lz4/v4 v4.1.18
 	github.com/stretchr/testify v1.8.2
 )
 
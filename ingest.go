@@ -0,0 +1,386 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/3JoB/goleveldb/opt"
+	"github.com/3JoB/goleveldb/storage"
+	"github.com/3JoB/goleveldb/table"
+)
+
+// SSTWriter writes a sorted table file, which can then be loaded into a DB
+// using IngestExternalFiles. Records must be added in strictly increasing
+// key order, as defined by the comparer of the given options; the comparer
+// must be the same as the comparer of the DB the file is ingested into.
+//
+// The records are written with a zero sequence number; the sequence number
+// is assigned when the file is ingested.
+type SSTWriter struct {
+	icmp *iComparer
+	tw   *table.Writer
+
+	ikScratch []byte
+	last      []byte
+	err       error
+}
+
+// NewSSTWriter creates a new SSTWriter which writes the table into w. The
+// block size, compression and filter of the table are taken from o.
+func NewSSTWriter(w io.Writer, o *opt.Options) *SSTWriter {
+	icmp := &iComparer{ucmp: o.GetComparer()}
+	no := dupOptions(o)
+	no.Comparer = icmp
+	if filter := o.GetFilter(); filter != nil {
+		no.Filter = &iFilter{Filter: filter}
+	}
+	return &SSTWriter{
+		icmp: icmp,
+		tw:   table.NewWriter(w, no, nil),
+	}
+}
+
+func (w *SSTWriter) append(kt keyType, key, value []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.last != nil && w.icmp.uCompare(key, w.last) <= 0 {
+		return ErrSSTKeyOrder
+	}
+	w.ikScratch = makeInternalKey(w.ikScratch, key, 0, kt)
+	if err := w.tw.Append(w.ikScratch, value); err != nil {
+		w.err = err
+		return err
+	}
+	w.last = append(w.last[:0], key...)
+	return nil
+}
+
+// Put appends the given key/value pair to the table. The key must be
+// greater than the previously added key.
+//
+// It is safe to modify the contents of the arguments after Put returns.
+func (w *SSTWriter) Put(key, value []byte) error {
+	return w.append(keyTypeVal, key, value)
+}
+
+// Delete appends a deletion marker of the given key to the table. The key
+// must be greater than the previously added key.
+//
+// It is safe to modify the contents of the arguments after Delete returns.
+func (w *SSTWriter) Delete(key []byte) error {
+	return w.append(keyTypeDel, key, nil)
+}
+
+// EntriesLen returns the number of records added so far.
+func (w *SSTWriter) EntriesLen() int {
+	return w.tw.EntriesLen()
+}
+
+// Close finalizes the table. The underlying writer is not closed.
+//
+// Put and Delete should not be called after the table has been closed.
+func (w *SSTWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.tw.Close()
+	if w.err == nil {
+		w.err = ErrSSTClosed
+		return nil
+	}
+	return w.err
+}
+
+// externalFile is a table file being ingested.
+type externalFile struct {
+	path       string
+	r          *table.Reader
+	umin, umax []byte
+	level      int
+}
+
+func (ef *externalFile) errorf(format string, v ...any) error {
+	return fmt.Errorf("leveldb: external file %s: %s", ef.path, fmt.Sprintf(format, v...))
+}
+
+// Opens the external file and reads its key range.
+func (db *DB) openExternalFile(path string) (ef *externalFile, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	o := *db.s.o.Options
+	o.Strict = opt.StrictAll
+	r, err := table.NewReader(f, fi.Size(), storage.FileDesc{}, nil, db.s.tops.blockBuffer, &o, nil, nil)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	ef = &externalFile{path: path, r: r}
+	defer func() {
+		if err != nil {
+			r.Release()
+		}
+	}()
+
+	rdIter := r.NewRangeDelIterator(nil)
+	hasRangeDel := rdIter.Next()
+	rdIter.Release()
+	if err := rdIter.Error(); err != nil {
+		return nil, ef.errorf("%v", err)
+	}
+	if hasRangeDel {
+		return nil, ef.errorf("range tombstones are not supported")
+	}
+
+	iter := r.NewIterator(nil, nil)
+	defer iter.Release()
+	if !iter.First() {
+		if err := iter.Error(); err != nil {
+			return nil, ef.errorf("%v", err)
+		}
+		return nil, ef.errorf("empty table")
+	}
+	ef.umin = append([]byte(nil), internalKey(iter.Key()).ukey()...)
+	if !iter.Last() {
+		return nil, ef.errorf("%v", iter.Error())
+	}
+	ef.umax = append([]byte(nil), internalKey(iter.Key()).ukey()...)
+	return ef, nil
+}
+
+// Writes the records of the external file into a new table of the DB, with
+// the given sequence number. The records are validated while being copied.
+func (db *DB) copyExternalFile(ef *externalFile, seq uint64) (t *tFile, n int, err error) {
+	w, err := db.s.tops.create(ef.level)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if err != nil {
+			if derr := w.drop(); derr != nil {
+				err = fmt.Errorf("error copyExternalFile (%v); error dropping (%v)", err, derr)
+			}
+		}
+	}()
+
+	var (
+		iter  = ef.r.NewIterator(nil, nil)
+		last  []byte
+		ikey  []byte
+		icmp  = db.s.icmp
+		nKeys int
+	)
+	defer iter.Release()
+	for iter.Next() {
+		ukey, kseq, kt, kerr := parseInternalKey(iter.Key())
+		if kerr != nil {
+			return nil, 0, ef.errorf("%v", kerr)
+		}
+		if kseq != 0 || (kt != keyTypeVal && kt != keyTypeDel) {
+			return nil, 0, ef.errorf("invalid record %q", iter.Key())
+		}
+		if last != nil && icmp.uCompare(ukey, last) <= 0 {
+			return nil, 0, ef.errorf("keys are not in increasing order")
+		}
+		last = append(last[:0], ukey...)
+		ikey = makeInternalKey(ikey, ukey, seq, kt)
+		if err := w.append(ikey, iter.Value()); err != nil {
+			return nil, 0, err
+		}
+		nKeys++
+	}
+	if err := iter.Error(); err != nil {
+		return nil, 0, ef.errorf("%v", err)
+	}
+	t, err = w.finish()
+	return t, nKeys, err
+}
+
+// Picks the level of a table ingested into the DB, holding user keys from
+// umin to umax. Keys found in a level make later levels irrelevant, so the
+// table is placed in the deepest level such that it doesn't overlap any
+// table of the levels down to it. Level-0 tables are ordered by sequence
+// number, so the table is placed in level-0 if it overlaps level-0.
+func (v *version) pickIngestLevel(umin, umax []byte) (level int) {
+	if len(v.levels) == 0 || v.levels[0].overlaps(v.s.icmp, umin, umax, true) {
+		return 0
+	}
+	for ; level+1 < len(v.levels); level++ {
+		if v.levels[level+1].overlaps(v.s.icmp, umin, umax, false) {
+			break
+		}
+	}
+	return
+}
+
+// IngestExternalFiles loads the given table files, written using
+// SSTWriter, into the DB. The records of the files are assigned a single
+// sequence number, newer than any record of the DB, and the files are
+// added to the DB atomically. The files must not overlap each other.
+//
+// Each file is copied into a new table of the DB, placed in the deepest
+// level that doesn't overlap existing data; the given files are left
+// untouched. The memdb is flushed beforehand, and writes and table
+// compaction are blocked until the ingestion is done.
+//
+// Ingesting isn't supported in TTL mode, since the ingested values carry no
+// expiry time; ErrIngestTTL is returned.
+func (db *DB) IngestExternalFiles(paths []string) error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	if db.ttlEnabled() {
+		return ErrIngestTTL
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	efs := make([]*externalFile, 0, len(paths))
+	defer func() {
+		for _, ef := range efs {
+			ef.r.Release()
+		}
+	}()
+	for _, path := range paths {
+		ef, err := db.openExternalFile(path)
+		if err != nil {
+			return err
+		}
+		efs = append(efs, ef)
+	}
+	icmp := db.s.icmp
+	sort.Slice(efs, func(i, j int) bool {
+		return icmp.uCompare(efs[i].umin, efs[j].umin) < 0
+	})
+	for i := 1; i < len(efs); i++ {
+		if icmp.uCompare(efs[i-1].umax, efs[i].umin) >= 0 {
+			return ErrIngestOverlap
+		}
+	}
+
+	// Hold write lock, so that no record newer than the ingested one is
+	// written meanwhile.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+	defer func() { <-db.writeLockC }()
+
+	// Flush memdbs, so that the ingested records aren't shadowed by older
+	// ones and the journal doesn't hold records older than the ingested
+	// ones.
+	if db.hasUnflushedMem() {
+		if _, err := db.rotateMem(0, true); err != nil {
+			return err
+		}
+	} else if err := db.compTriggerWait(db.mcompCmdC); err != nil {
+		// Wait for pending memdb compaction.
+		return err
+	}
+
+	// Pause table compaction, so that the picked levels stay valid until
+	// commit.
+	resumeC := make(chan struct{})
+	select {
+	case db.tcompPauseC <- (chan<- struct{})(resumeC):
+	case err := <-db.compPerErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+	defer func() {
+		select {
+		case <-resumeC:
+			close(resumeC)
+		case <-db.closeC:
+		}
+	}()
+
+	v := db.s.version()
+	for _, ef := range efs {
+		ef.level = v.pickIngestLevel(ef.umin, ef.umax)
+	}
+	v.release()
+
+	var (
+		rec      = &sessionRecord{}
+		seq      = db.seq + 1
+		stats    = make([]cStatStaging, len(efs))
+		duration time.Duration
+	)
+	for i, ef := range efs {
+		stats[i].startTimer()
+		t, n, err := db.copyExternalFile(ef, seq)
+		stats[i].stopTimer()
+		if err != nil {
+			for _, r := range rec.addedTables {
+				db.logf("ingest@revert @%d", r.num)
+				db.s.tops.remove(storage.FileDesc{Type: storage.TypeTable, Num: r.num})
+			}
+			return err
+		}
+		rec.addTableFile(ef.level, t)
+		stats[i].write += t.size
+		duration += stats[i].duration
+		db.logf("ingest@copy L%d@%d N·%d S·%s %q:%q", ef.level, t.fd.Num, n, shortenb(t.size), t.imin, t.imax)
+	}
+
+	rec.setSeqNum(seq)
+	db.compCommitLk.Lock()
+	err := db.s.commit(rec, false)
+	if err == nil {
+		db.setSeq(seq)
+		for i, ef := range efs {
+			db.compStats.addStat(ef.level, &stats[i])
+		}
+	}
+	db.compCommitLk.Unlock()
+	if err != nil {
+		for _, r := range rec.addedTables {
+			db.logf("ingest@revert @%d", r.num)
+			db.s.tops.remove(storage.FileDesc{Type: storage.TypeTable, Num: r.num})
+		}
+		return err
+	}
+	db.logf("ingest@commit F·%d Q·%d T·%v", len(rec.addedTables), seq, duration)
+
+	// Trigger table auto-compaction.
+	db.compTrigger(db.tcompCmdC)
+	return nil
+}
+
+// Returns true if the memdb or a column family memdb isn't empty; need
+// write lock.
+func (db *DB) hasUnflushedMem() bool {
+	if db.mem != nil && (db.mem.Len() != 0 || db.mem.rdel.Len() != 0) {
+		return true
+	}
+	db.cfMu.RLock()
+	defer db.cfMu.RUnlock()
+	for _, cf := range db.cfs {
+		if cf.mem != nil && cf.mem.Len() != 0 {
+			return true
+		}
+	}
+	return false
+}
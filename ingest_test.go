@@ -0,0 +1,173 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/3JoB/goleveldb/opt"
+	"github.com/3JoB/goleveldb/util"
+)
+
+// Writes an external file with the given records; a nil value is written
+// as deletion.
+func writeSST(t *testing.T, path string, o *opt.Options, kvs ...string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal("Create: got error: ", err)
+	}
+	defer f.Close()
+	w := NewSSTWriter(f, o)
+	for i := 0; i < len(kvs); i += 2 {
+		if kvs[i+1] == "" {
+			err = w.Delete([]byte(kvs[i]))
+		} else {
+			err = w.Put([]byte(kvs[i]), []byte(kvs[i+1]))
+		}
+		if err != nil {
+			t.Fatal("SSTWriter: got error: ", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("SSTWriter.Close: got error: ", err)
+	}
+}
+
+func TestSSTWriter_KeyOrder(t *testing.T) {
+	w := NewSSTWriter(new(util.Buffer), nil)
+	if err := w.Put([]byte("b"), []byte("1")); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := w.Put([]byte(key), []byte("2")); err != ErrSSTKeyOrder {
+			t.Errorf("Put(%q): expect ErrSSTKeyOrder, got %v", key, err)
+		}
+	}
+	if err := w.Delete([]byte("c")); err != nil {
+		t.Fatal("Delete: got error: ", err)
+	}
+	if n := w.EntriesLen(); n != 2 {
+		t.Errorf("EntriesLen: got %d, want 2", n)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("Close: got error: ", err)
+	}
+	if err := w.Put([]byte("d"), []byte("3")); err != ErrSSTClosed {
+		t.Errorf("Put: expect ErrSSTClosed, got %v", err)
+	}
+}
+
+func TestDB_IngestExternalFiles(t *testing.T) {
+	temp := checkpointDir(t)
+	defer os.RemoveAll(temp)
+
+	dir := filepath.Join(temp, "db")
+	db, err := OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer func() { db.Close() }()
+
+	for i := 0; i < 10; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("k%d", i)), []byte("v1"), nil); err != nil {
+			t.Fatal("Put: got error: ", err)
+		}
+	}
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	// Unflushed records are shadowed by the ingested ones.
+	if err := db.Put([]byte("k2"), []byte("v2"), nil); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+
+	sst1 := filepath.Join(temp, "1.sst")
+	sst2 := filepath.Join(temp, "2.sst")
+	writeSST(t, sst1, nil, "k1", "", "k2", "i1", "k3", "i1")
+	writeSST(t, sst2, nil, "x1", "i2", "x2", "i2")
+	seq := db.getSeq()
+	var written [8]int64
+	for level := range written {
+		_, _, written[level] = db.compStats.getStat(level)
+	}
+	if err := db.IngestExternalFiles([]string{sst2, sst1}); err != nil {
+		t.Fatal("IngestExternalFiles: got error: ", err)
+	}
+	if got := db.getSeq(); got != seq+1 {
+		t.Errorf("seq: got %d, want %d", got, seq+1)
+	}
+
+	check := func(want map[string]string) {
+		t.Helper()
+		for k, v := range want {
+			got, err := db.Get([]byte(k), nil)
+			if v == "" {
+				if err != ErrNotFound {
+					t.Errorf("Get(%q): expect ErrNotFound, got (%q, %v)", k, got, err)
+				}
+			} else if err != nil || string(got) != v {
+				t.Errorf("Get(%q): got (%q, %v), want %q", k, got, err, v)
+			}
+		}
+	}
+	want := map[string]string{"k0": "v1", "k1": "", "k2": "i1", "k3": "i1", "k4": "v1", "x1": "i2", "x2": "i2"}
+	check(want)
+
+	// The file not overlapping any table is placed in the deepest level.
+	v := db.s.version()
+	if n := len(v.levels); n < 2 || v.levels[n-1].size() == 0 {
+		t.Fatalf("expect tables at deeper level, got %v", v.levels)
+	}
+	var found bool
+	for _, tf := range v.levels[len(v.levels)-1] {
+		if string(tf.imin.ukey()) == "x1" {
+			found = true
+		}
+	}
+	// The stats of each file are recorded at its level; level-0 stats also
+	// hold the memdb flush.
+	for level := 1; level < len(v.levels); level++ {
+		var size int64
+		for _, tf := range v.levels[level] {
+			if s, _ := tf.imin.parseNum(); s == seq+1 {
+				size += tf.size
+			}
+		}
+		if _, _, w := db.compStats.getStat(level); w-written[level] != size {
+			t.Errorf("L%d stats: got %d bytes written, want %d", level, w-written[level], size)
+		}
+	}
+	v.release()
+	if !found {
+		t.Error("ingested table isn't placed in the deepest level")
+	}
+
+	// Overlapping files are rejected.
+	writeSST(t, sst2, nil, "k3", "o", "k9", "o")
+	if err := db.IngestExternalFiles([]string{sst1, sst2}); err != ErrIngestOverlap {
+		t.Errorf("IngestExternalFiles: expect ErrIngestOverlap, got %v", err)
+	}
+	check(want)
+
+	// Records written after ingestion are newer, also after reopen.
+	db.Close()
+	if db, err = OpenFile(dir, nil); err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	check(want)
+	if err := db.Put([]byte("k3"), []byte("v3"), nil); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	want["k3"] = "v3"
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	check(want)
+}
@@ -36,6 +36,10 @@ func (kt keyType) String() string {
 		return "d"
 	case keyTypeVal:
 		return "v"
+	case keyTypeRangeDel:
+		return "r"
+	case keyTypeMerge:
+		return "m"
 	}
 	return fmt.Sprintf("<invalid:%#x>", uint(kt))
 }
@@ -43,16 +47,30 @@ func (kt keyType) String() string {
 // Value types encoded as the last component of internal keys.
 // Don't modify; this value are saved to disk.
 const (
-	keyTypeDel = keyType(0)
-	keyTypeVal = keyType(1)
+	keyTypeDel      = keyType(0)
+	keyTypeVal      = keyType(1)
+	keyTypeRangeDel = keyType(2)
+	keyTypeMerge    = keyType(3)
 )
 
+// keyTypeMax is the highest-numbered valid keyType.
+const keyTypeMax = keyTypeMerge
+
+// hasValue returns whether a record of the given type carries a value. The
+// value of a range tombstone is its limit, the value of a merge record is
+// its operand.
+func (kt keyType) hasValue() bool {
+	return kt != keyTypeDel
+}
+
 // keyTypeSeek defines the keyType that should be passed when constructing an
 // internal key for seeking to a particular sequence number (since we
 // sort sequence numbers in decreasing order and the value type is
 // embedded as the low 8 bits in the sequence number in internal keys,
 // we need to use the highest-numbered ValueType, not the lowest).
-const keyTypeSeek = keyTypeVal
+// Range tombstones never share key space with point entries, so they
+// don't need to be considered here.
+const keyTypeSeek = keyTypeMerge
 
 const (
 	// Maximum value possible for sequence number; the 8-bits are
@@ -76,7 +94,7 @@ type internalKey []byte
 func makeInternalKey(dst, ukey []byte, seq uint64, kt keyType) internalKey {
 	if seq > keyMaxSeq {
 		panic("leveldb: invalid sequence number")
-	} else if kt > keyTypeVal {
+	} else if kt > keyTypeMax {
 		panic("leveldb: invalid type")
 	}
 
@@ -92,7 +110,7 @@ func parseInternalKey(ik []byte) (ukey []byte, seq uint64, kt keyType, err error
 	}
 	num := binary.LittleEndian.Uint64(ik[len(ik)-8:])
 	seq, kt = num>>8, keyType(num&0xff)
-	if kt > keyTypeVal {
+	if kt > keyTypeMax {
 		return nil, 0, 0, newErrInternalKeyCorrupted(ik, "invalid type")
 	}
 	ukey = ik[:len(ik)-8]
@@ -126,7 +144,7 @@ func (ik internalKey) num() uint64 {
 func (ik internalKey) parseNum() (seq uint64, kt keyType) {
 	num := ik.num()
 	seq, kt = num>>8, keyType(num&0xff)
-	if kt > keyTypeVal {
+	if kt > keyTypeMax {
 		panic(fmt.Sprintf("leveldb: internal key %q, len=%d: invalid type %#x", []byte(ik), len(ik), kt))
 	}
 	return
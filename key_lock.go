@@ -0,0 +1,157 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const keyLockStripes = 16
+
+// keyLock is an exclusive lock of a key, held by a transaction.
+type keyLock struct {
+	owner    uint64
+	released chan struct{} // closed once the lock is released
+}
+
+type keyLockStripe struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyWait is an edge of the wait-for graph: a transaction waits for the
+// owner of the lock of key.
+type keyWait struct {
+	owner uint64
+	key   string
+}
+
+// keyLockManager manages the key locks of the pessimistic transactions.
+// Locks are striped by key hash; waits are recorded in a wait-for graph,
+// which is checked for cycles before waiting.
+type keyLockManager struct {
+	stripes [keyLockStripes]keyLockStripe
+	lastID  uint64
+
+	waitMu  sync.Mutex
+	waitFor map[uint64]keyWait
+}
+
+func newKeyLockManager() *keyLockManager {
+	m := &keyLockManager{
+		waitFor: make(map[uint64]keyWait),
+	}
+	for i := range m.stripes {
+		m.stripes[i].locks = make(map[string]*keyLock)
+	}
+	return m
+}
+
+// Returns a new transaction id.
+func (m *keyLockManager) newID() uint64 {
+	return atomic.AddUint64(&m.lastID, 1)
+}
+
+func (m *keyLockManager) stripe(key string) *keyLockStripe {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &m.stripes[h.Sum32()%keyLockStripes]
+}
+
+// Returns the current owner of the lock of the given key, or zero.
+func (m *keyLockManager) owner(key string) uint64 {
+	s := m.stripe(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l := s.locks[key]; l != nil {
+		return l.owner
+	}
+	return 0
+}
+
+// Records that id waits for owner to release the lock of key. It returns
+// false if waiting would deadlock, that is if owner transitively waits for
+// id.
+func (m *keyLockManager) addWait(id, owner uint64, key string) bool {
+	m.waitMu.Lock()
+	defer m.waitMu.Unlock()
+	for cur := owner; ; {
+		if cur == id {
+			return false
+		}
+		w, ok := m.waitFor[cur]
+		// Ignore stale edges, of a lock released meanwhile.
+		if !ok || m.owner(w.key) != w.owner {
+			break
+		}
+		cur = w.owner
+	}
+	m.waitFor[id] = keyWait{owner: owner, key: key}
+	return true
+}
+
+func (m *keyLockManager) removeWait(id uint64) {
+	m.waitMu.Lock()
+	delete(m.waitFor, id)
+	m.waitMu.Unlock()
+}
+
+// Acquires the lock of the given key for transaction id. It waits up to
+// timeout for the lock to be released, or indefinitely if timeout is
+// negative. It is a noop if the lock is already held by id.
+func (m *keyLockManager) lock(id uint64, key []byte, timeout time.Duration, closeC <-chan struct{}) error {
+	var timeoutC <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+	skey := string(key)
+	s := m.stripe(skey)
+	for {
+		s.mu.Lock()
+		l := s.locks[skey]
+		if l == nil {
+			s.locks[skey] = &keyLock{owner: id, released: make(chan struct{})}
+			s.mu.Unlock()
+			return nil
+		}
+		owner, released := l.owner, l.released
+		s.mu.Unlock()
+		if owner == id {
+			return nil
+		}
+
+		if !m.addWait(id, owner, skey) {
+			return ErrDeadlock
+		}
+		select {
+		case <-released:
+		case <-timeoutC:
+			m.removeWait(id)
+			return ErrLockTimeout
+		case <-closeC:
+			m.removeWait(id)
+			return ErrClosed
+		}
+		m.removeWait(id)
+	}
+}
+
+// Releases the lock of the given key, if held by transaction id.
+func (m *keyLockManager) unlock(id uint64, key string) {
+	s := m.stripe(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l := s.locks[key]; l != nil && l.owner == id {
+		delete(s.locks, key)
+		close(l.released)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"github.com/3JoB/goleveldb/iterator"
+	"github.com/3JoB/goleveldb/opt"
+)
+
+// Merges the given operands, ordered from the oldest to the newest, into the
+// existing value of the given key. The existing value is nil if the key
+// doesn't exist.
+func fullMerge(mo opt.MergeOperator, key, existing []byte, operands [][]byte) ([]byte, error) {
+	if mo == nil {
+		return nil, ErrNoMergeOperator
+	}
+	value, ok := mo.FullMerge(key, existing, operands)
+	if !ok {
+		return nil, ErrMergeFailed
+	}
+	return value, nil
+}
+
+// mergeOperands holds merge operands of a key collected by a point lookup,
+// ordered from the newest to the oldest.
+type mergeOperands [][]byte
+
+// Seeks the given iterator to ikey and collects merge operands of its user
+// key. Entries with sequence number less than rdSeq are deleted by a range
+// tombstone. It returns true once the end of the operand chain is found,
+// along with the value underneath the operands; err is ErrNotFound if the
+// key doesn't exist underneath the operands.
+func (ops *mergeOperands) collect(iter iterator.Iterator, icmp *iComparer, ikey internalKey, rdSeq uint64) (done bool, value []byte, err error) {
+	ukey := ikey.ukey()
+	for ok := iter.Seek(ikey); ok; ok = iter.Next() {
+		fukey, fseq, fkt, kerr := parseInternalKey(iter.Key())
+		if kerr != nil {
+			return true, nil, kerr
+		}
+		if icmp.uCompare(fukey, ukey) != 0 {
+			return false, nil, nil
+		}
+		if fseq < rdSeq {
+			return true, nil, ErrNotFound
+		}
+		switch fkt {
+		case keyTypeMerge:
+			*ops = append(*ops, append([]byte(nil), iter.Value()...))
+		case keyTypeVal:
+			return true, append([]byte(nil), iter.Value()...), nil
+		default:
+			return true, nil, ErrNotFound
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return true, nil, err
+	}
+	return false, nil, nil
+}
+
+// Resolves the collected operands against the value found underneath them.
+// The err is the lookup error of that value; ErrNotFound means the key
+// doesn't exist underneath the operands.
+func (ops mergeOperands) resolve(mo opt.MergeOperator, key, value []byte, err error) ([]byte, error) {
+	if len(ops) == 0 {
+		return value, err
+	}
+	switch err {
+	case nil:
+		if value == nil {
+			value = []byte{}
+		}
+	case ErrNotFound:
+		value = nil
+	default:
+		return nil, err
+	}
+	operands := make([][]byte, len(ops))
+	for i, op := range ops {
+		operands[len(ops)-1-i] = op
+	}
+	return fullMerge(mo, key, value, operands)
+}
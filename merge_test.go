@@ -0,0 +1,205 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/3JoB/goleveldb/opt"
+)
+
+// appendMerger appends operands to the existing value, separated by comma.
+type appendMerger struct {
+	noPartial bool
+}
+
+func (m appendMerger) FullMerge(key, existing []byte, operands [][]byte) ([]byte, bool) {
+	if existing != nil {
+		operands = append([][]byte{existing}, operands...)
+	}
+	return bytes.Join(operands, []byte(",")), true
+}
+
+func (m appendMerger) PartialMerge(key, left, right []byte) ([]byte, bool) {
+	if m.noPartial {
+		return nil, false
+	}
+	return bytes.Join([][]byte{left, right}, []byte(",")), true
+}
+
+func (h *dbHarness) merge(key, operand string) {
+	if err := h.db.Merge([]byte(key), []byte(operand), h.wo); err != nil {
+		h.t.Error("Merge: got error: ", err)
+	}
+}
+
+func TestDB_Merge(t *testing.T) {
+	truno(t, &opt.Options{DisableLargeBatchTransaction: true, MergeOperator: appendMerger{}}, func(h *dbHarness) {
+		h.put("a", "0")
+		h.merge("a", "1")
+		h.merge("b", "1")
+		h.put("c", "0")
+		h.delete("c")
+		h.merge("c", "1")
+		h.put("d", "")
+		h.merge("d", "1")
+		snap := h.getSnapshot()
+		defer snap.Release()
+
+		h.merge("a", "2")
+		h.getVal("a", "0,1,2")
+		h.getVal("b", "1")
+		h.getVal("c", "1")
+		h.getVal("d", ",1")
+		h.getValr(snap, "a", "0,1")
+		h.getKeyVal("(a->0,1,2)(b->1)(c->1)(d->,1)")
+		h.getKeyValReverse("(d->,1)(c->1)(b->1)(a->0,1,2)")
+		if ok, err := h.db.Has([]byte("b"), h.ro); err != nil || !ok {
+			t.Errorf("Has: got (%v, %v)", ok, err)
+		}
+
+		// Operands span the memdb and the tables.
+		h.compactMem()
+		h.merge("a", "3")
+		h.merge("e", "1")
+		h.getVal("a", "0,1,2,3")
+		h.getKeyVal("(a->0,1,2,3)(b->1)(c->1)(d->,1)(e->1)")
+		h.getKeyValReverse("(e->1)(d->,1)(c->1)(b->1)(a->0,1,2,3)")
+		h.getValr(snap, "a", "0,1")
+
+		h.deleteRange("a", "b")
+		h.merge("a", "4")
+		h.getVal("a", "4")
+
+		h.reopenDB()
+		h.getVal("a", "4")
+		h.getKeyVal("(a->4)(b->1)(c->1)(d->,1)(e->1)")
+	})
+}
+
+func TestDB_Merge_Compaction(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		MergeOperator:                appendMerger{},
+	})
+	defer h.close()
+
+	h.put("a", "0")
+	h.put("b", "0")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.compactRangeAt(1, "", "")
+	h.tablesPerLevel("0,0,1")
+
+	h.merge("a", "1")
+	h.merge("a", "2")
+	h.merge("b", "1")
+	snap := h.getSnapshot()
+	h.merge("b", "2")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.tablesPerLevel("0,1,1")
+
+	// Operands are combined using partial merge, unless a snapshot could
+	// see them separately.
+	h.allEntriesFor("a", "[ +1,2, 0 ]")
+	h.allEntriesFor("b", "[ +2, +1, 0 ]")
+	h.getVal("a", "0,1,2")
+	h.getVal("b", "0,1,2")
+	h.getValr(snap, "b", "0,1")
+
+	// Operands are merged into the value underneath them.
+	snap.Release()
+	h.compactRange("", "")
+	h.allEntriesFor("a", "[ 0,1,2 ]")
+	h.allEntriesFor("b", "[ 0,1,2 ]")
+	h.getKeyVal("(a->0,1,2)(b->0,1,2)")
+}
+
+func TestDB_Merge_NoPartialMerge(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		MergeOperator:                appendMerger{noPartial: true},
+	})
+	defer h.close()
+
+	h.put("a", "0")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.compactRangeAt(1, "", "")
+	h.merge("a", "1")
+	h.merge("a", "2")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.allEntriesFor("a", "[ +2, +1, 0 ]")
+	h.getVal("a", "0,1,2")
+
+	// No data underneath the operands.
+	h.merge("b", "1")
+	h.merge("b", "2")
+	h.compactMem()
+	h.compactRange("", "")
+	h.allEntriesFor("b", "[ 1,2 ]")
+	h.getKeyVal("(a->0,1,2)(b->1,2)")
+}
+
+type mergeReplay struct {
+	funcReplay
+	operands []string
+}
+
+func (r *mergeReplay) Merge(key, operand []byte) {
+	r.operands = append(r.operands, string(key)+"="+string(operand))
+}
+
+func TestDB_Merge_Batch(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	if err := h.db.Merge([]byte("a"), []byte("1"), h.wo); err != ErrNoMergeOperator {
+		t.Errorf("Merge: expect ErrNoMergeOperator, got %v", err)
+	}
+	b := new(Batch)
+	b.Put([]byte("a"), []byte("0"))
+	b.Merge([]byte("a"), []byte("1"))
+	if err := h.db.Write(b, h.wo); err != ErrNoMergeOperator {
+		t.Errorf("Write: expect ErrNoMergeOperator, got %v", err)
+	}
+
+	var n int
+	r := &mergeReplay{funcReplay: func() { n++ }}
+	if err := b.Replay(r); err != nil {
+		t.Fatal("Replay: got error: ", err)
+	}
+	if n != 1 || len(r.operands) != 1 || r.operands[0] != "a=1" {
+		t.Errorf("Replay: got %d records and operands %v", n, r.operands)
+	}
+
+	h.o.MergeOperator = appendMerger{}
+	h.reopenDB()
+	h.write(b)
+	h.getVal("a", "0,1")
+
+	// Merge within a transaction.
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		t.Fatal("OpenTransaction: got error: ", err)
+	}
+	b.Reset()
+	b.Merge([]byte("a"), []byte("2"))
+	if err := tr.Write(b, h.wo); err != nil {
+		t.Fatal("Transaction.Write: got error: ", err)
+	}
+	if v, err := tr.Get([]byte("a"), h.ro); err != nil || string(v) != "0,1,2" {
+		t.Errorf("Transaction.Get: got (%q, %v)", v, err)
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal("Transaction.Commit: got error: ", err)
+	}
+	h.getVal("a", "0,1,2")
+}
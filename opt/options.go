@@ -8,7 +8,9 @@
 package opt
 
 import (
+	"fmt"
 	"math"
+	"time"
 
 	"github.com/3JoB/goleveldb/cache"
 	"github.com/3JoB/goleveldb/comparer"
@@ -27,6 +29,7 @@ var (
 	DefaultBlockRestartInterval          = 16
 	DefaultBlockSize                     = 4 * KiB
 	DefaultCompactionExpandLimitFactor   = 25
+	DefaultCompactionFIFOMaxSize         = int64(1 * GiB)
 	DefaultCompactionGPOverlapsFactor    = 10
 	DefaultCompactionL0Trigger           = 4
 	DefaultCompactionSourceLimitFactor   = 1
@@ -34,14 +37,18 @@ var (
 	DefaultCompactionTableSizeMultiplier = 1.0
 	DefaultCompactionTotalSize           = 10 * MiB
 	DefaultCompactionTotalSizeMultiplier = 10.0
+	DefaultCompactionUniversalMaxSizeAmp = 200
+	DefaultCompactionUniversalSizeRatio  = 1
 	DefaultCompressionType               = SnappyCompression
 	DefaultIteratorSamplingRate          = 1 * MiB
 	DefaultOpenFilesCacher               = LRUCacher
+	DefaultTransactionLockTimeout        = time.Second
 	DefaultWriteBuffer                   = 4 * MiB
 	DefaultWriteL0PauseTrigger           = 12
 	DefaultWriteL0SlowdownTrigger        = 8
 	DefaultFilterBaseLg                  = 11
 	DefaultMaxManifestFileSize           = int64(64 * MiB)
+	DefaultMaxSubcompactions             = 1
 )
 
 // Cacher is a caching algorithm.
@@ -105,6 +112,52 @@ var (
 	NoCacher = CacherFunc(nil)
 )
 
+// MergeOperator defines how merge operands, written using Merge, are
+// combined into a value. Operands of a key are resolved when the key is
+// read and, when possible, collapsed during table compaction.
+//
+// The operands and the existing value must not be modified nor retained
+// after the method returns.
+type MergeOperator interface {
+	// FullMerge merges the given operands, ordered from the oldest to the
+	// newest, into the existing value of the key. The existing value is
+	// nil if the key doesn't exist. It returns false if the operands
+	// can't be merged, in which case the read fails.
+	FullMerge(key, existing []byte, operands [][]byte) ([]byte, bool)
+
+	// PartialMerge combines two adjacent operands, left being the older
+	// one, into a single operand. It returns false if the operands can't
+	// be combined without knowing the existing value, in which case both
+	// operands are kept.
+	PartialMerge(key, left, right []byte) ([]byte, bool)
+}
+
+// CompactionDecision is the decision of a CompactionFilter about an entry.
+type CompactionDecision int
+
+const (
+	// CompactionKeep keeps the entry as is.
+	CompactionKeep CompactionDecision = iota
+	// CompactionRemove removes the entry, as if the key were deleted.
+	CompactionRemove
+	// CompactionChangeValue replaces the value of the entry.
+	CompactionChangeValue
+)
+
+// CompactionFilter allows dropping or rewriting entries during table
+// compaction. It is called for the latest value of each key compacted,
+// unless the value is visible to a live snapshot, so that snapshot reads
+// aren't affected. Deletions and merge operands aren't filtered.
+//
+// Filter may be called concurrently. The key and value must not be modified
+// nor retained after the method returns.
+type CompactionFilter interface {
+	// Filter returns the decision about the given entry of the given
+	// level, along with the new value if the decision is
+	// CompactionChangeValue.
+	Filter(level int, key, value []byte) (decision CompactionDecision, newValue []byte)
+}
+
 // Compression is the 'sorted table' block compression algorithm to use.
 type Compression uint
 
@@ -116,15 +169,89 @@ func (c Compression) String() string {
 		return "none"
 	case SnappyCompression:
 		return "snappy"
+	case ZstdCompression:
+		return "zstd"
+	case LZ4Compression:
+		return "lz4"
+	}
+	if blockType, ok := c.Custom(); ok {
+		return fmt.Sprintf("custom(%#x)", blockType)
 	}
 	return "invalid"
 }
 
+// Custom returns the block type of a compression returned by
+// CustomCompression.
+func (c Compression) Custom() (blockType byte, ok bool) {
+	if c&^0xff == customCompression {
+		return byte(c), true
+	}
+	return 0, false
+}
+
+func (c Compression) valid() bool {
+	_, custom := c.Custom()
+	return (c > DefaultCompression && c < nCompression) || custom
+}
+
 const (
 	DefaultCompression Compression = iota
 	NoCompression
 	SnappyCompression
+	ZstdCompression
+	LZ4Compression
 	nCompression
+
+	customCompression Compression = 1 << 8
+)
+
+// CustomCompression returns the compression that uses the compressor
+// registered for the given block type, see table.RegisterCompressor.
+func CustomCompression(blockType byte) Compression {
+	return customCompression | Compression(blockType)
+}
+
+// CompactionStyle is the 'sorted table' compaction style.
+type CompactionStyle int
+
+func (c CompactionStyle) String() string {
+	switch c {
+	case DefaultCompactionStyle:
+		return "default"
+	case LeveledCompaction:
+		return "leveled"
+	case UniversalCompaction:
+		return "universal"
+	case FIFOCompaction:
+		return "fifo"
+	}
+	return "invalid"
+}
+
+const (
+	DefaultCompactionStyle CompactionStyle = iota
+
+	// LeveledCompaction compacts a level into the next one once the level
+	// exceeds its total size, see CompactionTotalSize.
+	LeveledCompaction
+
+	// UniversalCompaction, also known as tiered compaction, treats each
+	// level-0 'sorted table' and each other level as a sorted run, and
+	// merges the newest sorted runs once there are too many of them, see
+	// CompactionL0Trigger. Sorted runs of similar size are merged together,
+	// see CompactionUniversalSizeRatio, and all of them are merged once the
+	// space amplification gets too large, see
+	// CompactionUniversalMaxSizeAmp. This trades read performance and space
+	// for lower write amplification.
+	UniversalCompaction
+
+	// FIFOCompaction keeps 'sorted table' at level-0 and drops the oldest
+	// ones once their total size exceeds CompactionFIFOMaxSize, or once they
+	// get older than CompactionFIFOMaxAge. The newest small ones may also be
+	// merged together, see CompactionFIFOMerge. This suits data that simply
+	// expires, such as time series; writes aren't throttled by the number of
+	// level-0 'sorted table'.
+	FIFOCompaction
 )
 
 // Strict is the DB 'strict level'.
@@ -212,12 +339,53 @@ type Options struct {
 	// The default value is 4KiB.
 	BlockSize int
 
+	// ColumnFamilies defines options of the existing column families, keyed
+	// by column family name. Options of a column family that isn't listed
+	// here default to nil options, in which case the column family comparer
+	// must match the default comparer.
+	// Only 'sorted table', comparer, filter, compaction and write buffer
+	// options are applicable to a column family, the rest are inherited from
+	// the DB options. The block cache and the open files cache of the DB are
+	// shared by its column families.
+	//
+	// The default value is nil.
+	ColumnFamilies map[string]*Options
+
+	// CompactionFilter allows dropping or rewriting entries during table
+	// compaction, see CompactionFilter.
+	//
+	// The default value is nil.
+	CompactionFilter CompactionFilter
+
 	// CompactionExpandLimitFactor limits compaction size after expanded.
 	// This will be multiplied by table size limit at compaction target level.
 	//
 	// The default value is 25.
 	CompactionExpandLimitFactor int
 
+	// CompactionFIFOMaxAge defines the age beyond which a 'sorted table' is
+	// dropped. The age of a 'sorted table' is the one of its newest data,
+	// which is only tracked with FIFOCompaction; 'sorted table' written
+	// with another compaction style are never dropped by age.
+	// This only applies to FIFOCompaction.
+	//
+	// The default value is 0, which disables dropping by age.
+	CompactionFIFOMaxAge time.Duration
+
+	// CompactionFIFOMaxSize defines the total size of 'sorted table' beyond
+	// which the oldest ones are dropped. This only applies to
+	// FIFOCompaction.
+	//
+	// The default value is 1GiB.
+	CompactionFIFOMaxSize int64
+
+	// CompactionFIFOMerge defines whether to merge the newest small level-0
+	// 'sorted table' once there are CompactionL0Trigger of them, up to
+	// CompactionTableSize in total. This only applies to FIFOCompaction.
+	//
+	// The default value is false.
+	CompactionFIFOMerge bool
+
 	// CompactionGPOverlapsFactor limits overlaps in grandparent (Level + 2) that a
 	// single 'sorted table' generates.
 	// This will be multiplied by table size limit at grandparent level.
@@ -226,7 +394,8 @@ type Options struct {
 	CompactionGPOverlapsFactor int
 
 	// CompactionL0Trigger defines number of 'sorted table' at level-0 that will
-	// trigger compaction.
+	// trigger compaction. With UniversalCompaction it is the number of sorted
+	// runs instead.
 	//
 	// The default value is 4.
 	CompactionL0Trigger int
@@ -238,6 +407,13 @@ type Options struct {
 	// The default value is 1.
 	CompactionSourceLimitFactor int
 
+	// CompactionStyle defines the 'sorted table' compaction style. The style
+	// may be changed between DB sessions. 'Seeks triggered compaction' is
+	// disabled with UniversalCompaction and FIFOCompaction.
+	//
+	// The default value is LeveledCompaction.
+	CompactionStyle CompactionStyle
+
 	// CompactionTableSize limits size of 'sorted table' that compaction generates.
 	// The limits for each level will be calculated as:
 	//   CompactionTableSize * (CompactionTableSizeMultiplier ^ Level)
@@ -279,6 +455,22 @@ type Options struct {
 	// The default value is nil.
 	CompactionTotalSizeMultiplierPerLevel []float64
 
+	// CompactionUniversalMaxSizeAmp defines, in percent, the maximum size of
+	// all sorted runs but the oldest relative to the size of the oldest one,
+	// beyond which all sorted runs are merged. This only applies to
+	// UniversalCompaction.
+	//
+	// The default value is 200.
+	CompactionUniversalMaxSizeAmp int
+
+	// CompactionUniversalSizeRatio defines, in percent, how much larger than
+	// the total size of the newer sorted runs picked a sorted run may be to
+	// be merged along with them, a negative value means zero. This only
+	// applies to UniversalCompaction.
+	//
+	// The default value is 1.
+	CompactionUniversalSizeRatio int
+
 	// Comparer defines a total ordering over the space of []byte keys: a 'less
 	// than' relationship. The same comparison algorithm must be used for reads
 	// and writes over the lifetime of the DB.
@@ -287,10 +479,48 @@ type Options struct {
 	Comparer comparer.Comparer
 
 	// Compression defines the 'sorted table' block compression to use.
+	// Use CustomCompression to select a compressor registered using
+	// table.RegisterCompressor.
 	//
 	// The default value (DefaultCompression) uses snappy compression.
 	Compression Compression
 
+	// CompressionDictSize defines the maximum size of the compression
+	// dictionary trained from samples of the compacted entries and stored
+	// in each 'sorted table' built by a table compaction. The samples are
+	// taken from each compacted 'sorted table', in proportion to its size.
+	// It only applies to codecs that support dictionaries, such as zstd.
+	// Use zero to disable compression dictionaries.
+	//
+	// The default value is 0.
+	CompressionDictSize int
+
+	// CompressionLevel defines the compression level of codecs that support
+	// it. For zstd it is the zstd compression level. For lz4 a level between
+	// 1 and 9 selects the high compression variant.
+	// Use zero for the codec default.
+	//
+	// The default value is 0.
+	CompressionLevel int
+
+	// CompressionPerLevel defines per-level 'sorted table' block compression,
+	// e.g. snappy for upper levels and zstd for the bottom levels.
+	// Use DefaultCompression to skip a level, Compression is used instead.
+	//
+	// The default value is nil.
+	CompressionPerLevel []Compression
+
+	// DataBlockHashIndex defines whether to append a hash index to each
+	// data block of a 'sorted table', mapping hashes of the user keys to
+	// the restart points holding them. Point lookups then seek the restart
+	// point directly rather than binary searching the block, at the cost of
+	// about one byte per key. The hash index is omitted for data blocks with
+	// more than 253 restart points.
+	// Tables are readable regardless of this option.
+	//
+	// The default value is false.
+	DataBlockHashIndex bool
+
 	// DisableBufferPool allows disable use of util.BufferPool functionality.
 	//
 	// The default value is false.
@@ -350,6 +580,26 @@ type Options struct {
 	// The default value is nil.
 	Filter filter.Filter
 
+	// FullFilter defines whether to generate one filter for the whole
+	// 'sorted table', rather than one filter per 2^FilterBaseLg bytes of
+	// data blocks. A full filter is smaller, and is consulted before the
+	// index block is read.
+	// Tables are readable regardless of this option, the filter is matched
+	// by name against Filter and AltFilters either way.
+	//
+	// The default value is false.
+	FullFilter bool
+
+	// IndexPartitionSize is the target size in bytes of each index partition
+	// of a 'sorted table'. If positive, the index is partitioned and a
+	// top-level index over the partitions is written instead, and the filter
+	// is split along the partitions. Only the partitions needed are then
+	// loaded and cached, which benefits tables whose index or filter are
+	// large.
+	//
+	// The default value is 0, which disables partitioning.
+	IndexPartitionSize int
+
 	// IteratorSamplingRate defines approximate gap (in bytes) between read
 	// sampling of an iterator. The samples will be used to determine when
 	// compaction should be triggered.
@@ -359,6 +609,36 @@ type Options struct {
 	// The default is 1MiB.
 	IteratorSamplingRate int
 
+	// JournalRetentionSize defines the total size of obsolete journal
+	// files retained for reading the committed batches (see
+	// DB.GetUpdatesSince). The oldest journals are removed first when the
+	// limit is exceeded.
+	// Use zero to not limit the size, the journals are then removed after
+	// JournalRetentionTime; obsolete journals are removed immediately if
+	// both are zero.
+	//
+	// The default value is 0.
+	JournalRetentionSize int
+
+	// JournalRetentionTime defines for how long obsolete journal files are
+	// retained for reading the committed batches (see DB.GetUpdatesSince).
+	// Use zero to not limit the time, the journals are then removed
+	// according to JournalRetentionSize; obsolete journals are removed
+	// immediately if both are zero.
+	// The retention time is checked when a journal becomes obsolete and
+	// when DB.GetUpdatesSince is called.
+	//
+	// The default value is 0.
+	JournalRetentionTime time.Duration
+
+	// MergeOperator defines how merge operands of a key are combined.
+	// A merge operator must be specified in order to use Merge. Records
+	// written using Merge should always be read with the same merge
+	// operator.
+	//
+	// The default value is nil.
+	MergeOperator MergeOperator
+
 	// NoSync allows completely disable fsync.
 	//
 	// The default is false.
@@ -381,6 +661,30 @@ type Options struct {
 	// The default value is 200 on MacOS and 500 on other.
 	OpenFilesCacheCapacity int
 
+	// PrefixExtractor defines the prefix extractor to use along with the
+	// 'effective filter'. If defined, prefixes of keys are added to the
+	// filter block too, so that iterating keys with a given prefix, e.g.
+	// using util.BytesPrefix, may skip tables and blocks whose filter
+	// excludes the prefix. The extractor name will be stored on disk.
+	//
+	// Prefix iteration assumes that keys sharing a prefix are contiguous,
+	// as with the default comparer.
+	//
+	// The default value is nil.
+	PrefixExtractor filter.PrefixExtractor
+
+	// RangeFilter defines a range filter to use. If defined, a range filter
+	// block is generated for each 'sorted table', so that iterating a range
+	// of keys may skip tables with no key within the range. The filter name
+	// will be stored on disk, tables written with another range filter are
+	// never skipped.
+	//
+	// Range filters assume keys are ordered bytewise, as with the default
+	// comparer.
+	//
+	// The default value is nil.
+	RangeFilter filter.RangeFilter
+
 	// If true then opens DB in read-only mode.
 	//
 	// The default value is false.
@@ -389,6 +693,19 @@ type Options struct {
 	// Strict defines the DB strict level.
 	Strict Strict
 
+	// TTL enables the TTL mode, where entries of the default column family
+	// expire. Entries written without their own TTL, see WriteOptions.TTL,
+	// expire after TTL. Use negative value to enable the TTL mode without
+	// default expiry.
+	//
+	// In TTL mode the expiry time is stored along with the value; expired
+	// entries are hidden from reads and purged during table compaction.
+	// A DB written in TTL mode must always be opened in TTL mode. Merge
+	// isn't supported in TTL mode.
+	//
+	// The default value is 0, which disables the TTL mode.
+	TTL time.Duration
+
 	// WriteBuffer defines maximum size of a 'memdb' before flushed to
 	// 'sorted table'. 'memdb' is an in-memory DB backed by an on-disk
 	// unsorted journal.
@@ -421,6 +738,16 @@ type Options struct {
 	//
 	// The default value is 64 MiB.
 	MaxManifestFileSize int64
+
+	// MaxSubcompactions defines the maximum number of disjoint key ranges a
+	// table compaction is split into, each one compacted by its own
+	// goroutine. The ranges are split at the boundaries of the compacted
+	// 'sorted table'; the output of all of them is committed at once.
+	// Table compactions aren't split unless StrictCompaction is set, since
+	// corrupted keys then can't be skipped over.
+	//
+	// The default value is 1, which disables splitting.
+	MaxSubcompactions int
 }
 
 func (o *Options) GetAltFilters() []filter.Filter {
@@ -467,6 +794,20 @@ func (o *Options) GetBlockSize() int {
 	return o.BlockSize
 }
 
+func (o *Options) GetColumnFamilies() map[string]*Options {
+	if o == nil {
+		return nil
+	}
+	return o.ColumnFamilies
+}
+
+func (o *Options) GetCompactionFilter() CompactionFilter {
+	if o == nil {
+		return nil
+	}
+	return o.CompactionFilter
+}
+
 func (o *Options) GetCompactionExpandLimit(level int) int {
 	factor := DefaultCompactionExpandLimitFactor
 	if o != nil && o.CompactionExpandLimitFactor > 0 {
@@ -475,6 +816,27 @@ func (o *Options) GetCompactionExpandLimit(level int) int {
 	return o.GetCompactionTableSize(level+1) * factor
 }
 
+func (o *Options) GetCompactionFIFOMaxAge() time.Duration {
+	if o == nil || o.CompactionFIFOMaxAge < 0 {
+		return 0
+	}
+	return o.CompactionFIFOMaxAge
+}
+
+func (o *Options) GetCompactionFIFOMaxSize() int64 {
+	if o == nil || o.CompactionFIFOMaxSize <= 0 {
+		return DefaultCompactionFIFOMaxSize
+	}
+	return o.CompactionFIFOMaxSize
+}
+
+func (o *Options) GetCompactionFIFOMerge() bool {
+	if o == nil {
+		return false
+	}
+	return o.CompactionFIFOMerge
+}
+
 func (o *Options) GetCompactionGPOverlaps(level int) int {
 	factor := DefaultCompactionGPOverlapsFactor
 	if o != nil && o.CompactionGPOverlapsFactor > 0 {
@@ -498,6 +860,13 @@ func (o *Options) GetCompactionSourceLimit(level int) int {
 	return o.GetCompactionTableSize(level+1) * factor
 }
 
+func (o *Options) GetCompactionStyle() CompactionStyle {
+	if o == nil || o.CompactionStyle == DefaultCompactionStyle {
+		return LeveledCompaction
+	}
+	return o.CompactionStyle
+}
+
 func (o *Options) GetCompactionTableSize(level int) int {
 	var (
 		base = DefaultCompactionTableSize
@@ -540,6 +909,22 @@ func (o *Options) GetCompactionTotalSize(level int) int64 {
 	return int64(float64(base) * mult)
 }
 
+func (o *Options) GetCompactionUniversalMaxSizeAmp() int {
+	if o == nil || o.CompactionUniversalMaxSizeAmp <= 0 {
+		return DefaultCompactionUniversalMaxSizeAmp
+	}
+	return o.CompactionUniversalMaxSizeAmp
+}
+
+func (o *Options) GetCompactionUniversalSizeRatio() int {
+	if o == nil || o.CompactionUniversalSizeRatio == 0 {
+		return DefaultCompactionUniversalSizeRatio
+	} else if o.CompactionUniversalSizeRatio < 0 {
+		return 0
+	}
+	return o.CompactionUniversalSizeRatio
+}
+
 func (o *Options) GetComparer() comparer.Comparer {
 	if o == nil || o.Comparer == nil {
 		return comparer.DefaultComparer
@@ -548,12 +933,42 @@ func (o *Options) GetComparer() comparer.Comparer {
 }
 
 func (o *Options) GetCompression() Compression {
-	if o == nil || o.Compression <= DefaultCompression || o.Compression >= nCompression {
+	if o == nil || !o.Compression.valid() {
 		return DefaultCompressionType
 	}
 	return o.Compression
 }
 
+func (o *Options) GetCompressionDictSize() int {
+	if o == nil || o.CompressionDictSize < 0 {
+		return 0
+	}
+	return o.CompressionDictSize
+}
+
+func (o *Options) GetCompressionLevel() int {
+	if o == nil {
+		return 0
+	}
+	return o.CompressionLevel
+}
+
+func (o *Options) GetCompressionPerLevel(level int) Compression {
+	if o != nil && level >= 0 && level < len(o.CompressionPerLevel) {
+		if c := o.CompressionPerLevel[level]; c.valid() {
+			return c
+		}
+	}
+	return o.GetCompression()
+}
+
+func (o *Options) GetDataBlockHashIndex() bool {
+	if o == nil {
+		return false
+	}
+	return o.DataBlockHashIndex
+}
+
 func (o *Options) GetDisableBufferPool() bool {
 	if o == nil {
 		return false
@@ -586,7 +1001,7 @@ func (o *Options) GetDisableSeeksCompaction() bool {
 	if o == nil {
 		return false
 	}
-	return o.DisableSeeksCompaction
+	return o.DisableSeeksCompaction || o.GetCompactionStyle() != LeveledCompaction
 }
 
 func (o *Options) GetErrorIfExist() bool {
@@ -610,6 +1025,20 @@ func (o *Options) GetFilter() filter.Filter {
 	return o.Filter
 }
 
+func (o *Options) GetFullFilter() bool {
+	if o == nil {
+		return false
+	}
+	return o.FullFilter
+}
+
+func (o *Options) GetIndexPartitionSize() int {
+	if o == nil || o.IndexPartitionSize <= 0 {
+		return 0
+	}
+	return o.IndexPartitionSize
+}
+
 func (o *Options) GetIteratorSamplingRate() int {
 	if o == nil || o.IteratorSamplingRate == 0 {
 		return DefaultIteratorSamplingRate
@@ -619,6 +1048,27 @@ func (o *Options) GetIteratorSamplingRate() int {
 	return o.IteratorSamplingRate
 }
 
+func (o *Options) GetJournalRetentionSize() int {
+	if o == nil || o.JournalRetentionSize < 0 {
+		return 0
+	}
+	return o.JournalRetentionSize
+}
+
+func (o *Options) GetJournalRetentionTime() time.Duration {
+	if o == nil || o.JournalRetentionTime < 0 {
+		return 0
+	}
+	return o.JournalRetentionTime
+}
+
+func (o *Options) GetMergeOperator() MergeOperator {
+	if o == nil {
+		return nil
+	}
+	return o.MergeOperator
+}
+
 func (o *Options) GetNoSync() bool {
 	if o == nil {
 		return false
@@ -649,6 +1099,20 @@ func (o *Options) GetOpenFilesCacheCapacity() int {
 	return o.OpenFilesCacheCapacity
 }
 
+func (o *Options) GetPrefixExtractor() filter.PrefixExtractor {
+	if o == nil {
+		return nil
+	}
+	return o.PrefixExtractor
+}
+
+func (o *Options) GetRangeFilter() filter.RangeFilter {
+	if o == nil {
+		return nil
+	}
+	return o.RangeFilter
+}
+
 func (o *Options) GetReadOnly() bool {
 	if o == nil {
 		return false
@@ -663,6 +1127,13 @@ func (o *Options) GetStrict(strict Strict) bool {
 	return o.Strict&strict != 0
 }
 
+func (o *Options) GetTTL() time.Duration {
+	if o == nil {
+		return 0
+	}
+	return o.TTL
+}
+
 func (o *Options) GetWriteBuffer() int {
 	if o == nil || o.WriteBuffer <= 0 {
 		return DefaultWriteBuffer
@@ -741,6 +1212,12 @@ type WriteOptions struct {
 	//
 	// The default value is false.
 	Sync bool
+
+	// TTL overrides Options.TTL for the written entries, in TTL mode. Use
+	// negative value for entries that never expire.
+	//
+	// The default value is 0, which means Options.TTL.
+	TTL time.Duration
 }
 
 func (wo *WriteOptions) GetNoWriteMerge() bool {
@@ -757,6 +1234,31 @@ func (wo *WriteOptions) GetSync() bool {
 	return wo.Sync
 }
 
+func (wo *WriteOptions) GetTTL() time.Duration {
+	if wo == nil {
+		return 0
+	}
+	return wo.TTL
+}
+
+// TransactionOptions holds the optional parameters for the pessimistic
+// transaction.
+type TransactionOptions struct {
+	// LockTimeout defines how long to wait for a key lock held by another
+	// transaction before giving up.
+	// Use negative value to wait indefinitely.
+	//
+	// The default value is 1 second.
+	LockTimeout time.Duration
+}
+
+func (to *TransactionOptions) GetLockTimeout() time.Duration {
+	if to == nil || to.LockTimeout == 0 {
+		return DefaultTransactionLockTimeout
+	}
+	return to.LockTimeout
+}
+
 func GetStrict(o *Options, ro *ReadOptions, strict Strict) bool {
 	if ro.GetStrict(StrictOverride) {
 		return ro.GetStrict(strict)
@@ -770,3 +1272,10 @@ func (o *Options) GetMaxManifestFileSize() int64 {
 	}
 	return o.MaxManifestFileSize
 }
+
+func (o *Options) GetMaxSubcompactions() int {
+	if o == nil || o.MaxSubcompactions <= 0 {
+		return DefaultMaxSubcompactions
+	}
+	return o.MaxSubcompactions
+}
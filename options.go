@@ -22,6 +22,34 @@ func dupOptions(o *opt.Options) *opt.Options {
 	return newo
 }
 
+// Returns options of a column family, built by overlaying the options
+// applicable to a column family onto the DB options.
+func familyOptions(dbo, o *opt.Options) *opt.Options {
+	no := dupOptions(o)
+	no.BlockCacher = dbo.BlockCacher
+	no.BlockCacheCapacity = dbo.BlockCacheCapacity
+	no.BlockCacheEvictRemoved = dbo.BlockCacheEvictRemoved
+	no.DisableBlockCache = dbo.DisableBlockCache
+	no.DisableBufferPool = dbo.DisableBufferPool
+	no.DisableCompactionBackoff = dbo.DisableCompactionBackoff
+	no.DisableLargeBatchTransaction = dbo.DisableLargeBatchTransaction
+	no.ErrorIfExist = dbo.ErrorIfExist
+	no.ErrorIfMissing = dbo.ErrorIfMissing
+	no.IteratorSamplingRate = dbo.IteratorSamplingRate
+	no.JournalRetentionSize = dbo.JournalRetentionSize
+	no.JournalRetentionTime = dbo.JournalRetentionTime
+	no.MaxManifestFileSize = dbo.MaxManifestFileSize
+	no.NoSync = dbo.NoSync
+	no.NoWriteMerge = dbo.NoWriteMerge
+	no.OpenFilesCacher = dbo.OpenFilesCacher
+	no.OpenFilesCacheCapacity = dbo.OpenFilesCacheCapacity
+	no.ReadOnly = dbo.ReadOnly
+	no.Strict = dbo.Strict
+	no.TTL = 0
+	no.ColumnFamilies = nil
+	return no
+}
+
 func (s *session) setOptions(o *opt.Options) {
 	no := dupOptions(o)
 	// Alternative filters.
@@ -38,6 +66,14 @@ func (s *session) setOptions(o *opt.Options) {
 	if filter := o.GetFilter(); filter != nil {
 		no.Filter = &iFilter{Filter: filter}
 	}
+	// Prefix extractor.
+	if prefix := o.GetPrefixExtractor(); prefix != nil {
+		no.PrefixExtractor = &iPrefixExtractor{PrefixExtractor: prefix}
+	}
+	// Range filter.
+	if rangeFilter := o.GetRangeFilter(); rangeFilter != nil {
+		no.RangeFilter = &iRangeFilter{RangeFilter: rangeFilter}
+	}
 
 	s.o = &cachedOptions{Options: no}
 	s.o.cache()
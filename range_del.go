@@ -0,0 +1,162 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"sort"
+
+	"github.com/3JoB/goleveldb/iterator"
+)
+
+// rangeTombstone is a range deletion record. It deletes entries whose user
+// key is within [start, limit) and whose sequence number is less than seq.
+type rangeTombstone struct {
+	start, limit []byte
+	seq          uint64
+}
+
+// rangeTombstones is a list of range tombstones sorted by start.
+type rangeTombstones []rangeTombstone
+
+// Sorts range tombstones by start, then by sequence number in decreasing
+// order, which is the order of their internal keys.
+func (ts rangeTombstones) sort(icmp *iComparer) {
+	sort.Slice(ts, func(i, j int) bool {
+		if n := icmp.uCompare(ts[i].start, ts[j].start); n != 0 {
+			return n < 0
+		}
+		return ts[i].seq > ts[j].seq
+	})
+}
+
+// Splits the range tombstones, sorted by start, into fragments.
+func (ts rangeTombstones) fragment(icmp *iComparer) rangeFragments {
+	if len(ts) == 0 {
+		return nil
+	}
+	bounds := make([][]byte, 0, 2*len(ts))
+	for _, t := range ts {
+		bounds = append(bounds, t.start, t.limit)
+	}
+	sort.Slice(bounds, func(i, j int) bool {
+		return icmp.uCompare(bounds[i], bounds[j]) < 0
+	})
+
+	var (
+		frags  rangeFragments
+		active rangeTombstones
+		next   int
+	)
+	for k := 0; k+1 < len(bounds); k++ {
+		start, limit := bounds[k], bounds[k+1]
+		if icmp.uCompare(start, limit) == 0 {
+			continue
+		}
+		// Tombstones covering [start, limit) are the ones started and not
+		// yet ended.
+		n := 0
+		for _, t := range active {
+			if icmp.uCompare(t.limit, start) > 0 {
+				active[n] = t
+				n++
+			}
+		}
+		active = active[:n]
+		for ; next < len(ts) && icmp.uCompare(ts[next].start, start) <= 0; next++ {
+			if icmp.uCompare(ts[next].limit, start) > 0 {
+				active = append(active, ts[next])
+			}
+		}
+		if len(active) == 0 {
+			continue
+		}
+		seqs := make([]uint64, len(active))
+		for i, t := range active {
+			seqs[i] = t.seq
+		}
+		sort.Slice(seqs, func(i, j int) bool {
+			return seqs[i] > seqs[j]
+		})
+		frags = append(frags, rangeFragment{start: start, limit: limit, seqs: seqs})
+	}
+	return frags
+}
+
+// rangeFragment is a piece of the key space, [start, limit), covered by
+// range tombstones. It holds the sequence numbers of those tombstones, in
+// decreasing order.
+type rangeFragment struct {
+	start, limit []byte
+	seqs         []uint64
+}
+
+// rangeFragments is a list of sorted, non-overlapping range fragments, so
+// that the tombstones covering a key are found by binary search.
+type rangeFragments []rangeFragment
+
+// Returns the highest sequence number, not greater than seq, of range
+// tombstones covering the given user key. It returns zero if there is
+// no such tombstone.
+func (fs rangeFragments) coverSeq(icmp *iComparer, ukey []byte, seq uint64) uint64 {
+	i := sort.Search(len(fs), func(i int) bool {
+		return icmp.uCompare(fs[i].limit, ukey) > 0
+	})
+	if i == len(fs) || icmp.uCompare(fs[i].start, ukey) > 0 {
+		return 0
+	}
+	seqs := fs[i].seqs
+	j := sort.Search(len(seqs), func(j int) bool {
+		return seqs[j] <= seq
+	})
+	if j == len(seqs) {
+		return 0
+	}
+	return seqs[j]
+}
+
+// Appends range tombstones read from the given iterator, skipping those
+// with sequence number greater than seq.
+func appendRangeTombstones(dst rangeTombstones, iter iterator.Iterator, seq uint64) (rangeTombstones, error) {
+	for iter.Next() {
+		ukey, tseq, kt, kerr := parseInternalKey(iter.Key())
+		if kerr != nil {
+			return dst, kerr
+		}
+		if kt != keyTypeRangeDel {
+			return dst, newErrInternalKeyCorrupted(iter.Key(), "invalid range tombstone type")
+		}
+		if tseq > seq {
+			continue
+		}
+		dst = append(dst, rangeTombstone{
+			start: append([]byte(nil), ukey...),
+			limit: append([]byte(nil), iter.Value()...),
+			seq:   tseq,
+		})
+	}
+	return dst, iter.Error()
+}
+
+// Returns the range tombstones of the memdb, fragmented. The fragments are
+// cached until a tombstone is added.
+func (m *memDB) rangeDels(icmp *iComparer) (rangeFragments, error) {
+	if m.rdel == nil {
+		return nil, nil
+	}
+	m.rdMu.Lock()
+	defer m.rdMu.Unlock()
+	if n := m.rdel.Len(); n != m.rdFragsLen {
+		iter := m.rdel.NewIterator(nil)
+		rdels, err := appendRangeTombstones(nil, iter, keyMaxSeq)
+		iter.Release()
+		if err != nil {
+			return nil, err
+		}
+		m.rdFrags, m.rdFragsLen = rdels.fragment(icmp), n
+	}
+	return m.rdFrags, nil
+}
@@ -0,0 +1,272 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/3JoB/goleveldb/comparer"
+	"github.com/3JoB/goleveldb/opt"
+)
+
+func (h *dbHarness) deleteRange(start, limit string) {
+	if err := h.db.DeleteRange([]byte(start), []byte(limit), h.wo); err != nil {
+		h.t.Error("DeleteRange: got error: ", err)
+	}
+}
+
+func (h *dbHarness) getKeyValReverse(want string) {
+	iter := h.db.NewIterator(nil, h.ro)
+	defer iter.Release()
+	var res string
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		res += fmt.Sprintf("(%s->%s)", iter.Key(), iter.Value())
+	}
+	if err := iter.Error(); err != nil {
+		h.t.Error("Iterator: got error: ", err)
+	}
+	if res != want {
+		h.t.Errorf("GetKeyValReverse: invalid key/value pair, got=%q want=%q", res, want)
+	}
+}
+
+func TestRangeTombstones_Fragment(t *testing.T) {
+	icmp := &iComparer{ucmp: comparer.DefaultComparer}
+	ts := rangeTombstones{
+		{start: []byte("a"), limit: []byte("e"), seq: 3},
+		{start: []byte("b"), limit: []byte("d"), seq: 5},
+		{start: []byte("b"), limit: []byte("c"), seq: 1},
+		{start: []byte("c"), limit: []byte("c"), seq: 9},
+		{start: []byte("d"), limit: []byte("g"), seq: 4},
+		{start: []byte("h"), limit: []byte("i"), seq: 2},
+	}
+	ts.sort(icmp)
+	frags := ts.fragment(icmp)
+	var got string
+	for _, f := range frags {
+		got += fmt.Sprintf("[%s,%s)%v", f.start, f.limit, f.seqs)
+	}
+	if want := "[a,b)[3][b,c)[5 3 1][c,d)[5 3][d,e)[4 3][e,g)[4][h,i)[2]"; got != want {
+		t.Errorf("fragment: got %s, want %s", got, want)
+	}
+
+	// Same result as a linear scan of the tombstones.
+	for _, key := range []string{"", "a", "b", "bb", "c", "d", "e", "f", "g", "h", "i", "z"} {
+		for seq := uint64(0); seq < 10; seq++ {
+			var want uint64
+			for _, rt := range ts {
+				if rt.seq <= seq && rt.seq > want && icmp.uCompare(rt.start, []byte(key)) <= 0 && icmp.uCompare([]byte(key), rt.limit) < 0 {
+					want = rt.seq
+				}
+			}
+			if got := frags.coverSeq(icmp, []byte(key), seq); got != want {
+				t.Errorf("coverSeq(%q, %d): got %d, want %d", key, seq, got, want)
+			}
+		}
+	}
+}
+
+func TestDB_DeleteRange(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		for _, k := range []string{"a", "b", "c", "d"} {
+			h.put(k, "v"+k)
+		}
+		snap := h.getSnapshot()
+		defer snap.Release()
+
+		h.deleteRange("b", "d")
+		h.getVal("a", "va")
+		h.get("b", false)
+		h.get("c", false)
+		h.getVal("d", "vd")
+		h.getKeyVal("(a->va)(d->vd)")
+		h.getKeyValReverse("(d->vd)(a->va)")
+		if ok, err := h.db.Has([]byte("c"), h.ro); err != nil || ok {
+			t.Errorf("Has: got (%v, %v)", ok, err)
+		}
+		h.getValr(snap, "c", "vc")
+
+		// Newer writes are not affected.
+		h.put("c", "vc2")
+		h.getKeyVal("(a->va)(c->vc2)(d->vd)")
+
+		h.compactMem()
+		h.getKeyVal("(a->va)(c->vc2)(d->vd)")
+		h.get("b", false)
+		h.getValr(snap, "b", "vb")
+
+		h.reopenDB()
+		h.getKeyVal("(a->va)(c->vc2)(d->vd)")
+		h.getKeyValReverse("(d->vd)(c->vc2)(a->va)")
+
+		// Empty range is a no-op.
+		h.deleteRange("d", "a")
+		h.getKeyVal("(a->va)(c->vc2)(d->vd)")
+	})
+}
+
+func TestDB_DeleteRange_Compaction(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		BlockSize:                    128,
+		CompactionTableSize:          256,
+	})
+	defer h.close()
+
+	for i := 0; i < 100; i++ {
+		h.put(fmt.Sprintf("k%03d", i), "v")
+	}
+	h.compactMem()
+	h.compactRange("", "")
+
+	snap := h.getSnapshot()
+	h.deleteRange("k010", "k090")
+	h.put("k050", "x")
+	h.compactMem()
+	h.compactRange("", "")
+	h.assertNumKeys(21)
+	h.getVal("k050", "x")
+	h.get("k089", false)
+	h.getValr(snap, "k089", "v")
+
+	// Covered keys are dropped once no snapshot could see them.
+	snap.Release()
+	h.tablesPerLevel("0,4")
+	h.compactRangeAt(1, "", "")
+	h.allEntriesFor("k020", "[ ]")
+	h.allEntriesFor("k050", "[ x ]")
+	h.assertNumKeys(21)
+	v := h.db.s.version()
+	if rdels, err := v.rangeDels(); err != nil || len(rdels) != 0 {
+		t.Errorf("obsolete range tombstones are not dropped: %v, %v", rdels, err)
+	}
+	v.release()
+
+	h.reopenDB()
+	h.assertNumKeys(21)
+	h.get("k010", false)
+	h.getVal("k009", "v")
+	h.getVal("k090", "v")
+}
+
+func TestDB_DeleteRange_Flush(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		h.put("a", "va")
+		h.put("b", "vb")
+		h.put("c", "vc")
+		h.compactMem()
+
+		// The flushed table holds range tombstones only.
+		h.deleteRange("a", "c")
+		h.compactMem()
+		h.get("a", false)
+		h.get("b", false)
+		h.getVal("c", "vc")
+		h.getKeyVal("(c->vc)")
+		h.getKeyValReverse("(c->vc)")
+
+		h.reopenDB()
+		h.getKeyVal("(c->vc)")
+		h.compactRange("", "")
+		h.getKeyVal("(c->vc)")
+		h.get("a", false)
+	})
+}
+
+func TestDB_DeleteRange_Recover(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	h.put("foo", "v1")
+	h.put("fox", "v2")
+	h.deleteRange("fo", "fp")
+	h.put("fox", "v3")
+
+	// Recover from journal.
+	h.reopenDB()
+	h.get("foo", false)
+	h.getVal("fox", "v3")
+	h.getKeyVal("(fox->v3)")
+}
+
+type rangeDelReplay struct {
+	funcReplay
+	ranges []string
+}
+
+func (r *rangeDelReplay) DeleteRange(start, limit []byte) {
+	r.ranges = append(r.ranges, string(start)+"-"+string(limit))
+}
+
+func TestDB_DeleteRange_Batch(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	b := new(Batch)
+	b.Put([]byte("a"), []byte("1"))
+	b.Put([]byte("b"), []byte("2"))
+	b.DeleteRange([]byte("a"), []byte("b"))
+	b.Put([]byte("c"), []byte("3"))
+	h.write(b)
+	h.getKeyVal("(b->2)(c->3)")
+
+	var n int
+	r := &rangeDelReplay{funcReplay: func() { n++ }}
+	if err := b.Replay(r); err != nil {
+		t.Fatal("Replay: got error: ", err)
+	}
+	if n != 3 || len(r.ranges) != 1 || r.ranges[0] != "a-b" {
+		t.Errorf("Replay: got %d records and ranges %v", n, r.ranges)
+	}
+
+	b2 := new(Batch)
+	if err := b2.Load(b.Dump()); err != nil {
+		t.Fatal("Load: got error: ", err)
+	}
+	if b2.Len() != 4 || !b2.hasRangeDel() {
+		t.Errorf("Load: got %d records", b2.Len())
+	}
+
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		t.Fatal("OpenTransaction: got error: ", err)
+	}
+	if err := tr.Write(b, h.wo); err != errTransactionRangeDel {
+		t.Errorf("Transaction.Write: expect errTransactionRangeDel, got %v", err)
+	}
+	tr.Discard()
+}
+
+func TestDB_DeleteRange_BatchInverted(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "va")
+	h.put("m", "vm")
+	h.put("z", "vz")
+	h.compactMem()
+
+	// Inverted ranges delete nothing, and aren't flushed into tables.
+	b := new(Batch)
+	b.DeleteRange([]byte("z"), []byte("a"))
+	h.write(b)
+	h.getKeyVal("(a->va)(m->vm)(z->vz)")
+	h.compactMem()
+	if n := h.totalTables(); n != 1 {
+		t.Errorf("got %d tables, want 1", n)
+	}
+
+	// Neither when recovered from the journal.
+	h.write(b)
+	h.reopenDB()
+	h.compactMem()
+	if n := h.totalTables(); n != 1 {
+		t.Errorf("got %d tables, want 1", n)
+	}
+	h.getKeyVal("(a->va)(m->vm)(z->vz)")
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"sync"
 
 	"github.com/3JoB/goleveldb/errors"
@@ -66,6 +67,15 @@ type session struct {
 
 	baseManifestSize int64 // manifest file size since last build
 
+	// Column family fields. A column family session shares storage, file
+	// numbers and manifest with its parent session.
+	parent            *session
+	cfID              uint32
+	cfName            string
+	cfDropped         bool                // need external synchronization
+	families          map[uint32]*session // need external synchronization
+	stMaxColumnFamily uint32              // need external synchronization
+
 	// Testing fields
 	fileRefCh chan chan map[int64]int // channel used to pass current reference stat
 
@@ -89,6 +99,7 @@ func newSession(stor storage.Storage, o *opt.Options) (s *session, err error) {
 		abandon:   make(chan int64),
 		fileRefCh: make(chan chan map[int64]int),
 		closeC:    make(chan struct{}),
+		families:  make(map[uint32]*session),
 	}
 	s.setOptions(o)
 	s.tops = newTableOps(s)
@@ -100,8 +111,50 @@ func newSession(stor storage.Storage, o *opt.Options) (s *session, err error) {
 	return
 }
 
+// Creates new column family session that shares storage, file numbers
+// and manifest with s.
+func (s *session) newFamily(id uint32, name string, o *opt.Options) *session {
+	fs := &session{
+		parent:    s,
+		cfID:      id,
+		cfName:    name,
+		stor:      s.stor,
+		refCh:     make(chan *vTask),
+		relCh:     make(chan *vTask),
+		deltaCh:   make(chan *vDelta),
+		abandon:   make(chan int64),
+		fileRefCh: make(chan chan map[int64]int),
+		closeC:    make(chan struct{}),
+	}
+	fs.setOptions(familyOptions(s.o.Options, o))
+	fs.tops = newTableOps(fs)
+
+	fs.closeW.Add(1)
+	go fs.refLoop()
+	fs.setVersion(nil, newVersion(fs))
+	return fs
+}
+
+// Returns live column family sessions sorted by id; need external
+// synchronization.
+func (s *session) liveFamilies() []*session {
+	families := make([]*session, 0, len(s.families))
+	for _, fs := range s.families {
+		if !fs.cfDropped {
+			families = append(families, fs)
+		}
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].cfID < families[j].cfID
+	})
+	return families
+}
+
 // Close session.
 func (s *session) close() {
+	for _, fs := range s.families {
+		fs.close()
+	}
 	s.tops.close()
 	if s.manifest != nil {
 		s.manifest.Close()
@@ -158,7 +211,10 @@ func (s *session) recover() (err error) {
 
 		jr      = journal.NewReader(reader, dropper{s: s, fd: fd}, strict, true)
 		rec     = &sessionRecord{}
+		cur     = &sessionRecord{}
 		staging = s.stVersion.newStaging()
+
+		familyStagings = make(map[uint32]*versionStaging)
 	)
 	for {
 		var r io.Reader
@@ -171,24 +227,36 @@ func (s *session) recover() (err error) {
 			return errors.SetFd(err, fd)
 		}
 
-		err = rec.decode(r)
+		*cur = sessionRecord{}
+		err = cur.decode(r)
 		if err == nil {
+			if cur.has(recNextFileNum) {
+				rec.setNextFileNum(cur.nextFileNum)
+			}
+			if cur.has(recColumnFamily) {
+				// commit record to column family version staging
+				if err = s.recoverFamily(fd, cur, familyStagings); err != nil {
+					return
+				}
+				continue
+			}
+			rec.merge(cur)
 			// save compact pointers
-			for _, r := range rec.compPtrs {
+			for _, r := range cur.compPtrs {
 				s.setCompPtr(r.level, r.ikey)
 			}
 			// commit record to version staging
-			staging.commit(rec)
+			staging.commit(cur)
 		} else {
 			err = errors.SetFd(err, fd)
 			if strict || !errors.IsCorrupted(err) {
 				return
 			}
 			s.logf("manifest error: %v (skipped)", errors.SetFd(err, fd))
+			if !cur.has(recColumnFamily) {
+				rec.merge(cur)
+			}
 		}
-		rec.resetCompPtrs()
-		rec.resetAddedTables()
-		rec.resetDeletedTables()
 	}
 
 	switch {
@@ -208,6 +276,47 @@ func (s *session) recover() (err error) {
 	s.setVersion(rec, staging.finish(false))
 	s.setNextFileNum(rec.nextFileNum)
 	s.recordCommited(rec)
+	for id, fstaging := range familyStagings {
+		// column family versions are built from an empty version, so all
+		// of their tables are the version delta
+		fs, nv := s.families[id], fstaging.finish(false)
+		frec := &sessionRecord{}
+		nv.fillRecord(frec)
+		fs.setVersion(frec, nv)
+	}
+	return nil
+}
+
+// Recover a column family record; need external synchronization.
+func (s *session) recoverFamily(fd storage.FileDesc, rec *sessionRecord, stagings map[uint32]*versionStaging) error {
+	id := rec.cfID
+	if id > s.stMaxColumnFamily {
+		s.stMaxColumnFamily = id
+	}
+	fs := s.families[id]
+	if rec.has(recAddColumnFamily) && fs == nil {
+		if !rec.has(recComparer) {
+			return newErrManifestCorrupted(fd, "comparer", fmt.Sprintf("missing for column family '%s'", rec.cfName))
+		}
+		fs = s.newFamily(id, rec.cfName, s.o.GetColumnFamilies()[rec.cfName])
+		s.families[id] = fs
+		stagings[id] = fs.stVersion.newStaging()
+	}
+	if fs == nil {
+		// The column family was dropped.
+		return nil
+	}
+	if rec.has(recDropColumnFamily) {
+		delete(s.families, id)
+		delete(stagings, id)
+		fs.close()
+		return nil
+	}
+	if rec.has(recComparer) && rec.comparer != fs.icmp.uName() {
+		return newErrManifestCorrupted(fd, "comparer", fmt.Sprintf("mismatch for column family '%s': want '%s', got '%s'", fs.cfName, fs.icmp.uName(), rec.comparer))
+	}
+	stagings[id].commit(rec)
+	fs.recordCommited(rec)
 	return nil
 }
 
@@ -239,7 +348,10 @@ func (s *session) commit(r *sessionRecord, trivial bool) (err error) {
 		}
 	}()
 
-	if s.manifest == nil {
+	if s.parent != nil {
+		// column family records are written into parent manifest
+		err = s.parent.flushFamilyManifest(s, r)
+	} else if s.manifest == nil {
 		// manifest journal writer not yet created, create one
 		err = s.newManifest(r, nv)
 	} else if s.shouldRebuildManifest() {
@@ -9,10 +9,12 @@ package leveldb
 import (
 	"sort"
 	"sync/atomic"
+	"time"
 
 	"github.com/3JoB/goleveldb/iterator"
 	"github.com/3JoB/goleveldb/memdb"
 	"github.com/3JoB/goleveldb/opt"
+	"github.com/3JoB/goleveldb/util"
 )
 
 const (
@@ -20,23 +22,53 @@ const (
 	level0Compaction
 	nonLevel0Compaction
 	seekCompaction
+	universalCompaction
+	fifoCompaction
+	fifoMergeCompaction
 )
 
+// Number of levels sorted runs of universal compaction are spread over, the
+// oldest sorted run is at the last level.
+const universalNumLevel = 7
+
 func (s *session) pickMemdbLevel(umin, umax []byte, maxLevel int) int {
+	if s.o.GetCompactionStyle() != opt.LeveledCompaction {
+		// Each memdb flush is a new sorted run, or stays in level-0 for
+		// FIFO compaction.
+		return 0
+	}
 	v := s.version()
 	defer v.release()
 	return v.pickMemdbLevel(umin, umax, maxLevel)
 }
 
-func (s *session) flushMemdb(rec *sessionRecord, mdb *memdb.DB, maxLevel int) (int, error) {
-	// Create sorted table.
+// memdbRange returns the user-key range covered by the memdb entries and
+// its range tombstones.
+func (s *session) memdbRange(mdb, rdel *memdb.DB) (umin, umax []byte) {
 	iter := mdb.NewIterator(nil)
 	defer iter.Release()
-	t, n, err := s.tops.createFrom(iter)
-	if err != nil {
-		return 0, err
+	if iter.First() {
+		umin = append([]byte(nil), internalKey(iter.Key()).ukey()...)
+		iter.Last()
+		umax = append([]byte(nil), internalKey(iter.Key()).ukey()...)
+	}
+	if rdel == nil || rdel.Len() == 0 {
+		return
+	}
+	rdIter := rdel.NewIterator(nil)
+	defer rdIter.Release()
+	for rdIter.Next() {
+		if ukey := internalKey(rdIter.Key()).ukey(); umin == nil || s.icmp.uCompare(ukey, umin) < 0 {
+			umin = append(umin[:0], ukey...)
+		}
+		if limit := rdIter.Value(); umax == nil || s.icmp.uCompare(limit, umax) > 0 {
+			umax = append(umax[:0], limit...)
+		}
 	}
+	return
+}
 
+func (s *session) flushMemdb(rec *sessionRecord, mdb, rdel *memdb.DB, maxLevel int) (int, error) {
 	// Pick level other than zero can cause compaction issue with large
 	// bulk insert and delete on strictly incrementing key-space. The
 	// problem is that the small deletion markers trapped at lower level,
@@ -45,7 +77,24 @@ func (s *session) flushMemdb(rec *sessionRecord, mdb *memdb.DB, maxLevel int) (i
 	// higher level, thus maximum possible level is always picked, while
 	// overlapping deletion marker pushed into lower level.
 	// See: https://github.com/syndtr/goleveldb/issues/127.
-	flushLevel := s.pickMemdbLevel(t.imin.ukey(), t.imax.ukey(), maxLevel)
+	//
+	// The level is picked before the table is written, so that the table
+	// is compressed with the codec of the level it lands on.
+	umin, umax := s.memdbRange(mdb, rdel)
+	flushLevel := s.pickMemdbLevel(umin, umax, maxLevel)
+
+	// Create sorted table.
+	iter := mdb.NewIterator(nil)
+	defer iter.Release()
+	var rdIter iterator.Iterator
+	if rdel != nil && rdel.Len() > 0 {
+		rdIter = rdel.NewIterator(nil)
+		defer rdIter.Release()
+	}
+	t, n, err := s.tops.createFrom(iter, rdIter, flushLevel)
+	if err != nil {
+		return 0, err
+	}
 	rec.addTableFile(flushLevel, t)
 
 	s.logf("memdb@flush created L%d@%d N·%d S·%s %q:%q", flushLevel, t.fd.Num, n, shortenb(t.size), t.imin, t.imax)
@@ -56,6 +105,28 @@ func (s *session) flushMemdb(rec *sessionRecord, mdb *memdb.DB, maxLevel int) (i
 func (s *session) pickCompaction() *compaction {
 	v := s.version()
 
+	switch s.o.GetCompactionStyle() {
+	case opt.UniversalCompaction:
+		if v.cScore >= 1 {
+			if c := s.pickUniversalCompaction(v); c != nil {
+				return c
+			}
+		}
+		v.release()
+		return nil
+	case opt.FIFOCompaction:
+		if v.cScore >= 1 {
+			if drops := v.fifoOversized(); len(drops) > 0 {
+				return newFIFOCompaction(s, v, drops)
+			}
+			if tables := v.fifoMergeTables(); len(tables) > 0 {
+				return newFIFOMergeCompaction(s, v, tables)
+			}
+		}
+		v.release()
+		return nil
+	}
+
 	var sourceLevel int
 	var t0 tFiles
 	var typ int
@@ -94,6 +165,100 @@ func (s *session) pickCompaction() *compaction {
 	return newCompaction(s, v, sourceLevel, t0, typ)
 }
 
+// Pick the newest sorted runs to merge; need external synchronization.
+func (s *session) pickUniversalCompaction(v *version) *compaction {
+	runs := v.sortedRuns()
+	n := len(runs)
+	if n < 2 {
+		return nil
+	}
+
+	var total int64
+	for _, r := range runs {
+		total += r.size
+	}
+	var pick int
+	if oldest := runs[n-1].size; (total-oldest)*100 > oldest*int64(s.o.GetCompactionUniversalMaxSizeAmp()) {
+		// Too much space amplification, merge all sorted runs.
+		pick = n
+		s.logf("table@compaction universal size amplification S·%s/%s", shortenb(total-oldest), shortenb(oldest))
+	} else {
+		// Merge sorted runs of similar size.
+		ratio := int64(100 + s.o.GetCompactionUniversalSizeRatio())
+		size := runs[0].size
+		for pick = 1; pick < n && runs[pick].size*100 <= size*ratio; pick++ {
+			size += runs[pick].size
+		}
+		if pick < 2 {
+			// Reduce the number of sorted runs below the trigger.
+			pick = n - s.o.GetCompactionL0Trigger() + 1
+			if pick < 2 {
+				pick = 2
+			}
+		}
+	}
+
+	// Level-0 tables are read before any other level, hence they are all
+	// merged at once to keep the newest data first.
+	for pick < n && runs[pick].level == 0 {
+		pick++
+	}
+	// The output is placed right above the next older sorted run, which is
+	// merged as well if there is no room.
+	for pick < n && runs[pick].level-1 < maxInt(runs[pick-1].level, 1) {
+		pick++
+	}
+	outLevel := runs[pick-1].level
+	if pick < n {
+		outLevel = runs[pick].level - 1
+	} else if outLevel < universalNumLevel-1 {
+		outLevel = universalNumLevel - 1
+	}
+
+	return newUniversalCompaction(s, v, runs[:pick], outLevel)
+}
+
+// Returns whether tables are dropped once older than the age limit of FIFO
+// compaction.
+func (s *session) fifoAging() bool {
+	return s.o.GetCompactionStyle() == opt.FIFOCompaction && s.o.GetCompactionFIFOMaxAge() > 0
+}
+
+// Pick the tables of FIFO compaction older than the age limit at the given
+// time; need external synchronization.
+func (s *session) pickFIFOExpiry(now time.Time) *compaction {
+	v := s.version()
+	if drops := v.fifoExpired(now); len(drops) > 0 {
+		return newFIFOCompaction(s, v, drops)
+	}
+	v.release()
+	return nil
+}
+
+// Create FIFO compaction merging the level-0 tables overlapping the given
+// range, along with the ones between them in age, so that the merged tables
+// are consecutive in age; need external synchronization.
+func (s *session) getFIFOCompactionRange(umin, umax []byte) *compaction {
+	v := s.version()
+
+	first, last := -1, -1
+	if len(v.levels) > 0 {
+		for i, t := range v.levels[0] {
+			if t.overlaps(s.icmp, umin, umax) {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+		}
+	}
+	if first < 0 {
+		v.release()
+		return nil
+	}
+	return newFIFOMergeCompaction(s, v, append(tFiles(nil), v.levels[0][first:last+1]...))
+}
+
 // Create compaction from given level and range; need external synchronization.
 func (s *session) getCompactionRange(sourceLevel int, umin, umax []byte, noLimit bool) *compaction {
 	v := s.version()
@@ -133,13 +298,49 @@ func (s *session) getCompactionRange(sourceLevel int, umin, umax []byte, noLimit
 	return newCompaction(s, v, sourceLevel, t0, typ)
 }
 
+// Create compaction of the given level and range into the same level, so
+// that the dropped entries are purged without pushing the others deeper;
+// need external synchronization.
+func (s *session) getCompactionInPlace(level int, umin, umax []byte) *compaction {
+	v := s.version()
+
+	if level >= len(v.levels) {
+		v.release()
+		return nil
+	}
+
+	t0 := v.levels[level].getOverlaps(nil, s.icmp, umin, umax, level == 0)
+	if len(t0) == 0 {
+		v.release()
+		return nil
+	}
+
+	typ := level0Compaction
+	if level != 0 {
+		typ = nonLevel0Compaction
+	}
+	c := &compaction{
+		s:           s,
+		v:           v,
+		typ:         typ,
+		sourceLevel: level,
+		outLevel:    level,
+		levels:      []tFiles{t0, nil},
+		tPtrs:       make([]int, len(v.levels)),
+	}
+	c.imin, c.imax = t0.getRange(s.icmp)
+	c.save()
+	return c
+}
+
 func newCompaction(s *session, v *version, sourceLevel int, t0 tFiles, typ int) *compaction {
 	c := &compaction{
 		s:             s,
 		v:             v,
 		typ:           typ,
 		sourceLevel:   sourceLevel,
-		levels:        [2]tFiles{t0, nil},
+		outLevel:      sourceLevel + 1,
+		levels:        []tFiles{t0, nil},
 		maxGPOverlaps: int64(s.o.GetCompactionGPOverlaps(sourceLevel)),
 		tPtrs:         make([]int, len(v.levels)),
 	}
@@ -148,6 +349,58 @@ func newCompaction(s *session, v *version, sourceLevel int, t0 tFiles, typ int)
 	return c
 }
 
+func newUniversalCompaction(s *session, v *version, runs []sortedRun, outLevel int) *compaction {
+	sourceLevel := runs[0].level
+	c := &compaction{
+		s:           s,
+		v:           v,
+		typ:         universalCompaction,
+		sourceLevel: sourceLevel,
+		outLevel:    outLevel,
+		levels:      make([]tFiles, runs[len(runs)-1].level-sourceLevel+1),
+		tPtrs:       make([]int, len(v.levels)),
+	}
+	var all tFiles
+	for _, r := range runs {
+		i := r.level - sourceLevel
+		c.levels[i] = append(c.levels[i], r.tables...)
+		all = append(all, r.tables...)
+	}
+	c.imin, c.imax = all.getRange(s.icmp)
+	c.save()
+	return c
+}
+
+func newFIFOCompaction(s *session, v *version, drops []tSet) *compaction {
+	c := &compaction{
+		s:     s,
+		v:     v,
+		typ:   fifoCompaction,
+		tPtrs: make([]int, len(v.levels)),
+	}
+	for _, ts := range drops {
+		for len(c.levels) <= ts.level {
+			c.levels = append(c.levels, nil)
+		}
+		c.levels[ts.level] = append(c.levels[ts.level], ts.table)
+	}
+	c.save()
+	return c
+}
+
+func newFIFOMergeCompaction(s *session, v *version, tables tFiles) *compaction {
+	c := &compaction{
+		s:      s,
+		v:      v,
+		typ:    fifoMergeCompaction,
+		levels: []tFiles{tables},
+		tPtrs:  make([]int, len(v.levels)),
+	}
+	c.imin, c.imax = tables.getRange(s.icmp)
+	c.save()
+	return c
+}
+
 // compaction represent a compaction state.
 type compaction struct {
 	s *session
@@ -155,7 +408,8 @@ type compaction struct {
 
 	typ           int
 	sourceLevel   int
-	levels        [2]tFiles
+	outLevel      int
+	levels        []tFiles // Input tables of each level from sourceLevel.
 	maxGPOverlaps int64
 
 	gp                tFiles
@@ -166,6 +420,9 @@ type compaction struct {
 	imin, imax        internalKey
 	tPtrs             []int
 
+	// User key range of a subcompaction, nil for the whole compaction.
+	slice *util.Range
+
 	snapGPI               int
 	snapSeenKey           bool
 	snapGPOverlappedBytes int64
@@ -245,13 +502,112 @@ func (c *compaction) expand() {
 	c.imin, c.imax = imin, imax
 }
 
+// Returns the user keys splitting the compaction into at most n disjoint
+// ranges of similar input size, picked among the compacted tables bounds.
+// Returns nil if the compaction can't be split.
+func (c *compaction) subBounds(n int) (bounds [][]byte) {
+	if n < 2 {
+		return nil
+	}
+	var ukeys [][]byte
+	for _, tables := range c.levels {
+		for _, t := range tables {
+			ukeys = append(ukeys, t.imin.ukey(), t.imax.ukey())
+		}
+	}
+	sort.Slice(ukeys, func(i, j int) bool {
+		return c.s.icmp.uCompare(ukeys[i], ukeys[j]) < 0
+	})
+	m := 0
+	for _, ukey := range ukeys {
+		if m == 0 || c.s.icmp.uCompare(ukey, ukeys[m-1]) != 0 {
+			ukeys[m] = ukey
+			m++
+		}
+	}
+	ukeys = ukeys[:m]
+	if len(ukeys) < 3 {
+		return nil
+	}
+
+	// Estimate the input size up to each key, the size of a table is
+	// spread evenly over the keys it spans.
+	search := func(ukey []byte) int {
+		return sort.Search(len(ukeys), func(i int) bool {
+			return c.s.icmp.uCompare(ukeys[i], ukey) >= 0
+		})
+	}
+	var (
+		sizes = make([]int64, len(ukeys))
+		total int64
+	)
+	for _, tables := range c.levels {
+		for _, t := range tables {
+			i, j := search(t.imin.ukey()), search(t.imax.ukey())
+			if i == j {
+				sizes[i] += t.size
+			} else {
+				for k := i + 1; k <= j; k++ {
+					sizes[k] += t.size / int64(j-i)
+				}
+			}
+			total += t.size
+		}
+	}
+
+	var size int64
+	for i, ukey := range ukeys[:len(ukeys)-1] {
+		size += sizes[i]
+		if i > 0 && size*int64(n) >= total*int64(len(bounds)+1) {
+			bounds = append(bounds, append([]byte(nil), ukey...))
+			if len(bounds) == n-1 {
+				break
+			}
+		}
+	}
+	return
+}
+
+// Returns a copy of the compaction restricted to the given user key range,
+// with its own state so that it can be compacted concurrently with the other
+// ranges. A nil umin or umax means unbounded. The copy doesn't own the
+// version, releasing it is a no-op.
+func (c *compaction) sub(umin, umax []byte) *compaction {
+	sc := &compaction{
+		s:             c.s,
+		v:             c.v,
+		typ:           c.typ,
+		sourceLevel:   c.sourceLevel,
+		outLevel:      c.outLevel,
+		levels:        c.levels,
+		maxGPOverlaps: c.maxGPOverlaps,
+		gp:            c.gp,
+		released:      true,
+		imin:          c.imin,
+		imax:          c.imax,
+		tPtrs:         make([]int, len(c.tPtrs)),
+		slice:         &util.Range{Start: umin, Limit: umax},
+	}
+	sc.save()
+	return sc
+}
+
 // Check whether compaction is trivial.
 func (c *compaction) trivial() bool {
-	return len(c.levels[0]) == 1 && len(c.levels[1]) == 0 && c.gp.size() <= c.maxGPOverlaps
+	for _, tables := range c.levels[1:] {
+		if len(tables) > 0 {
+			return false
+		}
+	}
+	return len(c.levels[0]) == 1 && c.gp.size() <= c.maxGPOverlaps
 }
 
 func (c *compaction) baseLevelForKey(ukey []byte) bool {
-	for level := c.sourceLevel + 2; level < len(c.v.levels); level++ {
+	if c.outLevel == 0 {
+		// Older level-0 tables may hold the key.
+		return false
+	}
+	for level := c.outLevel + 1; level < len(c.v.levels); level++ {
 		tables := c.v.levels[level]
 		for c.tPtrs[level] < len(tables) {
 			t := tables[c.tPtrs[level]]
@@ -269,6 +625,32 @@ func (c *compaction) baseLevelForKey(ukey []byte) bool {
 	return true
 }
 
+// baseLevelForRange returns true if there is no data within the given user
+// key range in levels higher than the compaction output level.
+func (c *compaction) baseLevelForRange(umin, umax []byte) bool {
+	if c.outLevel == 0 {
+		return false
+	}
+	for level := c.outLevel + 1; level < len(c.v.levels); level++ {
+		if c.v.levels[level].overlaps(c.s.icmp, umin, umax, false) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the creation time of the newest compacted table, zero if unknown.
+func (c *compaction) ctime() (ctime int64) {
+	for _, tables := range c.levels {
+		for _, t := range tables {
+			if t.ctime > ctime {
+				ctime = t.ctime
+			}
+		}
+	}
+	return
+}
+
 func (c *compaction) shouldStopBefore(ikey internalKey) bool {
 	for ; c.gpi < len(c.gp); c.gpi++ {
 		gp := c.gp[c.gpi]
@@ -308,6 +690,7 @@ func (c *compaction) newIterator() iterator.Iterator {
 	if strict {
 		ro.Strict |= opt.StrictReader
 	}
+	slice := makeInternalRange(c.slice)
 
 	for i, tables := range c.levels {
 		if len(tables) == 0 {
@@ -317,10 +700,10 @@ func (c *compaction) newIterator() iterator.Iterator {
 		// Level-0 is not sorted and may overlaps each other.
 		if c.sourceLevel+i == 0 {
 			for _, t := range tables {
-				its = append(its, c.s.tops.newIterator(t, nil, ro))
+				its = append(its, c.s.tops.newIterator(t, slice, ro))
 			}
 		} else {
-			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, nil, ro), strict)
+			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, slice, ro), strict)
 			its = append(its, it)
 		}
 	}
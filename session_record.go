@@ -33,6 +33,19 @@ const (
 
 	// 8 was used for large value refs
 	recPrevJournalNum = 9
+
+	recColumnFamily     = 10
+	recAddColumnFamily  = 11
+	recDropColumnFamily = 12
+	recMaxColumnFamily  = 13
+
+	// recAddTableRangeDel is recAddTable of a table that contains range
+	// tombstones.
+	recAddTableRangeDel = 14
+
+	// recTableTime is the creation time of the table of the preceding
+	// recAddTable with the same number.
+	recTableTime = 15
 )
 
 type cpRecord struct {
@@ -46,6 +59,12 @@ type atRecord struct {
 	size  int64
 	imin  internalKey
 	imax  internalKey
+
+	// rangeDel is true if the table contains range tombstones.
+	rangeDel bool
+
+	// ctime is the table creation time in unix nanoseconds, zero if unknown.
+	ctime int64
 }
 
 type dtRecord struct {
@@ -64,6 +83,12 @@ type sessionRecord struct {
 	addedTables    []atRecord
 	deletedTables  []dtRecord
 
+	// Column family fields. A record with a column family applies to that
+	// column family instead of the default one.
+	cfID            uint32
+	cfName          string
+	maxColumnFamily uint32
+
 	scratch [binary.MaxVarintLen64]byte
 	err     error
 }
@@ -112,8 +137,28 @@ func (p *sessionRecord) addTable(level int, num, size int64, imin, imax internal
 	p.addedTables = append(p.addedTables, atRecord{level: level, num: num, size: size, imin: imin, imax: imax})
 }
 
+func (p *sessionRecord) addRangeDelTable(level int, num, size int64, imin, imax internalKey) {
+	p.hasRec |= 1 << recAddTable
+	p.addedTables = append(p.addedTables, atRecord{level: level, num: num, size: size, imin: imin, imax: imax, rangeDel: true})
+}
+
 func (p *sessionRecord) addTableFile(level int, t *tFile) {
-	p.addTable(level, t.fd.Num, t.size, t.imin, t.imax)
+	if t.rangeDel {
+		p.addRangeDelTable(level, t.fd.Num, t.size, t.imin, t.imax)
+	} else {
+		p.addTable(level, t.fd.Num, t.size, t.imin, t.imax)
+	}
+	p.setTableTime(t.fd.Num, t.ctime)
+}
+
+// Sets the creation time of the added table with the given number.
+func (p *sessionRecord) setTableTime(num, ctime int64) {
+	for i := len(p.addedTables) - 1; i >= 0; i-- {
+		if p.addedTables[i].num == num {
+			p.addedTables[i].ctime = ctime
+			return
+		}
+	}
 }
 
 func (p *sessionRecord) resetAddedTables() {
@@ -131,6 +176,47 @@ func (p *sessionRecord) resetDeletedTables() {
 	p.deletedTables = p.deletedTables[:0]
 }
 
+func (p *sessionRecord) setColumnFamily(id uint32) {
+	p.hasRec |= 1 << recColumnFamily
+	p.cfID = id
+}
+
+func (p *sessionRecord) addColumnFamily(name string) {
+	p.hasRec |= 1 << recAddColumnFamily
+	p.cfName = name
+}
+
+func (p *sessionRecord) dropColumnFamily() {
+	p.hasRec |= 1 << recDropColumnFamily
+}
+
+func (p *sessionRecord) setMaxColumnFamily(id uint32) {
+	p.hasRec |= 1 << recMaxColumnFamily
+	p.maxColumnFamily = id
+}
+
+// merge copies fields other than compaction pointers and tables from r.
+func (p *sessionRecord) merge(r *sessionRecord) {
+	if r.has(recComparer) {
+		p.setComparer(r.comparer)
+	}
+	if r.has(recJournalNum) {
+		p.setJournalNum(r.journalNum)
+	}
+	if r.has(recPrevJournalNum) {
+		p.setPrevJournalNum(r.prevJournalNum)
+	}
+	if r.has(recNextFileNum) {
+		p.setNextFileNum(r.nextFileNum)
+	}
+	if r.has(recSeqNum) {
+		p.setSeqNum(r.seqNum)
+	}
+	if r.has(recMaxColumnFamily) {
+		p.setMaxColumnFamily(r.maxColumnFamily)
+	}
+}
+
 func (p *sessionRecord) putUvarint(w io.Writer, x uint64) {
 	if p.err != nil {
 		return
@@ -159,6 +245,21 @@ func (p *sessionRecord) putBytes(w io.Writer, x []byte) {
 
 func (p *sessionRecord) encode(w io.Writer) error {
 	p.err = nil
+	if p.has(recColumnFamily) {
+		p.putUvarint(w, recColumnFamily)
+		p.putUvarint(w, uint64(p.cfID))
+	}
+	if p.has(recAddColumnFamily) {
+		p.putUvarint(w, recAddColumnFamily)
+		p.putBytes(w, []byte(p.cfName))
+	}
+	if p.has(recDropColumnFamily) {
+		p.putUvarint(w, recDropColumnFamily)
+	}
+	if p.has(recMaxColumnFamily) {
+		p.putUvarint(w, recMaxColumnFamily)
+		p.putUvarint(w, uint64(p.maxColumnFamily))
+	}
 	if p.has(recComparer) {
 		p.putUvarint(w, recComparer)
 		p.putBytes(w, []byte(p.comparer))
@@ -186,12 +287,21 @@ func (p *sessionRecord) encode(w io.Writer) error {
 		p.putVarint(w, r.num)
 	}
 	for _, r := range p.addedTables {
-		p.putUvarint(w, recAddTable)
+		if r.rangeDel {
+			p.putUvarint(w, recAddTableRangeDel)
+		} else {
+			p.putUvarint(w, recAddTable)
+		}
 		p.putUvarint(w, uint64(r.level))
 		p.putVarint(w, r.num)
 		p.putVarint(w, r.size)
 		p.putBytes(w, r.imin)
 		p.putBytes(w, r.imax)
+		if r.ctime != 0 {
+			p.putUvarint(w, recTableTime)
+			p.putVarint(w, r.num)
+			p.putVarint(w, r.ctime)
+		}
 	}
 	return p.err
 }
@@ -311,12 +421,44 @@ func (p *sessionRecord) decode(r io.Reader) error {
 			if p.err == nil {
 				p.addTable(level, num, size, imin, imax)
 			}
+		case recAddTableRangeDel:
+			level := p.readLevel("add-table.level", br)
+			num := p.readVarint("add-table.num", br)
+			size := p.readVarint("add-table.size", br)
+			imin := p.readBytes("add-table.imin", br)
+			imax := p.readBytes("add-table.imax", br)
+			if p.err == nil {
+				p.addRangeDelTable(level, num, size, imin, imax)
+			}
+		case recTableTime:
+			num := p.readVarint("table-time.num", br)
+			ctime := p.readVarint("table-time.ctime", br)
+			if p.err == nil {
+				p.setTableTime(num, ctime)
+			}
 		case recDelTable:
 			level := p.readLevel("del-table.level", br)
 			num := p.readVarint("del-table.num", br)
 			if p.err == nil {
 				p.delTable(level, num)
 			}
+		case recColumnFamily:
+			x := p.readUvarint("column-family", br)
+			if p.err == nil {
+				p.setColumnFamily(uint32(x))
+			}
+		case recAddColumnFamily:
+			x := p.readBytes("add-column-family.name", br)
+			if p.err == nil {
+				p.addColumnFamily(string(x))
+			}
+		case recDropColumnFamily:
+			p.dropColumnFamily()
+		case recMaxColumnFamily:
+			x := p.readUvarint("max-column-family", br)
+			if p.err == nil {
+				p.setMaxColumnFamily(uint32(x))
+			}
 		}
 	}
 
@@ -59,4 +59,18 @@ func TestSessionRecord_EncodeDecode(t *testing.T) {
 	v.setNextFileNum(big + 200)
 	v.setSeqNum(uint64(big + 1000))
 	test()
+
+	v.addRangeDelTable(2, big+800, big+801,
+		makeInternalKey(nil, []byte("bar"), uint64(big+802), keyTypeRangeDel),
+		makeInternalKey(nil, []byte("baz"), keyMaxSeq, keyTypeSeek))
+	test()
+	v.setTableTime(big+800, big+803)
+	test()
+
+	v.setMaxColumnFamily(7)
+	v.setColumnFamily(3)
+	v.addColumnFamily("bar")
+	test()
+	v.dropColumnFamily()
+	test()
 }
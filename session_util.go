@@ -37,6 +37,9 @@ func (s *session) logf(format string, v ...any) { s.stor.Log(fmt.Sprintf(format,
 // File utils.
 
 func (s *session) newTemp() storage.FileDesc {
+	if s.parent != nil {
+		return s.parent.newTemp()
+	}
 	num := atomic.AddInt64(&s.stTempFileNum, 1) - 1
 	return storage.FileDesc{Type: storage.TypeTemp, Num: num}
 }
@@ -297,16 +300,27 @@ func (s *session) setVersion(r *sessionRecord, v *version) {
 
 // Get current unused file number.
 func (s *session) nextFileNum() int64 {
+	if s.parent != nil {
+		return s.parent.nextFileNum()
+	}
 	return atomic.LoadInt64(&s.stNextFileNum)
 }
 
 // Set current unused file number to num.
 func (s *session) setNextFileNum(num int64) {
+	if s.parent != nil {
+		s.parent.setNextFileNum(num)
+		return
+	}
 	atomic.StoreInt64(&s.stNextFileNum, num)
 }
 
 // Mark file number as used.
 func (s *session) markFileNum(num int64) {
+	if s.parent != nil {
+		s.parent.markFileNum(num)
+		return
+	}
 	nextFileNum := num + 1
 	for {
 		old, x := atomic.LoadInt64(&s.stNextFileNum), nextFileNum
@@ -321,11 +335,21 @@ func (s *session) markFileNum(num int64) {
 
 // Allocate a file number.
 func (s *session) allocFileNum() int64 {
+	if s.parent != nil {
+		return s.parent.allocFileNum()
+	}
 	return atomic.AddInt64(&s.stNextFileNum, 1) - 1
 }
 
 // Reuse given file number.
 func (s *session) reuseFileNum(num int64) {
+	if s.parent != nil {
+		s.parent.reuseFileNum(num)
+		return
+	}
+	if s.stor.removeFrozen() {
+		return
+	}
 	for {
 		old, x := atomic.LoadInt64(&s.stNextFileNum), num
 		if old != x+1 {
@@ -363,8 +387,16 @@ func (s *session) fillRecord(r *sessionRecord, snapshot bool) {
 	r.setNextFileNum(s.nextFileNum())
 
 	if snapshot {
-		if !r.has(recJournalNum) {
-			r.setJournalNum(s.stJournalNum)
+		if s.parent != nil {
+			r.setColumnFamily(s.cfID)
+			r.addColumnFamily(s.cfName)
+		} else {
+			if !r.has(recJournalNum) {
+				r.setJournalNum(s.stJournalNum)
+			}
+			if s.stMaxColumnFamily > 0 {
+				r.setMaxColumnFamily(s.stMaxColumnFamily)
+			}
 		}
 
 		if !r.has(recSeqNum) {
@@ -396,6 +428,10 @@ func (s *session) recordCommited(rec *sessionRecord) {
 		s.stSeqNum = rec.seqNum
 	}
 
+	if rec.has(recMaxColumnFamily) && rec.maxColumnFamily > s.stMaxColumnFamily {
+		s.stMaxColumnFamily = rec.maxColumnFamily
+	}
+
 	for _, r := range rec.compPtrs {
 		s.setCompPtr(r.level, r.ikey)
 	}
@@ -453,6 +489,12 @@ func (s *session) newManifest(rec *sessionRecord, v *version) (err error) {
 	if err != nil {
 		return
 	}
+	for _, fs := range s.liveFamilies() {
+		err = fs.writeSnapshot(jw)
+		if err != nil {
+			return
+		}
+	}
 	err = jw.Flush()
 	if err != nil {
 		return
@@ -467,9 +509,56 @@ func (s *session) newManifest(rec *sessionRecord, v *version) (err error) {
 	return
 }
 
+// Write column family snapshot record into the given manifest journal; need
+// external synchronization.
+func (s *session) writeSnapshot(jw *journal.Writer) error {
+	v := s.version()
+	defer v.release()
+
+	rec := &sessionRecord{}
+	s.fillRecord(rec, true)
+	v.fillRecord(rec)
+
+	w, err := jw.Next()
+	if err != nil {
+		return err
+	}
+	return rec.encode(w)
+}
+
+// Flush column family record to disk; need external synchronization.
+func (s *session) flushFamilyManifest(fs *session, rec *sessionRecord) (err error) {
+	// Records of dropped column family are no longer persisted.
+	if fs.cfDropped {
+		return
+	}
+	if s.manifest == nil {
+		// manifest journal writer not yet created, create one by
+		// committing an empty record to s
+		if err = s.commit(&sessionRecord{}, false); err != nil {
+			return
+		}
+	}
+	fs.fillRecord(rec, false)
+	rec.setColumnFamily(fs.cfID)
+	if err = s.writeManifest(rec); err != nil {
+		return
+	}
+	fs.recordCommited(rec)
+	return
+}
+
 // Flush record to disk.
 func (s *session) flushManifest(rec *sessionRecord) (err error) {
 	s.fillRecord(rec, false)
+	if err = s.writeManifest(rec); err != nil {
+		return
+	}
+	s.recordCommited(rec)
+	return
+}
+
+func (s *session) writeManifest(rec *sessionRecord) (err error) {
 	w, err := s.manifest.Next()
 	if err != nil {
 		return
@@ -484,10 +573,6 @@ func (s *session) flushManifest(rec *sessionRecord) (err error) {
 	}
 	if !s.o.GetNoSync() {
 		err = s.manifestWriter.Sync()
-		if err != nil {
-			return
-		}
 	}
-	s.recordCommited(rec)
 	return
 }
@@ -1,6 +1,8 @@
 package leveldb
 
 import (
+	"fmt"
+	"sync"
 	"sync/atomic"
 
 	"github.com/3JoB/goleveldb/storage"
@@ -10,6 +12,11 @@ type iStorage struct {
 	storage.Storage
 	read  uint64
 	write uint64
+
+	// File removals are deferred while frozen, see freezeRemove.
+	rmMu      sync.Mutex
+	rmFrozen  int
+	rmPending []storage.FileDesc
 }
 
 func (c *iStorage) Open(fd storage.FileDesc) (storage.Reader, error) {
@@ -22,6 +29,49 @@ func (c *iStorage) Create(fd storage.FileDesc) (storage.Writer, error) {
 	return &iStorageWriter{Writer: w, c: c}, err
 }
 
+func (c *iStorage) Remove(fd storage.FileDesc) error {
+	c.rmMu.Lock()
+	defer c.rmMu.Unlock()
+	if c.rmFrozen > 0 {
+		c.rmPending = append(c.rmPending, fd)
+		return nil
+	}
+	return c.Storage.Remove(fd)
+}
+
+// Freezes file removals, the removals are deferred until the returned
+// function is called.
+func (c *iStorage) freezeRemove() (unfreeze func()) {
+	c.rmMu.Lock()
+	c.rmFrozen++
+	c.rmMu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.rmMu.Lock()
+			defer c.rmMu.Unlock()
+			c.rmFrozen--
+			if c.rmFrozen > 0 {
+				return
+			}
+			for _, fd := range c.rmPending {
+				if err := c.Storage.Remove(fd); err != nil {
+					c.Log(fmt.Sprintf("remove %s: %v (deferred)", fd, err))
+				}
+			}
+			c.rmPending = nil
+		})
+	}
+}
+
+// Returns true if file removals are frozen. File number of a deferred
+// removal must not be reused until it is actually removed.
+func (c *iStorage) removeFrozen() bool {
+	c.rmMu.Lock()
+	defer c.rmMu.Unlock()
+	return c.rmFrozen > 0
+}
+
 func (c *iStorage) reads() uint64 {
 	return atomic.LoadUint64(&c.read)
 }
@@ -547,6 +547,26 @@ func (fs *fileStorage) Remove(fd FileDesc) error {
 	return err
 }
 
+func (fs *fileStorage) Link(fd FileDesc, dir string) error {
+	if !FileDescOk(fd) {
+		return ErrInvalidFile
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.open < 0 {
+		return ErrClosed
+	}
+	newname := filepath.Join(dir, fsGenName(fd))
+	err := os.Link(filepath.Join(fs.path, fsGenName(fd)), newname)
+	if err != nil && fsHasOldName(fd) && os.IsNotExist(err) {
+		if e1 := os.Link(filepath.Join(fs.path, fsGenOldName(fd)), newname); !os.IsNotExist(e1) {
+			err = e1
+		}
+	}
+	return err
+}
+
 func (fs *fileStorage) Rename(oldfd, newfd FileDesc) error {
 	if !FileDescOk(oldfd) || !FileDescOk(newfd) {
 		return ErrInvalidFile
@@ -105,6 +105,43 @@ func TestFileStorage_MetaSetGet(t *testing.T) {
 	os.RemoveAll(temp)
 }
 
+func TestFileStorage_Link(t *testing.T) {
+	temp := tempDir(t)
+	defer os.RemoveAll(temp)
+	fs, err := OpenFile(filepath.Join(temp, "src"), false)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer fs.Close()
+	dst := filepath.Join(temp, "dst")
+	if err := os.Mkdir(dst, 0755); err != nil {
+		t.Fatal("Mkdir: got error: ", err)
+	}
+
+	fd := FileDesc{Type: TypeTable, Num: 1}
+	w, err := fs.Create(fd)
+	if err != nil {
+		t.Fatal("Create: got error: ", err)
+	}
+	if _, err := w.Write([]byte("TEST")); err != nil {
+		t.Fatal("Write: got error: ", err)
+	}
+	w.Close()
+	if err := fs.(Linker).Link(fd, dst); err != nil {
+		t.Fatal("Link: got error: ", err)
+	}
+	if err := fs.(Linker).Link(FileDesc{Type: TypeTable, Num: 2}, dst); !os.IsNotExist(err) {
+		t.Errorf("Link: expect os.ErrNotExist, got %v", err)
+	}
+	if err := fs.Remove(fd); err != nil {
+		t.Fatal("Remove: got error: ", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dst, fsGenName(fd)))
+	if err != nil || string(data) != "TEST" {
+		t.Errorf("ReadFile: got (%q, %v)", data, err)
+	}
+}
+
 func TestFileStorage_Meta(t *testing.T) {
 	type current struct {
 		num      int64
@@ -186,3 +186,13 @@ type Storage interface {
 	// called after the storage has been closed.
 	Close() error
 }
+
+// Linker is implemented by a storage that keeps its files in the file system,
+// such as the storage returned by OpenFile. It allows files to be shared with
+// another file system directory without copying.
+type Linker interface {
+	// Link hard-links file with the given 'file descriptor' into the given
+	// directory, named as a file storage of that directory would name it.
+	// Returns ErrClosed if the underlying storage is closed.
+	Link(fd FileDesc, dir string) error
+}
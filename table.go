@@ -9,8 +9,10 @@ package leveldb
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"sort"
 	"sync/atomic"
+	"time"
 
 	"github.com/3JoB/goleveldb/cache"
 	"github.com/3JoB/goleveldb/iterator"
@@ -26,6 +28,14 @@ type tFile struct {
 	seekLeft   int32
 	size       int64
 	imin, imax internalKey
+
+	// rangeDel is true if the table contains range tombstones.
+	rangeDel bool
+
+	// ctime is the creation time of the table data in unix nanoseconds,
+	// which is the newest one of the compacted tables for compaction output.
+	// Zero if unknown.
+	ctime int64
 }
 
 // Returns true if given key is after largest key of this table.
@@ -79,7 +89,10 @@ func newTableFile(fd storage.FileDesc, size int64, imin, imax internalKey) *tFil
 }
 
 func tableFileFromRecord(r atRecord) *tFile {
-	return newTableFile(storage.FileDesc{Type: storage.TypeTable, Num: r.num}, r.size, r.imin, r.imax)
+	t := newTableFile(storage.FileDesc{Type: storage.TypeTable, Num: r.num}, r.size, r.imin, r.imax)
+	t.rangeDel = r.rangeDel
+	t.ctime = r.ctime
+	return t
 }
 
 // tFiles hold multiple tFile.
@@ -361,24 +374,31 @@ type tOps struct {
 	bcacheMiss  uint64
 }
 
-// Creates an empty table and returns table writer.
-func (t *tOps) create() (*tWriter, error) {
+// Creates an empty table for the given level and returns table writer.
+func (t *tOps) create(level int) (*tWriter, error) {
 	fd := storage.FileDesc{Type: storage.TypeTable, Num: t.s.allocFileNum()}
 	fw, err := t.s.stor.Create(fd)
 	if err != nil {
 		return nil, err
 	}
+	o := t.s.o.Options
+	if c := o.GetCompressionPerLevel(level); c != o.GetCompression() {
+		no := *o
+		no.Compression = c
+		o = &no
+	}
 	return &tWriter{
 		t:  t,
 		fd: fd,
 		w:  fw,
-		tw: table.NewWriter(fw, t.s.o.Options, t.blockBuffer),
+		tw: table.NewWriter(fw, o, t.blockBuffer),
 	}, nil
 }
 
-// Builds table from src iterator.
-func (t *tOps) createFrom(src iterator.Iterator) (f *tFile, n int, err error) {
-	w, err := t.create()
+// Builds table for the given level from src iterator. The optional rdels
+// iterator holds range tombstones to be written along with src.
+func (t *tOps) createFrom(src, rdels iterator.Iterator, level int) (f *tFile, n int, err error) {
+	w, err := t.create(level)
 	if err != nil {
 		return
 	}
@@ -401,6 +421,18 @@ func (t *tOps) createFrom(src iterator.Iterator) (f *tFile, n int, err error) {
 	if err != nil {
 		return
 	}
+	if rdels != nil {
+		for rdels.Next() {
+			err = w.appendRangeDel(rdels.Key(), rdels.Value())
+			if err != nil {
+				return
+			}
+		}
+		err = rdels.Error()
+		if err != nil {
+			return
+		}
+	}
 
 	n = w.tw.EntriesLen()
 	f, err = w.finish()
@@ -439,6 +471,25 @@ func (t *tOps) open(f *tFile) (ch *cache.Handle, err error) {
 	return
 }
 
+// Copies raw content of the given table into a file of the same
+// 'file descriptor' in dst.
+func (t *tOps) copyTo(f *tFile, dst storage.Storage) error {
+	ch, err := t.open(f)
+	if err != nil {
+		return err
+	}
+	defer ch.Release()
+	w, err := dst.Create(f.fd)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if _, err := io.Copy(w, io.NewSectionReader(ch.Value().(*table.Reader), 0, f.size)); err != nil {
+		return err
+	}
+	return w.Sync()
+}
+
 // Finds key/value pair whose key is greater than or equal to the
 // given key.
 func (t *tOps) find(f *tFile, key []byte, ro *opt.ReadOptions) (rkey, rvalue []byte, err error) {
@@ -476,7 +527,40 @@ func (t *tOps) newIterator(f *tFile, slice *util.Range, ro *opt.ReadOptions) ite
 	if err != nil {
 		return iterator.NewEmptyIterator(err)
 	}
-	iter := ch.Value().(*table.Reader).NewIterator(slice, ro)
+	// Skip the table if its range filter excludes the slice.
+	if !ch.Value().(*table.Reader).MayContainRange(slice, ro) {
+		ch.Release()
+		return iterator.NewEmptyIterator(nil)
+	}
+	var iter iterator.Iterator
+	if prefix := t.rangePrefix(slice); prefix != nil {
+		iter = ch.Value().(*table.Reader).NewPrefixIterator(prefix, slice, ro)
+	} else {
+		iter = ch.Value().(*table.Reader).NewIterator(slice, ro)
+	}
+	iter.SetReleaser(ch)
+	return iter
+}
+
+// Returns the prefix shared by all keys within the given range, if the
+// tables are written with a prefix extractor.
+func (t *tOps) rangePrefix(slice *util.Range) []byte {
+	if e, ok := t.s.o.GetPrefixExtractor().(*iPrefixExtractor); ok && t.s.o.GetFilter() != nil {
+		return e.rangePrefix(slice)
+	}
+	return nil
+}
+
+// Creates a range tombstones iterator from the given table.
+func (t *tOps) newRangeDelIterator(f *tFile, ro *opt.ReadOptions) iterator.Iterator {
+	if !f.rangeDel {
+		return iterator.NewEmptyIterator(nil)
+	}
+	ch, err := t.open(f)
+	if err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	iter := ch.Value().(*table.Reader).NewRangeDelIterator(ro)
 	iter.SetReleaser(ch)
 	return iter
 }
@@ -484,6 +568,12 @@ func (t *tOps) newIterator(f *tFile, slice *util.Range, ro *opt.ReadOptions) ite
 // Removes table from persistent storage. It waits until
 // no one use the the table.
 func (t *tOps) remove(fd storage.FileDesc) {
+	t.removeFile(fd, true)
+}
+
+// Removes table from persistent storage, optionally allowing its file num to
+// be reused.
+func (t *tOps) removeFile(fd storage.FileDesc, reuse bool) {
 	t.fileCache.Delete(0, uint64(fd.Num), func() {
 		if err := t.s.stor.Remove(fd); err != nil {
 			t.s.logf("table@remove removing @%d %q", fd.Num, err)
@@ -494,13 +584,19 @@ func (t *tOps) remove(fd storage.FileDesc) {
 			t.blockCache.EvictNS(uint64(fd.Num))
 		}
 		// Try to reuse file num, useful for discarded transaction.
-		t.s.reuseFileNum(fd.Num)
+		if reuse {
+			t.s.reuseFileNum(fd.Num)
+		}
 	})
 }
 
 // Closes the table ops instance. It will close all tables,
 // regadless still used or not.
 func (t *tOps) close() {
+	if t.s.parent != nil {
+		// The caches are owned by the DB.
+		return
+	}
 	t.fileCache.Close(true)
 	if t.blockCache != nil {
 		t.blockCache.Close(false)
@@ -514,6 +610,20 @@ func newTableOps(s *session) *tOps {
 		blockCache  *cache.Cache
 		blockBuffer *util.BufferPool
 	)
+	if !s.o.GetDisableBufferPool() {
+		blockBuffer = util.NewBufferPool(s.o.GetBlockSize() * 2)
+	}
+	// Column families share the caches of the DB.
+	if s.parent != nil {
+		return &tOps{
+			s:            s,
+			noSync:       s.o.GetNoSync(),
+			evictRemoved: s.o.GetBlockCacheEvictRemoved(),
+			fileCache:    s.parent.tops.fileCache,
+			blockCache:   s.parent.tops.blockCache,
+			blockBuffer:  blockBuffer,
+		}
+	}
 	if s.o.GetOpenFilesCacheCapacity() > 0 {
 		fileCacher = s.o.GetOpenFilesCacher().New(s.o.GetOpenFilesCacheCapacity())
 	}
@@ -524,9 +634,6 @@ func newTableOps(s *session) *tOps {
 		}
 		blockCache = cache.NewCache(blockCacher)
 	}
-	if !s.o.GetDisableBufferPool() {
-		blockBuffer = util.NewBufferPool(s.o.GetBlockSize() * 2)
-	}
 	return &tOps{
 		s:            s,
 		noSync:       s.o.GetNoSync(),
@@ -547,6 +654,9 @@ type tWriter struct {
 	tw *table.Writer
 
 	first, last []byte
+
+	// Key range covered by range tombstones.
+	rdFirst, rdLast []byte
 }
 
 // Append key/value pair to the table.
@@ -558,9 +668,22 @@ func (w *tWriter) append(key, value []byte) error {
 	return w.tw.Append(key, value)
 }
 
+// Append range tombstone to the table. The tombstone covers user keys from
+// the user key of the given key up to limit.
+func (w *tWriter) appendRangeDel(key, limit []byte) error {
+	icmp := w.t.s.icmp
+	if w.rdFirst == nil {
+		w.rdFirst = append([]byte(nil), key...)
+	}
+	if w.rdLast == nil || icmp.uCompare(limit, internalKey(w.rdLast).ukey()) > 0 {
+		w.rdLast = makeInternalKey(w.rdLast[:0], limit, keyMaxSeq, keyTypeSeek)
+	}
+	return w.tw.AppendRangeDel(key, limit)
+}
+
 // Returns true if the table is empty.
 func (w *tWriter) empty() bool {
-	return w.first == nil
+	return w.first == nil && w.rdFirst == nil
 }
 
 // Closes the storage.Writer.
@@ -595,7 +718,21 @@ func (w *tWriter) finish() (f *tFile, err error) {
 			return
 		}
 	}
-	f = newTableFile(w.fd, int64(w.tw.BytesLen()), internalKey(w.first), internalKey(w.last))
+	imin, imax := w.first, w.last
+	if w.rdFirst != nil {
+		icmp := w.t.s.icmp
+		if imin == nil || icmp.Compare(w.rdFirst, imin) < 0 {
+			imin = w.rdFirst
+		}
+		if imax == nil || icmp.Compare(w.rdLast, imax) > 0 {
+			imax = w.rdLast
+		}
+	}
+	f = newTableFile(w.fd, int64(w.tw.BytesLen()), internalKey(imin), internalKey(imax))
+	f.rangeDel = w.rdFirst != nil
+	if w.t.s.o.GetCompactionStyle() == opt.FIFOCompaction {
+		f.ctime = time.Now().UnixNano()
+	}
 	return
 }
 
@@ -607,6 +744,8 @@ func (w *tWriter) drop() error {
 	w.tw = nil
 	w.first = nil
 	w.last = nil
+	w.rdFirst = nil
+	w.rdLast = nil
 	if err := w.t.s.stor.Remove(w.fd); err != nil {
 		return err
 	}
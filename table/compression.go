@@ -0,0 +1,349 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package table
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+
+	"github.com/3JoB/goleveldb/opt"
+)
+
+// Compressor is a 'sorted table' block compression codec. A compressor is
+// identified by the block type stored in the trailer of each block it
+// encodes, see RegisterCompressor.
+//
+// A compressor must be safe for concurrent use.
+type Compressor interface {
+	// Encode appends the encoded src to dst and returns the resulting
+	// slice. The level is the compression level from the options, zero
+	// means the codec default. It may return nil if src is
+	// incompressible, in which case the block is stored uncompressed.
+	Encode(dst, src []byte, level int) ([]byte, error)
+
+	// DecodedLen returns the length of the decoded src, or zero if it's
+	// unknown. It is used to allocate the buffer given to Decode.
+	DecodedLen(src []byte) (int, error)
+
+	// Decode decodes src into dst, which length is DecodedLen(src), and
+	// returns the decoded block. The returned slice may be dst itself or
+	// a newly allocated slice.
+	Decode(dst, src []byte) ([]byte, error)
+}
+
+// DictCompressor is a Compressor that supports compression dictionaries,
+// see Writer.SetCompressionDict.
+type DictCompressor interface {
+	Compressor
+
+	// TrainDict trains a dictionary of at most size bytes from the given
+	// samples.
+	TrainDict(samples [][]byte, size int) ([]byte, error)
+
+	// WithDict returns a compressor that encodes and decodes blocks using
+	// the given dictionary. The returned compressor may implement
+	// util.Releaser, in which case it is released once it is no longer
+	// used.
+	WithDict(dict []byte) (Compressor, error)
+}
+
+// ErrUnregisteredCompressor is the error type that is returned when a table
+// block references, or the options select, a block type with no registered
+// compressor.
+type ErrUnregisteredCompressor struct {
+	BlockType byte
+}
+
+func (e *ErrUnregisteredCompressor) Error() string {
+	return fmt.Sprintf("leveldb/table: no compressor registered for block type %#x", e.BlockType)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[byte]Compressor{}
+)
+
+// RegisterCompressor registers the compressor for the given block type. The
+// block type is stored in the trailer of each block, so it must never be
+// reused for a different codec. Use opt.CustomCompression to select a
+// registered compressor for writing.
+//
+// RegisterCompressor panics if the block type is already registered, or is
+// the block type of uncompressed blocks.
+func RegisterCompressor(blockType byte, c Compressor) {
+	if c == nil {
+		panic("leveldb/table: register nil compressor")
+	}
+	if blockType == blockTypeNoCompression {
+		panic("leveldb/table: register compressor for uncompressed block type")
+	}
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	if _, dup := compressors[blockType]; dup {
+		panic(fmt.Sprintf("leveldb/table: compressor for block type %#x already registered", blockType))
+	}
+	compressors[blockType] = c
+}
+
+func getCompressor(blockType byte) (Compressor, error) {
+	compressorsMu.RLock()
+	c := compressors[blockType]
+	compressorsMu.RUnlock()
+	if c == nil {
+		return nil, &ErrUnregisteredCompressor{BlockType: blockType}
+	}
+	return c, nil
+}
+
+// Returns the block type of the given compression.
+func compressionBlockType(c opt.Compression) byte {
+	switch c {
+	case opt.SnappyCompression:
+		return blockTypeSnappyCompression
+	case opt.ZstdCompression:
+		return blockTypeZstdCompression
+	case opt.LZ4Compression:
+		return blockTypeLZ4Compression
+	}
+	if blockType, ok := c.Custom(); ok {
+		return blockType
+	}
+	return blockTypeNoCompression
+}
+
+// TrainCompressionDict trains a compression dictionary of at most size bytes
+// for the given compression from the given samples. It returns nil if the
+// compressor of the given compression doesn't support dictionaries.
+func TrainCompressionDict(c opt.Compression, samples [][]byte, size int) ([]byte, error) {
+	blockType := compressionBlockType(c)
+	if blockType == blockTypeNoCompression {
+		return nil, nil
+	}
+	comp, err := getCompressor(blockType)
+	if err != nil {
+		return nil, err
+	}
+	dc, ok := comp.(DictCompressor)
+	if !ok {
+		return nil, nil
+	}
+	return dc.TrainDict(samples, size)
+}
+
+func init() {
+	RegisterCompressor(blockTypeSnappyCompression, snappyCompressor{})
+	RegisterCompressor(blockTypeZstdCompression, &zstdCompressor{encoders: map[zstd.EncoderLevel]*zstd.Encoder{}})
+	RegisterCompressor(blockTypeLZ4Compression, lz4Compressor{})
+}
+
+// Grows dst to have room for at least n more bytes.
+func growScratch(dst []byte, n int) []byte {
+	if cap(dst)-len(dst) < n {
+		ndst := make([]byte, len(dst), len(dst)+n)
+		copy(ndst, dst)
+		dst = ndst
+	}
+	return dst
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Encode(dst, src []byte, level int) ([]byte, error) {
+	dst = growScratch(dst, snappy.MaxEncodedLen(len(src)))
+	encoded := snappy.Encode(dst[len(dst):cap(dst)], src)
+	return dst[:len(dst)+len(encoded)], nil
+}
+
+func (snappyCompressor) DecodedLen(src []byte) (int, error) {
+	return snappy.DecodedLen(src)
+}
+
+func (snappyCompressor) Decode(dst, src []byte) ([]byte, error) {
+	return snappy.Decode(dst, src)
+}
+
+var errZstdLength = errors.New("zstd: invalid decoded length")
+
+// zstdMaxRatio is the maximum ratio of decoded to encoded lengths of a zstd
+// frame; at best a 128KiB block is encoded as a 4-byte RLE block.
+const zstdMaxRatio = 128 << 10 / 4
+
+type zstdCompressor struct {
+	// The dictionary, if any, used by the encoders and the decoder.
+	dict []byte
+
+	// Encoders keyed by encoder level. An encoder is safe for concurrent
+	// use with EncodeAll.
+	mu       sync.Mutex
+	encoders map[zstd.EncoderLevel]*zstd.Encoder
+
+	decoderOnce sync.Once
+	decoder     *zstd.Decoder
+	decoderErr  error
+}
+
+func (c *zstdCompressor) getEncoder(level int) (*zstd.Encoder, error) {
+	elevel := zstd.SpeedDefault
+	if level != 0 {
+		elevel = zstd.EncoderLevelFromZstd(level)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if enc, ok := c.encoders[elevel]; ok {
+		return enc, nil
+	}
+	opts := []zstd.EOption{zstd.WithEncoderLevel(elevel), zstd.WithEncoderConcurrency(1)}
+	if c.dict != nil {
+		opts = append(opts, zstd.WithEncoderDict(c.dict))
+	}
+	enc, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.encoders[elevel] = enc
+	return enc, nil
+}
+
+func (c *zstdCompressor) getDecoder() (*zstd.Decoder, error) {
+	c.decoderOnce.Do(func() {
+		opts := []zstd.DOption{zstd.WithDecoderConcurrency(0)}
+		if c.dict != nil {
+			opts = append(opts, zstd.WithDecoderDicts(c.dict))
+		}
+		c.decoder, c.decoderErr = zstd.NewReader(nil, opts...)
+	})
+	return c.decoder, c.decoderErr
+}
+
+func (c *zstdCompressor) Encode(dst, src []byte, level int) ([]byte, error) {
+	enc, err := c.getEncoder(level)
+	if err != nil {
+		return nil, err
+	}
+	return enc.EncodeAll(src, dst), nil
+}
+
+func (c *zstdCompressor) DecodedLen(src []byte) (int, error) {
+	var h zstd.Header
+	if err := h.Decode(src); err != nil {
+		return 0, err
+	}
+	if !h.HasFCS {
+		return 0, nil
+	}
+	if h.FrameContentSize > uint64(len(src))*zstdMaxRatio || h.FrameContentSize > uint64(maxInt) {
+		return 0, errZstdLength
+	}
+	return int(h.FrameContentSize), nil
+}
+
+func (c *zstdCompressor) Decode(dst, src []byte) ([]byte, error) {
+	dec, err := c.getDecoder()
+	if err != nil {
+		return nil, err
+	}
+	return dec.DecodeAll(src, dst[:0])
+}
+
+func (c *zstdCompressor) TrainDict(samples [][]byte, size int) ([]byte, error) {
+	// The dictionary is built for the default level, since building for
+	// the best compression level is too slow for use during compactions.
+	return dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: size,
+		HashBytes:   6,
+		ZstdLevel:   zstd.SpeedDefault,
+	})
+}
+
+func (c *zstdCompressor) WithDict(d []byte) (Compressor, error) {
+	// Validate the dictionary upfront, the encoders and the decoder are
+	// created lazily.
+	if _, err := zstd.InspectDictionary(d); err != nil {
+		return nil, err
+	}
+	return &zstdCompressor{
+		dict:     d,
+		encoders: map[zstd.EncoderLevel]*zstd.Encoder{},
+	}, nil
+}
+
+// Release closes the encoders and the decoder of the compressor.
+func (c *zstdCompressor) Release() {
+	c.mu.Lock()
+	for level, enc := range c.encoders {
+		enc.Close()
+		delete(c.encoders, level)
+	}
+	c.mu.Unlock()
+	c.decoderOnce.Do(func() {})
+	if c.decoder != nil {
+		c.decoder.Close()
+	}
+}
+
+var errLZ4Length = errors.New("lz4: invalid decoded length")
+
+// lz4MaxRatio is the maximum ratio of decoded to encoded lengths of a lz4
+// block; a match length grows by at most 255 for each encoded byte.
+const lz4MaxRatio = 255
+
+// lz4Compressor encodes a block as its decoded length followed by the lz4
+// compressed block.
+type lz4Compressor struct{}
+
+func (lz4Compressor) Encode(dst, src []byte, level int) ([]byte, error) {
+	dst = growScratch(dst, binary.MaxVarintLen64+lz4.CompressBlockBound(len(src)))
+	o := len(dst)
+	n := binary.PutUvarint(dst[o:cap(dst)], uint64(len(src)))
+	var (
+		m   int
+		err error
+	)
+	if level > 0 {
+		// Levels 1 to 9 select the high compression variant.
+		if level > 9 {
+			level = 9
+		}
+		m, err = lz4.CompressBlockHC(src, dst[o+n:cap(dst)], lz4.CompressionLevel(1<<(8+level)), nil, nil)
+	} else {
+		m, err = lz4.CompressBlock(src, dst[o+n:cap(dst)], nil)
+	}
+	if err != nil || m == 0 {
+		return nil, err
+	}
+	return dst[:o+n+m], nil
+}
+
+func (lz4Compressor) DecodedLen(src []byte) (int, error) {
+	x, n := binary.Uvarint(src)
+	if n <= 0 || x > uint64(len(src)-n)*lz4MaxRatio || x > uint64(maxInt) {
+		return 0, errLZ4Length
+	}
+	return int(x), nil
+}
+
+func (lz4Compressor) Decode(dst, src []byte) ([]byte, error) {
+	_, n := binary.Uvarint(src)
+	m, err := lz4.UncompressBlock(src[n:], dst)
+	if err != nil {
+		return nil, err
+	}
+	if m != len(dst) {
+		return nil, errLZ4Length
+	}
+	return dst, nil
+}
+
+const maxInt = int(^uint(0) >> 1)
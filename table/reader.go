@@ -7,6 +7,7 @@
 package table
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -15,8 +16,6 @@ import (
 	"sync"
 	"sync/atomic"
 
-	"github.com/golang/snappy"
-
 	"github.com/3JoB/goleveldb/cache"
 	"github.com/3JoB/goleveldb/comparer"
 	"github.com/3JoB/goleveldb/errors"
@@ -60,9 +59,17 @@ type block struct {
 	data           []byte
 	restartsLen    int
 	restartsOffset int
+
+	// Hash index buckets, nil if the block has no hash index.
+	hashBuckets []byte
 }
 
 func (b *block) seek(cmp comparer.Comparer, rstart, rlimit int, key []byte) (index, offset int, err error) {
+	if b.restartsOffset == 0 {
+		// The block has no entries, such as the index block of a table
+		// holding range deletions only.
+		return rstart, 0, nil
+	}
 	index = sort.Search(b.restartsLen-rstart-(b.restartsLen-rlimit), func(i int) bool {
 		offset := int(binary.LittleEndian.Uint32(b.data[b.restartsOffset+4*(rstart+i):]))
 		offset++                                    // shared always zero, since this is a restart point
@@ -256,6 +263,47 @@ func (i *blockIter) Seek(key []byte) bool {
 	return false
 }
 
+// Seeks the first entry not less than the key whose user key is the key one,
+// using the hash index of the block if any. It returns absent=true if the
+// block has no such entry, and neither may the next blocks. Otherwise, the
+// entry may be within the next blocks if ok=false. The iterator position is
+// undefined unless ok=true.
+func (i *blockIter) seekUserKey(key, ukey []byte) (ok, absent bool) {
+	if i.err != nil || i.dir == dirReleased || i.block.hashBuckets == nil {
+		return i.Seek(key), false
+	}
+
+	buckets := i.block.hashBuckets
+	ri := int(buckets[blockHash(ukey)%uint32(len(buckets))])
+	switch {
+	case ri == blockHashNoEntry:
+		// The user key isn't within the block, though it may be within the
+		// next block if the key is greater than all keys of the block.
+		if i.Last() && i.tr.cmp.Compare(i.key, key) < 0 {
+			return false, false
+		}
+		return false, i.err == nil
+	case ri >= i.block.restartsLen:
+		// Collision, which is also used for garbage.
+		return i.Seek(key), false
+	}
+
+	i.restartIndex = ri
+	i.offset = i.block.restartOffset(ri)
+	if i.dir == dirSOI || i.dir == dirEOI {
+		i.dir = dirForward
+	}
+	for i.Next() {
+		if i.tr.cmp.Compare(i.key, key) >= 0 {
+			if !bytes.Equal(userKey(i.tr.cmp, i.key), ukey) {
+				return false, true
+			}
+			return true, false
+		}
+	}
+	return false, false
+}
+
 func (i *blockIter) Next() bool {
 	if i.dir == dirEOI || i.err != nil {
 		return false
@@ -468,9 +516,13 @@ type filterBlock struct {
 	oOffset    int
 	baseLg     uint
 	filtersNum int
+	full       bool // the data is a single filter for all keys
 }
 
 func (b *filterBlock) contains(filter filter.Filter, offset uint64, key []byte) bool {
+	if b.full {
+		return filter.Contains(b.data, key)
+	}
 	i := int(offset >> b.baseLg)
 	if i < b.filtersNum {
 		o := b.data[b.oOffset+i*4:]
@@ -485,6 +537,22 @@ func (b *filterBlock) contains(filter filter.Filter, offset uint64, key []byte)
 	return true
 }
 
+// Returns true unless all filters exclude the given key.
+func (b *filterBlock) containsAny(filter filter.Filter, key []byte) bool {
+	if b.full {
+		return filter.Contains(b.data, key)
+	}
+	for i := 0; i < b.filtersNum; i++ {
+		o := b.data[b.oOffset+i*4:]
+		n := int(binary.LittleEndian.Uint32(o))
+		m := int(binary.LittleEndian.Uint32(o[4:]))
+		if n < m && (m > b.oOffset || filter.Contains(b.data[n:m], key)) {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *filterBlock) Release() {
 	b.bpool.Put(b.data)
 	b.bpool = nil
@@ -496,6 +564,10 @@ type indexIter struct {
 	tr    *Reader
 	slice *util.Range
 
+	// Prefix of all keys within the slice, if any; data blocks whose filter
+	// excludes it are skipped.
+	prefix []byte
+
 	// Options
 	fillCache bool
 }
@@ -510,6 +582,10 @@ func (i *indexIter) Get() iterator.Iterator {
 		return iterator.NewEmptyIterator(i.tr.newErrCorruptedBH(i.tr.indexBH, "bad data block handle"))
 	}
 
+	if i.prefix != nil && !i.tr.prefixMayMatch(int64(dataBH.offset), i.prefix, i.fillCache) {
+		return iterator.NewEmptyIterator(nil)
+	}
+
 	var slice *util.Range
 	if i.slice != nil && (i.blockIter.isFirst() || i.blockIter.isLast()) {
 		slice = i.slice
@@ -517,6 +593,71 @@ func (i *indexIter) Get() iterator.Iterator {
 	return i.tr.getDataIterErr(dataBH, slice, i.tr.verifyChecksum, i.fillCache)
 }
 
+// partitionIter iterates the top-level index of a partitioned index.
+type partitionIter struct {
+	*blockIter
+	tr    *Reader
+	slice *util.Range
+
+	// If true, Get returns an iterator over the data blocks of the index
+	// partition. Otherwise it returns an iterator over the index entries of
+	// the partition, which requires the reader lock to be held.
+	data bool
+
+	// Prefix of all keys within the slice, if any; index partitions whose
+	// filter block excludes it are skipped.
+	prefix []byte
+
+	// Options
+	fillCache bool
+	strict    bool
+}
+
+func (i *partitionIter) Get() iterator.Iterator {
+	value := i.Value()
+	if value == nil {
+		return nil
+	}
+	indexBH, filterBH, ok := decodePartitionHandles(value)
+	if !ok {
+		return iterator.NewEmptyIterator(i.tr.newErrCorruptedBH(i.tr.indexBH, "bad index partition handle"))
+	}
+
+	if !i.data {
+		b, rel, err := i.tr.readBlockCached(indexBH, true, i.fillCache)
+		if err != nil {
+			return iterator.NewEmptyIterator(err)
+		}
+		return i.tr.newBlockIter(b, rel, nil, true)
+	}
+
+	if i.prefix != nil && !i.tr.partitionPrefixMayMatch(filterBH, i.prefix, i.fillCache) {
+		return iterator.NewEmptyIterator(nil)
+	}
+
+	var slice *util.Range
+	if i.slice != nil && (i.blockIter.isFirst() || i.blockIter.isLast()) {
+		slice = i.slice
+	}
+	return i.tr.getPartitionIterErr(indexBH, slice, i.fillCache, i.strict)
+}
+
+// Decodes a top-level index value, which is the block handle of the index
+// partition optionally followed by the block handle of its filter block.
+func decodePartitionHandles(value []byte) (indexBH, filterBH blockHandle, ok bool) {
+	indexBH, n := decodeBlockHandle(value)
+	if n == 0 {
+		return
+	}
+	if n < len(value) {
+		var m int
+		if filterBH, m = decodeBlockHandle(value[n:]); m == 0 {
+			return
+		}
+	}
+	return indexBH, filterBH, true
+}
+
 // Reader is a table reader.
 type Reader struct {
 	mu     sync.RWMutex
@@ -531,12 +672,21 @@ type Reader struct {
 
 	cmp            comparer.Comparer
 	filter         filter.Filter
+	prefixed       bool // the filter block holds prefixes too
+	fullFilter     bool // the filter block is a full filter
+	rangeFilter    filter.RangeFilter
 	verifyChecksum bool
 
 	dataEnd                   int64
 	metaBH, indexBH, filterBH blockHandle
+	rangeDelBH                blockHandle
+	dictBH                    blockHandle
+	partitionBH               blockHandle // the region of the index partitions, if any
+	rangeFilterBH             blockHandle
 	indexBlock                *block
 	filterBlock               *filterBlock
+	rangeFilterBlock          *filterBlock
+	compressionDict           *compressionDict
 
 	// Cache statistics
 	cacheTotal *uint64 // The pointer to underlying cache counter passed by tops
@@ -554,6 +704,21 @@ func (r *Reader) blockKind(bh blockHandle) string {
 		if r.filterBH.length > 0 {
 			return "filter-block"
 		}
+	case r.rangeDelBH.offset:
+		if r.rangeDelBH.length > 0 {
+			return "rangedel-block"
+		}
+	case r.dictBH.offset:
+		if r.dictBH.length > 0 {
+			return "compressiondict-block"
+		}
+	case r.rangeFilterBH.offset:
+		if r.rangeFilterBH.length > 0 {
+			return "rangefilter-block"
+		}
+	}
+	if r.partitionBH.length > 0 && bh.offset >= r.partitionBH.offset && bh.offset < r.partitionBH.offset+r.partitionBH.length {
+		return "partition-block"
 	}
 	return "data-block"
 }
@@ -592,26 +757,42 @@ func (r *Reader) readRawBlock(bh blockHandle, verifyChecksum bool) ([]byte, erro
 		}
 	}
 
-	switch data[bh.length] {
-	case blockTypeNoCompression:
+	if blockType := data[bh.length]; blockType == blockTypeNoCompression {
 		data = data[:bh.length]
-	case blockTypeSnappyCompression:
-		decLen, err := snappy.DecodedLen(data[:bh.length])
+	} else {
+		var c Compressor
+		// Only data blocks are compressed using the dictionary.
+		if r.dictBH.length > 0 && bh.offset < uint64(r.dataEnd) {
+			d, rel, err := r.getCompressionDict()
+			if err != nil {
+				r.bpool.Put(data)
+				return nil, err
+			}
+			defer rel.Release()
+			if d.blockType == blockType {
+				c = d.c
+			}
+		}
+		if c == nil {
+			var err error
+			if c, err = getCompressor(blockType); err != nil {
+				r.bpool.Put(data)
+				return nil, err
+			}
+		}
+		decLen, err := c.DecodedLen(data[:bh.length])
 		if err != nil {
 			r.bpool.Put(data)
 			return nil, r.newErrCorruptedBH(bh, err.Error())
 		}
-		decData := r.bpool.Get(decLen)
-		decData, err = snappy.Decode(decData, data[:bh.length])
+		decBuf := r.bpool.Get(decLen)
+		decData, err := c.Decode(decBuf, data[:bh.length])
 		r.bpool.Put(data)
 		if err != nil {
-			r.bpool.Put(decData)
+			r.bpool.Put(decBuf)
 			return nil, r.newErrCorruptedBH(bh, err.Error())
 		}
 		data = decData
-	default:
-		r.bpool.Put(data)
-		return nil, r.newErrCorruptedBH(bh, fmt.Sprintf("unknown compression type %#x", data[bh.length]))
 	}
 	return data, nil
 }
@@ -621,13 +802,29 @@ func (r *Reader) readBlock(bh blockHandle, verifyChecksum bool) (*block, error)
 	if err != nil {
 		return nil, err
 	}
-	restartsLen := int(binary.LittleEndian.Uint32(data[len(data)-4:]))
+	n := len(data) - 4
+	restartsLen := binary.LittleEndian.Uint32(data[n:])
+	var hashBuckets []byte
+	if restartsLen&blockHashIndexFlag != 0 {
+		restartsLen &^= blockHashIndexFlag
+		if n < 2 || restartsLen > blockHashMaxRestarts {
+			r.bpool.Put(data)
+			return nil, r.newErrCorruptedBH(bh, "bad hash index")
+		}
+		nBuckets := int(binary.LittleEndian.Uint16(data[n-2:]))
+		if n -= 2 + nBuckets; nBuckets == 0 || n < int(restartsLen)*4 {
+			r.bpool.Put(data)
+			return nil, r.newErrCorruptedBH(bh, "bad hash index")
+		}
+		hashBuckets = data[n : n+nBuckets]
+	}
 	b := &block{
 		bpool:          r.bpool,
 		bh:             bh,
 		data:           data,
-		restartsLen:    restartsLen,
-		restartsOffset: len(data) - (restartsLen+1)*4,
+		restartsLen:    int(restartsLen),
+		restartsOffset: n - int(restartsLen)*4,
+		hashBuckets:    hashBuckets,
 	}
 	return b, nil
 }
@@ -680,6 +877,9 @@ func (r *Reader) readFilterBlock(bh blockHandle) (*filterBlock, error) {
 	if err != nil {
 		return nil, err
 	}
+	if r.fullFilter || bh == r.rangeFilterBH {
+		return &filterBlock{bpool: r.bpool, data: data, full: true}, nil
+	}
 	n := len(data)
 	if n < 5 {
 		return nil, r.newErrCorruptedBH(bh, "too short")
@@ -742,6 +942,87 @@ func (r *Reader) readFilterBlockCached(bh blockHandle, fillCache bool) (*filterB
 	return b, b, err
 }
 
+// compressionDict is a compression dictionary of a table, along with the
+// compressor bound to it.
+type compressionDict struct {
+	blockType byte
+	size      int
+	c         Compressor
+}
+
+func (d *compressionDict) Release() {
+	if r, ok := d.c.(util.Releaser); ok {
+		r.Release()
+	}
+	d.c = nil
+}
+
+func (r *Reader) readCompressionDict(bh blockHandle) (*compressionDict, error) {
+	data, err := r.readRawBlock(bh, true)
+	if err != nil {
+		return nil, err
+	}
+	defer r.bpool.Put(data)
+	if len(data) < 1 {
+		return nil, r.newErrCorruptedBH(bh, "too short")
+	}
+	blockType := data[0]
+	c, err := getCompressor(blockType)
+	if err != nil {
+		return nil, err
+	}
+	dc, ok := c.(DictCompressor)
+	if !ok {
+		return nil, r.newErrCorruptedBH(bh, fmt.Sprintf("compressor for block type %#x doesn't support dictionary", blockType))
+	}
+	// The dictionary is retained by the compressor, so it can't be
+	// backed by the buffer pool.
+	dict := append([]byte(nil), data[1:]...)
+	dictc, err := dc.WithDict(dict)
+	if err != nil {
+		return nil, r.newErrCorruptedBH(bh, err.Error())
+	}
+	return &compressionDict{blockType: blockType, size: len(dict), c: dictc}, nil
+}
+
+// Returns the compression dictionary of the table. The dictionary is loaded
+// once per table, and kept in the cache if any.
+func (r *Reader) getCompressionDict() (*compressionDict, util.Releaser, error) {
+	if r.compressionDict != nil {
+		return r.compressionDict, util.NoopReleaser{}, nil
+	}
+	if r.cache != nil {
+		var err error
+		if r.cacheTotal != nil {
+			atomic.AddUint64(r.cacheTotal, 1)
+		}
+		ch := r.cache.Get(r.dictBH.offset, func() (size int, value cache.Value) {
+			if r.cacheMiss != nil {
+				atomic.AddUint64(r.cacheMiss, 1)
+			}
+			var d *compressionDict
+			d, err = r.readCompressionDict(r.dictBH)
+			if err != nil {
+				return 0, nil
+			}
+			return d.size, d
+		})
+		if ch != nil {
+			d, ok := ch.Value().(*compressionDict)
+			if !ok {
+				ch.Release()
+				return nil, nil, errors.New("leveldb/table: inconsistent block type")
+			}
+			return d, ch, err
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	d, err := r.readCompressionDict(r.dictBH)
+	return d, d, err
+}
+
 func (r *Reader) getIndexBlock(fillCache bool) (b *block, rel util.Releaser, err error) {
 	if r.indexBlock == nil {
 		return r.readBlockCached(r.indexBH, true, fillCache)
@@ -756,6 +1037,155 @@ func (r *Reader) getFilterBlock(fillCache bool) (*filterBlock, util.Releaser, er
 	return r.filterBlock, util.NoopReleaser{}, nil
 }
 
+func (r *Reader) getRangeFilterBlock(fillCache bool) (*filterBlock, util.Releaser, error) {
+	if r.rangeFilterBlock == nil {
+		return r.readFilterBlockCached(r.rangeFilterBH, fillCache)
+	}
+	return r.rangeFilterBlock, util.NoopReleaser{}, nil
+}
+
+// MayContainRange returns false if the range filter of the table excludes
+// any key within the given range. A nil Range.Start is treated as a key
+// before all keys in the table, and a nil Range.Limit as a key after all
+// keys in the table.
+//
+// It returns true if the table isn't written with the range filter of the
+// options.
+func (r *Reader) MayContainRange(slice *util.Range, ro *opt.ReadOptions) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil || r.rangeFilter == nil || slice == nil {
+		return true
+	}
+	filterBlock, rel, err := r.getRangeFilterBlock(!ro.GetDontFillCache())
+	if err != nil {
+		return true
+	}
+	defer rel.Release()
+	return r.rangeFilter.MayContainRange(filterBlock.data, slice.Start, slice.Limit)
+}
+
+// Returns false if the filter of the data block at the given offset, or of
+// any data block if offset is negative, excludes the given prefix.
+func (r *Reader) prefixMayMatch(offset int64, prefix []byte, fillCache bool) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil || r.filter == nil || !r.prefixed {
+		return true
+	}
+	if r.partitionBH.length > 0 && !r.fullFilter {
+		// Checked per index partition instead, see partitionIter.
+		return true
+	}
+	filterBlock, rel, err := r.getFilterBlock(fillCache)
+	if err != nil {
+		return true
+	}
+	defer rel.Release()
+	if offset < 0 {
+		return filterBlock.containsAny(r.filter, prefix)
+	}
+	return filterBlock.contains(r.filter, uint64(offset), prefix)
+}
+
+// Returns false if the filter block of an index partition excludes the
+// given prefix.
+func (r *Reader) partitionPrefixMayMatch(filterBH blockHandle, prefix []byte, fillCache bool) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil || r.filter == nil || !r.prefixed || filterBH.length == 0 {
+		return true
+	}
+	filterBlock, rel, err := r.readFilterBlockCached(filterBH, fillCache)
+	if err != nil {
+		return true
+	}
+	defer rel.Release()
+	return filterBlock.contains(r.filter, 0, prefix)
+}
+
+// Returns false if the filter excludes the given key, which is within the
+// data block of the given handle. The reader lock must be held.
+func (r *Reader) filterMayContain(dataBH blockHandle, key []byte) (bool, error) {
+	var (
+		filterBlock *filterBlock
+		rel         util.Releaser
+		err         error
+		offset      = dataBH.offset
+	)
+	if r.partitionBH.length > 0 && !r.fullFilter {
+		// The filter block is the one of the index partition holding the
+		// data block, which is the partition sought by the key.
+		indexBlock, irel, err := r.getIndexBlock(true)
+		if err != nil {
+			return false, err
+		}
+		index := r.newBlockIter(indexBlock, irel, nil, true)
+		var filterBH blockHandle
+		if index.Seek(key) {
+			_, filterBH, _ = decodePartitionHandles(index.Value())
+		}
+		index.Release()
+		if filterBH.length == 0 {
+			return true, nil
+		}
+		filterBlock, rel, err = r.readFilterBlockCached(filterBH, true)
+		offset = 0
+	} else {
+		filterBlock, rel, err = r.getFilterBlock(true)
+	}
+	if err != nil {
+		if errors.IsCorrupted(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer rel.Release()
+	return filterBlock.contains(r.filter, offset, key), nil
+}
+
+// Returns an iterator over the index entries, whose values are the data
+// block handles. The reader lock must be held.
+func (r *Reader) getIndexIter(fillCache bool) (iterator.Iterator, error) {
+	indexBlock, rel, err := r.getIndexBlock(fillCache)
+	if err != nil {
+		return nil, err
+	}
+	index := r.newBlockIter(indexBlock, rel, nil, true)
+	if r.partitionBH.length == 0 {
+		return index, nil
+	}
+	return iterator.NewIndexedIterator(&partitionIter{
+		blockIter: index,
+		tr:        r,
+		fillCache: fillCache,
+	}, true), nil
+}
+
+func (r *Reader) getPartitionIterErr(indexBH blockHandle, slice *util.Range, fillCache, strict bool) iterator.Iterator {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil {
+		return iterator.NewEmptyIterator(r.err)
+	}
+
+	b, rel, err := r.readBlockCached(indexBH, true, fillCache)
+	if err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	index := &indexIter{
+		blockIter: r.newBlockIter(b, rel, slice, true),
+		tr:        r,
+		slice:     slice,
+		fillCache: fillCache,
+	}
+	return iterator.NewIndexedIterator(index, strict)
+}
+
 func (r *Reader) newBlockIter(b *block, bReleaser util.Releaser, slice *util.Range, inclLimit bool) *blockIter {
 	bi := &blockIter{
 		tr:            r,
@@ -831,6 +1261,23 @@ func (r *Reader) getDataIterErr(dataBH blockHandle, slice *util.Range, verifyChe
 //
 // Also read Iterator documentation of the leveldb/iterator package.
 func (r *Reader) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	return r.newIterator(slice, nil, ro)
+}
+
+// NewPrefixIterator is like NewIterator, except that data blocks whose
+// filter excludes the given prefix are skipped, as is the whole table if
+// all filters exclude it. All keys within the slice must have the prefix.
+//
+// The prefix is as extracted by opt.Options.PrefixExtractor, nothing is
+// skipped if the table isn't written with the same prefix extractor.
+func (r *Reader) NewPrefixIterator(prefix []byte, slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	if !r.prefixMayMatch(-1, prefix, !ro.GetDontFillCache()) {
+		return iterator.NewEmptyIterator(nil)
+	}
+	return r.newIterator(slice, prefix, ro)
+}
+
+func (r *Reader) newIterator(slice *util.Range, prefix []byte, ro *opt.ReadOptions) iterator.Iterator {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -839,17 +1286,50 @@ func (r *Reader) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.It
 	}
 
 	fillCache := !ro.GetDontFillCache()
+	strict := opt.GetStrict(r.o, ro, opt.StrictReader)
 	indexBlock, rel, err := r.getIndexBlock(fillCache)
 	if err != nil {
 		return iterator.NewEmptyIterator(err)
 	}
+	if r.partitionBH.length > 0 {
+		index := &partitionIter{
+			blockIter: r.newBlockIter(indexBlock, rel, slice, true),
+			tr:        r,
+			slice:     slice,
+			data:      true,
+			prefix:    prefix,
+			fillCache: fillCache,
+			strict:    strict,
+		}
+		return iterator.NewIndexedIterator(index, strict)
+	}
 	index := &indexIter{
 		blockIter: r.newBlockIter(indexBlock, rel, slice, true),
 		tr:        r,
 		slice:     slice,
-		fillCache: !ro.GetDontFillCache(),
+		prefix:    prefix,
+		fillCache: fillCache,
 	}
-	return iterator.NewIndexedIterator(index, opt.GetStrict(r.o, ro, opt.StrictReader))
+	return iterator.NewIndexedIterator(index, strict)
+}
+
+// NewRangeDelIterator creates an iterator over the range tombstones of the
+// table. The iterator key is the tombstone start and the value is its limit.
+// An empty iterator is returned if the table has no range tombstones.
+//
+// The returned iterator is not safe for concurrent use and should be released
+// after use.
+func (r *Reader) NewRangeDelIterator(ro *opt.ReadOptions) iterator.Iterator {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil {
+		return iterator.NewEmptyIterator(r.err)
+	}
+	if r.rangeDelBH.length == 0 {
+		return iterator.NewEmptyIterator(nil)
+	}
+	return r.getDataIter(r.rangeDelBH, nil, r.verifyChecksum, !ro.GetDontFillCache())
 }
 
 func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bool) (rkey, value []byte, err error) {
@@ -861,13 +1341,23 @@ func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bo
 		return
 	}
 
-	indexBlock, rel, err := r.getIndexBlock(true)
+	// The full filter is consulted before reading the index.
+	exact := filtered
+	filtered = filtered && r.filter != nil
+	if filtered && r.fullFilter {
+		var ok bool
+		if ok, err = r.filterMayContain(blockHandle{}, key); err != nil {
+			return
+		} else if !ok {
+			return nil, nil, ErrNotFound
+		}
+		filtered = false
+	}
+
+	index, err := r.getIndexIter(true)
 	if err != nil {
 		return
 	}
-	defer rel.Release()
-
-	index := r.newBlockIter(indexBlock, nil, nil, true)
 	defer index.Release()
 
 	if !index.Seek(key) {
@@ -884,21 +1374,29 @@ func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bo
 	}
 
 	// The filter should only used for exact match.
-	if filtered && r.filter != nil {
-		filterBlock, frel, ferr := r.getFilterBlock(true)
-		if ferr == nil {
-			if !filterBlock.contains(r.filter, dataBH.offset, key) {
-				frel.Release()
-				return nil, nil, ErrNotFound
-			}
-			frel.Release()
-		} else if !errors.IsCorrupted(ferr) {
+	if filtered {
+		ok, ferr := r.filterMayContain(dataBH, key)
+		if ferr != nil {
 			return nil, nil, ferr
 		}
+		if !ok {
+			return nil, nil, ErrNotFound
+		}
 	}
 
 	data := r.getDataIter(dataBH, nil, r.verifyChecksum, !ro.GetDontFillCache())
-	if !data.Seek(key) {
+	var ok bool
+	if bi, isBlock := data.(*blockIter); isBlock && exact {
+		// The hash index should only used for exact match as well.
+		var absent bool
+		if ok, absent = bi.seekUserKey(key, userKey(r.cmp, key)); absent {
+			data.Release()
+			return nil, nil, ErrNotFound
+		}
+	} else {
+		ok = data.Seek(key)
+	}
+	if !ok {
 		data.Release()
 		if err = data.Error(); err != nil {
 			return
@@ -948,7 +1446,9 @@ func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bo
 // such pair.
 // If filtered is true then the nearest 'block' will be checked against
 // 'filter data' (if present) and will immediately return ErrNotFound if
-// 'filter data' indicates that such pair doesn't exist.
+// 'filter data' indicates that such pair doesn't exist. Likewise, the data
+// block hash index (if present) is then used, which also returns
+// ErrNotFound if no key of the block shares the user key of the given key.
 //
 // The caller may modify the contents of the returned slice as it is its
 // own copy.
@@ -961,7 +1461,8 @@ func (r *Reader) Find(key []byte, filtered bool, ro *opt.ReadOptions) (rkey, val
 // It returns ErrNotFound if the table doesn't contain such key.
 // If filtered is true then the nearest 'block' will be checked against
 // 'filter data' (if present) and will immediately return ErrNotFound if
-// 'filter data' indicates that such key doesn't exist.
+// 'filter data' indicates that such key doesn't exist. Likewise, the data
+// block hash index (if present) is then used.
 //
 // The caller may modify the contents of the returned slice as it is its
 // own copy.
@@ -1006,13 +1507,10 @@ func (r *Reader) OffsetOf(key []byte) (offset int64, err error) {
 		return
 	}
 
-	indexBlock, rel, err := r.readBlockCached(r.indexBH, true, true)
+	index, err := r.getIndexIter(true)
 	if err != nil {
 		return
 	}
-	defer rel.Release()
-
-	index := r.newBlockIter(indexBlock, nil, nil, true)
 	defer index.Release()
 	if index.Seek(key) {
 		dataBH, n := decodeBlockHandle(index.Value())
@@ -1030,6 +1528,18 @@ func (r *Reader) OffsetOf(key []byte) (offset int64, err error) {
 	return
 }
 
+// ReadAt reads raw bytes of the table file at the given offset, it
+// implements io.ReaderAt.
+func (r *Reader) ReadAt(p []byte, off int64) (n int, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.reader == nil {
+		return 0, ErrReaderReleased
+	}
+	return r.reader.ReadAt(p, off)
+}
+
 // Release implements util.Releaser.
 // It also close the file if it is an io.Closer.
 func (r *Reader) Release() {
@@ -1047,6 +1557,14 @@ func (r *Reader) Release() {
 		r.filterBlock.Release()
 		r.filterBlock = nil
 	}
+	if r.rangeFilterBlock != nil {
+		r.rangeFilterBlock.Release()
+		r.rangeFilterBlock = nil
+	}
+	if r.compressionDict != nil {
+		r.compressionDict.Release()
+		r.compressionDict = nil
+	}
 	r.reader = nil
 	r.cache = nil
 	r.bpool = nil
@@ -1121,10 +1639,81 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 	metaIter := r.newBlockIter(metaBlock, nil, nil, true)
 	for metaIter.Next() {
 		key := string(metaIter.Key())
-		if !strings.HasPrefix(key, "filter.") {
+		if key == rangeDelBlockName {
+			rangeDelBH, n := decodeBlockHandle(metaIter.Value())
+			if n == 0 {
+				r.err = r.newErrCorruptedBH(r.metaBH, "bad rangedel block handle")
+				break
+			}
+			r.rangeDelBH = rangeDelBH
+			// Update data end.
+			if int64(rangeDelBH.offset) < r.dataEnd {
+				r.dataEnd = int64(rangeDelBH.offset)
+			}
+			continue
+		}
+		if key == compressionDictBlockName {
+			dictBH, n := decodeBlockHandle(metaIter.Value())
+			if n == 0 {
+				r.err = r.newErrCorruptedBH(r.metaBH, "bad compression dictionary block handle")
+				break
+			}
+			r.dictBH = dictBH
+			// Update data end.
+			if int64(dictBH.offset) < r.dataEnd {
+				r.dataEnd = int64(dictBH.offset)
+			}
+			continue
+		}
+		if key == partitionedIndexName {
+			partitionBH, n := decodeBlockHandle(metaIter.Value())
+			if n == 0 {
+				r.err = r.newErrCorruptedBH(r.metaBH, "bad partitioned index handle")
+				break
+			}
+			r.partitionBH = partitionBH
+			// Update data end.
+			if int64(partitionBH.offset) < r.dataEnd {
+				r.dataEnd = int64(partitionBH.offset)
+			}
+			continue
+		}
+		if strings.HasPrefix(key, rangeFilterName) {
+			rangeFilterBH, n := decodeBlockHandle(metaIter.Value())
+			if n == 0 {
+				continue
+			}
+			if rf := o.GetRangeFilter(); rf != nil && rf.Name() == key[len(rangeFilterName):] {
+				r.rangeFilter = rf
+				r.rangeFilterBH = rangeFilterBH
+			}
+			// Update data end.
+			if int64(rangeFilterBH.offset) < r.dataEnd {
+				r.dataEnd = int64(rangeFilterBH.offset)
+			}
+			continue
+		}
+		if strings.HasPrefix(key, prefixExtractorName) {
+			if pe := o.GetPrefixExtractor(); pe != nil && pe.Name() == key[len(prefixExtractorName):] {
+				r.prefixed = true
+			}
+			continue
+		}
+		if r.filter != nil {
+			continue
+		}
+		var fn string
+		switch {
+		case strings.HasPrefix(key, "filter."):
+			fn = key[7:]
+		case strings.HasPrefix(key, fullFilterName):
+			fn = key[len(fullFilterName):]
+		case strings.HasPrefix(key, partitionedFilterName):
+			// The filter blocks are found through the top-level index.
+			fn = key[len(partitionedFilterName):]
+		default:
 			continue
 		}
-		fn := key[7:]
 		if f0 := o.GetFilter(); f0 != nil && f0.Name() == fn {
 			r.filter = f0
 		} else {
@@ -1135,19 +1724,24 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 				}
 			}
 		}
-		if r.filter != nil {
+		if r.filter != nil && !strings.HasPrefix(key, partitionedFilterName) {
 			filterBH, n := decodeBlockHandle(metaIter.Value())
 			if n == 0 {
 				continue
 			}
+			r.fullFilter = strings.HasPrefix(key, fullFilterName)
 			r.filterBH = filterBH
 			// Update data end.
-			r.dataEnd = int64(filterBH.offset)
-			break
+			if int64(filterBH.offset) < r.dataEnd {
+				r.dataEnd = int64(filterBH.offset)
+			}
 		}
 	}
 	metaIter.Release()
 	metaBlock.Release()
+	if r.err != nil {
+		return r, nil
+	}
 
 	// Cache index and filter block locally, since we don't have global cache.
 	if cache == nil {
@@ -1159,7 +1753,28 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 			}
 			return nil, err
 		}
-		if r.filter != nil {
+		if r.dictBH.length > 0 {
+			r.compressionDict, err = r.readCompressionDict(r.dictBH)
+			if err != nil {
+				if errors.IsCorrupted(err) {
+					r.err = err
+					return r, nil
+				}
+				return nil, err
+			}
+		}
+		if r.rangeFilter != nil {
+			r.rangeFilterBlock, err = r.readFilterBlock(r.rangeFilterBH)
+			if err != nil {
+				if !errors.IsCorrupted(err) {
+					return nil, err
+				}
+
+				// Don't use range filter then.
+				r.rangeFilter = nil
+			}
+		}
+		if r.filter != nil && (r.partitionBH.length == 0 || r.fullFilter) {
 			r.filterBlock, err = r.readFilterBlock(r.filterBH)
 			if err != nil {
 				if !errors.IsCorrupted(err) {
@@ -9,6 +9,9 @@ package table
 
 import (
 	"encoding/binary"
+
+	"github.com/3JoB/goleveldb/comparer"
+	"github.com/3JoB/goleveldb/util"
 )
 
 /*
@@ -22,14 +25,34 @@ keep record of data blocks offset and length, index block use one as
 restart interval. The key used by index block are the last key of preceding
 block, shorter separator of adjacent blocks or shorter successor of the
 last key of the last block. Filter block is an optional block contains
-sequence of filter data generated by a filter generator.
+sequence of filter data generated by a filter generator. Range deletion
+block is an optional block contains range tombstones, it use the same
+format as data block and is recorded in the metaindex block as "rangedel".
+Compression dictionary block is an optional uncompressed block contains the
+block type of data blocks compressed using the dictionary followed by the
+dictionary itself, it is recorded in the metaindex block as "compressiondict".
+The index may be partitioned, in which case the index block is a top-level
+index over index partitions, each one an index block over some of the data
+blocks. Its values are the block handle of the index partition, followed by
+the block handle of the filter block of the partition keys if any. Index
+partitions and their filter blocks are written after the dictionary block,
+the region they span is recorded in the metaindex block as "partitionedindex",
+and also as "partitionedfilter." followed by the filter name if any.
+The filter block may instead be a full filter block, which is a single filter
+generated from all keys of the table. It is recorded in the metaindex block
+as "fullfilter." followed by the filter name, and takes precedence over the
+filter blocks within the index partitions.
+Range filter block is an optional uncompressed block generated by a range
+filter from all keys of the table, it is written after the filter block and
+is recorded in the metaindex block as "rangefilter." followed by the range
+filter name.
 
 Table data structure:
-                                                         + optional
-                                                        /
-    +--------------+--------------+--------------+------+-------+-----------------+-------------+--------+
-    | data block 1 |      ...     | data block n | filter block | metaindex block | index block | footer |
-    +--------------+--------------+--------------+--------------+-----------------+-------------+--------+
+                                                                      + optional
+                                                                     /
+    +--------------+--------------+--------------+-----------------+------------------+--------------+-----------------+-------------+--------+
+    | data block 1 |      ...     | data block n | rangedel block  | dictionary block | filter block | metaindex block | index block | footer |
+    +--------------+--------------+--------------+-----------------+------------------+--------------+-----------------+-------------+--------+
 
     Each block followed by a 5-bytes trailer contains compression type and checksum.
 
@@ -111,6 +134,21 @@ Block trailer:
     | restart point 1 |       ....      | restart point n | restart points len (4-bytes) |
     +-----------------+-----------------+-----------------+------------------------------+
 
+Data block may have a hash index between the restart points and the restart
+points len, in which case the highest bit of the restart points len is set.
+The hash index is an array of 1-byte buckets, each one the index of the
+restart point of the first key whose user key hashes to the bucket, 254 if
+the user keys hashing to the bucket are under different restart points or
+255 if none. Blocks without the highest bit set have no hash index.
+
+Data block hash index:
+
+      +-- 1-byte --+
+     /              \
+    +----------------+----------------+----------------+----------------------+
+    |    bucket 1    |      ....      |    bucket n    | buckets len (2-byte) |
+    +----------------+----------------+----------------+----------------------+
+
 
 NOTE: All fixed-length integer are little-endian.
 */
@@ -147,10 +185,52 @@ const (
 
 	magic = "\x57\xfb\x80\x8b\x24\x75\x47\xdb"
 
+	// rangeDelBlockName is the metaindex key of the range deletion block.
+	rangeDelBlockName = "rangedel"
+
+	// compressionDictBlockName is the metaindex key of the compression
+	// dictionary block.
+	compressionDictBlockName = "compressiondict"
+
+	// prefixExtractorName is the metaindex key prefix naming the prefix
+	// extractor whose prefixes are in the filter block.
+	prefixExtractorName = "prefix."
+
+	// partitionedIndexName is the metaindex key of the region spanned by the
+	// index partitions and their filter blocks.
+	partitionedIndexName = "partitionedindex"
+
+	// partitionedFilterName is the metaindex key prefix naming the filter
+	// of the filter blocks within the index partitions.
+	partitionedFilterName = "partitionedfilter."
+
+	// fullFilterName is the metaindex key prefix of the full filter block.
+	fullFilterName = "fullfilter."
+
+	// rangeFilterName is the metaindex key prefix of the range filter block.
+	rangeFilterName = "rangefilter."
+
+	// blockHashIndexFlag is set on the restart points len of data blocks
+	// having a hash index.
+	blockHashIndexFlag = 1 << 31
+
+	// Hash index bucket values other than restart point indexes.
+	blockHashCollision = 254
+	blockHashNoEntry   = 255
+
+	// blockHashMaxRestarts is the maximum number of restart points of data
+	// blocks having a hash index.
+	blockHashMaxRestarts = 253
+
+	// blockHashSeed is the seed of the hash of the hash index user keys.
+	blockHashSeed = 0x7a3c91d5
+
 	// The block type gives the per-block compression format.
 	// These constants are part of the file format and should not be changed.
 	blockTypeNoCompression     = 0
 	blockTypeSnappyCompression = 1
+	blockTypeLZ4Compression    = 4
+	blockTypeZstdCompression   = 7
 )
 
 type blockHandle struct {
@@ -171,3 +251,23 @@ func encodeBlockHandle(dst []byte, b blockHandle) int {
 	m := binary.PutUvarint(dst[n:], b.length)
 	return n + m
 }
+
+// userKeyer is implemented by comparers whose keys are made of a user key
+// followed by a suffix, such as a sequence number. The data block hash index
+// maps user keys, so that all keys sharing a user key are found by a lookup.
+type userKeyer interface {
+	UserKey(key []byte) []byte
+}
+
+// Returns the user key of the key, which is the key itself unless the
+// comparer implements userKeyer.
+func userKey(cmp comparer.Comparer, key []byte) []byte {
+	if uk, ok := cmp.(userKeyer); ok {
+		return uk.UserKey(key)
+	}
+	return key
+}
+
+func blockHash(ukey []byte) uint32 {
+	return util.Hash(ukey, blockHashSeed)
+}
@@ -8,10 +8,15 @@ package table
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/3JoB/goleveldb/cache"
+	"github.com/3JoB/goleveldb/filter"
 	"github.com/3JoB/goleveldb/iterator"
 	"github.com/3JoB/goleveldb/opt"
 	"github.com/3JoB/goleveldb/storage"
@@ -19,6 +24,60 @@ import (
 	"github.com/3JoB/goleveldb/util"
 )
 
+const testBlockType = 0x40
+
+// reverseCompressor reverses bytes of the block.
+type reverseCompressor struct{}
+
+func (reverseCompressor) Encode(dst, src []byte, level int) ([]byte, error) {
+	for i := len(src) - 1; i >= 0; i-- {
+		dst = append(dst, src[i])
+	}
+	return dst, nil
+}
+
+func (reverseCompressor) DecodedLen(src []byte) (int, error) {
+	return len(src), nil
+}
+
+func (reverseCompressor) Decode(dst, src []byte) ([]byte, error) {
+	for i := range src {
+		dst[len(src)-1-i] = src[i]
+	}
+	return dst, nil
+}
+
+func init() {
+	RegisterCompressor(testBlockType, reverseCompressor{})
+}
+
+// versionComparer compares keys made of a user key followed by a 1-byte
+// version, with greater versions first.
+type versionComparer struct{}
+
+func (versionComparer) Compare(a, b []byte) int {
+	if x := bytes.Compare(a[:len(a)-1], b[:len(b)-1]); x != 0 {
+		return x
+	}
+	return int(b[len(b)-1]) - int(a[len(a)-1])
+}
+
+func (versionComparer) Name() string {
+	return "test.VersionComparer"
+}
+
+func (versionComparer) Separator(dst, a, b []byte) []byte {
+	return nil
+}
+
+func (versionComparer) Successor(dst, b []byte) []byte {
+	return nil
+}
+
+func (versionComparer) UserKey(key []byte) []byte {
+	return key[:len(key)-1]
+}
+
 type tableWrapper struct {
 	*Reader
 }
@@ -89,6 +148,647 @@ var _ = testutil.Defer(func() {
 			})
 		})
 
+		Describe("range deletion test", func() {
+			o := &opt.Options{
+				BlockSize:   1024,
+				Compression: opt.NoCompression,
+			}
+
+			It("Should be able to read back range tombstones", func() {
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o, nil)
+				Expect(tw.Append([]byte("k01"), []byte("hello"))).ShouldNot(HaveOccurred())
+				Expect(tw.AppendRangeDel([]byte("k00"), []byte("k02"))).ShouldNot(HaveOccurred())
+				Expect(tw.AppendRangeDel([]byte("k05"), []byte("k09"))).ShouldNot(HaveOccurred())
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+
+				tr, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), storage.FileDesc{}, nil, nil, o, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				iter := tr.NewRangeDelIterator(nil)
+				var got []string
+				for iter.Next() {
+					got = append(got, string(iter.Key())+"-"+string(iter.Value()))
+				}
+				Expect(iter.Error()).ShouldNot(HaveOccurred())
+				iter.Release()
+				Expect(got).Should(Equal([]string{"k00-k02", "k05-k09"}))
+
+				value, err := tr.Get([]byte("k01"), nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(value).Should(Equal([]byte("hello")))
+			})
+
+			It("Should reject out of order range tombstones", func() {
+				tw := NewWriter(&bytes.Buffer{}, o, nil)
+				Expect(tw.AppendRangeDel([]byte("k05"), []byte("k09"))).ShouldNot(HaveOccurred())
+				Expect(tw.AppendRangeDel([]byte("k03"), []byte("k04"))).Should(HaveOccurred())
+			})
+		})
+
+		Describe("prefix filter test", func() {
+			o := &opt.Options{
+				BlockSize:       64,
+				Compression:     opt.NoCompression,
+				Filter:          filter.NewBloomFilter(10),
+				FilterBaseLg:    6,
+				PrefixExtractor: filter.NewFixedPrefix(2),
+			}
+			Build := func() *Reader {
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o, nil)
+				for _, prefix := range []string{"aa", "cc"} {
+					for i := 0; i < 20; i++ {
+						key := []byte(fmt.Sprintf("%s%02d", prefix, i))
+						Expect(tw.Append(key, []byte("v"))).ShouldNot(HaveOccurred())
+					}
+				}
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+				tr, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), storage.FileDesc{}, nil, nil, o, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				return tr
+			}
+			Count := func(iter iterator.Iterator) (n int) {
+				for iter.Next() {
+					n++
+				}
+				Expect(iter.Error()).ShouldNot(HaveOccurred())
+				iter.Release()
+				return
+			}
+
+			It("Should be able to iterate keys with a prefix", func() {
+				tr := Build()
+				Expect(Count(tr.NewPrefixIterator([]byte("aa"), util.BytesPrefix([]byte("aa")), nil))).Should(Equal(20))
+				Expect(Count(tr.NewPrefixIterator([]byte("cc"), util.BytesPrefix([]byte("cc")), nil))).Should(Equal(20))
+			})
+
+			It("Should skip tables and blocks whose filter excludes the prefix", func() {
+				tr := Build()
+				Expect(Count(tr.NewIterator(nil, nil))).Should(Equal(40))
+				Expect(Count(tr.NewPrefixIterator([]byte("bb"), nil, nil))).Should(Equal(0))
+				Expect(tr.prefixMayMatch(-1, []byte("bb"), true)).Should(BeFalse())
+			})
+
+			It("Should not skip without matching prefix extractor", func() {
+				tr := Build()
+				tr.prefixed = false
+				Expect(Count(tr.NewPrefixIterator([]byte("bb"), nil, nil))).Should(Equal(40))
+			})
+		})
+
+		Describe("partitioned index test", func() {
+			o := &opt.Options{
+				BlockSize:          64,
+				Compression:        opt.NoCompression,
+				Filter:             filter.NewBloomFilter(10),
+				IndexPartitionSize: 64,
+				PrefixExtractor:    filter.NewFixedPrefix(2),
+			}
+			Build := func(o *opt.Options) []byte {
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o, nil)
+				for _, prefix := range []string{"aa", "cc", "ee"} {
+					for i := 0; i < 50; i++ {
+						key := []byte(fmt.Sprintf("%s%02d", prefix, i))
+						Expect(tw.Append(key, key)).ShouldNot(HaveOccurred())
+					}
+				}
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+				return buf.Bytes()
+			}
+			data := Build(o)
+			Open := func(ns *cache.NamespaceGetter, cacheTotal, cacheMiss *uint64) *Reader {
+				tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, ns, nil, o, cacheTotal, cacheMiss)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(tr.partitionBH.length).ShouldNot(BeZero())
+				return tr
+			}
+			Keys := func(iter iterator.Iterator) (keys []string) {
+				for iter.Next() {
+					keys = append(keys, string(iter.Key()))
+				}
+				Expect(iter.Error()).ShouldNot(HaveOccurred())
+				iter.Release()
+				return
+			}
+
+			It("Should be able to read back keys", func() {
+				c := cache.NewCache(cache.NewLRU(1 << 20))
+				defer c.Close(true)
+				for _, ns := range []*cache.NamespaceGetter{nil, {Cache: c, NS: 1}} {
+					tr := Open(ns, nil, nil)
+					Expect(Keys(tr.NewIterator(nil, nil))).Should(HaveLen(150))
+					Expect(Keys(tr.NewIterator(&util.Range{Start: []byte("aa45"), Limit: []byte("cc05")}, nil))).Should(Equal([]string{
+						"aa45", "aa46", "aa47", "aa48", "aa49", "cc00", "cc01", "cc02", "cc03", "cc04",
+					}))
+					iter := tr.NewIterator(nil, nil)
+					Expect(iter.Last()).Should(BeTrue())
+					Expect(iter.Key()).Should(Equal([]byte("ee49")))
+					Expect(iter.Seek([]byte("cc"))).Should(BeTrue())
+					Expect(iter.Prev()).Should(BeTrue())
+					Expect(iter.Key()).Should(Equal([]byte("aa49")))
+					iter.Release()
+
+					rkey, value, err := tr.Find([]byte("cc"), false, nil)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(rkey).Should(Equal([]byte("cc00")))
+					Expect(value).Should(Equal([]byte("cc00")))
+					_, _, err = tr.Find([]byte("ef"), false, nil)
+					Expect(err).Should(Equal(ErrNotFound))
+					for _, prefix := range []string{"aa", "cc", "ee"} {
+						for i := 0; i < 50; i++ {
+							key := []byte(fmt.Sprintf("%s%02d", prefix, i))
+							rkey, _, err := tr.Find(key, true, nil)
+							Expect(err).ShouldNot(HaveOccurred())
+							Expect(rkey).Should(Equal(key))
+						}
+					}
+					_, _, err = tr.Find([]byte("dd00"), true, nil)
+					Expect(err).Should(Equal(ErrNotFound))
+					tr.Release()
+				}
+			})
+
+			It("Should have the same offsets as the single index", func() {
+				o0 := *o
+				o0.Filter = nil
+				data0 := Build(&o0)
+				tr0, err := NewReader(bytes.NewReader(data0), int64(len(data0)), storage.FileDesc{}, nil, nil, &o0, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(tr0.partitionBH.length).ShouldNot(BeZero())
+				o0.IndexPartitionSize = 0
+				data1 := Build(&o0)
+				tr1, err := NewReader(bytes.NewReader(data1), int64(len(data1)), storage.FileDesc{}, nil, nil, &o0, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(tr1.partitionBH.length).Should(BeZero())
+				for _, key := range []string{"", "aa10", "bb", "cc49", "ee30", "zz"} {
+					offset0, err := tr0.OffsetOf([]byte(key))
+					Expect(err).ShouldNot(HaveOccurred())
+					offset1, err := tr1.OffsetOf([]byte(key))
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(offset0).Should(Equal(offset1), "OffsetOf(%q)", key)
+				}
+			})
+
+			It("Should only load the partitions needed", func() {
+				c := cache.NewCache(cache.NewLRU(1 << 20))
+				defer c.Close(true)
+				var cacheTotal, cacheMiss uint64
+				tr := Open(&cache.NamespaceGetter{Cache: c, NS: 1}, &cacheTotal, &cacheMiss)
+				_, _, err := tr.Find([]byte("dd00"), true, nil)
+				Expect(err).Should(Equal(ErrNotFound))
+				// The top-level index, an index partition and its filter block,
+				// but no data block.
+				Expect(cacheMiss).Should(BeEquivalentTo(3))
+				indexBlock, rel, err := tr.getIndexBlock(true)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(len(Keys(tr.newBlockIter(indexBlock, rel, nil, true)))).Should(BeNumerically(">", 3))
+
+				Expect(Keys(tr.NewPrefixIterator([]byte("bb"), util.BytesPrefix([]byte("bb")), nil))).Should(BeEmpty())
+				Expect(Keys(tr.NewPrefixIterator([]byte("cc"), util.BytesPrefix([]byte("cc")), nil))).Should(HaveLen(50))
+				Expect(Keys(tr.NewPrefixIterator([]byte("dd"), nil, nil))).Should(BeEmpty())
+			})
+		})
+
+		Describe("full filter test", func() {
+			Build := func(o *opt.Options) []byte {
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o, nil)
+				for i := 0; i < 1000; i += 2 {
+					key := []byte(fmt.Sprintf("k%04d", i))
+					Expect(tw.Append(key, key)).ShouldNot(HaveOccurred())
+				}
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+				return buf.Bytes()
+			}
+			Open := func(data []byte, o *opt.Options, ns *cache.NamespaceGetter, cacheMiss *uint64) *Reader {
+				tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, ns, nil, o, nil, cacheMiss)
+				Expect(err).ShouldNot(HaveOccurred())
+				return tr
+			}
+			Check := func(tr *Reader) {
+				for i := 0; i < 1000; i++ {
+					key := []byte(fmt.Sprintf("k%04d", i))
+					rkey, _, err := tr.Find(key, true, nil)
+					if i%2 == 0 {
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(rkey).Should(Equal(key))
+					} else if err == nil {
+						Expect(rkey).ShouldNot(Equal(key))
+					}
+				}
+			}
+
+			It("Should be smaller than per-block filters", func() {
+				o := &opt.Options{BlockSize: 256, Filter: filter.NewBloomFilter(10), FilterBaseLg: 8}
+				tr0 := Open(Build(o), o, nil, nil)
+				Expect(tr0.fullFilter).Should(BeFalse())
+				o.FullFilter = true
+				tr := Open(Build(o), o, nil, nil)
+				Expect(tr.fullFilter).Should(BeTrue())
+				Expect(tr.filterBH.length).Should(BeNumerically("<", tr0.filterBH.length))
+				Check(tr)
+			})
+
+			It("Should consult the filter before reading the index block", func() {
+				o := &opt.Options{Filter: filter.NewBloomFilter(10), FullFilter: true}
+				c := cache.NewCache(cache.NewLRU(1 << 20))
+				defer c.Close(true)
+				var cacheMiss uint64
+				tr := Open(Build(o), o, &cache.NamespaceGetter{Cache: c, NS: 1}, &cacheMiss)
+				_, _, err := tr.Find([]byte("k0001"), true, nil)
+				Expect(err).Should(Equal(ErrNotFound))
+				// Only the filter block is read.
+				Expect(cacheMiss).Should(BeEquivalentTo(1))
+				var found int
+				for i := 1; i < 1000; i += 2 {
+					if _, _, err := tr.Find([]byte(fmt.Sprintf("k%04d", i)), true, nil); err != ErrNotFound {
+						found++
+					}
+				}
+				Expect(found).Should(BeNumerically("<", 25))
+			})
+
+			It("Should be able to read either layout through AltFilters", func() {
+				bloom := filter.NewBloomFilter(10)
+				o := &opt.Options{BlockSize: 256, Filter: bloom}
+				o1 := &opt.Options{BlockSize: 256, Filter: bloom, FullFilter: true, IndexPartitionSize: 128}
+				ro := &opt.Options{AltFilters: []filter.Filter{bloom}}
+				for _, data := range [][]byte{Build(o), Build(o1)} {
+					tr := Open(data, ro, nil, nil)
+					Expect(tr.filter).ShouldNot(BeNil())
+					Check(tr)
+				}
+			})
+		})
+
+		Describe("range filter test", func() {
+			o := &opt.Options{
+				BlockSize:   512,
+				RangeFilter: filter.NewTrieRangeFilter(),
+			}
+			Build := func(o *opt.Options) []byte {
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o, nil)
+				for i := 0; i < 100; i++ {
+					key := []byte(fmt.Sprintf("k%03d", i*10))
+					Expect(tw.Append(key, key)).ShouldNot(HaveOccurred())
+				}
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+				return buf.Bytes()
+			}
+			Range := func(start, limit string) *util.Range {
+				return &util.Range{Start: []byte(start), Limit: []byte(limit)}
+			}
+
+			It("Should exclude ranges with no key", func() {
+				c := cache.NewCache(cache.NewLRU(1 << 20))
+				defer c.Close(true)
+				data := Build(o)
+				for _, ns := range []*cache.NamespaceGetter{nil, {Cache: c, NS: 1}} {
+					tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, ns, nil, o, nil, nil)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(tr.rangeFilterBH.length).ShouldNot(BeZero())
+					Expect(tr.MayContainRange(nil, nil)).Should(BeTrue())
+					Expect(tr.MayContainRange(Range("k100", "k101"), nil)).Should(BeTrue())
+					Expect(tr.MayContainRange(Range("k101", "k109"), nil)).Should(BeFalse())
+					Expect(tr.MayContainRange(Range("a", "k"), nil)).Should(BeFalse())
+					Expect(tr.MayContainRange(&util.Range{Start: []byte("k991")}, nil)).Should(BeFalse())
+					Expect(tr.MayContainRange(&util.Range{Limit: []byte("k001")}, nil)).Should(BeTrue())
+					tr.Release()
+				}
+			})
+
+			It("Should not exclude without matching range filter", func() {
+				data := Build(&opt.Options{BlockSize: 512})
+				tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, nil, o, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(tr.MayContainRange(Range("k101", "k109"), nil)).Should(BeTrue())
+				data = Build(o)
+				tr, err = NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, nil, nil, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(tr.MayContainRange(Range("k101", "k109"), nil)).Should(BeTrue())
+				iter := tr.NewIterator(nil, nil)
+				var n int
+				for iter.Next() {
+					n++
+				}
+				Expect(iter.Error()).ShouldNot(HaveOccurred())
+				iter.Release()
+				Expect(n).Should(Equal(100))
+			})
+		})
+
+		Describe("data block hash index test", func() {
+			o := &opt.Options{
+				BlockSize:          512,
+				DataBlockHashIndex: true,
+			}
+			Build := func(o *opt.Options) []byte {
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o, nil)
+				for i := 0; i < 100; i++ {
+					key := []byte(fmt.Sprintf("k%03d", i*10))
+					Expect(tw.Append(key, key)).ShouldNot(HaveOccurred())
+				}
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+				return buf.Bytes()
+			}
+
+			It("Should find existing keys and miss absent ones", func() {
+				data := Build(o)
+				tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, nil, o, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				defer tr.Release()
+				index, err := tr.getIndexIter(true)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(index.First()).Should(BeTrue())
+				bh, _ := decodeBlockHandle(index.Value())
+				index.Release()
+				b, err := tr.readBlock(bh, true)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(b.hashBuckets).ShouldNot(BeNil())
+				b.Release()
+				var nNotFound int
+				for i := 0; i < 1000; i++ {
+					key := []byte(fmt.Sprintf("k%03d", i))
+					rkey, rvalue, err := tr.Find(key, true, nil)
+					if i%10 == 0 {
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(rkey).Should(Equal(key))
+						Expect(rvalue).Should(Equal(key))
+					} else if err == ErrNotFound {
+						nNotFound++
+					} else {
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(bytes.Compare(rkey, key)).Should(BeNumerically(">", 0))
+					}
+
+					// Nearest lookups don't use the hash index.
+					rkey, _, err = tr.Find(key, false, nil)
+					if i < 990 {
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(rkey).Should(Equal([]byte(fmt.Sprintf("k%03d", (i+9)/10*10))))
+					}
+				}
+				Expect(nNotFound).Should(BeNumerically(">", 600))
+			})
+
+			It("Should find all versions of a user key", func() {
+				o := &opt.Options{
+					BlockSize:          256,
+					Comparer:           versionComparer{},
+					DataBlockHashIndex: true,
+				}
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o, nil)
+				for i := 0; i < 100; i++ {
+					for v := 5; v > 0; v-- {
+						key := []byte(fmt.Sprintf("u%03d%c", i, v))
+						Expect(tw.Append(key, bytes.Repeat(key, 4))).ShouldNot(HaveOccurred())
+					}
+				}
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+				Expect(tw.BlocksLen()).Should(BeNumerically(">", 10))
+				data := buf.Bytes()
+				tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, nil, o, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				defer tr.Release()
+				for i := 0; i < 100; i++ {
+					for v := 6; v >= 0; v-- {
+						key := []byte(fmt.Sprintf("u%03d%c", i, v))
+						rkey, _, err := tr.Find(key, true, nil)
+						if v == 0 {
+							if err != ErrNotFound {
+								Expect(err).ShouldNot(HaveOccurred())
+								Expect(rkey[:4]).ShouldNot(Equal(key[:4]))
+							}
+							continue
+						}
+						if v > 5 {
+							v = 5
+						}
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(rkey).Should(Equal([]byte(fmt.Sprintf("u%03d%c", i, v))))
+					}
+				}
+			})
+
+			It("Should be readable with or without the hash index", func() {
+				for _, data := range [][]byte{Build(o), Build(&opt.Options{BlockSize: 512})} {
+					for _, ro := range []*opt.Options{o, nil} {
+						tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, nil, ro, nil, nil)
+						Expect(err).ShouldNot(HaveOccurred())
+						iter := tr.NewIterator(nil, nil)
+						var n int
+						for iter.Next() {
+							Expect(iter.Key()).Should(Equal([]byte(fmt.Sprintf("k%03d", n*10))))
+							n++
+						}
+						Expect(iter.Error()).ShouldNot(HaveOccurred())
+						iter.Release()
+						Expect(n).Should(Equal(100))
+						tr.Release()
+					}
+				}
+			})
+		})
+
+		Describe("metaindex test", func() {
+			It("Should have its entries sorted by key", func() {
+				o := &opt.Options{
+					BlockSize:          64,
+					Compression:        opt.ZstdCompression,
+					Filter:             filter.NewBloomFilter(10),
+					IndexPartitionSize: 64,
+					PrefixExtractor:    filter.NewFixedPrefix(2),
+					RangeFilter:        filter.NewTrieRangeFilter(),
+				}
+				value := func(i int) []byte {
+					return []byte(fmt.Sprintf(`{"id":%d,"name":"user-%d","email":"user-%d@example.com","active":true}`, i, i, i))
+				}
+				var samples [][]byte
+				for i := 0; i < 1000; i++ {
+					samples = append(samples, value(i))
+				}
+				dict, err := TrainCompressionDict(opt.ZstdCompression, samples, 1024)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o, nil)
+				Expect(tw.SetCompressionDict(dict)).ShouldNot(HaveOccurred())
+				for i := 0; i < 100; i++ {
+					Expect(tw.Append([]byte(fmt.Sprintf("k%03d", i)), value(1000+i))).ShouldNot(HaveOccurred())
+				}
+				Expect(tw.AppendRangeDel([]byte("k00"), []byte("k02"))).ShouldNot(HaveOccurred())
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+
+				tr, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), storage.FileDesc{}, nil, nil, o, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				defer tr.Release()
+				metaBlock, err := tr.readBlock(tr.metaBH, true)
+				Expect(err).ShouldNot(HaveOccurred())
+				defer metaBlock.Release()
+				iter := tr.newBlockIter(metaBlock, nil, nil, true)
+				var keys []string
+				for iter.Next() {
+					keys = append(keys, string(iter.Key()))
+				}
+				Expect(iter.Error()).ShouldNot(HaveOccurred())
+				iter.Release()
+				Expect(keys).Should(HaveLen(6))
+				Expect(sort.StringsAreSorted(keys)).Should(BeTrue(), "keys: %v", keys)
+			})
+		})
+
+		Describe("compression test", func() {
+			Build := func(compression opt.Compression, level int) []byte {
+				o := &opt.Options{
+					BlockSize:        512,
+					Compression:      compression,
+					CompressionLevel: level,
+				}
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o, nil)
+				for i := 0; i < 100; i++ {
+					key := []byte(fmt.Sprintf("k%03d", i))
+					Expect(tw.Append(key, bytes.Repeat(key, 20))).ShouldNot(HaveOccurred())
+				}
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+				return buf.Bytes()
+			}
+			Check := func(data []byte) {
+				tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, util.NewBufferPool(512), nil, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				iter := tr.NewIterator(nil, nil)
+				defer iter.Release()
+				var i int
+				for ; iter.Next(); i++ {
+					key := []byte(fmt.Sprintf("k%03d", i))
+					Expect(iter.Key()).Should(Equal(key))
+					Expect(iter.Value()).Should(Equal(bytes.Repeat(key, 20)))
+				}
+				Expect(iter.Error()).ShouldNot(HaveOccurred())
+				Expect(i).Should(Equal(100))
+			}
+
+			It("Should be able to use registered compressor", func() {
+				Check(Build(opt.CustomCompression(testBlockType), 0))
+			})
+
+			It("Should return error on unregistered compressor", func() {
+				tw := NewWriter(&bytes.Buffer{}, &opt.Options{Compression: opt.CustomCompression(0x7f)}, nil)
+				Expect(tw.Append([]byte("k"), []byte("v"))).ShouldNot(HaveOccurred())
+				Expect(tw.Close()).Should(Equal(&ErrUnregisteredCompressor{BlockType: 0x7f}))
+
+				// Retype the index block.
+				data := Build(opt.CustomCompression(testBlockType), 0)
+				footer := data[len(data)-footerLen:]
+				_, n := decodeBlockHandle(footer)
+				bh, _ := decodeBlockHandle(footer[n:])
+				trailer := data[bh.offset+bh.length:]
+				trailer[0] = 0x7f
+				binary.LittleEndian.PutUint32(trailer[1:], util.NewCRC(data[bh.offset:bh.offset+bh.length+1]).Value())
+				_, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, nil, nil, nil, nil)
+				Expect(err).Should(Equal(&ErrUnregisteredCompressor{BlockType: 0x7f}))
+			})
+
+			It("Should be able to use compression dictionary", func() {
+				value := func(i int) []byte {
+					return []byte(fmt.Sprintf(`{"id":%d,"name":"user-%d","email":"user-%d@example.com","active":true}`, i, i, i))
+				}
+				var samples [][]byte
+				for i := 0; i < 1000; i++ {
+					samples = append(samples, value(i))
+				}
+				dict, err := TrainCompressionDict(opt.ZstdCompression, samples, 1024)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(dict).ShouldNot(BeEmpty())
+				dict0, err := TrainCompressionDict(opt.SnappyCompression, samples, 1024)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(dict0).Should(BeNil())
+
+				Expect(NewWriter(&bytes.Buffer{}, &opt.Options{Compression: opt.SnappyCompression}, nil).SetCompressionDict(dict)).Should(HaveOccurred())
+				o := &opt.Options{BlockSize: 512, Compression: opt.ZstdCompression}
+				build := func(dict []byte) []byte {
+					buf := &bytes.Buffer{}
+					tw := NewWriter(buf, o, nil)
+					if dict != nil {
+						Expect(tw.SetCompressionDict(dict)).ShouldNot(HaveOccurred())
+					}
+					for i := 0; i < 100; i++ {
+						Expect(tw.Append([]byte(fmt.Sprintf("k%03d", i)), value(1000+i))).ShouldNot(HaveOccurred())
+					}
+					Expect(tw.SetCompressionDict(dict)).Should(HaveOccurred())
+					Expect(tw.Close()).ShouldNot(HaveOccurred())
+					return buf.Bytes()
+				}
+				data, data0 := build(dict), build(nil)
+				tr0, err := NewReader(bytes.NewReader(data0), int64(len(data0)), storage.FileDesc{}, nil, nil, o, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(tr0.dictBH.length).Should(BeZero())
+				tr0.Release()
+
+				c := cache.NewCache(cache.NewLRU(1 << 20))
+				defer c.Close(true)
+				for _, ns := range []*cache.NamespaceGetter{nil, {Cache: c, NS: 1}} {
+					tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, ns, util.NewBufferPool(512), o, nil, nil)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(tr.dictBH.length).ShouldNot(BeZero())
+					// The data blocks are smaller.
+					Expect(tr.dataEnd).Should(BeNumerically("<", tr0.dataEnd))
+					iter := tr.NewIterator(nil, nil)
+					var i int
+					for ; iter.Next(); i++ {
+						Expect(iter.Key()).Should(Equal([]byte(fmt.Sprintf("k%03d", i))))
+						Expect(iter.Value()).Should(Equal(value(1000 + i)))
+					}
+					Expect(iter.Error()).ShouldNot(HaveOccurred())
+					Expect(i).Should(Equal(100))
+					iter.Release()
+					tr.Release()
+				}
+			})
+
+			It("Should reject decoded lengths beyond the compression ratio", func() {
+				for _, blockType := range []byte{blockTypeZstdCompression, blockTypeLZ4Compression} {
+					c, err := getCompressor(blockType)
+					Expect(err).ShouldNot(HaveOccurred())
+					// Highly compressible blocks are within the ratio.
+					src := make([]byte, 1<<20)
+					enc, err := c.Encode(nil, src, 9)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(c.DecodedLen(enc)).Should(Equal(len(src)))
+				}
+
+				c, _ := getCompressor(blockTypeLZ4Compression)
+				_, err := c.DecodedLen(append(binary.AppendUvarint(nil, 1<<40), 0xf0, 0xff, 0xff))
+				Expect(err).Should(HaveOccurred())
+
+				// A single-segment frame header with a 8-byte content size,
+				// followed by a last RLE block.
+				frame := []byte{0x28, 0xb5, 0x2f, 0xfd, 0xe0}
+				frame = binary.LittleEndian.AppendUint64(frame, 1<<40)
+				frame = append(frame, 0x03, 0x00, 0x01, 0x00)
+				c, _ = getCompressor(blockTypeZstdCompression)
+				_, err = c.DecodedLen(frame)
+				Expect(err).Should(HaveOccurred())
+			})
+
+			for _, c := range []opt.Compression{opt.NoCompression, opt.SnappyCompression, opt.ZstdCompression, opt.LZ4Compression} {
+				for _, level := range []int{0, 9} {
+					c, level := c, level
+					It(fmt.Sprintf("Should be able to read back %v blocks at level %d", c, level), func() {
+						data := Build(c, level)
+						if c != opt.NoCompression {
+							Expect(len(data)).Should(BeNumerically("<", len(Build(opt.NoCompression, 0))))
+						}
+						Check(data)
+					})
+				}
+			}
+		})
+
 		Describe("read test", func() {
 			Build := func(kv testutil.KeyValue) testutil.DB {
 				o := &opt.Options{
@@ -7,12 +7,12 @@
 package table
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-
-	"github.com/golang/snappy"
+	"sort"
 
 	"github.com/3JoB/goleveldb/comparer"
 	lerrs "github.com/3JoB/goleveldb/errors"
@@ -39,6 +39,12 @@ type blockWriter struct {
 	prevKey         []byte
 	restarts        []uint32
 	scratch         []byte
+
+	// If not nil, a hash index of the user keys is appended to the block,
+	// holding the hash and restart point index of each user key.
+	userKey    func(key []byte) []byte
+	keyHashes  []uint32
+	keyRestart []int
 }
 
 func (w *blockWriter) append(key, value []byte) (err error) {
@@ -60,6 +66,15 @@ func (w *blockWriter) append(key, value []byte) (err error) {
 	if _, err = w.buf.Write(value); err != nil {
 		return err
 	}
+	if w.userKey != nil {
+		// Only the first key of each user key is indexed, the lookup then
+		// starts at the first restart point holding the user key.
+		ukey := w.userKey(key)
+		if w.nEntries == 0 || !bytes.Equal(w.userKey(w.prevKey), ukey) {
+			w.keyHashes = append(w.keyHashes, blockHash(ukey))
+			w.keyRestart = append(w.keyRestart, len(w.restarts)-1)
+		}
+	}
 	w.prevKey = append(w.prevKey[:0], key...)
 	w.nEntries++
 	return nil
@@ -71,18 +86,53 @@ func (w *blockWriter) finish() error {
 		// Must have at least one restart entry.
 		w.restarts = append(w.restarts, 0)
 	}
-	w.restarts = append(w.restarts, uint32(len(w.restarts)))
 	for _, x := range w.restarts {
 		buf4 := w.buf.Alloc(4)
 		binary.LittleEndian.PutUint32(buf4, x)
 	}
+	restartsLen := uint32(len(w.restarts))
+	if nBuckets := w.hashBucketsLen(); nBuckets > 0 {
+		buckets := w.buf.Alloc(nBuckets)
+		for i := range buckets {
+			buckets[i] = blockHashNoEntry
+		}
+		for i, h := range w.keyHashes {
+			ri := uint8(w.keyRestart[i])
+			switch b := &buckets[h%uint32(nBuckets)]; *b {
+			case blockHashNoEntry:
+				*b = ri
+			case ri, blockHashCollision:
+			default:
+				*b = blockHashCollision
+			}
+		}
+		binary.LittleEndian.PutUint16(w.buf.Alloc(2), uint16(nBuckets))
+		restartsLen |= blockHashIndexFlag
+	}
+	binary.LittleEndian.PutUint32(w.buf.Alloc(4), restartsLen)
 	return nil
 }
 
+// Returns the number of hash index buckets, or zero if the block has no hash
+// index.
+func (w *blockWriter) hashBucketsLen() int {
+	if w.userKey == nil || w.nEntries == 0 || len(w.restarts) > blockHashMaxRestarts {
+		return 0
+	}
+	// Keep buckets 3/4 full at most.
+	n := len(w.keyHashes)*4/3 + 1
+	if n > 0xffff {
+		n = 0xffff
+	}
+	return n
+}
+
 func (w *blockWriter) reset() {
 	w.buf.Reset()
 	w.nEntries = 0
 	w.restarts = w.restarts[:0]
+	w.keyHashes = w.keyHashes[:0]
+	w.keyRestart = w.keyRestart[:0]
 }
 
 func (w *blockWriter) bytesLen() int {
@@ -90,7 +140,11 @@ func (w *blockWriter) bytesLen() int {
 	if restartsLen == 0 {
 		restartsLen = 1
 	}
-	return w.buf.Len() + 4*restartsLen + 4
+	n := w.buf.Len() + 4*restartsLen + 4
+	if nBuckets := w.hashBucketsLen(); nBuckets > 0 {
+		n += nBuckets + 2
+	}
+	return n
 }
 
 type filterWriter struct {
@@ -99,6 +153,17 @@ type filterWriter struct {
 	nKeys     int
 	offsets   []uint32
 	baseLg    uint
+
+	// Prefix extractor, and the last prefix added to the current filter.
+	prefix     filter.PrefixExtractor
+	lastPrefix []byte
+
+	// If true, a filter block holding one filter is generated for each
+	// index partition, see finishPartition.
+	partitioned bool
+
+	// If true, a single filter is generated for all keys.
+	full bool
 }
 
 func (w *filterWriter) add(key []byte) {
@@ -107,10 +172,18 @@ func (w *filterWriter) add(key []byte) {
 	}
 	w.generator.Add(key)
 	w.nKeys++
+	if w.prefix != nil && w.prefix.InDomain(key) {
+		// Consecutive keys mostly share the prefix, add it once.
+		if prefix := w.prefix.Prefix(key); w.lastPrefix == nil || !bytes.Equal(prefix, w.lastPrefix) {
+			w.generator.Add(prefix)
+			w.nKeys++
+			w.lastPrefix = append(w.lastPrefix[:0], prefix...)
+		}
+	}
 }
 
 func (w *filterWriter) flush(offset uint64) {
-	if w.generator == nil {
+	if w.generator == nil || w.partitioned || w.full {
 		return
 	}
 	for x := int(offset / uint64(1<<w.baseLg)); x > len(w.offsets); {
@@ -122,6 +195,13 @@ func (w *filterWriter) finish() error {
 	if w.generator == nil {
 		return nil
 	}
+	if w.full {
+		if w.nKeys > 0 {
+			w.generator.Generate(&w.buf)
+			w.nKeys = 0
+		}
+		return nil
+	}
 	// Generate last keys.
 
 	if w.nKeys > 0 {
@@ -135,6 +215,19 @@ func (w *filterWriter) finish() error {
 	return w.buf.WriteByte(byte(w.baseLg))
 }
 
+// Finishes the filter block of the current index partition, holding one
+// filter for all the keys added since the previous partition.
+func (w *filterWriter) finishPartition() ([]byte, error) {
+	w.generate()
+	if err := w.finish(); err != nil {
+		return nil, err
+	}
+	b := append([]byte(nil), w.buf.Bytes()...)
+	w.buf.Reset()
+	w.offsets = w.offsets[:0]
+	return b, nil
+}
+
 func (w *filterWriter) generate() {
 	// Record offset.
 	w.offsets = append(w.offsets, uint32(w.buf.Len()))
@@ -142,6 +235,7 @@ func (w *filterWriter) generate() {
 	if w.nKeys > 0 {
 		w.generator.Generate(&w.buf)
 		w.nKeys = 0
+		w.lastPrefix = nil
 	}
 }
 
@@ -153,9 +247,10 @@ type Writer struct {
 	// Options
 	cmp comparer.Comparer
 
-	filter      filter.Filter
-	compression opt.Compression
-	blockSize   int
+	filter           filter.Filter
+	compression      opt.Compression
+	compressionLevel int
+	blockSize        int
 
 	bpool       *util.BufferPool
 	dataBlock   blockWriter
@@ -165,6 +260,23 @@ type Writer struct {
 	offset      uint64
 	nEntries    int
 
+	rangeDelBlock blockWriter
+
+	// The range filter, and the generator of the range filter block.
+	rangeFilter          filter.RangeFilter
+	rangeFilterGenerator filter.FilterGenerator
+
+	// Finished index partitions, written after the data blocks. The index
+	// is partitioned if partitionSize is positive.
+	partitionSize int
+	partitions    []indexPartition
+	nBlocks       int
+
+	// The compression dictionary and the compressor bound to it, used to
+	// compress data blocks.
+	compressionDict []byte
+	dictCompressor  Compressor
+
 	// Scratch allocated enough for 5 uvarint. Block writer should not use
 	// first 20-bytes since it will be used to encode block handle, which
 	// then passed to the block writer itself.
@@ -174,19 +286,35 @@ type Writer struct {
 	compressionScratch []byte
 }
 
-func (w *Writer) writeBlock(buf *util.Buffer, compression opt.Compression) (bh blockHandle, err error) {
+// indexPartition is a finished index partition along with the filter block
+// of its keys, if any.
+type indexPartition struct {
+	key           []byte // the last key of the partition
+	index, filter []byte
+}
+
+// Writes the buffer as a block. The c is the compressor to use instead of the
+// registered one, it may be nil.
+func (w *Writer) writeBlock(buf *util.Buffer, compression opt.Compression, c Compressor) (bh blockHandle, err error) {
 	// Compress the buffer if necessary.
 	var b []byte
-	if compression == opt.SnappyCompression {
-		// Allocate scratch enough for compression and block trailer.
-		if n := snappy.MaxEncodedLen(buf.Len()) + blockTrailerLen; len(w.compressionScratch) < n {
-			w.compressionScratch = make([]byte, n)
+	if blockType := compressionBlockType(compression); blockType != blockTypeNoCompression {
+		if c == nil {
+			if c, err = getCompressor(blockType); err != nil {
+				return bh, err
+			}
 		}
-		compressed := snappy.Encode(w.compressionScratch, buf.Bytes())
-		n := len(compressed)
-		b = compressed[:n+blockTrailerLen]
-		b[n] = blockTypeSnappyCompression
-	} else {
+		compressed, err := c.Encode(w.compressionScratch[:0], buf.Bytes(), w.compressionLevel)
+		if err != nil {
+			return bh, err
+		}
+		if compressed != nil {
+			// Reuse the scratch, including room for the block trailer.
+			b = append(compressed, blockType, 0, 0, 0, 0)
+			w.compressionScratch = b
+		}
+	}
+	if b == nil {
 		tmp := buf.Alloc(blockTrailerLen)
 		tmp[0] = blockTypeNoCompression
 		b = buf.Bytes()
@@ -227,6 +355,13 @@ func (w *Writer) flushPendingBH(key []byte) error {
 	if err := w.indexBlock.append(separator, w.scratch[:n]); err != nil {
 		return err
 	}
+	w.nBlocks++
+	// Finish the index partition if partition size target reached.
+	if w.partitionSize > 0 && w.indexBlock.bytesLen() >= w.partitionSize {
+		if err := w.finishPartition(); err != nil {
+			return err
+		}
+	}
 	// Reset prev key of the data block.
 	w.dataBlock.prevKey = w.dataBlock.prevKey[:0]
 	// Clear pending block handle.
@@ -238,7 +373,7 @@ func (w *Writer) finishBlock() error {
 	if err := w.dataBlock.finish(); err != nil {
 		return err
 	}
-	bh, err := w.writeBlock(&w.dataBlock.buf, w.compression)
+	bh, err := w.writeBlock(&w.dataBlock.buf, w.compression, w.dictCompressor)
 	if err != nil {
 		return err
 	}
@@ -250,6 +385,58 @@ func (w *Writer) finishBlock() error {
 	return nil
 }
 
+func (w *Writer) finishPartition() error {
+	if err := w.indexBlock.finish(); err != nil {
+		return err
+	}
+	p := indexPartition{
+		key:   append([]byte(nil), w.indexBlock.prevKey...),
+		index: append([]byte(nil), w.indexBlock.buf.Bytes()...),
+	}
+	w.indexBlock.reset()
+	if w.filterBlock.generator != nil && w.filterBlock.partitioned {
+		var err error
+		if p.filter, err = w.filterBlock.finishPartition(); err != nil {
+			return err
+		}
+	}
+	w.partitions = append(w.partitions, p)
+	return nil
+}
+
+// Writes the index partitions along with their filter blocks, and appends
+// their handles to the index block, which becomes the top-level index.
+func (w *Writer) writePartitions() (bh blockHandle, err error) {
+	if w.indexBlock.nEntries > 0 {
+		if err := w.finishPartition(); err != nil {
+			return bh, err
+		}
+	}
+	bh.offset = w.offset
+	for _, p := range w.partitions {
+		indexBH, err := w.writeBlock(util.NewBuffer(p.index), w.compression, nil)
+		if err != nil {
+			return bh, err
+		}
+		// The scratch is used by the index block, encode the handles apart.
+		var handles [40]byte
+		n := encodeBlockHandle(handles[:], indexBH)
+		if p.filter != nil {
+			filterBH, err := w.writeBlock(util.NewBuffer(p.filter), opt.NoCompression, nil)
+			if err != nil {
+				return bh, err
+			}
+			n += encodeBlockHandle(handles[n:], filterBH)
+		}
+		if err := w.indexBlock.append(p.key, handles[:n]); err != nil {
+			return bh, err
+		}
+	}
+	bh.length = w.offset - bh.offset
+	w.partitions = nil
+	return bh, nil
+}
+
 // Append appends key/value pair to the table. The keys passed must
 // be in increasing order.
 //
@@ -272,6 +459,9 @@ func (w *Writer) Append(key, value []byte) error {
 	}
 	// Add key to the filter block.
 	w.filterBlock.add(key)
+	if w.rangeFilterGenerator != nil {
+		w.rangeFilterGenerator.Add(key)
+	}
 
 	// Finish the data block if block size target reached.
 	if w.dataBlock.bytesLen() >= w.blockSize {
@@ -284,9 +474,57 @@ func (w *Writer) Append(key, value []byte) error {
 	return nil
 }
 
+// AppendRangeDel appends range tombstone to the range deletion block of
+// the table. The key is the tombstone start and the value is its limit.
+// The keys passed must be in increasing order.
+//
+// It is safe to modify the contents of the arguments after AppendRangeDel
+// returns.
+func (w *Writer) AppendRangeDel(key, value []byte) error {
+	if lerrs.IsUnrecoverableError(w.err) {
+		return w.err
+	}
+	if w.rangeDelBlock.nEntries > 0 && w.cmp.Compare(w.rangeDelBlock.prevKey, key) >= 0 {
+		w.err = fmt.Errorf("leveldb/table: Writer: range tombstones are not in increasing order: %q, %q", w.rangeDelBlock.prevKey, key)
+		return w.err
+	}
+	return w.rangeDelBlock.append(key, value)
+}
+
+// SetCompressionDict sets the dictionary used to compress the data blocks of
+// the table, see TrainCompressionDict. The dictionary is stored in the table
+// and it must not be modified afterwards. It must be called before any
+// key/value pair is appended, and the compressor of the writer compression
+// must implement DictCompressor.
+func (w *Writer) SetCompressionDict(dict []byte) error {
+	if lerrs.IsUnrecoverableError(w.err) {
+		return w.err
+	}
+	if w.nEntries > 0 || w.dictCompressor != nil {
+		return errors.New("leveldb/table: Writer: compression dictionary must be set once before appending")
+	}
+	blockType := compressionBlockType(w.compression)
+	if blockType == blockTypeNoCompression {
+		return errors.New("leveldb/table: Writer: compression dictionary requires compression")
+	}
+	c, err := getCompressor(blockType)
+	if err != nil {
+		return err
+	}
+	dc, ok := c.(DictCompressor)
+	if !ok {
+		return fmt.Errorf("leveldb/table: Writer: compressor for block type %#x doesn't support dictionary", blockType)
+	}
+	if w.dictCompressor, err = dc.WithDict(dict); err != nil {
+		return err
+	}
+	w.compressionDict = dict
+	return nil
+}
+
 // BlocksLen returns number of blocks written so far.
 func (w *Writer) BlocksLen() int {
-	n := w.indexBlock.nEntries
+	n := w.nBlocks
 	if w.pendingBH.length > 0 {
 		// Includes the pending block.
 		n++
@@ -316,6 +554,10 @@ func (w *Writer) Close() error {
 			w.dataBlock.buf.Reset()
 			w.bpool.Put(w.dataBlock.buf.Bytes())
 		}
+		if r, ok := w.dictCompressor.(util.Releaser); ok {
+			r.Release()
+		}
+		w.dictCompressor = nil
 	}()
 
 	if w.err != nil {
@@ -323,8 +565,10 @@ func (w *Writer) Close() error {
 	}
 
 	// Write the last data block. Or empty data block if there
-	// aren't any data blocks at all.
-	if w.dataBlock.nEntries > 0 || w.nEntries == 0 {
+	// aren't any data blocks at all, unless the table holds range
+	// deletions, in which case the index is left empty since there is
+	// no key to index the empty block with.
+	if w.dataBlock.nEntries > 0 || (w.nEntries == 0 && w.rangeDelBlock.nEntries == 0) {
 		if err := w.finishBlock(); err != nil {
 			w.err = err
 			return w.err
@@ -334,40 +578,122 @@ func (w *Writer) Close() error {
 		return err
 	}
 
-	// Write the filter block.
-	var filterBH blockHandle
-	if err := w.filterBlock.finish(); err != nil {
-		return err
+	// Write the range deletion block.
+	var rangeDelBH blockHandle
+	if w.rangeDelBlock.nEntries > 0 {
+		if err := w.rangeDelBlock.finish(); err != nil {
+			return err
+		}
+		rangeDelBH, w.err = w.writeBlock(&w.rangeDelBlock.buf, w.compression, nil)
+		if lerrs.IsUnrecoverableError(w.err) {
+			return w.err
+		}
+	}
+
+	// Write the compression dictionary block.
+	var dictBH blockHandle
+	if w.dictCompressor != nil {
+		w.dataBlock.buf.Reset()
+		w.dataBlock.buf.WriteByte(compressionBlockType(w.compression))
+		w.dataBlock.buf.Write(w.compressionDict)
+		dictBH, w.err = w.writeBlock(&w.dataBlock.buf, opt.NoCompression, nil)
+		if lerrs.IsUnrecoverableError(w.err) {
+			return w.err
+		}
+		w.dataBlock.buf.Reset()
+	}
+
+	// Write the index partitions, and the filter block unless the filter
+	// blocks are within the partitions.
+	var filterBH, partitionBH blockHandle
+	if w.partitionSize > 0 {
+		if partitionBH, w.err = w.writePartitions(); lerrs.IsUnrecoverableError(w.err) {
+			return w.err
+		}
+	}
+	if w.filterBlock.partitioned {
+		filterBH = partitionBH
+	} else {
+		if err := w.filterBlock.finish(); err != nil {
+			return err
+		}
+		if buf := &w.filterBlock.buf; buf.Len() > 0 {
+			filterBH, w.err = w.writeBlock(buf, opt.NoCompression, nil)
+			if lerrs.IsUnrecoverableError(w.err) {
+				return w.err
+			}
+		}
 	}
-	if buf := &w.filterBlock.buf; buf.Len() > 0 {
-		filterBH, w.err = w.writeBlock(buf, opt.NoCompression)
+
+	// Write the range filter block.
+	var rangeFilterBH blockHandle
+	if w.rangeFilterGenerator != nil {
+		w.dataBlock.buf.Reset()
+		w.rangeFilterGenerator.Generate(&w.dataBlock.buf)
+		rangeFilterBH, w.err = w.writeBlock(&w.dataBlock.buf, opt.NoCompression, nil)
 		if lerrs.IsUnrecoverableError(w.err) {
 			return w.err
 		}
+		w.dataBlock.buf.Reset()
 	}
 
-	// Write the metaindex block.
+	// Write the metaindex block, with its entries sorted by key.
+	type metaEntry struct {
+		key   string
+		value []byte
+	}
+	var metas []metaEntry
+	addMeta := func(key string, bh blockHandle) {
+		value := make([]byte, 20)
+		n := encodeBlockHandle(value, bh)
+		metas = append(metas, metaEntry{key: key, value: value[:n]})
+	}
 	if filterBH.length > 0 {
-		key := []byte("filter." + w.filter.Name())
-		n := encodeBlockHandle(w.scratch[:20], filterBH)
-		if err := w.dataBlock.append(key, w.scratch[:n]); err != nil {
+		key := "filter." + w.filter.Name()
+		if w.filterBlock.full {
+			key = fullFilterName + w.filter.Name()
+		} else if w.filterBlock.partitioned {
+			key = partitionedFilterName + w.filter.Name()
+		}
+		addMeta(key, filterBH)
+		if w.filterBlock.prefix != nil {
+			metas = append(metas, metaEntry{key: prefixExtractorName + w.filterBlock.prefix.Name()})
+		}
+	}
+	if rangeFilterBH.length > 0 {
+		addMeta(rangeFilterName+w.rangeFilter.Name(), rangeFilterBH)
+	}
+	if partitionBH.length > 0 {
+		addMeta(partitionedIndexName, partitionBH)
+	}
+	if rangeDelBH.length > 0 {
+		addMeta(rangeDelBlockName, rangeDelBH)
+	}
+	if dictBH.length > 0 {
+		addMeta(compressionDictBlockName, dictBH)
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].key < metas[j].key
+	})
+	for _, m := range metas {
+		if err := w.dataBlock.append([]byte(m.key), m.value); err != nil {
 			return err
 		}
 	}
 	if err := w.dataBlock.finish(); err != nil {
 		return err
 	}
-	metaindexBH, err := w.writeBlock(&w.dataBlock.buf, w.compression)
+	metaindexBH, err := w.writeBlock(&w.dataBlock.buf, w.compression, nil)
 	if err != nil {
 		w.err = err
 		return w.err
 	}
 
-	// Write the index block.
+	// Write the index block, which is the top-level index if partitioned.
 	if err := w.indexBlock.finish(); err != nil {
 		return err
 	}
-	indexBH, err := w.writeBlock(&w.indexBlock.buf, w.compression)
+	indexBH, err := w.writeBlock(&w.indexBlock.buf, w.compression, nil)
 	if err != nil {
 		w.err = err
 		return w.err
@@ -397,26 +723,44 @@ func (w *Writer) Close() error {
 func NewWriter(f io.Writer, o *opt.Options, pool *util.BufferPool) *Writer {
 	bufBytes := pool.Get(0)
 	w := &Writer{
-		writer:          f,
-		cmp:             o.GetComparer(),
-		filter:          o.GetFilter(),
-		compression:     o.GetCompression(),
-		blockSize:       o.GetBlockSize(),
-		comparerScratch: make([]byte, 0),
-		bpool:           pool,
-		dataBlock:       blockWriter{buf: *util.NewBuffer(bufBytes)},
+		writer:           f,
+		cmp:              o.GetComparer(),
+		filter:           o.GetFilter(),
+		compression:      o.GetCompression(),
+		compressionLevel: o.GetCompressionLevel(),
+		blockSize:        o.GetBlockSize(),
+		partitionSize:    o.GetIndexPartitionSize(),
+		comparerScratch:  make([]byte, 0),
+		bpool:            pool,
+		dataBlock:        blockWriter{buf: *util.NewBuffer(bufBytes)},
 	}
 	// data block
 	w.dataBlock.restartInterval = o.GetBlockRestartInterval()
 	// The first 20-bytes are used for encoding block handle.
 	w.dataBlock.scratch = w.scratch[20:]
+	if o.GetDataBlockHashIndex() {
+		cmp := w.cmp
+		w.dataBlock.userKey = func(key []byte) []byte {
+			return userKey(cmp, key)
+		}
+	}
 	// index block
 	w.indexBlock.restartInterval = 1
 	w.indexBlock.scratch = w.scratch[20:]
+	// range deletion block
+	w.rangeDelBlock.restartInterval = 1
+	w.rangeDelBlock.scratch = w.scratch[20:]
+	// range filter block
+	if w.rangeFilter = o.GetRangeFilter(); w.rangeFilter != nil {
+		w.rangeFilterGenerator = w.rangeFilter.NewGenerator()
+	}
 	// filter block
 	if w.filter != nil {
 		w.filterBlock.generator = w.filter.NewGenerator()
 		w.filterBlock.baseLg = uint(o.GetFilterBaseLg())
+		w.filterBlock.prefix = o.GetPrefixExtractor()
+		w.filterBlock.full = o.GetFullFilter()
+		w.filterBlock.partitioned = w.partitionSize > 0 && !w.filterBlock.full
 		w.filterBlock.flush(0)
 	}
 	return w
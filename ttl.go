@@ -0,0 +1,100 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"encoding/binary"
+
+	"github.com/3JoB/goleveldb/opt"
+)
+
+// In TTL mode, values of the default column family are suffixed with their
+// expiry time, in unix nanoseconds; zero means never.
+const ttlSuffixLen = 8
+
+func appendTTL(dst, value []byte, expiry uint64) []byte {
+	dst = append(dst, value...)
+	return binary.LittleEndian.AppendUint64(dst, expiry)
+}
+
+func splitTTL(v []byte) (value []byte, expiry uint64) {
+	if len(v) < ttlSuffixLen {
+		return v, 0
+	}
+	n := len(v) - ttlSuffixLen
+	return v[:n], binary.LittleEndian.Uint64(v[n:])
+}
+
+func (db *DB) ttlEnabled() bool {
+	return db.s.o.GetTTL() != 0
+}
+
+// Returns the expiry time of entries written with the given options.
+func (db *DB) ttlExpiry(wo *opt.WriteOptions) uint64 {
+	ttl := wo.GetTTL()
+	if ttl == 0 {
+		ttl = db.s.o.GetTTL()
+	}
+	if ttl < 0 {
+		return 0
+	}
+	return uint64(db.ttlNow().Add(ttl).UnixNano())
+}
+
+func (db *DB) ttlExpired(expiry uint64) bool {
+	return expiry != 0 && expiry <= uint64(db.ttlNow().UnixNano())
+}
+
+// Returns whether the given stored value is expired.
+func (db *DB) ttlValueExpired(v []byte) bool {
+	_, expiry := splitTTL(v)
+	return db.ttlExpired(expiry)
+}
+
+// Strips the expiry time of the given stored value. It returns ErrNotFound
+// if the value is expired.
+func (db *DB) ttlValue(v []byte) ([]byte, error) {
+	value, expiry := splitTTL(v)
+	if db.ttlExpired(expiry) {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+// Returns the given batch with the expiry time appended to the values of the
+// default column family, in TTL mode.
+func (db *DB) ttlBatch(b *Batch, wo *opt.WriteOptions) *Batch {
+	if !db.ttlEnabled() {
+		return b
+	}
+	expiry := db.ttlExpiry(wo)
+	nb := MakeBatch(len(b.data) + b.Len()*ttlSuffixLen)
+	var buf []byte
+	for _, index := range b.index {
+		value := index.v(b.data)
+		if index.cf == 0 && index.keyType == keyTypeVal {
+			buf = appendTTL(buf[:0], value, expiry)
+			value = buf
+		}
+		nb.appendRec(index.cf, index.keyType, index.k(b.data), value)
+	}
+	return nb
+}
+
+// Returns the given batch with the expiry time stripped from the values of
+// the default column family.
+func ttlStripBatch(b *Batch) *Batch {
+	nb := MakeBatch(len(b.data))
+	for _, index := range b.index {
+		value := index.v(b.data)
+		if index.cf == 0 && index.keyType == keyTypeVal {
+			value, _ = splitTTL(value)
+		}
+		nb.appendRec(index.cf, index.keyType, index.k(b.data), value)
+	}
+	return nb
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/3JoB/goleveldb/opt"
+)
+
+// ttlClock is a manually advanced clock for the TTL mode.
+type ttlClock struct {
+	now atomic.Int64
+}
+
+func newTTLClock(h *dbHarness) *ttlClock {
+	c := &ttlClock{}
+	c.now.Store(time.Now().UnixNano())
+	h.db.ttlNow = func() time.Time { return time.Unix(0, c.now.Load()) }
+	return c
+}
+
+func (c *ttlClock) advance(d time.Duration) {
+	c.now.Add(int64(d))
+}
+
+func TestDB_TTL(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		TTL:                          time.Hour,
+	})
+	defer h.close()
+	clock := newTTLClock(h)
+
+	h.put("a", "v1")
+	h.put("c", "v1")
+	if err := h.db.Put([]byte("b"), []byte("v1"), &opt.WriteOptions{TTL: 2 * time.Hour}); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	if err := h.db.Put([]byte("d"), []byte("v1"), &opt.WriteOptions{TTL: -1}); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	h.getKeyVal("(a->v1)(b->v1)(c->v1)(d->v1)")
+
+	clock.advance(90 * time.Minute)
+	h.get("a", false)
+	if ok, err := h.db.Has([]byte("c"), nil); ok || err != nil {
+		t.Errorf("Has: expired key is found, err=%v", err)
+	}
+	h.getVal("b", "v1")
+	h.getKeyVal("(b->v1)(d->v1)")
+	iter := h.db.NewIterator(nil, nil)
+	if !iter.Last() || string(iter.Key()) != "d" || !iter.Prev() || string(iter.Key()) != "b" || iter.Prev() {
+		t.Errorf("Prev: expired keys aren't skipped")
+	}
+	iter.Release()
+
+	// Rewritten keys expire anew.
+	h.put("c", "v2")
+	h.compactMem()
+	h.compactRange("", "")
+	h.allEntriesFor("a", "[ ]")
+	h.getKeyVal("(b->v1)(c->v2)(d->v1)")
+
+	clock.advance(time.Hour)
+	h.compactRange("", "")
+	h.allEntriesFor("b", "[ ]")
+	h.allEntriesFor("c", "[ ]")
+	h.getKeyVal("(d->v1)")
+}
+
+func TestDB_TTL_CompactRange(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		TTL:                          time.Hour,
+	})
+	defer h.close()
+
+	// The deepest level is compacted in place, rather than pushed down.
+	for i := 0; i < 12; i++ {
+		h.put("a", fmt.Sprint(i))
+		h.compactRange("", "")
+	}
+	h.tablesPerLevel("0,1")
+	h.getVal("a", "11")
+}
+
+func TestDB_TTL_Snapshot(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		TTL:                          time.Hour,
+	})
+	defer h.close()
+	clock := newTTLClock(h)
+
+	if err := h.db.Put([]byte("a"), []byte("v1"), &opt.WriteOptions{TTL: -1}); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	h.compactMem()
+	snap := h.getSnapshot()
+	defer snap.Release()
+	h.put("a", "v2")
+	h.put("b", "v1")
+
+	clock.advance(2 * time.Hour)
+	h.compactMem()
+	h.compactRange("", "")
+
+	// The expired value is shadowed rather than dropped, as the older value
+	// is still visible to the snapshot.
+	h.allEntriesFor("a", "[ DEL, "+string(appendTTL(nil, []byte("v1"), 0))+" ]")
+	h.getValr(snap, "a", "v1")
+	h.getKeyVal("")
+}
+
+func TestDB_TTL_CompactionFilter(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		CompactionFilter:             &prefixFilter{levels: make(map[int]bool)},
+		TTL:                          time.Hour,
+	})
+	defer h.close()
+	clock := newTTLClock(h)
+
+	h.put("up-a", "v1")
+	h.compactMem()
+	h.compactRange("", "")
+	h.getKeyVal("(up-a->V1)")
+
+	// The expiry is kept across the value change.
+	clock.advance(2 * time.Hour)
+	h.getKeyVal("")
+}
+
+func TestDB_TTL_Merge(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		MergeOperator:                appendMerger{},
+		TTL:                          time.Hour,
+	})
+	defer h.close()
+
+	if err := h.db.Merge([]byte("a"), []byte("1"), nil); err != ErrMergeTTL {
+		t.Errorf("Merge: got error %v, want %v", err, ErrMergeTTL)
+	}
+	b := new(Batch)
+	b.Merge([]byte("a"), []byte("1"))
+	if err := h.db.Write(b, nil); err != ErrMergeTTL {
+		t.Errorf("Write: got error %v, want %v", err, ErrMergeTTL)
+	}
+}
+
+func TestDB_TTL_Ingest(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{TTL: time.Hour})
+	defer h.close()
+
+	if err := h.db.IngestExternalFiles([]string{"1.sst"}); err != ErrIngestTTL {
+		t.Errorf("IngestExternalFiles: got error %v, want %v", err, ErrIngestTTL)
+	}
+}
+
+func TestDB_TTL_GetUpdatesSince(t *testing.T) {
+	dir := checkpointDir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := OpenFile(dir, &opt.Options{TTL: time.Hour})
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+
+	b := new(Batch)
+	b.Put([]byte("a"), []byte("v1"))
+	b.Delete([]byte("b"))
+	if err := db.Write(b, nil); err != nil {
+		t.Fatal("Write: got error: ", err)
+	}
+	if got, err := getUpdatesSince(t, db, 0); err != nil || got != "1:(a,v1)(b)" {
+		t.Errorf("GetUpdatesSince(0): got (%s, %v), want 1:(a,v1)(b)", got, err)
+	}
+}
@@ -8,6 +8,8 @@ package leveldb
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -37,6 +39,11 @@ type version struct {
 
 	cSeek unsafe.Pointer
 
+	// Range tombstones of the version tables, fragmented on first use.
+	rdOnce  sync.Once
+	rdFrags rangeFragments
+	rdErr   error
+
 	closing  bool
 	ref      int
 	released bool
@@ -139,7 +146,37 @@ func (v *version) walkOverlapping(aux tFiles, ikey internalKey, f func(level int
 	}
 }
 
-func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue bool) (value []byte, tcomp bool, err error) {
+// rangeDels returns range tombstones of all tables within the version,
+// fragmented.
+func (v *version) rangeDels() (rangeFragments, error) {
+	v.rdOnce.Do(func() {
+		ro := &opt.ReadOptions{DontFillCache: true}
+		var rdels rangeTombstones
+		for _, tables := range v.levels {
+			for _, t := range tables {
+				if !t.rangeDel {
+					continue
+				}
+				iter := v.s.tops.newRangeDelIterator(t, ro)
+				rdels, v.rdErr = appendRangeTombstones(rdels, iter, keyMaxSeq)
+				iter.Release()
+				if v.rdErr != nil {
+					return
+				}
+			}
+		}
+		rdels.sort(v.s.icmp)
+		v.rdFrags = rdels.fragment(v.s.icmp)
+	})
+	return v.rdFrags, v.rdErr
+}
+
+// get finds the value of the given key. Entries with sequence number less
+// than rdSeq are deleted by a range tombstone and treated as deletion.
+// Merge operands found are appended to ops, and the returned value is the
+// one underneath them. If ops is nil, a merge operand is reported as an
+// existing value.
+func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue bool, rdSeq uint64, ops *mergeOperands) (value []byte, tcomp bool, err error) {
 	if v.closing {
 		return nil, false, ErrClosed
 	}
@@ -203,8 +240,14 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 				} else {
 					switch fkt {
 					case keyTypeVal:
-						value = fval
-						err = nil
+						if fseq >= rdSeq {
+							value = fval
+							err = nil
+						}
+					case keyTypeMerge:
+						if fseq >= rdSeq {
+							value, err = v.getMerge(aux, ikey, ro, rdSeq, ops)
+						}
 					case keyTypeDel:
 					default:
 						panic("leveldb: invalid internalKey type")
@@ -222,8 +265,14 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 		if zfound {
 			switch zkt {
 			case keyTypeVal:
-				value = zval
-				err = nil
+				if zseq >= rdSeq {
+					value = zval
+					err = nil
+				}
+			case keyTypeMerge:
+				if zseq >= rdSeq {
+					value, err = v.getMerge(aux, ikey, ro, rdSeq, ops)
+				}
 			case keyTypeDel:
 			default:
 				panic("leveldb: invalid internalKey type")
@@ -241,6 +290,25 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 	return
 }
 
+// Collects merge operands of the given key from all tables, and returns the
+// value underneath them. If ops is nil, the key is reported as existing.
+func (v *version) getMerge(aux tFiles, ikey internalKey, ro *opt.ReadOptions, rdSeq uint64, ops *mergeOperands) ([]byte, error) {
+	if ops == nil {
+		return nil, nil
+	}
+	its := make([]iterator.Iterator, 0, len(aux))
+	for _, t := range aux {
+		its = append(its, v.s.tops.newIterator(t, nil, ro))
+	}
+	its = append(its, v.getIterators(nil, ro)...)
+	iter := iterator.NewMergedIterator(its, v.s.icmp, opt.GetStrict(v.s.o.Options, ro, opt.StrictReader))
+	defer iter.Release()
+	if ok, value, err := ops.collect(iter, v.s.icmp, ikey, rdSeq); ok {
+		return value, err
+	}
+	return nil, ErrNotFound
+}
+
 func (v *version) sampleSeek(ikey internalKey) (tcomp bool) {
 	var tset *tSet
 
@@ -328,6 +396,28 @@ func (v *version) offsetOf(ikey internalKey) (n int64, err error) {
 	return
 }
 
+func (v *version) sizeOf(ranges []util.Range) (Sizes, error) {
+	sizes := make(Sizes, 0, len(ranges))
+	for _, r := range ranges {
+		imin := makeInternalKey(nil, r.Start, keyMaxSeq, keyTypeSeek)
+		imax := makeInternalKey(nil, r.Limit, keyMaxSeq, keyTypeSeek)
+		start, err := v.offsetOf(imin)
+		if err != nil {
+			return nil, err
+		}
+		limit, err := v.offsetOf(imax)
+		if err != nil {
+			return nil, err
+		}
+		var size int64
+		if limit >= start {
+			size = limit - start
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
 func (v *version) pickMemdbLevel(umin, umax []byte, maxLevel int) (level int) {
 	if maxLevel > 0 {
 		if len(v.levels) == 0 {
@@ -394,12 +484,144 @@ func (v *version) computeCompaction() {
 		statTotSize += size
 	}
 
+	switch v.s.o.GetCompactionStyle() {
+	case opt.UniversalCompaction:
+		// Sorted runs are merged regardless of their level.
+		bestLevel = 0
+		bestScore = float64(len(v.sortedRuns())) / float64(v.s.o.GetCompactionL0Trigger())
+	case opt.FIFOCompaction:
+		// Tables are dropped once too large in total, otherwise the newest
+		// small ones may be merged.
+		bestLevel = 0
+		bestScore = 0
+		if maxSize := v.s.o.GetCompactionFIFOMaxSize(); statTotSize > maxSize {
+			bestScore = float64(statTotSize) / float64(maxSize)
+		} else if n := len(v.fifoMergeTables()); n > 0 {
+			bestScore = float64(n) / float64(v.s.o.GetCompactionL0Trigger())
+		}
+	}
+
 	v.cLevel = bestLevel
 	v.cScore = bestScore
 
 	v.s.logf("version@stat F·%v S·%s%v Sc·%v", statFiles, shortenb(statTotSize), statSizes, statScore)
 }
 
+// sortedRun is a sorted run of universal compaction, which is either a
+// level-0 table or a whole level.
+type sortedRun struct {
+	level  int
+	tables tFiles
+	size   int64
+}
+
+// Returns the sorted runs from the newest to the oldest.
+func (v *version) sortedRuns() (runs []sortedRun) {
+	for level, tables := range v.levels {
+		if len(tables) == 0 {
+			continue
+		}
+		if level == 0 {
+			for _, t := range tables {
+				runs = append(runs, sortedRun{level: 0, tables: tFiles{t}, size: t.size})
+			}
+		} else {
+			runs = append(runs, sortedRun{level: level, tables: tables, size: tables.size()})
+		}
+	}
+	return
+}
+
+// Returns all tables from the oldest to the newest, for FIFO compaction.
+func (v *version) fifoTables() (tables []tSet) {
+	for level, tt := range v.levels {
+		for _, t := range tt {
+			tables = append(tables, tSet{level: level, table: t})
+		}
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		ti, tj := tables[i].table, tables[j].table
+		if ti.ctime != tj.ctime {
+			return ti.ctime < tj.ctime
+		}
+		return ti.fd.Num < tj.fd.Num
+	})
+	return
+}
+
+// Returns the oldest tables FIFO compaction drops for the total size to be
+// within the limit.
+func (v *version) fifoOversized() (drops []tSet) {
+	tables := v.fifoTables()
+	var total int64
+	for _, ts := range tables {
+		total += ts.table.size
+	}
+	maxSize := v.s.o.GetCompactionFIFOMaxSize()
+	for _, ts := range tables {
+		if total <= maxSize {
+			break
+		}
+		drops = append(drops, ts)
+		total -= ts.table.size
+	}
+	return
+}
+
+// Returns the tables FIFO compaction drops for being older than the age
+// limit at the given time.
+func (v *version) fifoExpired(now time.Time) (drops []tSet) {
+	maxAge := v.s.o.GetCompactionFIFOMaxAge()
+	if v.s.o.GetCompactionStyle() != opt.FIFOCompaction || maxAge == 0 {
+		return nil
+	}
+	for _, ts := range v.fifoTables() {
+		if ts.table.ctime != 0 && ts.table.ctime+int64(maxAge) <= now.UnixNano() {
+			drops = append(drops, ts)
+		}
+	}
+	return
+}
+
+// Returns the time the next table gets older than the age limit of FIFO
+// compaction, false if none does.
+func (v *version) fifoNextExpiry() (time.Time, bool) {
+	maxAge := v.s.o.GetCompactionFIFOMaxAge()
+	if v.s.o.GetCompactionStyle() != opt.FIFOCompaction || maxAge == 0 {
+		return time.Time{}, false
+	}
+	for _, ts := range v.fifoTables() {
+		if ts.table.ctime != 0 {
+			return time.Unix(0, ts.table.ctime+int64(maxAge)), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Returns the newest small level-0 tables FIFO compaction merges, nil if
+// there are too few of them or merging is disabled.
+func (v *version) fifoMergeTables() tFiles {
+	if !v.s.o.GetCompactionFIFOMerge() || len(v.levels) == 0 {
+		return nil
+	}
+	var (
+		tables tFiles
+		total  int64
+		limit  = int64(v.s.o.GetCompactionTableSize(0))
+	)
+	for _, t := range v.levels[0] {
+		if total+t.size > limit {
+			break
+		}
+		tables = append(tables, t)
+		total += t.size
+	}
+	if len(tables) < v.s.o.GetCompactionL0Trigger() || len(tables) < 2 {
+		return nil
+	}
+	return tables
+}
+
 func (v *version) needCompaction() bool {
 	return v.cScore >= 1 || atomic.LoadPointer(&v.cSeek) != nil
 }
@@ -440,6 +662,11 @@ func (p *versionStaging) commit(r *sessionRecord) {
 
 	// New tables.
 	for _, r := range r.addedTables {
+		// Creation times are only kept for FIFO compaction, so that they
+		// aren't written into the manifest otherwise.
+		if p.base.s.o.GetCompactionStyle() != opt.FIFOCompaction {
+			r.ctime = 0
+		}
 		scratch := p.getScratch(r.level)
 		if scratch.added == nil {
 			scratch.added = make(map[int64]atRecord)